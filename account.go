@@ -356,6 +356,18 @@ of the invoice related to this journal`},
 		"GroupInvoiceLines": models.BooleanField{
 			Help: `If this box is checked, the system will try to group the accounting lines when generating
 them from invoices.`},
+		"LockDate": models.DateField{
+			String: "Journal Lock Date",
+			Help: `No users, including Advisers, can add or modify entries of this journal prior to and inclusive
+of this date. Use it to close a journal before the company's period lock date.`},
+		"PostingDateFrom": models.DateField{
+			String:     "Allowed Posting From",
+			Constraint: h.AccountJournal().Methods().CheckPostingWindow(),
+			Help:       "If set, entries of this journal dated before this date cannot be posted"},
+		"PostingDateTo": models.DateField{
+			String:     "Allowed Posting Until",
+			Constraint: h.AccountJournal().Methods().CheckPostingWindow(),
+			Help:       "If set, entries of this journal dated after this date cannot be posted"},
 		"EntrySequence": models.Many2OneField{
 			RelationModel: h.Sequence(),
 			JSON:          "sequence_id",
@@ -473,6 +485,14 @@ to manage payments outside of the software.`},
 			}
 		})
 
+	h.AccountJournal().Methods().CheckPostingWindow().DeclareMethod(
+		`CheckPostingWindow checks that the allowed posting window of the journal is consistent`,
+		func(rs m.AccountJournalSet) {
+			if !rs.PostingDateFrom().IsZero() && !rs.PostingDateTo().IsZero() && rs.PostingDateTo().Lower(rs.PostingDateFrom()) {
+				panic(rs.T(`The end of the allowed posting window of journal %s must be after its start.`, rs.Name()))
+			}
+		})
+
 	h.AccountJournal().Methods().CheckBankAccount().DeclareMethod(
 		`CheckBankAccount`,
 		func(rs m.AccountJournalSet) {
@@ -864,15 +884,26 @@ Note: 'None' means a tax can't be used by itself however it can still be used in
 			String: "TaxAdjustment",
 			Help: `Set this field to true if this tax can be used in the tax adjustment wizard,
 used to manually fill some data in the tax declaration`},
+		"ValidUntil": models.DateField{
+			String: "Valid Until",
+			Help: `Last day on which this tax applies. Documents dated after this day use the successor tax instead.
+Leave empty if this tax has no end of validity.`},
+		"SuccessorTax": models.Many2OneField{
+			String:        "Successor Tax",
+			RelationModel: h.AccountTax(),
+			Constraint:    h.AccountTax().Methods().CheckSuccessorTax(),
+			Help:          "Tax replacing this tax for documents dated after its validity date, e.g. after a change of rate"},
 		"AmountType": models.SelectionField{
 			String: "Tax Computation",
 			Selection: types.Selection{
 				"group":    "Group of Taxes",
 				"fixed":    "Fixed",
 				"percent":  "Percentage of Price",
-				"division": "Percentage of Price Tax Included"},
-			Required: true,
-			Default:  models.DefaultValue("percent")},
+				"division": "Percentage of Price Tax Included",
+				"formula":  "Formula"},
+			Required:   true,
+			Default:    models.DefaultValue("percent"),
+			Constraint: h.AccountTax().Methods().CheckFormula()},
 		"Active": models.BooleanField{
 			String:  "Active",
 			Default: models.DefaultValue(true),
@@ -1051,10 +1082,49 @@ to the same analytic account as the invoice line (if any)`},
 		`Returns a string that will be used to group account.invoice.tax sharing the same properties`,
 		func(rs m.AccountTaxSet, invoiceTaxVal m.AccountInvoiceTaxData) string {
 			rs.EnsureOne()
-			str := fmt.Sprintf(`%d-%d-%d`, invoiceTaxVal.Tax().ID(), invoiceTaxVal.Account().ID(), invoiceTaxVal.AccountAnalytic().ID())
+			str := fmt.Sprintf(`%d-%d-%d-%d`, invoiceTaxVal.Tax().ID(), invoiceTaxVal.Account().ID(), invoiceTaxVal.AccountAnalytic().ID(),
+				invoiceTaxVal.RepartitionLine().ID())
 			return str
 		})
 
+	h.AccountTax().Methods().CheckSuccessorTax().DeclareMethod(
+		`CheckSuccessorTax checks that the successor tax is of the same kind and that successions do not loop`,
+		func(rs m.AccountTaxSet) {
+			for _, tax := range rs.Records() {
+				if tax.SuccessorTax().IsEmpty() {
+					continue
+				}
+				if tax.SuccessorTax().TypeTaxUse() != tax.TypeTaxUse() || !tax.SuccessorTax().Company().Equals(tax.Company()) {
+					panic(rs.T(`The successor tax of %s must have the same scope and company.`, tax.Name()))
+				}
+				visited := map[int64]bool{tax.ID(): true}
+				for succ := tax.SuccessorTax(); succ.IsNotEmpty(); succ = succ.SuccessorTax() {
+					if visited[succ.ID()] {
+						panic(rs.T(`The successor taxes of %s form a loop.`, tax.Name()))
+					}
+					visited[succ.ID()] = true
+				}
+			}
+		})
+
+	h.AccountTax().Methods().AtDate().DeclareMethod(
+		`AtDate returns the taxes effective at the given date, replacing each tax of this set which
+		is no longer valid at this date by its successor.`,
+		func(rs m.AccountTaxSet, date dates.Date) m.AccountTaxSet {
+			if date.IsZero() {
+				date = dates.Today()
+			}
+			res := h.AccountTax().NewSet(rs.Env())
+			for _, tax := range rs.Records() {
+				// Successions are checked not to loop
+				for !tax.ValidUntil().IsZero() && date.Greater(tax.ValidUntil()) && tax.SuccessorTax().IsNotEmpty() {
+					tax = tax.SuccessorTax()
+				}
+				res = res.Union(tax)
+			}
+			return res
+		})
+
 	h.AccountTax().Methods().ComputeAmount().DeclareMethod(
 		`ComputeAmount returns the amount of a single tax.
 
@@ -1183,6 +1253,10 @@ to the same analytic account as the invoice line (if any)`},
 				return taxRecords[i].Sequence() < taxRecords[j].Sequence()
 			})
 			for _, tax := range taxRecords {
+				if tax.Withholding() {
+					// Withholding taxes are applied on payments, not on invoices
+					continue
+				}
 				if tax.AmountType() == "group" {
 					children := tax.ChildrenTaxes().WithContext("base_values", []float64{totalExcluded, totalIncluded, base})
 					retBase, retExcl, retIncl, retTaxes := children.ComputeAll(priceUnit, currency, quantity, product, partner)
@@ -1202,30 +1276,39 @@ to the same analytic account as the invoice line (if any)`},
 					taxAmount = currency.Round(taxAmount)
 				}
 
+				// Taxes with repartition lines are split on these lines
+				taxData := tax.Repartition(accounttypes.AppliedTaxData{
+					ID:              tax.ID(),
+					Name:            tax.WithContext("lang", partner.Lang()).Name(),
+					Amount:          taxAmount,
+					Sequence:        tax.Sequence(),
+					AccountID:       tax.Account().ID(),
+					RefundAccountID: tax.RefundAccount().ID(),
+					Analytic:        tax.Analytic(),
+				}, currency, prec)
+				var netTaxAmount float64
+				for _, td := range taxData {
+					netTaxAmount += td.Amount
+				}
+
 				if tax.PriceInclude() {
 					totalExcluded -= taxAmount
 					base -= taxAmount
 				} else {
-					totalIncluded += taxAmount
+					totalIncluded += netTaxAmount
 				}
 
 				// Keep base amount used for the current tax
 				taxBase := base
 
 				if tax.IncludeBaseAmount() {
-					base += taxAmount
+					base += netTaxAmount
 				}
 
-				taxes = append(taxes, accounttypes.AppliedTaxData{
-					ID:              tax.ID(),
-					Name:            tax.WithContext("lang", partner.Lang()).Name(),
-					Amount:          taxAmount,
-					Base:            taxBase,
-					Sequence:        tax.Sequence(),
-					AccountID:       tax.Account().ID(),
-					RefundAccountID: tax.RefundAccount().ID(),
-					Analytic:        tax.Analytic(),
-				})
+				for i := range taxData {
+					taxData[i].Base = taxBase
+				}
+				taxes = append(taxes, taxData...)
 			}
 
 			if roundTotal {
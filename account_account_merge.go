@@ -0,0 +1,327 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package account
+
+import (
+	"strings"
+
+	"github.com/hexya-erp/hexya/src/actions"
+	"github.com/hexya-erp/hexya/src/models"
+	"github.com/hexya-erp/hexya/src/models/types"
+	"github.com/hexya-erp/hexya/src/models/types/dates"
+	"github.com/hexya-erp/hexya/src/tools/strutils"
+	"github.com/hexya-erp/pool/h"
+	"github.com/hexya-erp/pool/m"
+	"github.com/hexya-erp/pool/q"
+)
+
+func init() {
+
+	h.AccountAccount().AddFields(map[string]models.FieldDefinition{
+		"MergedInto": models.Many2OneField{
+			String:        "Merged Into",
+			RelationModel: h.AccountAccount(),
+			ReadOnly:      true,
+			NoCopy:        true,
+			Help:          "Account to which the history of this account has been moved"},
+	})
+
+	h.AccountAccountMerge().DeclareTransientModel()
+	h.AccountAccountMerge().AddFields(map[string]models.FieldDefinition{
+		"Company": models.Many2OneField{
+			RelationModel: h.Company(),
+			Required:      true,
+			Default: func(env models.Environment) interface{} {
+				return h.User().NewSet(env).CurrentUser().Company()
+			}},
+		"SourceAccounts": models.Many2ManyField{
+			String:        "Accounts to Merge",
+			RelationModel: h.AccountAccount(),
+			JSON:          "source_account_ids",
+			Required:      true,
+			Default: func(env models.Environment) interface{} {
+				if !strutils.IsIn(env.Context().GetString("active_model"), "account.account", "AccountAccount") {
+					return h.AccountAccount().NewSet(env)
+				}
+				return h.AccountAccount().Browse(env, env.Context().GetIntegerSlice("active_ids"))
+			}},
+		"TargetAccount": models.Many2OneField{
+			RelationModel: h.AccountAccount(),
+			Required:      true,
+			Filter:        q.AccountAccount().Deprecated().Equals(false)},
+		"State": models.SelectionField{
+			Selection: types.Selection{
+				"draft": "Draft",
+				"done":  "Done"},
+			Default: models.DefaultValue("draft")},
+		"Log": models.TextField{
+			ReadOnly: true},
+	})
+
+	h.AccountAccountMerge().Methods().CheckCompatibility().DeclareMethod(
+		`CheckCompatibility panics if the source accounts cannot be merged into the target account,
+		i.e. if they belong to another company, or have another internal type, reconciliation flag or currency.`,
+		func(rs m.AccountAccountMergeSet) {
+			target := rs.TargetAccount()
+			if target.Deprecated() {
+				panic(rs.T(`The target account %s is deprecated.`, target.NameGet()))
+			}
+			if !target.Company().Equals(rs.Company()) {
+				panic(rs.T(`The target account %s does not belong to company %s.`, target.NameGet(), rs.Company().Name()))
+			}
+			for _, source := range rs.SourceAccounts().Records() {
+				switch {
+				case source.Equals(target):
+					panic(rs.T(`The target account cannot be one of the accounts to merge.`))
+				case !source.Company().Equals(target.Company()):
+					panic(rs.T(`Account %s belongs to another company than the target account.`, source.NameGet()))
+				case source.InternalType() != target.InternalType(),
+					source.UserType().IncludeInitialBalance() != target.UserType().IncludeInitialBalance():
+					panic(rs.T(`Account %s cannot be merged into %s because their types %s and %s are not compatible.`,
+						source.NameGet(), target.NameGet(), source.UserType().Name(), target.UserType().Name()))
+				case source.Reconcile() != target.Reconcile():
+					panic(rs.T(`Account %s cannot be merged into %s because only one of them allows reconciliation.`,
+						source.NameGet(), target.NameGet()))
+				case target.Currency().IsNotEmpty() && !source.Currency().Equals(target.Currency()):
+					panic(rs.T(`Account %s cannot be merged into %s because the target account is in another currency.`,
+						source.NameGet(), target.NameGet()))
+				}
+			}
+		})
+
+	h.AccountAccountMerge().Methods().MergeMoveLines().DeclareMethod(
+		`MergeMoveLines moves the journal items of the source accounts to the target account and
+		returns the log of the changes. It panics if one of the entries is locked.`,
+		func(rs m.AccountAccountMergeSet) []string {
+			var log []string
+			lines := h.AccountMoveLine().Search(rs.Env(), q.AccountMoveLine().Account().In(rs.SourceAccounts()))
+			if lines.IsEmpty() {
+				return log
+			}
+			moves := h.AccountMove().NewSet(rs.Env())
+			for _, line := range lines.Records() {
+				moves = moves.Union(line.Move())
+			}
+			moves.CheckLockDate()
+			analyticLines := h.AccountAnalyticLine().Search(rs.Env(), q.AccountAnalyticLine().Move().In(lines))
+			// Posted and reconciled items are moved on purpose, so the account is not written through
+			// the ORM which forbids it. Lock dates have been checked above and the change is audited.
+			auditLog := h.AccountAuditLog().NewSet(rs.Env())
+			auditedLines := lines.WithContext("audit_operation", "merge")
+			before := auditLog.SnapshotValues(auditedLines, []string{"Account"})
+			rs.Env().Cr().Execute(`
+				UPDATE account_move_line
+				SET account_id = ?, write_uid = ?, write_date = ?
+				WHERE id IN (?)`,
+				rs.TargetAccount().ID(), rs.Env().Uid(), dates.Now(), lines.Ids())
+			lines.Collection().InvalidateCache()
+			auditLog.LogChanges(auditedLines, before)
+			log = append(log, rs.T(`Journal items: %d in %d entries`, lines.Len(), moves.Len()))
+			if analyticLines.IsNotEmpty() {
+				log = append(log, rs.T(`Analytic lines: %d`, analyticLines.Len()))
+			}
+			return log
+		})
+
+	h.AccountAccountMerge().Methods().MergeDocuments().DeclareMethod(
+		`MergeDocuments replaces the source accounts by the target account on invoices, invoice lines
+		and invoice tax lines, and returns the log of the changes.`,
+		func(rs m.AccountAccountMergeSet) []string {
+			var log []string
+			sources, target := rs.SourceAccounts(), rs.TargetAccount()
+			if invoices := h.AccountInvoice().Search(rs.Env(), q.AccountInvoice().Account().In(sources)); invoices.IsNotEmpty() {
+				log = append(log, rs.T(`Invoices: %d`, invoices.Len()))
+				invoices.WithContext("audit_operation", "merge").SetAccount(target)
+			}
+			if lines := h.AccountInvoiceLine().Search(rs.Env(), q.AccountInvoiceLine().Account().In(sources)); lines.IsNotEmpty() {
+				log = append(log, rs.T(`Invoice lines: %d`, lines.Len()))
+				lines.SetAccount(target)
+			}
+			if taxLines := h.AccountInvoiceTax().Search(rs.Env(), q.AccountInvoiceTax().Account().In(sources)); taxLines.IsNotEmpty() {
+				log = append(log, rs.T(`Invoice tax lines: %d`, taxLines.Len()))
+				taxLines.SetAccount(target)
+			}
+			return log
+		})
+
+	h.AccountAccountMerge().Methods().MergeTaxes().DeclareMethod(
+		`MergeTaxes replaces the source accounts by the target account on taxes and their
+		repartition lines, and returns the log of the changes.`,
+		func(rs m.AccountAccountMergeSet) []string {
+			var log []string
+			sources, target := rs.SourceAccounts(), rs.TargetAccount()
+			taxEnv := h.AccountTax().NewSet(rs.Env()).WithContext("active_test", false)
+			if taxes := taxEnv.Search(q.AccountTax().Account().In(sources)); taxes.IsNotEmpty() {
+				log = append(log, rs.T(`Taxes: %d`, taxes.Len()))
+				taxes.SetAccount(target)
+			}
+			if taxes := taxEnv.Search(q.AccountTax().RefundAccount().In(sources)); taxes.IsNotEmpty() {
+				log = append(log, rs.T(`Taxes on refunds: %d`, taxes.Len()))
+				taxes.SetRefundAccount(target)
+			}
+			repartitionLines := h.AccountTaxRepartitionLine().Search(rs.Env(),
+				q.AccountTaxRepartitionLine().Account().In(sources).Or().RefundAccount().In(sources))
+			for _, line := range repartitionLines.Records() {
+				data := h.AccountTaxRepartitionLine().NewData()
+				if line.Account().Intersect(sources).IsNotEmpty() {
+					data.SetAccount(target)
+				}
+				if line.RefundAccount().Intersect(sources).IsNotEmpty() {
+					data.SetRefundAccount(target)
+				}
+				line.Write(data)
+			}
+			if repartitionLines.IsNotEmpty() {
+				log = append(log, rs.T(`Tax repartition lines: %d`, repartitionLines.Len()))
+			}
+			return log
+		})
+
+	h.AccountAccountMerge().Methods().MergeFiscalPositions().DeclareMethod(
+		`MergeFiscalPositions replaces the source accounts by the target account in fiscal position
+		account mappings, and returns the log of the changes. Mappings which become duplicates or
+		which would map the target account onto itself are deleted.`,
+		func(rs m.AccountAccountMergeSet) []string {
+			var log []string
+			sources, target := rs.SourceAccounts(), rs.TargetAccount()
+			mappings := h.AccountFiscalPositionAccount().Search(rs.Env(),
+				q.AccountFiscalPositionAccount().AccountSrc().In(sources).Or().AccountDest().In(sources))
+			var updated, deleted int
+			for _, mapping := range mappings.Records() {
+				src, dest := mapping.AccountSrc(), mapping.AccountDest()
+				if src.Intersect(sources).IsNotEmpty() {
+					src = target
+				}
+				if dest.Intersect(sources).IsNotEmpty() {
+					dest = target
+				}
+				duplicates := h.AccountFiscalPositionAccount().Search(rs.Env(),
+					q.AccountFiscalPositionAccount().Position().Equals(mapping.Position()).
+						And().AccountSrc().Equals(src).
+						And().AccountDest().Equals(dest).
+						And().ID().NotEquals(mapping.ID()))
+				if src.Equals(dest) || duplicates.IsNotEmpty() {
+					mapping.Unlink()
+					deleted++
+					continue
+				}
+				mapping.Write(h.AccountFiscalPositionAccount().NewData().
+					SetAccountSrc(src).
+					SetAccountDest(dest))
+				updated++
+			}
+			if updated > 0 {
+				log = append(log, rs.T(`Fiscal position mappings updated: %d`, updated))
+			}
+			if deleted > 0 {
+				log = append(log, rs.T(`Fiscal position mappings deleted: %d`, deleted))
+			}
+			return log
+		})
+
+	h.AccountAccountMerge().Methods().MergeProperties().DeclareMethod(
+		`MergeProperties replaces the source accounts by the target account in the income and expense
+		accounts of products and product categories and in the receivable and payable accounts of
+		partners for the company of the wizard, and returns the log of the changes.`,
+		func(rs m.AccountAccountMergeSet) []string {
+			var log []string
+			sources, target := rs.SourceAccounts(), rs.TargetAccount()
+			env := h.ProductTemplate().NewSet(rs.Env()).WithContext("force_company", rs.Company().ID()).Env()
+			if products := h.ProductTemplate().Search(env, q.ProductTemplate().PropertyAccountIncome().In(sources)); products.IsNotEmpty() {
+				log = append(log, rs.T(`Products (income account): %d`, products.Len()))
+				products.SetPropertyAccountIncome(target)
+			}
+			if products := h.ProductTemplate().Search(env, q.ProductTemplate().PropertyAccountExpense().In(sources)); products.IsNotEmpty() {
+				log = append(log, rs.T(`Products (expense account): %d`, products.Len()))
+				products.SetPropertyAccountExpense(target)
+			}
+			if categories := h.ProductCategory().Search(env, q.ProductCategory().PropertyAccountIncomeCateg().In(sources)); categories.IsNotEmpty() {
+				log = append(log, rs.T(`Product categories (income account): %d`, categories.Len()))
+				categories.SetPropertyAccountIncomeCateg(target)
+			}
+			if categories := h.ProductCategory().Search(env, q.ProductCategory().PropertyAccountExpenseCateg().In(sources)); categories.IsNotEmpty() {
+				log = append(log, rs.T(`Product categories (expense account): %d`, categories.Len()))
+				categories.SetPropertyAccountExpenseCateg(target)
+			}
+			if partners := h.Partner().Search(env, q.Partner().PropertyAccountReceivable().In(sources)); partners.IsNotEmpty() {
+				log = append(log, rs.T(`Partners (receivable account): %d`, partners.Len()))
+				partners.SetPropertyAccountReceivable(target)
+			}
+			if partners := h.Partner().Search(env, q.Partner().PropertyAccountPayable().In(sources)); partners.IsNotEmpty() {
+				log = append(log, rs.T(`Partners (payable account): %d`, partners.Len()))
+				partners.SetPropertyAccountPayable(target)
+			}
+			return log
+		})
+
+	h.AccountAccountMerge().Methods().MergeJournals().DeclareMethod(
+		`MergeJournals replaces the source accounts by the target account in the default, profit and
+		loss accounts of journals, and returns the log of the changes.`,
+		func(rs m.AccountAccountMergeSet) []string {
+			var log []string
+			sources, target := rs.SourceAccounts(), rs.TargetAccount()
+			journals := h.AccountJournal().Search(rs.Env(),
+				q.AccountJournal().DefaultDebitAccount().In(sources).
+					Or().DefaultCreditAccount().In(sources).
+					Or().ProfitAccount().In(sources).
+					Or().LossAccount().In(sources))
+			for _, journal := range journals.Records() {
+				data := h.AccountJournal().NewData()
+				if journal.DefaultDebitAccount().Intersect(sources).IsNotEmpty() {
+					data.SetDefaultDebitAccount(target)
+				}
+				if journal.DefaultCreditAccount().Intersect(sources).IsNotEmpty() {
+					data.SetDefaultCreditAccount(target)
+				}
+				if journal.ProfitAccount().Intersect(sources).IsNotEmpty() {
+					data.SetProfitAccount(target)
+				}
+				if journal.LossAccount().Intersect(sources).IsNotEmpty() {
+					data.SetLossAccount(target)
+				}
+				journal.Write(data)
+			}
+			if journals.IsNotEmpty() {
+				log = append(log, rs.T(`Journals: %d`, journals.Len()))
+			}
+			return log
+		})
+
+	h.AccountAccountMerge().Methods().ActionMerge().DeclareMethod(
+		`ActionMerge moves all the history and settings of the source accounts to the target account,
+		deprecates the source accounts and records the merge in the audit trail.`,
+		func(rs m.AccountAccountMergeSet) *actions.Action {
+			rs.EnsureOne()
+			rs.CheckCompatibility()
+			target := rs.TargetAccount()
+			var log []string
+			log = append(log, rs.MergeMoveLines()...)
+			log = append(log, rs.MergeDocuments()...)
+			log = append(log, rs.MergeTaxes()...)
+			log = append(log, rs.MergeFiscalPositions()...)
+			log = append(log, rs.MergeProperties()...)
+			log = append(log, rs.MergeJournals()...)
+
+			auditLog := h.AccountAuditLog().NewSet(rs.Env())
+			for _, source := range rs.SourceAccounts().Records() {
+				source.Write(h.AccountAccount().NewData().
+					SetDeprecated(true).
+					SetMergedInto(target))
+				auditLog.CreateEntry(source, "merge", "MergedInto", "", target.NameGet())
+				log = append(log, rs.T(`Account %s merged into %s`, source.NameGet(), target.NameGet()))
+			}
+
+			rs.Write(h.AccountAccountMerge().NewData().
+				SetState("done").
+				SetLog(strings.Join(log, "\n")))
+			return &actions.Action{
+				Type:     actions.ActionActWindow,
+				Model:    "AccountAccountMerge",
+				ViewMode: "form",
+				ResID:    rs.ID(),
+				Target:   "new",
+			}
+		})
+
+}
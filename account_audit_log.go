@@ -0,0 +1,417 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package account
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/csv"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/hexya-erp/hexya/src/actions"
+	"github.com/hexya-erp/hexya/src/models"
+	"github.com/hexya-erp/hexya/src/models/fieldtype"
+	"github.com/hexya-erp/hexya/src/models/types"
+	"github.com/hexya-erp/hexya/src/models/types/dates"
+	"github.com/hexya-erp/pool/h"
+	"github.com/hexya-erp/pool/m"
+	"github.com/hexya-erp/pool/q"
+)
+
+// auditedFields lists for each audited model the fields whose last value
+// is recorded in the audit trail when a record is deleted.
+var auditedFields = map[string][]string{
+	"AccountMove":     {"Name", "Ref", "Date", "Journal", "State", "Partner", "Amount", "Narration"},
+	"AccountMoveLine": {"Name", "Account", "Partner", "Debit", "Credit", "AmountCurrency", "Currency", "Taxes", "TaxLine", "AnalyticAccount", "DateMaturity"},
+	"AccountInvoice":  {"Number", "Type", "State", "Partner", "DateInvoice", "DateDue", "Journal", "Account", "AmountUntaxed", "AmountTax", "AmountTotal", "Currency"},
+	"AccountPayment":  {"Name", "State", "PaymentType", "Partner", "Amount", "Currency", "PaymentDate", "Journal", "Communication"},
+}
+
+// auditIgnoredFields are technical fields that are never written to the audit trail.
+var auditIgnoredFields = map[string]bool{
+	"id":            true,
+	"create_date":   true,
+	"create_uid":    true,
+	"write_date":    true,
+	"write_uid":     true,
+	"display_name":  true,
+	"__last_update": true,
+}
+
+func init() {
+
+	h.AccountAuditLog().DeclareModel()
+	h.AccountAuditLog().SetDefaultOrder("Date DESC", "ID DESC")
+
+	h.AccountAuditLog().AddFields(map[string]models.FieldDefinition{
+		"Date": models.DateTimeField{
+			String:   "Date",
+			Required: true,
+			ReadOnly: true,
+			Index:    true,
+			Default: func(env models.Environment) interface{} {
+				return dates.Now()
+			}},
+		"User": models.Many2OneField{
+			RelationModel: h.User(),
+			ReadOnly:      true},
+		"ResModel": models.CharField{
+			String:   "Document Model",
+			Required: true,
+			ReadOnly: true,
+			Index:    true},
+		"ResID": models.IntegerField{
+			String:   "Document ID",
+			Required: true,
+			ReadOnly: true,
+			Index:    true},
+		"ResName": models.CharField{
+			String:   "Document",
+			ReadOnly: true},
+		"Operation": models.SelectionField{
+			Selection: types.Selection{
+				"write":  "Modification",
+				"unlink": "Deletion",
+				"post":   "Posting",
+				"cancel": "Cancellation",
+				"merge":  "Account Merge"},
+			Required: true,
+			ReadOnly: true},
+		"FieldName": models.CharField{
+			String:   "Field",
+			ReadOnly: true},
+		"FieldDescription": models.CharField{
+			String:   "Field Label",
+			ReadOnly: true},
+		"OldValue": models.TextField{
+			String:   "Old Value",
+			ReadOnly: true},
+		"NewValue": models.TextField{
+			String:   "New Value",
+			ReadOnly: true},
+		"Company": models.Many2OneField{
+			RelationModel: h.Company(),
+			ReadOnly:      true},
+	})
+
+	h.AccountAuditLog().Methods().FormatValue().DeclareMethod(
+		`FormatValue returns the human readable representation of the given field value
+		as it should be stored in the audit trail.`,
+		func(rs m.AccountAuditLogSet, value interface{}, info *models.FieldInfo) string {
+			switch val := value.(type) {
+			case nil:
+				return ""
+			case models.RecordSet:
+				var names []string
+				for _, rec := range val.Collection().Records() {
+					names = append(names, rec.Call("NameGet").(string))
+				}
+				return strings.Join(names, ", ")
+			case dates.Date:
+				if val.IsZero() {
+					return ""
+				}
+				return val.String()
+			case dates.DateTime:
+				if val.IsZero() {
+					return ""
+				}
+				return val.String()
+			case float64:
+				return strconv.FormatFloat(val, 'f', -1, 64)
+			case string:
+				if info.Type == fieldtype.Selection {
+					if label, ok := info.Selection[val]; ok {
+						return label
+					}
+				}
+				return val
+			}
+			return fmt.Sprintf("%v", value)
+		})
+
+	h.AccountAuditLog().Methods().SnapshotValues().DeclareMethod(
+		`SnapshotValues returns the formatted values of the given fields for each record
+		of the given RecordSet, indexed by record ID and field name.
+		One2many and technical fields are ignored.`,
+		func(rs m.AccountAuditLogSet, records models.RecordSet, fields []string) map[int64]map[string]string {
+			res := make(map[int64]map[string]string)
+			if len(fields) == 0 {
+				return res
+			}
+			rc := records.Collection()
+			var fieldNames []models.FieldNamer
+			for _, f := range fields {
+				fieldNames = append(fieldNames, models.FieldName(f))
+			}
+			infos := rc.Model().FieldsGet(fieldNames...)
+			for _, rec := range rc.Records() {
+				values := make(map[string]string)
+				for json, info := range infos {
+					if auditIgnoredFields[json] || info.Type == fieldtype.One2Many {
+						continue
+					}
+					values[info.Name] = rs.FormatValue(rec.Get(info.Name), info)
+				}
+				res[rec.Ids()[0]] = values
+			}
+			return res
+		})
+
+	h.AccountAuditLog().Methods().LogChanges().DeclareMethod(
+		`LogChanges creates an audit trail entry for each field of the given records whose value
+		differs from the one in the given snapshot taken by SnapshotValues before the change.
+
+		The operation is read from the 'audit_operation' context key and defaults to 'write'.`,
+		func(rs m.AccountAuditLogSet, records models.RecordSet, before map[int64]map[string]string) {
+			operation := records.Env().Context().GetString("audit_operation")
+			if operation == "" {
+				operation = "write"
+			}
+			rc := records.Collection()
+			for _, rec := range rc.Records() {
+				oldValues, ok := before[rec.Ids()[0]]
+				if !ok {
+					continue
+				}
+				var fields []string
+				for f := range oldValues {
+					fields = append(fields, f)
+				}
+				newValues := rs.SnapshotValues(rec, fields)[rec.Ids()[0]]
+				for _, f := range fields {
+					if oldValues[f] == newValues[f] {
+						continue
+					}
+					rs.CreateEntry(rec, operation, f, oldValues[f], newValues[f])
+				}
+			}
+		})
+
+	h.AccountAuditLog().Methods().LogUnlink().DeclareMethod(
+		`LogUnlink records in the audit trail the last values of the audited fields of the
+		given records, which are about to be deleted.`,
+		func(rs m.AccountAuditLogSet, records models.RecordSet) {
+			rc := records.Collection()
+			fields := auditedFields[rc.ModelName()]
+			snapshot := rs.SnapshotValues(records, fields)
+			for _, rec := range rc.Records() {
+				for _, f := range fields {
+					rs.CreateEntry(rec, "unlink", f, snapshot[rec.Ids()[0]][f], "")
+				}
+			}
+		})
+
+	h.AccountAuditLog().Methods().CreateEntry().DeclareMethod(
+		`CreateEntry creates a single audit trail entry for the given record and field.
+		The entry is created as superuser so that users without access to the audit trail
+		are still traced.`,
+		func(rs m.AccountAuditLogSet, record models.RecordSet, operation, fieldName, oldValue, newValue string) m.AccountAuditLogSet {
+			rc := record.Collection()
+			info := rc.Model().FieldsGet(models.FieldName(fieldName))
+			var description string
+			for _, fi := range info {
+				description = fi.String
+			}
+			company := rc.Get("Company").(models.RecordSet).Collection().Wrap().(m.CompanySet)
+			return rs.Sudo().Create(h.AccountAuditLog().NewData().
+				SetUser(h.User().NewSet(rs.Env()).CurrentUser()).
+				SetResModel(rc.ModelName()).
+				SetResID(rc.Ids()[0]).
+				SetResName(rc.Call("NameGet").(string)).
+				SetOperation(operation).
+				SetFieldName(fieldName).
+				SetFieldDescription(description).
+				SetOldValue(oldValue).
+				SetNewValue(newValue).
+				SetCompany(company))
+		})
+
+	h.AccountAuditLog().Methods().Write().Extend("",
+		func(rs m.AccountAuditLogSet, data m.AccountAuditLogData) bool {
+			panic(rs.T(`Audit trail entries cannot be modified.`))
+		})
+
+	h.AccountAuditLog().Methods().Unlink().Extend("",
+		func(rs m.AccountAuditLogSet) int64 {
+			panic(rs.T(`Audit trail entries cannot be deleted.`))
+		})
+
+	h.AccountMove().Methods().Write().Extend("",
+		func(rs m.AccountMoveSet, data m.AccountMoveData) bool {
+			auditLog := h.AccountAuditLog().NewSet(rs.Env())
+			before := auditLog.SnapshotValues(rs, data.Underlying().Keys())
+			res := rs.Super().Write(data)
+			auditLog.LogChanges(rs, before)
+			return res
+		})
+
+	h.AccountMove().Methods().Unlink().Extend("",
+		func(rs m.AccountMoveSet) int64 {
+			h.AccountAuditLog().NewSet(rs.Env()).LogUnlink(rs)
+			return rs.Super().Unlink()
+		})
+
+	h.AccountMoveLine().Methods().Write().Extend("",
+		func(rs m.AccountMoveLineSet, data m.AccountMoveLineData) bool {
+			auditLog := h.AccountAuditLog().NewSet(rs.Env())
+			before := auditLog.SnapshotValues(rs, data.Underlying().Keys())
+			res := rs.Super().Write(data)
+			auditLog.LogChanges(rs, before)
+			return res
+		})
+
+	h.AccountMoveLine().Methods().Unlink().Extend("",
+		func(rs m.AccountMoveLineSet) int64 {
+			h.AccountAuditLog().NewSet(rs.Env()).LogUnlink(rs)
+			return rs.Super().Unlink()
+		})
+
+	h.AccountInvoice().Methods().Write().Extend("",
+		func(rs m.AccountInvoiceSet, data m.AccountInvoiceData) bool {
+			auditLog := h.AccountAuditLog().NewSet(rs.Env())
+			before := auditLog.SnapshotValues(rs, data.Underlying().Keys())
+			res := rs.Super().Write(data)
+			auditLog.LogChanges(rs, before)
+			return res
+		})
+
+	h.AccountInvoice().Methods().Unlink().Extend("",
+		func(rs m.AccountInvoiceSet) int64 {
+			h.AccountAuditLog().NewSet(rs.Env()).LogUnlink(rs)
+			return rs.Super().Unlink()
+		})
+
+	h.AccountPayment().Methods().Write().Extend("",
+		func(rs m.AccountPaymentSet, data m.AccountPaymentData) bool {
+			auditLog := h.AccountAuditLog().NewSet(rs.Env())
+			before := auditLog.SnapshotValues(rs, data.Underlying().Keys())
+			res := rs.Super().Write(data)
+			auditLog.LogChanges(rs, before)
+			return res
+		})
+
+	h.AccountPayment().Methods().Unlink().Extend("",
+		func(rs m.AccountPaymentSet) int64 {
+			h.AccountAuditLog().NewSet(rs.Env()).LogUnlink(rs)
+			return rs.Super().Unlink()
+		})
+
+	h.AccountMove().Methods().OpenAuditLog().DeclareMethod(
+		`OpenAuditLog returns an action showing the audit trail of this journal entry and of its items.`,
+		func(rs m.AccountMoveSet) *actions.Action {
+			return &actions.Action{
+				Name:     rs.T(`Audit Trail`),
+				Type:     actions.ActionActWindow,
+				Model:    "AccountAuditLog",
+				ViewMode: "tree,form",
+				Domain: fmt.Sprintf("['|', '&', ('res_model', '=', 'AccountMove'), ('res_id', '=', %d), '&', ('res_model', '=', 'AccountMoveLine'), ('res_id', 'in', %s)]",
+					rs.ID(), strings.Replace(fmt.Sprint(rs.Lines().Ids()), " ", ",", -1)),
+			}
+		})
+
+	h.AccountInvoice().Methods().OpenAuditLog().DeclareMethod(
+		`OpenAuditLog returns an action showing the audit trail of this invoice.`,
+		func(rs m.AccountInvoiceSet) *actions.Action {
+			return &actions.Action{
+				Name:     rs.T(`Audit Trail`),
+				Type:     actions.ActionActWindow,
+				Model:    "AccountAuditLog",
+				ViewMode: "tree,form",
+				Domain:   fmt.Sprintf("[('res_model', '=', 'AccountInvoice'), ('res_id', '=', %d)]", rs.ID()),
+			}
+		})
+
+	h.AccountPayment().Methods().OpenAuditLog().DeclareMethod(
+		`OpenAuditLog returns an action showing the audit trail of this payment.`,
+		func(rs m.AccountPaymentSet) *actions.Action {
+			return &actions.Action{
+				Name:     rs.T(`Audit Trail`),
+				Type:     actions.ActionActWindow,
+				Model:    "AccountAuditLog",
+				ViewMode: "tree,form",
+				Domain:   fmt.Sprintf("[('res_model', '=', 'AccountPayment'), ('res_id', '=', %d)]", rs.ID()),
+			}
+		})
+
+	h.AccountAuditLogExport().DeclareTransientModel()
+	h.AccountAuditLogExport().AddFields(map[string]models.FieldDefinition{
+		"DateFrom": models.DateField{
+			String:   "Start Date",
+			Required: true,
+			Default: func(env models.Environment) interface{} {
+				return dates.Today().StartOfMonth()
+			}},
+		"DateTo": models.DateField{
+			String:   "End Date",
+			Required: true,
+			Default: func(env models.Environment) interface{} {
+				return dates.Today()
+			}},
+		"ResModel": models.SelectionField{
+			String: "Document Type",
+			Selection: types.Selection{
+				"AccountMove":     "Journal Entries",
+				"AccountMoveLine": "Journal Items",
+				"AccountInvoice":  "Invoices",
+				"AccountPayment":  "Payments"},
+			Help: "Leave empty to export the audit trail of all documents"},
+		"Data": models.BinaryField{
+			String:   "File",
+			ReadOnly: true},
+		"FileName": models.CharField{
+			ReadOnly: true},
+	})
+
+	h.AccountAuditLogExport().Methods().ActionExport().DeclareMethod(
+		`ActionExport generates a CSV file with all the audit trail entries between the wizard's dates
+		and reopens the wizard so that the file can be downloaded.`,
+		func(rs m.AccountAuditLogExportSet) *actions.Action {
+			if rs.DateTo().Lower(rs.DateFrom()) {
+				panic(rs.T(`The end date must be after the start date.`))
+			}
+			cond := q.AccountAuditLog().Date().GreaterOrEqual(rs.DateFrom().ToDateTime()).
+				And().Date().Lower(rs.DateTo().AddDate(0, 0, 1).ToDateTime())
+			if rs.ResModel() != "" {
+				cond = cond.And().ResModel().Equals(rs.ResModel())
+			}
+			entries := h.AccountAuditLog().Search(rs.Env(), cond).OrderBy("Date", "ID")
+
+			var buf bytes.Buffer
+			w := csv.NewWriter(&buf)
+			w.Write([]string{"Date", "User", "Company", "Document Model", "Document ID", "Document",
+				"Operation", "Field", "Field Label", "Old Value", "New Value"})
+			for _, entry := range entries.Records() {
+				w.Write([]string{
+					entry.Date().String(),
+					entry.User().Name(),
+					entry.Company().Name(),
+					entry.ResModel(),
+					strconv.FormatInt(entry.ResID(), 10),
+					entry.ResName(),
+					entry.Operation(),
+					entry.FieldName(),
+					entry.FieldDescription(),
+					entry.OldValue(),
+					entry.NewValue(),
+				})
+			}
+			w.Flush()
+
+			rs.Write(h.AccountAuditLogExport().NewData().
+				SetData(base64.StdEncoding.EncodeToString(buf.Bytes())).
+				SetFileName(fmt.Sprintf("audit_trail_%s_%s.csv", rs.DateFrom().String(), rs.DateTo().String())))
+			return &actions.Action{
+				Name:     rs.T(`Export Audit Trail`),
+				Type:     actions.ActionActWindow,
+				Model:    "AccountAuditLogExport",
+				ViewMode: "form",
+				ResID:    rs.ID(),
+				Target:   "new",
+			}
+		})
+
+}
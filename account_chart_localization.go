@@ -0,0 +1,364 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package account
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/hexya-addons/account/localization"
+	"github.com/hexya-erp/hexya/src/actions"
+	"github.com/hexya-erp/hexya/src/models"
+	"github.com/hexya-erp/pool/h"
+	"github.com/hexya-erp/pool/m"
+	"github.com/hexya-erp/pool/q"
+)
+
+func init() {
+
+	h.AccountChartTemplate().AddFields(map[string]models.FieldDefinition{
+		"LocalizationCode": models.CharField{
+			String:   "Localization Package",
+			ReadOnly: true,
+			Index:    true,
+			Help:     "Code of the localization package this chart template has been loaded from"},
+		"LocalizationVersion": models.CharField{
+			String:   "Package Version",
+			ReadOnly: true},
+	})
+
+	h.AccountReconcileModelTemplate().AddFields(map[string]models.FieldDefinition{
+		"ChartTemplate": models.Many2OneField{
+			RelationModel: h.AccountChartTemplate(),
+			OnDelete:      models.Cascade},
+	})
+
+	h.AccountChartTemplate().Methods().LocalizationRecordExists().DeclareMethod(
+		`LocalizationRecordExists returns true if a record of the given model of a localization
+		package exists in the database with the given external ID.`,
+		func(rs m.AccountChartTemplateSet, model, id string) bool {
+			env := rs.Env()
+			switch model {
+			case localization.AccountTagModel:
+				return h.AccountAccountTag().Search(env, q.AccountAccountTag().HexyaExternalID().Equals(id)).IsNotEmpty()
+			case localization.TaxGroupModel:
+				return h.AccountTaxGroup().Search(env, q.AccountTaxGroup().HexyaExternalID().Equals(id)).IsNotEmpty()
+			case localization.AccountTemplateModel:
+				return h.AccountAccountTemplate().Search(env, q.AccountAccountTemplate().HexyaExternalID().Equals(id)).IsNotEmpty()
+			case localization.ChartTemplateModel:
+				return h.AccountChartTemplate().Search(env, q.AccountChartTemplate().HexyaExternalID().Equals(id)).IsNotEmpty()
+			case localization.TaxTemplateModel:
+				return h.AccountTaxTemplate().Search(env, q.AccountTaxTemplate().HexyaExternalID().Equals(id)).IsNotEmpty()
+			case localization.FiscalPositionTemplateModel:
+				return h.AccountFiscalPositionTemplate().Search(env, q.AccountFiscalPositionTemplate().HexyaExternalID().Equals(id)).IsNotEmpty()
+			case "AccountAccountType":
+				return h.AccountAccountType().Search(env, q.AccountAccountType().HexyaExternalID().Equals(id)).IsNotEmpty()
+			case "Currency":
+				return h.Currency().Search(env, q.Currency().HexyaExternalID().Equals(id)).IsNotEmpty()
+			}
+			return false
+		})
+
+	h.AccountChartTemplate().Methods().LoadLocalization().DeclareMethod(
+		`LoadLocalization creates or updates the templates of the given validated localization package
+		and returns its chart templates.
+
+		Records are identified by their external ID, so that loading a new version of a package
+		updates the templates of the previous one. Loading an older version than the one which has
+		already been loaded is not allowed.`,
+		func(rs m.AccountChartTemplateSet, pkg *localization.Package) m.AccountChartTemplateSet {
+			env := rs.Env()
+			for _, chart := range h.AccountChartTemplate().Search(env, q.AccountChartTemplate().LocalizationCode().Equals(pkg.Code)).Records() {
+				if localization.CompareVersions(chart.LocalizationVersion(), pkg.Version) > 0 {
+					panic(rs.T(`Version %s of localization package %s has already been loaded and cannot be replaced by version %s.`,
+						chart.LocalizationVersion(), pkg.Code, pkg.Version))
+				}
+			}
+
+			tags := func(ids []string) m.AccountAccountTagSet {
+				return h.AccountAccountTag().Search(env, q.AccountAccountTag().HexyaExternalID().In(ids))
+			}
+			taxGroup := func(id string) m.AccountTaxGroupSet {
+				return h.AccountTaxGroup().Search(env, q.AccountTaxGroup().HexyaExternalID().Equals(id))
+			}
+			accountTemplate := func(id string) m.AccountAccountTemplateSet {
+				return h.AccountAccountTemplate().Search(env, q.AccountAccountTemplate().HexyaExternalID().Equals(id))
+			}
+			chartTemplate := func(id string) m.AccountChartTemplateSet {
+				return h.AccountChartTemplate().Search(env, q.AccountChartTemplate().HexyaExternalID().Equals(id))
+			}
+			taxTemplates := func(ids []string) m.AccountTaxTemplateSet {
+				return h.AccountTaxTemplate().Search(env, q.AccountTaxTemplate().HexyaExternalID().In(ids))
+			}
+			taxTemplate := func(id string) m.AccountTaxTemplateSet {
+				return taxTemplates([]string{id})
+			}
+			positionTemplate := func(id string) m.AccountFiscalPositionTemplateSet {
+				return h.AccountFiscalPositionTemplate().Search(env, q.AccountFiscalPositionTemplate().HexyaExternalID().Equals(id))
+			}
+			// valueOr returns the value of the given column of row, or def if it is not set
+			valueOr := func(row localization.Row, col, def string) string {
+				if val := row.Get(col); val != "" {
+					return val
+				}
+				return def
+			}
+
+			for _, row := range pkg.Rows[localization.AccountTagModel] {
+				data := h.AccountAccountTag().NewData().
+					SetName(row.Get("Name")).
+					SetApplicability(valueOr(row, "Applicability", "accounts")).
+					SetColor(row.Int("Color"))
+				if rec := tags([]string{row.ID()}); rec.IsNotEmpty() {
+					rec.Write(data)
+					continue
+				}
+				h.AccountAccountTag().Create(env, data.SetHexyaExternalID(row.ID()))
+			}
+
+			for _, row := range pkg.Rows[localization.TaxGroupModel] {
+				data := h.AccountTaxGroup().NewData().
+					SetName(row.Get("Name"))
+				if row.Get("Sequence") != "" {
+					data.SetSequence(row.Int("Sequence"))
+				}
+				if rec := taxGroup(row.ID()); rec.IsNotEmpty() {
+					rec.Write(data)
+					continue
+				}
+				h.AccountTaxGroup().Create(env, data.SetHexyaExternalID(row.ID()))
+			}
+
+			// Accounts are created before the chart templates which require a transfer
+			// account, and then linked to their chart template.
+			for _, row := range pkg.Rows[localization.AccountTemplateModel] {
+				data := h.AccountAccountTemplate().NewData().
+					SetCode(row.Get("Code")).
+					SetName(row.Get("Name")).
+					SetUserType(h.AccountAccountType().Search(env, q.AccountAccountType().HexyaExternalID().Equals(row.Get("UserType")))).
+					SetReconcile(row.Bool("Reconcile")).
+					SetCurrency(h.Currency().Search(env, q.Currency().HexyaExternalID().Equals(row.Get("Currency")))).
+					SetTags(tags(row.List("Tags"))).
+					SetNocreate(row.Bool("Nocreate")).
+					SetNote(row.Get("Note"))
+				if rec := accountTemplate(row.ID()); rec.IsNotEmpty() {
+					rec.Write(data)
+					continue
+				}
+				h.AccountAccountTemplate().Create(env, data.SetHexyaExternalID(row.ID()))
+			}
+
+			charts := h.AccountChartTemplate().NewSet(env)
+			chartRows, _ := pkg.ChartTemplates()
+			for _, row := range chartRows {
+				data := h.AccountChartTemplate().NewData().
+					SetName(row.Get("Name")).
+					SetParent(chartTemplate(row.Get("Parent"))).
+					SetCurrency(h.Currency().Search(env, q.Currency().HexyaExternalID().Equals(row.Get("Currency")))).
+					SetVisible(row.BoolOr("Visible", true)).
+					SetUseAngloSaxon(row.Bool("UseAngloSaxon")).
+					SetCompleteTaxSet(row.BoolOr("CompleteTaxSet", true)).
+					SetBankAccountCodePrefix(row.Get("BankAccountCodePrefix")).
+					SetCashAccountCodePrefix(row.Get("CashAccountCodePrefix")).
+					SetTransferAccount(accountTemplate(row.Get("TransferAccount"))).
+					SetIncomeCurrencyExchangeAccount(accountTemplate(row.Get("IncomeCurrencyExchangeAccount"))).
+					SetExpenseCurrencyExchangeAccount(accountTemplate(row.Get("ExpenseCurrencyExchangeAccount"))).
+					SetPropertyAccountReceivable(accountTemplate(row.Get("PropertyAccountReceivable"))).
+					SetPropertyAccountPayable(accountTemplate(row.Get("PropertyAccountPayable"))).
+					SetPropertyAccountExpenseCateg(accountTemplate(row.Get("PropertyAccountExpenseCateg"))).
+					SetPropertyAccountIncomeCateg(accountTemplate(row.Get("PropertyAccountIncomeCateg"))).
+					SetPropertyAccountExpense(accountTemplate(row.Get("PropertyAccountExpense"))).
+					SetPropertyAccountIncome(accountTemplate(row.Get("PropertyAccountIncome"))).
+					SetPropertyStockAccountInputCateg(accountTemplate(row.Get("PropertyStockAccountInputCateg"))).
+					SetPropertyStockAccountOutputCateg(accountTemplate(row.Get("PropertyStockAccountOutputCateg"))).
+					SetPropertyStockValuationAccount(accountTemplate(row.Get("PropertyStockValuationAccount"))).
+					SetLocalizationCode(pkg.Code).
+					SetLocalizationVersion(pkg.Version)
+				if row.Get("CodeDigits") != "" {
+					data.SetCodeDigits(row.Int("CodeDigits"))
+				}
+				rec := chartTemplate(row.ID())
+				if rec.IsNotEmpty() {
+					rec.Write(data)
+				} else {
+					rec = h.AccountChartTemplate().Create(env, data.SetHexyaExternalID(row.ID()))
+				}
+				charts = charts.Union(rec)
+			}
+			for _, row := range pkg.Rows[localization.AccountTemplateModel] {
+				accountTemplate(row.ID()).SetChartTemplate(chartTemplate(row.Get("ChartTemplate")))
+			}
+
+			for _, row := range pkg.Rows[localization.TaxTemplateModel] {
+				data := h.AccountTaxTemplate().NewData().
+					SetChartTemplate(chartTemplate(row.Get("ChartTemplate"))).
+					SetName(row.Get("Name")).
+					SetDescription(row.Get("Description")).
+					SetTypeTaxUse(row.Get("TypeTaxUse")).
+					SetAmountType(valueOr(row, "AmountType", "percent")).
+					SetAmount(row.Float("Amount")).
+					SetAccount(accountTemplate(row.Get("Account"))).
+					SetRefundAccount(accountTemplate(row.Get("RefundAccount"))).
+					SetPriceInclude(row.Bool("PriceInclude")).
+					SetIncludeBaseAmount(row.Bool("IncludeBaseAmount")).
+					SetAnalytic(row.Bool("Analytic")).
+					SetTaxAdjustment(row.Bool("TaxAdjustment")).
+					SetActive(row.BoolOr("Active", true)).
+					SetTags(tags(row.List("Tags"))).
+					SetTaxGroup(taxGroup(row.Get("TaxGroup")))
+				if row.Get("Sequence") != "" {
+					data.SetSequence(row.Int("Sequence"))
+				}
+				if rec := taxTemplate(row.ID()); rec.IsNotEmpty() {
+					rec.Write(data)
+					continue
+				}
+				h.AccountTaxTemplate().Create(env, data.SetHexyaExternalID(row.ID()))
+			}
+			// Children taxes and default taxes of accounts are set once all taxes exist
+			for _, row := range pkg.Rows[localization.TaxTemplateModel] {
+				taxTemplate(row.ID()).SetChildrenTaxes(taxTemplates(row.List("ChildrenTaxes")))
+			}
+			for _, row := range pkg.Rows[localization.AccountTemplateModel] {
+				accountTemplate(row.ID()).SetTaxes(taxTemplates(row.List("Taxes")))
+			}
+
+			for _, row := range pkg.Rows[localization.FiscalPositionTemplateModel] {
+				data := h.AccountFiscalPositionTemplate().NewData().
+					SetChartTemplate(chartTemplate(row.Get("ChartTemplate"))).
+					SetName(row.Get("Name")).
+					SetNote(row.Get("Note"))
+				if rec := positionTemplate(row.ID()); rec.IsNotEmpty() {
+					rec.Write(data)
+					continue
+				}
+				h.AccountFiscalPositionTemplate().Create(env, data.SetHexyaExternalID(row.ID()))
+			}
+
+			for _, row := range pkg.Rows[localization.FiscalPositionTaxModel] {
+				data := h.AccountFiscalPositionTaxTemplate().NewData().
+					SetPosition(positionTemplate(row.Get("Position"))).
+					SetTaxSrc(taxTemplate(row.Get("TaxSrc"))).
+					SetTaxDest(taxTemplate(row.Get("TaxDest")))
+				rec := h.AccountFiscalPositionTaxTemplate().Search(env,
+					q.AccountFiscalPositionTaxTemplate().HexyaExternalID().Equals(row.ID()))
+				if rec.IsNotEmpty() {
+					rec.Write(data)
+					continue
+				}
+				h.AccountFiscalPositionTaxTemplate().Create(env, data.SetHexyaExternalID(row.ID()))
+			}
+
+			for _, row := range pkg.Rows[localization.FiscalPositionAccountModel] {
+				data := h.AccountFiscalPositionAccountTemplate().NewData().
+					SetPosition(positionTemplate(row.Get("Position"))).
+					SetAccountSrc(accountTemplate(row.Get("AccountSrc"))).
+					SetAccountDest(accountTemplate(row.Get("AccountDest")))
+				rec := h.AccountFiscalPositionAccountTemplate().Search(env,
+					q.AccountFiscalPositionAccountTemplate().HexyaExternalID().Equals(row.ID()))
+				if rec.IsNotEmpty() {
+					rec.Write(data)
+					continue
+				}
+				h.AccountFiscalPositionAccountTemplate().Create(env, data.SetHexyaExternalID(row.ID()))
+			}
+
+			for _, row := range pkg.Rows[localization.ReconcileModelTemplateModel] {
+				data := h.AccountReconcileModelTemplate().NewData().
+					SetChartTemplate(chartTemplate(row.Get("ChartTemplate"))).
+					SetName(row.Get("Name")).
+					SetAccount(accountTemplate(row.Get("Account"))).
+					SetLabel(row.Get("Label")).
+					SetAmountType(valueOr(row, "AmountType", "percentage")).
+					SetAmount(row.Float("Amount")).
+					SetTax(taxTemplate(row.Get("Tax"))).
+					SetHasSecondLine(row.Bool("HasSecondLine")).
+					SetSecondAccount(accountTemplate(row.Get("SecondAccount"))).
+					SetSecondLabel(row.Get("SecondLabel")).
+					SetSecondAmountType(valueOr(row, "SecondAmountType", "percentage")).
+					SetSecondAmount(row.Float("SecondAmount")).
+					SetSecondTax(taxTemplate(row.Get("SecondTax")))
+				if row.Get("Amount") == "" {
+					data.SetAmount(100)
+				}
+				if row.Get("SecondAmount") == "" {
+					data.SetSecondAmount(100)
+				}
+				if row.Get("Sequence") != "" {
+					data.SetSequence(row.Int("Sequence"))
+				}
+				rec := h.AccountReconcileModelTemplate().Search(env,
+					q.AccountReconcileModelTemplate().HexyaExternalID().Equals(row.ID()))
+				if rec.IsNotEmpty() {
+					rec.Write(data)
+					continue
+				}
+				h.AccountReconcileModelTemplate().Create(env, data.SetHexyaExternalID(row.ID()))
+			}
+
+			return charts
+		})
+
+	h.AccountChartLocalizationImport().DeclareTransientModel()
+	h.AccountChartLocalizationImport().AddFields(map[string]models.FieldDefinition{
+		"Path": models.CharField{
+			String:   "Localization Package",
+			Required: true,
+			Help:     "Path on the server of the directory or zip archive of the localization package"},
+		"Report": models.TextField{
+			String:   "Validation Report",
+			ReadOnly: true},
+	})
+
+	h.AccountChartLocalizationImport().Methods().ReadPackage().DeclareMethod(
+		`ReadPackage reads and validates the localization package of this wizard.
+		It returns the package and the list of errors found in its files.`,
+		func(rs m.AccountChartLocalizationImportSet) (*localization.Package, localization.Errors) {
+			rs.EnsureOne()
+			pkg, err := localization.Open(rs.Path())
+			if errs, ok := err.(localization.Errors); ok {
+				return nil, errs
+			}
+			if err != nil {
+				panic(rs.T(`Unable to read localization package: %s`, err.Error()))
+			}
+			return pkg, pkg.Validate(h.AccountChartTemplate().NewSet(rs.Env()).LocalizationRecordExists)
+		})
+
+	h.AccountChartLocalizationImport().Methods().ActionValidate().DeclareMethod(
+		`ActionValidate checks the localization package without loading it and displays the result`,
+		func(rs m.AccountChartLocalizationImportSet) *actions.Action {
+			pkg, errs := rs.ReadPackage()
+			if len(errs) > 0 {
+				rs.SetReport(rs.T("%d errors found:\n%s", len(errs), errs.Error()))
+			} else {
+				rs.SetReport(rs.T(`Localization package %s version %s is valid.`, pkg.Code, pkg.Version))
+			}
+			return &actions.Action{
+				Type:     actions.ActionActWindow,
+				Model:    "AccountChartLocalizationImport",
+				ViewMode: "form",
+				ResID:    rs.ID(),
+				Target:   "new",
+			}
+		})
+
+	h.AccountChartLocalizationImport().Methods().ActionImport().DeclareMethod(
+		`ActionImport loads the localization package and displays its chart templates`,
+		func(rs m.AccountChartLocalizationImportSet) *actions.Action {
+			pkg, errs := rs.ReadPackage()
+			if len(errs) > 0 {
+				panic(rs.T("The localization package is invalid:\n%s", errs.Error()))
+			}
+			charts := h.AccountChartTemplate().NewSet(rs.Env()).LoadLocalization(pkg)
+			return &actions.Action{
+				Name:     rs.T(`Chart of Accounts Templates`),
+				Type:     actions.ActionActWindow,
+				Model:    "AccountChartTemplate",
+				ViewMode: "tree,form",
+				Domain:   fmt.Sprintf("[('id', 'in', %s)]", strings.Replace(fmt.Sprint(charts.Ids()), " ", ", ", -1)),
+			}
+		})
+
+}
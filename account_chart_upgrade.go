@@ -0,0 +1,562 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package account
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hexya-erp/hexya/src/actions"
+	"github.com/hexya-erp/hexya/src/models"
+	"github.com/hexya-erp/hexya/src/models/types"
+	"github.com/hexya-erp/pool/h"
+	"github.com/hexya-erp/pool/m"
+	"github.com/hexya-erp/pool/q"
+)
+
+func init() {
+
+	h.AccountAccount().AddFields(map[string]models.FieldDefinition{
+		"AccountTemplate": models.Many2OneField{
+			RelationModel: h.AccountAccountTemplate(),
+			OnDelete:      models.Restrict,
+			ReadOnly:      true,
+			Copy:          false,
+			Help:          "Template this account has been generated from"},
+	})
+
+	h.AccountTax().AddFields(map[string]models.FieldDefinition{
+		"TaxTemplate": models.Many2OneField{
+			RelationModel: h.AccountTaxTemplate(),
+			OnDelete:      models.Restrict,
+			ReadOnly:      true,
+			Copy:          false,
+			Help:          "Template this tax has been generated from"},
+	})
+
+	h.AccountFiscalPosition().AddFields(map[string]models.FieldDefinition{
+		"FiscalPositionTemplate": models.Many2OneField{
+			RelationModel: h.AccountFiscalPositionTemplate(),
+			OnDelete:      models.Restrict,
+			ReadOnly:      true,
+			Copy:          false,
+			Help:          "Template this fiscal position has been generated from"},
+	})
+
+	h.AccountChartUpgrade().DeclareTransientModel()
+	h.AccountChartUpgrade().AddFields(map[string]models.FieldDefinition{
+		"Company": models.Many2OneField{
+			RelationModel: h.Company(),
+			Required:      true,
+			Default: func(env models.Environment) interface{} {
+				return h.User().NewSet(env).CurrentUser().Company()
+			}},
+		"ChartTemplate": models.Many2OneField{
+			RelationModel: h.AccountChartTemplate(),
+			Related:       "Company.ChartTemplate"},
+		"Lines": models.One2ManyField{
+			String:        "Changes",
+			RelationModel: h.AccountChartUpgradeLine(),
+			ReverseFK:     "Upgrade",
+			JSON:          "line_ids"},
+	})
+
+	h.AccountChartUpgradeLine().DeclareTransientModel()
+	h.AccountChartUpgradeLine().SetDefaultOrder("Type", "Operation", "Name")
+	h.AccountChartUpgradeLine().AddFields(map[string]models.FieldDefinition{
+		"Upgrade": models.Many2OneField{
+			RelationModel: h.AccountChartUpgrade(),
+			Required:      true,
+			OnDelete:      models.Cascade},
+		"Selected": models.BooleanField{
+			String: "Apply"},
+		"Type": models.SelectionField{
+			Selection: types.Selection{
+				"account":         "Account",
+				"tax":             "Tax",
+				"fiscal_position": "Fiscal Position",
+				"tag":             "Tags"},
+			Required: true},
+		"Operation": models.SelectionField{
+			Selection: types.Selection{
+				"add":    "Added",
+				"update": "Changed",
+				"remove": "Removed"},
+			Required: true},
+		"Name":    models.CharField{},
+		"Changes": models.TextField{},
+		"Used": models.BooleanField{
+			String: "Used in Journal Items",
+			Help:   "Records used in journal items are never modified by the upgrade"},
+		"AccountTemplate": models.Many2OneField{
+			RelationModel: h.AccountAccountTemplate()},
+		"Account": models.Many2OneField{
+			RelationModel: h.AccountAccount()},
+		"TaxTemplate": models.Many2OneField{
+			RelationModel: h.AccountTaxTemplate()},
+		"Tax": models.Many2OneField{
+			RelationModel: h.AccountTax()},
+		"PositionTemplate": models.Many2OneField{
+			String:        "Fiscal Position Template",
+			RelationModel: h.AccountFiscalPositionTemplate()},
+		"Position": models.Many2OneField{
+			String:        "Fiscal Position",
+			RelationModel: h.AccountFiscalPosition()},
+	})
+
+	h.AccountChartUpgrade().Methods().Charts().DeclareMethod(
+		`Charts returns the chart template of the company of this wizard and its parents`,
+		func(rs m.AccountChartUpgradeSet) m.AccountChartTemplateSet {
+			res := h.AccountChartTemplate().NewSet(rs.Env())
+			for chart := rs.Company().ChartTemplate(); chart.IsNotEmpty() && chart.Intersect(res).IsEmpty(); chart = chart.Parent() {
+				res = res.Union(chart)
+			}
+			return res
+		})
+
+	h.AccountChartUpgrade().Methods().TemplateAccount().DeclareMethod(
+		`TemplateAccount returns the account of the company of this wizard generated from the given template.
+
+		Accounts installed before templates were linked to their accounts are found by their external ID.`,
+		func(rs m.AccountChartUpgradeSet, template m.AccountAccountTemplateSet) m.AccountAccountSet {
+			res := h.AccountAccount().Search(rs.Env(), q.AccountAccount().AccountTemplate().Equals(template).
+				And().Company().Equals(rs.Company()))
+			if res.IsEmpty() && template.HexyaExternalID() != "" {
+				res = h.AccountAccount().Search(rs.Env(), q.AccountAccount().
+					HexyaExternalID().Equals(fmt.Sprintf("%d_%s", rs.Company().ID(), template.HexyaExternalID())))
+			}
+			return res.Limit(1)
+		})
+
+	h.AccountChartUpgrade().Methods().TemplateTax().DeclareMethod(
+		`TemplateTax returns the tax of the company of this wizard generated from the given template.`,
+		func(rs m.AccountChartUpgradeSet, template m.AccountTaxTemplateSet) m.AccountTaxSet {
+			res := h.AccountTax().Search(rs.Env(), q.AccountTax().TaxTemplate().Equals(template).
+				And().Company().Equals(rs.Company()))
+			if res.IsEmpty() && template.HexyaExternalID() != "" {
+				res = h.AccountTax().Search(rs.Env(), q.AccountTax().
+					HexyaExternalID().Equals(fmt.Sprintf("%d_%s", rs.Company().ID(), template.HexyaExternalID())))
+			}
+			return res.Limit(1)
+		})
+
+	h.AccountChartUpgrade().Methods().TemplatePosition().DeclareMethod(
+		`TemplatePosition returns the fiscal position of the company of this wizard generated from the given template.`,
+		func(rs m.AccountChartUpgradeSet, template m.AccountFiscalPositionTemplateSet) m.AccountFiscalPositionSet {
+			res := h.AccountFiscalPosition().Search(rs.Env(), q.AccountFiscalPosition().FiscalPositionTemplate().Equals(template).
+				And().Company().Equals(rs.Company()))
+			if res.IsEmpty() && template.HexyaExternalID() != "" {
+				res = h.AccountFiscalPosition().Search(rs.Env(), q.AccountFiscalPosition().
+					HexyaExternalID().Equals(fmt.Sprintf("%d_%s", rs.Company().ID(), template.HexyaExternalID())))
+			}
+			return res.Limit(1)
+		})
+
+	h.AccountChartUpgrade().Methods().Templates().DeclareMethod(
+		`Templates returns the account, tax and fiscal position templates of the chart of the company`,
+		func(rs m.AccountChartUpgradeSet) (m.AccountAccountTemplateSet, m.AccountTaxTemplateSet, m.AccountFiscalPositionTemplateSet) {
+			charts := rs.Charts()
+			accounts := h.AccountAccountTemplate().Search(rs.Env(), q.AccountAccountTemplate().ChartTemplate().In(charts).
+				And().Nocreate().NotEquals(true))
+			taxes := h.AccountTaxTemplate().Search(rs.Env(), q.AccountTaxTemplate().ChartTemplate().In(charts).
+				And().Active().Equals(true))
+			positions := h.AccountFiscalPositionTemplate().Search(rs.Env(), q.AccountFiscalPositionTemplate().ChartTemplate().In(charts))
+			return accounts, taxes, positions
+		})
+
+	h.AccountChartUpgrade().Methods().TemplateRefs().DeclareMethod(
+		`TemplateRefs returns the mappings between the ids of the account and tax templates of the chart
+		and the ids of the accounts and taxes of the company generated from them.`,
+		func(rs m.AccountChartUpgradeSet) (map[int64]int64, map[int64]int64) {
+			accountRef := make(map[int64]int64)
+			taxRef := make(map[int64]int64)
+			accounts, taxes, _ := rs.Templates()
+			for _, template := range accounts.Records() {
+				if account := rs.TemplateAccount(template); account.IsNotEmpty() {
+					accountRef[template.ID()] = account.ID()
+				}
+			}
+			for _, template := range taxes.Records() {
+				if tax := rs.TemplateTax(template); tax.IsNotEmpty() {
+					taxRef[template.ID()] = tax.ID()
+				}
+			}
+			return accountRef, taxRef
+		})
+
+	h.AccountChartUpgrade().Methods().AccountVals().DeclareMethod(
+		`AccountVals returns the values of the account generated from the given template`,
+		func(rs m.AccountChartUpgradeSet, template m.AccountAccountTemplateSet, taxRef map[int64]int64) m.AccountAccountData {
+			digits := int(rs.Company().AccountsCodeDigits())
+			if digits == 0 {
+				digits = int(template.ChartTemplate().CodeDigits())
+			}
+			code := template.Code()
+			if len(code) > 0 && len(code) < digits {
+				code = code + strings.Repeat("0", digits-len(code))
+			}
+			return template.ChartTemplate().GetAccountVals(rs.Company(), template, code, taxRef)
+		})
+
+	h.AccountChartUpgrade().Methods().TaxVals().DeclareMethod(
+		`TaxVals returns the values of the tax generated from the given template, including its accounts`,
+		func(rs m.AccountChartUpgradeSet, template m.AccountTaxTemplateSet, accountRef, taxRef map[int64]int64) m.AccountTaxData {
+			var childrenIds []int64
+			for _, child := range template.ChildrenTaxes().Records() {
+				if id, ok := taxRef[child.ID()]; ok {
+					childrenIds = append(childrenIds, id)
+				}
+			}
+			return template.GetTaxVals(rs.Company()).
+				SetChildrenTaxes(h.AccountTax().Browse(rs.Env(), childrenIds)).
+				SetAccount(h.AccountAccount().BrowseOne(rs.Env(), accountRef[template.Account().ID()])).
+				SetRefundAccount(h.AccountAccount().BrowseOne(rs.Env(), accountRef[template.RefundAccount().ID()]))
+		})
+
+	h.AccountChartUpgrade().Methods().AccountChanges().DeclareMethod(
+		`AccountChanges returns the differences between the given account and the values of its template,
+		and the differences between their tags.`,
+		func(rs m.AccountChartUpgradeSet, account m.AccountAccountSet, data m.AccountAccountData) ([]string, string) {
+			var changes []string
+			changes = rs.AddChange(changes, "Code", account.Code(), data.Code())
+			changes = rs.AddChange(changes, "Name", account.Name(), data.Name())
+			changes = rs.AddChange(changes, "Type", account.UserType().Name(), data.UserType().Name())
+			changes = rs.AddChange(changes, "Reconcile", fmt.Sprint(account.Reconcile()), fmt.Sprint(data.Reconcile()))
+			changes = rs.AddChange(changes, "Default Taxes", rs.TaxNames(account.Taxes()), rs.TaxNames(data.Taxes()))
+			return changes, rs.TagChanges(account.Tags(), data.Tags())
+		})
+
+	h.AccountChartUpgrade().Methods().TaxChanges().DeclareMethod(
+		`TaxChanges returns the differences between the given tax and the values of its template,
+		and the differences between their tags.`,
+		func(rs m.AccountChartUpgradeSet, tax m.AccountTaxSet, data m.AccountTaxData) ([]string, string) {
+			var changes []string
+			changes = rs.AddChange(changes, "Name", tax.Name(), data.Name())
+			changes = rs.AddChange(changes, "Label on Invoices", tax.Description(), data.Description())
+			changes = rs.AddChange(changes, "Tax Scope", tax.TypeTaxUse(), data.TypeTaxUse())
+			changes = rs.AddChange(changes, "Tax Computation", tax.AmountType(), data.AmountType())
+			changes = rs.AddChange(changes, "Amount", fmt.Sprint(tax.Amount()), fmt.Sprint(data.Amount()))
+			changes = rs.AddChange(changes, "Included in Price", fmt.Sprint(tax.PriceInclude()), fmt.Sprint(data.PriceInclude()))
+			changes = rs.AddChange(changes, "Affect Subsequent Taxes", fmt.Sprint(tax.IncludeBaseAmount()), fmt.Sprint(data.IncludeBaseAmount()))
+			changes = rs.AddChange(changes, "Tax Group", tax.TaxGroup().Name(), data.TaxGroup().Name())
+			changes = rs.AddChange(changes, "Tax Account", tax.Account().Code(), data.Account().Code())
+			changes = rs.AddChange(changes, "Tax Account on Refunds", tax.RefundAccount().Code(), data.RefundAccount().Code())
+			changes = rs.AddChange(changes, "Children Taxes", rs.TaxNames(tax.ChildrenTaxes()), rs.TaxNames(data.ChildrenTaxes()))
+			return changes, rs.TagChanges(tax.Tags(), data.Tags())
+		})
+
+	h.AccountChartUpgrade().Methods().PositionChanges().DeclareMethod(
+		`PositionChanges returns the differences between the given fiscal position and its template`,
+		func(rs m.AccountChartUpgradeSet, position m.AccountFiscalPositionSet, template m.AccountFiscalPositionTemplateSet,
+			accountRef, taxRef map[int64]int64) []string {
+
+			var changes []string
+			changes = rs.AddChange(changes, "Name", position.Name(), template.Name())
+			changes = rs.AddChange(changes, "Notes", position.Note(), template.Note())
+			var taxMaps, templateTaxMaps, accountMaps, templateAccountMaps []string
+			for _, line := range position.Taxes().Records() {
+				taxMaps = append(taxMaps, fmt.Sprintf("%s > %s", line.TaxSrc().Name(), line.TaxDest().Name()))
+			}
+			for _, line := range template.Taxes().Records() {
+				templateTaxMaps = append(templateTaxMaps, fmt.Sprintf("%s > %s",
+					h.AccountTax().BrowseOne(rs.Env(), taxRef[line.TaxSrc().ID()]).Name(),
+					h.AccountTax().BrowseOne(rs.Env(), taxRef[line.TaxDest().ID()]).Name()))
+			}
+			for _, line := range position.Accounts().Records() {
+				accountMaps = append(accountMaps, fmt.Sprintf("%s > %s", line.AccountSrc().Code(), line.AccountDest().Code()))
+			}
+			for _, line := range template.Accounts().Records() {
+				templateAccountMaps = append(templateAccountMaps, fmt.Sprintf("%s > %s",
+					h.AccountAccount().BrowseOne(rs.Env(), accountRef[line.AccountSrc().ID()]).Code(),
+					h.AccountAccount().BrowseOne(rs.Env(), accountRef[line.AccountDest().ID()]).Code()))
+			}
+			sort.Strings(taxMaps)
+			sort.Strings(templateTaxMaps)
+			sort.Strings(accountMaps)
+			sort.Strings(templateAccountMaps)
+			changes = rs.AddChange(changes, "Tax Mapping", strings.Join(taxMaps, ", "), strings.Join(templateTaxMaps, ", "))
+			changes = rs.AddChange(changes, "Account Mapping", strings.Join(accountMaps, ", "), strings.Join(templateAccountMaps, ", "))
+			return changes
+		})
+
+	h.AccountChartUpgrade().Methods().AddChange().DeclareMethod(
+		`AddChange appends the description of the change of the given field to changes if
+		the installed value differs from the template value.`,
+		func(rs m.AccountChartUpgradeSet, changes []string, field, installed, template string) []string {
+			if installed == template {
+				return changes
+			}
+			return append(changes, fmt.Sprintf("%s: %s -> %s", field, installed, template))
+		})
+
+	h.AccountChartUpgrade().Methods().TaxNames().DeclareMethod(
+		`TaxNames returns the sorted names of the given taxes, separated by commas`,
+		func(rs m.AccountChartUpgradeSet, taxes m.AccountTaxSet) string {
+			var names []string
+			for _, tax := range taxes.Records() {
+				names = append(names, tax.Name())
+			}
+			sort.Strings(names)
+			return strings.Join(names, ", ")
+		})
+
+	h.AccountChartUpgrade().Methods().TagChanges().DeclareMethod(
+		`TagChanges returns the description of the tags to add to and remove from installed
+		to get the template tags, or an empty string if they are the same.`,
+		func(rs m.AccountChartUpgradeSet, installed, template m.AccountAccountTagSet) string {
+			var changes []string
+			for _, tag := range template.Subtract(installed).Records() {
+				changes = append(changes, "+"+tag.Name())
+			}
+			for _, tag := range installed.Subtract(template).Records() {
+				changes = append(changes, "-"+tag.Name())
+			}
+			return strings.Join(changes, ", ")
+		})
+
+	h.AccountChartUpgrade().Methods().AccountUsed().DeclareMethod(
+		`AccountUsed returns true if the given account has journal items`,
+		func(rs m.AccountChartUpgradeSet, account m.AccountAccountSet) bool {
+			return h.AccountMoveLine().Search(rs.Env(), q.AccountMoveLine().Account().Equals(account)).IsNotEmpty()
+		})
+
+	h.AccountChartUpgrade().Methods().TaxUsed().DeclareMethod(
+		`TaxUsed returns true if the given tax has been applied on or generated journal items`,
+		func(rs m.AccountChartUpgradeSet, tax m.AccountTaxSet) bool {
+			return h.AccountMoveLine().Search(rs.Env(), q.AccountMoveLine().TaxLine().Equals(tax).
+				Or().Taxes().Equals(tax)).IsNotEmpty()
+		})
+
+	h.AccountChartUpgrade().Methods().ActionCompute().DeclareMethod(
+		`ActionCompute lists the accounts, taxes, fiscal positions and tags added, changed or removed
+		in the chart template since it has been installed`,
+		func(rs m.AccountChartUpgradeSet) *actions.Action {
+			rs.EnsureOne()
+			if rs.ChartTemplate().IsEmpty() {
+				panic(rs.T(`No chart of accounts has been installed for company %s.`, rs.Company().Name()))
+			}
+			rs.Lines().Unlink()
+			accountRef, taxRef := rs.TemplateRefs()
+			accountTemplates, taxTemplates, positionTemplates := rs.Templates()
+			addLine := func(data m.AccountChartUpgradeLineData) {
+				h.AccountChartUpgradeLine().Create(rs.Env(), data.SetUpgrade(rs).SetSelected(!data.Used()))
+			}
+
+			for _, template := range accountTemplates.Records() {
+				account := rs.TemplateAccount(template)
+				line := h.AccountChartUpgradeLine().NewData().
+					SetType("account").
+					SetAccountTemplate(template).
+					SetAccount(account).
+					SetName(fmt.Sprintf("%s %s", template.Code(), template.Name()))
+				if account.IsEmpty() {
+					addLine(line.SetOperation("add"))
+					continue
+				}
+				changes, tagChanges := rs.AccountChanges(account, rs.AccountVals(template, taxRef))
+				used := rs.AccountUsed(account)
+				if len(changes) > 0 {
+					addLine(line.SetOperation("update").SetChanges(strings.Join(changes, "\n")).SetUsed(used))
+				}
+				if tagChanges != "" {
+					addLine(h.AccountChartUpgradeLine().NewData().
+						SetType("tag").
+						SetOperation("update").
+						SetAccountTemplate(template).
+						SetAccount(account).
+						SetName(fmt.Sprintf("%s %s", account.Code(), account.Name())).
+						SetChanges(tagChanges).
+						SetUsed(used))
+				}
+			}
+			for _, account := range h.AccountAccount().Search(rs.Env(), q.AccountAccount().Company().Equals(rs.Company()).
+				And().AccountTemplate().IsNotNull().
+				And().AccountTemplate().NotIn(accountTemplates).
+				And().Deprecated().Equals(false)).Records() {
+				addLine(h.AccountChartUpgradeLine().NewData().
+					SetType("account").
+					SetOperation("remove").
+					SetAccountTemplate(account.AccountTemplate()).
+					SetAccount(account).
+					SetName(fmt.Sprintf("%s %s", account.Code(), account.Name())).
+					SetUsed(rs.AccountUsed(account)))
+			}
+
+			for _, template := range taxTemplates.Records() {
+				tax := rs.TemplateTax(template)
+				line := h.AccountChartUpgradeLine().NewData().
+					SetType("tax").
+					SetTaxTemplate(template).
+					SetTax(tax).
+					SetName(template.Name())
+				if tax.IsEmpty() {
+					addLine(line.SetOperation("add"))
+					continue
+				}
+				changes, tagChanges := rs.TaxChanges(tax, rs.TaxVals(template, accountRef, taxRef))
+				used := rs.TaxUsed(tax)
+				if len(changes) > 0 {
+					addLine(line.SetOperation("update").SetChanges(strings.Join(changes, "\n")).SetUsed(used))
+				}
+				if tagChanges != "" {
+					addLine(h.AccountChartUpgradeLine().NewData().
+						SetType("tag").
+						SetOperation("update").
+						SetTaxTemplate(template).
+						SetTax(tax).
+						SetName(tax.Name()).
+						SetChanges(tagChanges).
+						SetUsed(used))
+				}
+			}
+			for _, tax := range h.AccountTax().Search(rs.Env(), q.AccountTax().Company().Equals(rs.Company()).
+				And().TaxTemplate().IsNotNull().
+				And().TaxTemplate().NotIn(taxTemplates).
+				And().Active().Equals(true)).Records() {
+				addLine(h.AccountChartUpgradeLine().NewData().
+					SetType("tax").
+					SetOperation("remove").
+					SetTaxTemplate(tax.TaxTemplate()).
+					SetTax(tax).
+					SetName(tax.Name()).
+					SetUsed(rs.TaxUsed(tax)))
+			}
+
+			for _, template := range positionTemplates.Records() {
+				position := rs.TemplatePosition(template)
+				line := h.AccountChartUpgradeLine().NewData().
+					SetType("fiscal_position").
+					SetPositionTemplate(template).
+					SetPosition(position).
+					SetName(template.Name())
+				if position.IsEmpty() {
+					addLine(line.SetOperation("add"))
+					continue
+				}
+				if changes := rs.PositionChanges(position, template, accountRef, taxRef); len(changes) > 0 {
+					addLine(line.SetOperation("update").SetChanges(strings.Join(changes, "\n")))
+				}
+			}
+			for _, position := range h.AccountFiscalPosition().Search(rs.Env(), q.AccountFiscalPosition().Company().Equals(rs.Company()).
+				And().FiscalPositionTemplate().IsNotNull().
+				And().FiscalPositionTemplate().NotIn(positionTemplates).
+				And().Active().Equals(true)).Records() {
+				addLine(h.AccountChartUpgradeLine().NewData().
+					SetType("fiscal_position").
+					SetOperation("remove").
+					SetPositionTemplate(position.FiscalPositionTemplate()).
+					SetPosition(position).
+					SetName(position.Name()))
+			}
+
+			return &actions.Action{
+				Type:     actions.ActionActWindow,
+				Model:    "AccountChartUpgrade",
+				ViewMode: "form",
+				ResID:    rs.ID(),
+				Target:   "new",
+			}
+		})
+
+	h.AccountChartUpgrade().Methods().ActionApply().DeclareMethod(
+		`ActionApply applies the selected changes to the accounts, taxes and fiscal positions of the company.
+		Lines of records used in journal items are ignored.`,
+		func(rs m.AccountChartUpgradeSet) *actions.Action {
+			rs.EnsureOne()
+			byType := func(typ string, operations ...string) m.AccountChartUpgradeLineSet {
+				return rs.Lines().Filtered(func(r m.AccountChartUpgradeLineSet) bool {
+					if !r.Selected() || r.Used() || r.Type() != typ {
+						return false
+					}
+					for _, op := range operations {
+						if r.Operation() == op {
+							return true
+						}
+					}
+					return false
+				})
+			}
+			company := rs.Company()
+
+			// New taxes are created first so that new accounts get their default taxes,
+			// and their accounts are set once new accounts have been created.
+			for _, line := range byType("tax", "add").Records() {
+				template := line.TaxTemplate()
+				line.SetTax(h.AccountTax().Create(rs.Env(), template.GetTaxVals(company).
+					SetHexyaExternalID(fmt.Sprintf("%d_%s", company.ID(), template.HexyaExternalID()))))
+			}
+			_, taxRef := rs.TemplateRefs()
+			for _, line := range byType("account", "add").Records() {
+				template := line.AccountTemplate()
+				line.SetAccount(h.AccountAccount().Create(rs.Env(), rs.AccountVals(template, taxRef).
+					SetHexyaExternalID(fmt.Sprintf("%d_%s", company.ID(), template.HexyaExternalID()))))
+			}
+			for _, line := range byType("account", "update").Records() {
+				data := rs.AccountVals(line.AccountTemplate(), taxRef)
+				line.Account().Write(h.AccountAccount().NewData().
+					SetCode(data.Code()).
+					SetName(data.Name()).
+					SetUserType(data.UserType()).
+					SetReconcile(data.Reconcile()).
+					SetTaxes(data.Taxes()).
+					SetAccountTemplate(line.AccountTemplate()))
+			}
+			accountRef, taxRef := rs.TemplateRefs()
+			for _, line := range byType("tax", "add", "update").Records() {
+				data := rs.TaxVals(line.TaxTemplate(), accountRef, taxRef)
+				line.Tax().Write(h.AccountTax().NewData().
+					SetName(data.Name()).
+					SetDescription(data.Description()).
+					SetTypeTaxUse(data.TypeTaxUse()).
+					SetAmountType(data.AmountType()).
+					SetAmount(data.Amount()).
+					SetPriceInclude(data.PriceInclude()).
+					SetIncludeBaseAmount(data.IncludeBaseAmount()).
+					SetTaxGroup(data.TaxGroup()).
+					SetAccount(data.Account()).
+					SetRefundAccount(data.RefundAccount()).
+					SetChildrenTaxes(data.ChildrenTaxes()).
+					SetTaxTemplate(line.TaxTemplate()))
+			}
+			for _, line := range byType("tag", "update").Records() {
+				if line.Account().IsNotEmpty() {
+					line.Account().SetTags(line.AccountTemplate().Tags())
+				}
+				if line.Tax().IsNotEmpty() {
+					line.Tax().SetTags(line.TaxTemplate().Tags())
+				}
+			}
+
+			for _, line := range byType("fiscal_position", "add").Records() {
+				line.SetPosition(line.PositionTemplate().GenerateFiscalPosition(taxRef, accountRef, company))
+			}
+			for _, line := range byType("fiscal_position", "update").Records() {
+				position := line.Position()
+				position.Write(h.AccountFiscalPosition().NewData().
+					SetName(line.PositionTemplate().Name()).
+					SetNote(line.PositionTemplate().Note()).
+					SetFiscalPositionTemplate(line.PositionTemplate()))
+				position.Taxes().Unlink()
+				position.Accounts().Unlink()
+				line.PositionTemplate().GenerateMappings(position, taxRef, accountRef, company)
+			}
+
+			// Removed records are archived rather than deleted
+			for _, line := range byType("account", "remove").Records() {
+				line.Account().SetDeprecated(true)
+			}
+			for _, line := range byType("tax", "remove").Records() {
+				line.Tax().SetActive(false)
+			}
+			for _, line := range byType("fiscal_position", "remove").Records() {
+				line.Position().SetActive(false)
+			}
+
+			return &actions.Action{
+				Type: actions.ActionCloseWindow,
+			}
+		})
+
+}
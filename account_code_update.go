@@ -0,0 +1,186 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package account
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/hexya-addons/account/accountcode"
+	"github.com/hexya-erp/hexya/src/actions"
+	"github.com/hexya-erp/hexya/src/models"
+	"github.com/hexya-erp/hexya/src/models/types"
+	"github.com/hexya-erp/pool/h"
+	"github.com/hexya-erp/pool/m"
+	"github.com/hexya-erp/pool/q"
+)
+
+func init() {
+
+	h.AccountCodeUpdate().DeclareTransientModel()
+	h.AccountCodeUpdate().AddFields(map[string]models.FieldDefinition{
+		"Company": models.Many2OneField{
+			RelationModel: h.Company(),
+			Required:      true,
+			OnChange:      h.AccountCodeUpdate().Methods().OnchangeCompany(),
+			Default: func(env models.Environment) interface{} {
+				return h.User().NewSet(env).CurrentUser().Company()
+			}},
+		"AccountsCodeDigits": models.IntegerField{
+			String: "Number of digits in an account code",
+			Default: func(env models.Environment) interface{} {
+				return h.User().NewSet(env).CurrentUser().Company().AccountsCodeDigits()
+			}},
+		"BankAccountCodePrefix": models.CharField{
+			String: "Prefix of the bank accounts",
+			Default: func(env models.Environment) interface{} {
+				return h.User().NewSet(env).CurrentUser().Company().BankAccountCodePrefix()
+			}},
+		"CashAccountCodePrefix": models.CharField{
+			String: "Prefix of the cash accounts",
+			Default: func(env models.Environment) interface{} {
+				return h.User().NewSet(env).CurrentUser().Company().CashAccountCodePrefix()
+			}},
+		"Lines": models.One2ManyField{
+			String:        "Code Changes",
+			RelationModel: h.AccountCodeUpdateLine(),
+			ReverseFK:     "Update",
+			JSON:          "line_ids",
+			ReadOnly:      true},
+		"HasIssues": models.BooleanField{
+			ReadOnly: true},
+	})
+
+	h.AccountCodeUpdateLine().DeclareTransientModel()
+	h.AccountCodeUpdateLine().AddFields(map[string]models.FieldDefinition{
+		"Update": models.Many2OneField{
+			RelationModel: h.AccountCodeUpdate(),
+			Required:      true,
+			OnDelete:      models.Cascade},
+		"Account": models.Many2OneField{
+			RelationModel: h.AccountAccount(),
+			Required:      true,
+			OnDelete:      models.Cascade},
+		"OldCode": models.CharField{
+			String: "Current Code"},
+		"NewCode": models.CharField{
+			String: "New Code"},
+		"Issue": models.SelectionField{
+			Selection: types.Selection{
+				"collision": "Duplicate Code",
+				"too_long":  "Too Many Digits"}},
+	})
+
+	h.AccountCodeUpdate().Methods().OnchangeCompany().DeclareMethod(
+		`OnchangeCompany sets the current code settings of the selected company`,
+		func(rs m.AccountCodeUpdateSet) m.AccountCodeUpdateData {
+			return h.AccountCodeUpdate().NewData().
+				SetAccountsCodeDigits(rs.Company().AccountsCodeDigits()).
+				SetBankAccountCodePrefix(rs.Company().BankAccountCodePrefix()).
+				SetCashAccountCodePrefix(rs.Company().CashAccountCodePrefix())
+		})
+
+	h.AccountCodeUpdate().Methods().ActionPreview().DeclareMethod(
+		`ActionPreview lists the accounts whose code would change with the new settings,
+		without changing anything.`,
+		func(rs m.AccountCodeUpdateSet) *actions.Action {
+			rs.EnsureOne()
+			rs.Lines().Unlink()
+			company := rs.Company()
+			digits := int(rs.AccountsCodeDigits())
+			changes := company.CodeChanges(digits, rs.BankAccountCodePrefix(), rs.CashAccountCodePrefix())
+			issues := company.CheckCodeChanges(changes, digits)
+			issueNames := map[accountcode.Issue]string{
+				accountcode.Collision: "collision",
+				accountcode.TooLong:   "too_long",
+			}
+			var hasIssues bool
+			for _, c := range changes {
+				h.AccountCodeUpdateLine().Create(rs.Env(), h.AccountCodeUpdateLine().NewData().
+					SetUpdate(rs).
+					SetAccount(h.AccountAccount().BrowseOne(rs.Env(), c.ID)).
+					SetOldCode(c.Old).
+					SetNewCode(c.New).
+					SetIssue(issueNames[issues[c.ID]]))
+				hasIssues = hasIssues || issues[c.ID] != accountcode.NoIssue
+			}
+			rs.SetHasIssues(hasIssues)
+			return &actions.Action{
+				Type:     actions.ActionActWindow,
+				Model:    "AccountCodeUpdate",
+				ViewMode: "form",
+				ResID:    rs.ID(),
+				Target:   "new",
+			}
+		})
+
+	h.AccountCodeUpdate().Methods().ActionApply().DeclareMethod(
+		`ActionApply saves the new settings on the company, which changes the codes of its accounts.`,
+		func(rs m.AccountCodeUpdateSet) *actions.Action {
+			rs.EnsureOne()
+			rs.Company().Write(h.Company().NewData().
+				SetAccountsCodeDigits(rs.AccountsCodeDigits()).
+				SetBankAccountCodePrefix(rs.BankAccountCodePrefix()).
+				SetCashAccountCodePrefix(rs.CashAccountCodePrefix()))
+			return &actions.Action{
+				Type: actions.ActionCloseWindow,
+			}
+		})
+
+	h.AccountChartTemplate().Methods().CodeChanges().DeclareMethod(
+		`CodeChanges returns the code changes of the account templates of this chart if its number
+		of digits was set to the given value.`,
+		func(rs m.AccountChartTemplateSet, digits int) []accountcode.Change {
+			var res []accountcode.Change
+			if digits == 0 {
+				return res
+			}
+			templates := h.AccountAccountTemplate().Search(rs.Env(), q.AccountAccountTemplate().ChartTemplate().Equals(rs))
+			for _, template := range templates.Records() {
+				if code := accountcode.Resize(template.Code(), digits); code != template.Code() {
+					res = append(res, accountcode.Change{ID: template.ID(), Old: template.Code(), New: code})
+				}
+			}
+			return res
+		})
+
+	h.AccountChartTemplate().Methods().Write().Extend("",
+		func(rs m.AccountChartTemplateSet, data m.AccountChartTemplateData) bool {
+			if data.HasCodeDigits() {
+				for _, chart := range rs.Records() {
+					if data.CodeDigits() == chart.CodeDigits() {
+						continue
+					}
+					changes := chart.CodeChanges(int(data.CodeDigits()))
+					changed := make(map[int64]bool)
+					for _, c := range changes {
+						changed[c.ID] = true
+					}
+					var unchanged []string
+					for _, template := range h.AccountAccountTemplate().Search(rs.Env(),
+						q.AccountAccountTemplate().ChartTemplate().Equals(chart)).Records() {
+						if !changed[template.ID()] {
+							unchanged = append(unchanged, template.Code())
+						}
+					}
+					issues := accountcode.Check(changes, unchanged, 0)
+					var collisions []string
+					for _, c := range changes {
+						if issues[c.ID] == accountcode.Collision {
+							collisions = append(collisions, fmt.Sprintf("%s → %s", c.Old, c.New))
+						}
+					}
+					if len(collisions) > 0 {
+						panic(rs.T(`The following account template codes cannot be changed because another template would have the same code: %s`,
+							strings.Join(collisions, ", ")))
+					}
+					for _, c := range changes {
+						h.AccountAccountTemplate().BrowseOne(rs.Env(), c.ID).SetCode(c.New)
+					}
+				}
+			}
+			return rs.Super().Write(data)
+		})
+
+}
@@ -0,0 +1,382 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package account
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/hexya-erp/hexya/src/actions"
+	"github.com/hexya-erp/hexya/src/models"
+	"github.com/hexya-erp/hexya/src/models/types"
+	"github.com/hexya-erp/hexya/src/models/types/dates"
+	"github.com/hexya-erp/pool/h"
+	"github.com/hexya-erp/pool/m"
+	"github.com/hexya-erp/pool/q"
+)
+
+func init() {
+
+	h.AccountConsolidation().DeclareModel()
+	h.AccountConsolidation().AddFields(map[string]models.FieldDefinition{
+		"Name": models.CharField{
+			Required: true},
+		"Company": models.Many2OneField{
+			String:        "Group Company",
+			RelationModel: h.Company(),
+			Required:      true,
+			OnChange:      h.AccountConsolidation().Methods().OnchangeCompany(),
+			Default: func(env models.Environment) interface{} {
+				return h.Company().NewSet(env).CompanyDefaultGet()
+			},
+			Help: "The company holding the consolidation. Its currency rates are used for the translation."},
+		"Currency": models.Many2OneField{
+			String:        "Group Currency",
+			RelationModel: h.Currency(),
+			Required:      true,
+			Default: func(env models.Environment) interface{} {
+				return h.Company().NewSet(env).CompanyDefaultGet().Currency()
+			}},
+		"Companies": models.Many2ManyField{
+			String:        "Subsidiaries",
+			RelationModel: h.Company(),
+			JSON:          "company_ids",
+			Required:      true,
+			Help:          "The companies whose balances are consolidated"},
+		"Accounts": models.One2ManyField{
+			String:        "Group Chart",
+			RelationModel: h.AccountConsolidationAccount(),
+			ReverseFK:     "Consolidation",
+			JSON:          "account_ids"},
+		"TranslationAccount": models.Many2OneField{
+			String:        "Translation Difference Account",
+			RelationModel: h.AccountConsolidationAccount(),
+			Help:          "Group account on which the cumulative translation difference of each subsidiary is booked"},
+		"RetainedEarningsAccount": models.Many2OneField{
+			String:        "Retained Earnings Account",
+			RelationModel: h.AccountConsolidationAccount(),
+			Help: `Group account on which the income and expenses of the subsidiaries before the start of the
+report period are booked, translated at historical rates`},
+		"EliminateIntercompany": models.BooleanField{
+			String:  "Eliminate Intercompany Balances",
+			Default: models.DefaultValue(true),
+			Help: `If set, the journal items of a subsidiary with another company of the consolidation
+as partner are eliminated from the consolidated balances`},
+	})
+
+	h.AccountConsolidationAccount().DeclareModel()
+	h.AccountConsolidationAccount().SetDefaultOrder("Code")
+
+	h.AccountConsolidationAccount().AddFields(map[string]models.FieldDefinition{
+		"Consolidation": models.Many2OneField{
+			RelationModel: h.AccountConsolidation(),
+			Required:      true,
+			OnDelete:      models.Cascade,
+			Index:         true},
+		"Code": models.CharField{
+			Required: true},
+		"Name": models.CharField{
+			Required: true},
+		"Nature": models.SelectionField{
+			Selection: types.Selection{
+				"balance": "Balance Sheet (closing rate)",
+				"income":  "Profit & Loss (average rate)",
+				"equity":  "Equity (historical rate)"},
+			Required: true,
+			Default:  models.DefaultValue("balance"),
+			Help:     "Defines the rate used to translate the balances of the subsidiaries into the group currency"},
+		"Accounts": models.Many2ManyField{
+			String:        "Subsidiary Accounts",
+			RelationModel: h.AccountAccount(),
+			JSON:          "account_ids",
+			Constraint:    h.AccountConsolidationAccount().Methods().CheckAccounts()},
+	})
+
+	h.AccountConsolidationReport().DeclareTransientModel()
+	h.AccountConsolidationReport().AddFields(map[string]models.FieldDefinition{
+		"Consolidation": models.Many2OneField{
+			RelationModel: h.AccountConsolidation(),
+			Required:      true},
+		"DateFrom": models.DateField{
+			String:   "Start Date",
+			Required: true,
+			Default: func(env models.Environment) interface{} {
+				dateFrom, _ := h.User().NewSet(env).CurrentUser().Company().ComputeFiscalyearDates(dates.Today())
+				return dateFrom
+			}},
+		"DateTo": models.DateField{
+			String:   "End Date",
+			Required: true,
+			Default: func(env models.Environment) interface{} {
+				return dates.Today()
+			}},
+		"TargetMove": models.SelectionField{
+			String: "Target Moves",
+			Selection: types.Selection{
+				"posted": "All Posted Entries",
+				"all":    "All Entries"},
+			Required: true,
+			Default:  models.DefaultValue("posted")},
+		"AverageRateType": models.SelectionField{
+			String:    "Income Rate Type",
+			Selection: currencyRateTypes,
+			Required:  true,
+			Default:   models.DefaultValue("average"),
+			Help: `Type of the rates used to translate the income and expense accounts. Spot rates are used
+for the currencies without rates of this type.`},
+		"Lines": models.One2ManyField{
+			RelationModel: h.AccountConsolidationReportLine(),
+			ReverseFK:     "Report",
+			JSON:          "line_ids",
+			ReadOnly:      true},
+	})
+
+	h.AccountConsolidationReportLine().DeclareTransientModel()
+	h.AccountConsolidationReportLine().SetDefaultOrder("Code")
+
+	h.AccountConsolidationReportLine().AddFields(map[string]models.FieldDefinition{
+		"Report": models.Many2OneField{
+			RelationModel: h.AccountConsolidationReport(),
+			Required:      true,
+			OnDelete:      models.Cascade},
+		"ConsolidationAccount": models.Many2OneField{
+			String:        "Group Account",
+			RelationModel: h.AccountConsolidationAccount(),
+			Required:      true,
+			OnDelete:      models.Cascade},
+		"Code": models.CharField{
+			Related: "ConsolidationAccount.Code"},
+		"Nature": models.SelectionField{
+			Related: "ConsolidationAccount.Nature"},
+		"Balance": models.FloatField{
+			String: "Translated Balance",
+			Help:   "Sum of the balances of the subsidiaries translated into the group currency"},
+		"Elimination": models.FloatField{
+			Help: "Intercompany balances eliminated from the consolidation"},
+		"Total": models.FloatField{
+			String: "Consolidated Balance"},
+	})
+
+	h.AccountConsolidation().Methods().OnchangeCompany().DeclareMethod(
+		`OnchangeCompany sets the group currency to the currency of the group company`,
+		func(rs m.AccountConsolidationSet) m.AccountConsolidationData {
+			return h.AccountConsolidation().NewData().SetCurrency(rs.Company().Currency())
+		})
+
+	h.AccountConsolidation().Methods().IntercompanyPartners().DeclareMethod(
+		`IntercompanyPartners returns the partners of the group company and of the subsidiaries
+		of this consolidation.`,
+		func(rs m.AccountConsolidationSet) m.PartnerSet {
+			res := rs.Company().Partner()
+			for _, company := range rs.Companies().Records() {
+				res = res.Union(company.Partner())
+			}
+			return res
+		})
+
+	h.AccountConsolidation().Methods().AccountMapping().DeclareMethod(
+		`AccountMapping returns the group account of each mapped subsidiary account, by account ID`,
+		func(rs m.AccountConsolidationSet) map[int64]m.AccountConsolidationAccountSet {
+			res := make(map[int64]m.AccountConsolidationAccountSet)
+			for _, consAccount := range rs.Accounts().Records() {
+				for _, account := range consAccount.Accounts().Records() {
+					res[account.ID()] = consAccount
+				}
+			}
+			return res
+		})
+
+	h.AccountConsolidationAccount().Methods().CheckAccounts().DeclareMethod(
+		`CheckAccounts checks that the subsidiary accounts belong to the consolidation perimeter and
+		that each of them is mapped to only one group account.`,
+		func(rs m.AccountConsolidationAccountSet) {
+			for _, consAccount := range rs.Records() {
+				consolidation := consAccount.Consolidation()
+				for _, account := range consAccount.Accounts().Records() {
+					if account.Company().Intersect(consolidation.Companies()).IsEmpty() {
+						panic(rs.T(`Account %s does not belong to a subsidiary of consolidation %s.`,
+							account.NameGet(), consolidation.Name()))
+					}
+				}
+				others := h.AccountConsolidationAccount().Search(rs.Env(),
+					q.AccountConsolidationAccount().Consolidation().Equals(consolidation).
+						And().ID().NotEquals(consAccount.ID()).
+						And().Accounts().In(consAccount.Accounts()))
+				if others.IsNotEmpty() {
+					panic(rs.T(`An account can only be mapped to one group account in consolidation %s (see %s).`,
+						consolidation.Name(), others.First().Code()))
+				}
+			}
+		})
+
+	h.AccountConsolidationReport().Methods().ClosingRate().DeclareMethod(
+		`ClosingRate returns the conversion rate from the given currency to the group currency
+		at the end date of the report.`,
+		func(rs m.AccountConsolidationReportSet, currency m.CurrencySet) float64 {
+			return rs.RateAt(currency, rs.DateTo(), "spot")
+		})
+
+	h.AccountConsolidationReport().Methods().AverageRate().DeclareMethod(
+		`AverageRate returns the mean of the conversion rates from the given currency to the group
+		currency at the end of each month of the report period, using the average rate type of the report.`,
+		func(rs m.AccountConsolidationReportSet, currency m.CurrencySet) float64 {
+			var sum float64
+			var count int
+			for month := rs.DateFrom().StartOfMonth(); ; month = month.AddDate(0, 1, 0) {
+				monthEnd := month.AddDate(0, 1, -1)
+				if !monthEnd.Lower(rs.DateTo()) {
+					break
+				}
+				sum += rs.RateAt(currency, monthEnd, rs.AverageRateType())
+				count++
+			}
+			sum += rs.RateAt(currency, rs.DateTo(), rs.AverageRateType())
+			count++
+			return sum / float64(count)
+		})
+
+	h.AccountConsolidationReport().Methods().RateAt().DeclareMethod(
+		`RateAt returns the conversion rate from the given currency to the group currency at the given
+		date, using the rates of the given type of the group company.`,
+		func(rs m.AccountConsolidationReportSet, currency m.CurrencySet, date dates.Date, rateType string) float64 {
+			consolidation := rs.Consolidation()
+			return currency.
+				WithContext("date", date).
+				WithContext("rate_type", rateType).
+				WithContext("company_id", consolidation.Company().ID()).
+				GetConversionRateTo(consolidation.Currency())
+		})
+
+	h.AccountConsolidationReport().Methods().MoveLines().DeclareMethod(
+		`MoveLines returns the journal items of the subsidiaries to consolidate, as selected by QueryGet
+		for the report period. Income and expense items dated before the period are returned separately.`,
+		func(rs m.AccountConsolidationReportSet) (m.AccountMoveLineSet, m.AccountMoveLineSet) {
+			consolidation := rs.Consolidation()
+			accountCond := q.AccountMoveLine().AccountFilteredOn(q.AccountAccount().Company().In(consolidation.Companies()))
+			lineSet := h.AccountMoveLine().NewSet(rs.Env()).
+				WithContext("state", rs.TargetMove()).
+				WithContext("company_ids", consolidation.Companies().Ids())
+			lines := h.AccountMoveLine().Search(rs.Env(), lineSet.
+				WithContext("date_from", rs.DateFrom()).
+				WithContext("date_to", rs.DateTo()).
+				QueryGetCondition(accountCond))
+			priorLines := h.AccountMoveLine().Search(rs.Env(), lineSet.
+				WithContext("date_to", rs.DateFrom().AddDate(0, 0, -1)).
+				QueryGetCondition(accountCond.AndCond(
+					q.AccountMoveLine().AccountFilteredOn(
+						q.AccountAccount().UserTypeFilteredOn(
+							q.AccountAccountType().IncludeInitialBalance().Equals(false))))))
+			return lines, priorLines
+		})
+
+	h.AccountConsolidationReport().Methods().ActionCompute().DeclareMethod(
+		`ActionCompute computes the consolidated balances of the group accounts.
+
+		The balances of the subsidiaries are translated into the group currency at the closing rate for
+		balance sheet accounts, at the average rate of the period for income accounts and at the rate of
+		the date of each journal item for equity accounts. The difference between the translated balances
+		of each subsidiary is booked on the translation difference account.`,
+		func(rs m.AccountConsolidationReportSet) *actions.Action {
+			rs.EnsureOne()
+			rs.Lines().Unlink()
+			consolidation := rs.Consolidation()
+			if consolidation.TranslationAccount().IsEmpty() || consolidation.RetainedEarningsAccount().IsEmpty() {
+				panic(rs.T(`Please define the translation difference and retained earnings accounts of consolidation %s.`,
+					consolidation.Name()))
+			}
+			groupCurrency := consolidation.Currency()
+			mapping := consolidation.AccountMapping()
+			lines, priorLines := rs.MoveLines()
+
+			intercompany := h.Partner().NewSet(rs.Env())
+			if consolidation.EliminateIntercompany() {
+				intercompany = consolidation.IntercompanyPartners()
+			}
+			closingRates := make(map[int64]float64)
+			averageRates := make(map[int64]float64)
+			balances := make(map[int64]float64)
+			eliminations := make(map[int64]float64)
+			translationDiffs := make(map[int64]float64)
+			unmapped := make(map[string]bool)
+
+			book := func(line m.AccountMoveLineSet, consAccount m.AccountConsolidationAccountSet, nature string) {
+				currency := line.Company().Currency()
+				companyID := line.Company().ID()
+				if _, ok := closingRates[companyID]; !ok {
+					closingRates[companyID] = rs.ClosingRate(currency)
+					averageRates[companyID] = rs.AverageRate(currency)
+				}
+				amount := line.Balance()
+				switch nature {
+				case "balance":
+					amount *= closingRates[companyID]
+				case "income":
+					amount *= averageRates[companyID]
+				default:
+					amount *= rs.RateAt(currency, line.Date(), "spot")
+				}
+				balances[consAccount.ID()] += amount
+				translationDiffs[companyID] -= amount
+				if intercompany.IsNotEmpty() && line.Partner().IsNotEmpty() &&
+					line.Partner().CommercialPartner().Intersect(intercompany).IsNotEmpty() &&
+					!line.Partner().CommercialPartner().Equals(line.Company().Partner()) {
+					eliminations[consAccount.ID()] -= amount
+				}
+			}
+			for _, line := range lines.Records() {
+				consAccount, ok := mapping[line.Account().ID()]
+				if !ok {
+					if !line.Company().Currency().IsZero(line.Balance()) {
+						unmapped[line.Account().NameGet()] = true
+					}
+					continue
+				}
+				book(line, consAccount, consAccount.Nature())
+			}
+			for _, line := range priorLines.Records() {
+				book(line, consolidation.RetainedEarningsAccount(), "equity")
+			}
+			if len(unmapped) > 0 {
+				var names []string
+				for name := range unmapped {
+					names = append(names, name)
+				}
+				sort.Strings(names)
+				panic(rs.T(`The following accounts have a balance but are not mapped to a group account: %s`,
+					strings.Join(names, ", ")))
+			}
+
+			translationAccount := consolidation.TranslationAccount()
+			for _, diff := range translationDiffs {
+				balances[translationAccount.ID()] += diff
+			}
+			var residual float64
+			for _, elimination := range eliminations {
+				residual += elimination
+			}
+			eliminations[translationAccount.ID()] -= residual
+
+			for _, consAccount := range consolidation.Accounts().Records() {
+				balance := groupCurrency.Round(balances[consAccount.ID()])
+				elimination := groupCurrency.Round(eliminations[consAccount.ID()])
+				if groupCurrency.IsZero(balance) && groupCurrency.IsZero(elimination) {
+					continue
+				}
+				h.AccountConsolidationReportLine().Create(rs.Env(), h.AccountConsolidationReportLine().NewData().
+					SetReport(rs).
+					SetConsolidationAccount(consAccount).
+					SetBalance(balance).
+					SetElimination(elimination).
+					SetTotal(groupCurrency.Round(balance+elimination)))
+			}
+			return &actions.Action{
+				Type:     actions.ActionActWindow,
+				Model:    "AccountConsolidationReport",
+				ViewMode: "form",
+				ResID:    rs.ID(),
+				Target:   "new",
+			}
+		})
+
+}
@@ -0,0 +1,206 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package account
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hexya-addons/account/currencyrate"
+	"github.com/hexya-erp/hexya/src/actions"
+	"github.com/hexya-erp/hexya/src/models"
+	"github.com/hexya-erp/hexya/src/models/types/dates"
+	"github.com/hexya-erp/pool/h"
+	"github.com/hexya-erp/pool/m"
+	"github.com/hexya-erp/pool/q"
+)
+
+func init() {
+
+	h.Company().AddFields(map[string]models.FieldDefinition{
+		"CurrencyRateDirectory": models.CharField{
+			String: "Currency Rates Directory",
+			Help: `Path on the server of a directory of ECB eurofxref XML or CSV rate files.
+The rate files of this directory are imported for this company by a scheduled job.`},
+		"CurrencyRateLastImport": models.DateTimeField{
+			String:   "Last Currency Rates Import",
+			ReadOnly: true,
+			NoCopy:   true,
+			Help:     "Only the rate files modified after this date are imported by the scheduled job"},
+	})
+
+	h.Company().Methods().ImportCurrencyRates().DeclareMethod(
+		`ImportCurrencyRates creates or updates the rates of the given type of this company from the
+		given rates. Rates are first converted to be relative to the company currency.
+
+		A rate of 1.0 is also recorded for the company currency on each imported date.
+
+		It returns the number of created or updated rates and a list of warnings about the
+		currencies that could not be found in the database, the active currencies for which
+		the given rates have no value and the dates for which the company currency is missing.`,
+		func(rs m.CompanySet, rates []currencyrate.Rate, rateType string) (int, []string) {
+			rs.EnsureOne()
+			var warnings []string
+			rebased, missingDates := currencyrate.Rebase(rates, rs.Currency().Name())
+			for _, date := range missingDates {
+				warnings = append(warnings, rs.T(`No rate for %s on %s: the rates of this day cannot be converted.`,
+					rs.Currency().Name(), dates.Date{Time: date}))
+			}
+			// Rebased rates are relative to the company currency, whose own rate is 1.0 on each imported date.
+			importedDates := make(map[time.Time]bool)
+			for _, rate := range rebased {
+				if importedDates[rate.Date] {
+					continue
+				}
+				importedDates[rate.Date] = true
+				rebased = append(rebased, currencyrate.Rate{Date: rate.Date, Currency: rs.Currency().Name(), Rate: 1})
+			}
+
+			currencyEnv := h.Currency().NewSet(rs.Env()).WithContext("active_test", false)
+			currencies := make(map[string]m.CurrencySet)
+			missing := make(map[string]bool)
+			var count int
+			for _, rate := range rebased {
+				currency, ok := currencies[rate.Currency]
+				if !ok {
+					currency = currencyEnv.Search(q.Currency().Name().Equals(rate.Currency)).Limit(1)
+					currencies[rate.Currency] = currency
+				}
+				if currency.IsEmpty() {
+					missing[rate.Currency] = true
+					continue
+				}
+				date := dates.Date{Time: rate.Date}.ToDateTime()
+				existing := h.CurrencyRate().Search(rs.Env(),
+					q.CurrencyRate().Currency().Equals(currency).
+						And().Company().Equals(rs).
+						And().Name().Equals(date).
+						AndCond(rateTypeCondition(rateType))).Limit(1)
+				switch {
+				case existing.IsEmpty():
+					h.CurrencyRate().Create(rs.Env(), h.CurrencyRate().NewData().
+						SetName(date).
+						SetCurrency(currency).
+						SetCompany(rs).
+						SetRateType(rateType).
+						SetRate(rate.Rate))
+				case existing.Rate() != rate.Rate:
+					existing.SetRate(rate.Rate)
+				default:
+					continue
+				}
+				count++
+			}
+			var missingNames []string
+			for name := range missing {
+				missingNames = append(missingNames, name)
+			}
+			sort.Strings(missingNames)
+			if len(missingNames) > 0 {
+				warnings = append(warnings, rs.T(`The following currencies do not exist in the database: %s`,
+					strings.Join(missingNames, ", ")))
+			}
+
+			var notFound []string
+			for _, currency := range h.Currency().Search(rs.Env(), q.Currency().Active().Equals(true)).Records() {
+				if _, ok := currencies[currency.Name()]; !ok && !currency.Equals(rs.Currency()) {
+					notFound = append(notFound, currency.Name())
+				}
+			}
+			sort.Strings(notFound)
+			if len(notFound) > 0 {
+				warnings = append(warnings, rs.T(`The rate files have no rate for the following active currencies: %s`,
+					strings.Join(notFound, ", ")))
+			}
+			return count, warnings
+		})
+
+	h.Company().Methods().CronImportCurrencyRates().DeclareMethod(
+		`CronImportCurrencyRates imports as spot rates the rate files of the currency rates directory of
+		each company that were modified since the last import. It is meant to be called by a scheduled job.`,
+		func(rs m.CompanySet) {
+			companies := h.Company().Search(rs.Env(), q.Company().CurrencyRateDirectory().IsNotNull())
+			for _, company := range companies.Records() {
+				if company.CurrencyRateDirectory() == "" {
+					continue
+				}
+				now := dates.Now()
+				files, err := currencyrate.ListFiles(company.CurrencyRateDirectory(), company.CurrencyRateLastImport().Time)
+				if err != nil {
+					log.Warn("Unable to read currency rates directory", "company", company.Name(), "error", err)
+					continue
+				}
+				for _, file := range files {
+					rates, err := currencyrate.ReadFile(file)
+					if err != nil {
+						log.Warn("Unable to read currency rates file", "company", company.Name(), "error", err)
+						continue
+					}
+					count, warnings := company.ImportCurrencyRates(rates, "spot")
+					log.Info("Imported currency rates", "company", company.Name(), "file", file, "count", count)
+					for _, warning := range warnings {
+						log.Warn(warning, "company", company.Name(), "file", file)
+					}
+				}
+				company.SetCurrencyRateLastImport(now)
+			}
+		})
+
+	h.AccountCurrencyRateImport().DeclareTransientModel()
+	h.AccountCurrencyRateImport().AddFields(map[string]models.FieldDefinition{
+		"Company": models.Many2OneField{
+			RelationModel: h.Company(),
+			Required:      true,
+			Default: func(env models.Environment) interface{} {
+				return h.User().NewSet(env).CurrentUser().Company()
+			}},
+		"Path": models.CharField{
+			String:   "Rates File",
+			Required: true,
+			Help: `Path on the server of an ECB eurofxref XML or CSV rate file, or of a directory
+whose rate files are all imported`},
+		"RateType": models.SelectionField{
+			String:    "Rate Type",
+			Selection: currencyRateTypes,
+			Required:  true,
+			Default:   models.DefaultValue("spot"),
+			Help:      "The imported rates are recorded with this type"},
+		"Report": models.TextField{
+			String:   "Import Report",
+			ReadOnly: true},
+	})
+
+	h.AccountCurrencyRateImport().Methods().ActionImport().DeclareMethod(
+		`ActionImport imports the rates of the file or directory of this wizard and displays the report`,
+		func(rs m.AccountCurrencyRateImportSet) *actions.Action {
+			rs.EnsureOne()
+			files := []string{rs.Path()}
+			if dirFiles, err := currencyrate.ListFiles(rs.Path(), time.Time{}); err == nil {
+				files = dirFiles
+			}
+			if len(files) == 0 {
+				panic(rs.T(`No rate file found in %s.`, rs.Path()))
+			}
+			var report []string
+			for _, file := range files {
+				rates, err := currencyrate.ReadFile(file)
+				if err != nil {
+					panic(rs.T(`Unable to read the rate file: %s`, err))
+				}
+				count, warnings := rs.Company().ImportCurrencyRates(rates, rs.RateType())
+				report = append(report, rs.T(`%s: %d rates imported.`, file, count))
+				report = append(report, warnings...)
+			}
+			rs.SetReport(strings.Join(report, "\n"))
+			return &actions.Action{
+				Type:     actions.ActionActWindow,
+				Model:    "AccountCurrencyRateImport",
+				ViewMode: "form",
+				ResID:    rs.ID(),
+				Target:   "new",
+			}
+		})
+
+}
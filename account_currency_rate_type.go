@@ -0,0 +1,96 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package account
+
+import (
+	"regexp"
+
+	"github.com/hexya-erp/hexya/src/models"
+	"github.com/hexya-erp/hexya/src/models/types"
+	"github.com/hexya-erp/hexya/src/models/types/dates"
+	"github.com/hexya-erp/pool/h"
+	"github.com/hexya-erp/pool/m"
+	"github.com/hexya-erp/pool/q"
+)
+
+// currencyRateTypes are the types of currency rates.
+// Transactions are always converted at spot rates.
+var currencyRateTypes = types.Selection{
+	"spot":    "Spot Rate",
+	"average": "Average Rate",
+	"budget":  "Budget Rate",
+}
+
+// currencyRateTable matches the currency rate table and its alias in SQL queries
+var currencyRateTable = regexp.MustCompile(`FROM currency_rate (\w+)`)
+
+// rateTypeCondition returns the condition on currency rates of the given type.
+// Rates without type are spot rates.
+func rateTypeCondition(rateType string) q.CurrencyRateCondition {
+	if rateType == "spot" {
+		return q.CurrencyRate().RateType().Equals("spot").Or().RateType().IsNull()
+	}
+	return q.CurrencyRate().RateType().Equals(rateType)
+}
+
+func init() {
+
+	h.CurrencyRate().AddFields(map[string]models.FieldDefinition{
+		"RateType": models.SelectionField{
+			String:    "Rate Type",
+			Selection: currencyRateTypes,
+			Required:  true,
+			Index:     true,
+			Default:   models.DefaultValue("spot"),
+			Help: `Spot rates are used to convert transactions. Average and budget rates are only used
+by the reports that are asked to translate amounts with them.`},
+	})
+
+	h.CurrencyRate().Methods().Search().Extend(
+		`Search only returns the rates of the type given by the 'rate_type' key of the context, if any.`,
+		func(rs m.CurrencyRateSet, cond q.CurrencyRateCondition) m.CurrencyRateSet {
+			if rateType := rs.Env().Context().GetString("rate_type"); rateType != "" {
+				cond = cond.AndCond(rateTypeCondition(rateType))
+			}
+			return rs.Super().Search(cond)
+		})
+
+	h.Currency().Methods().ComputeCurrentRate().Extend(
+		`ComputeCurrentRate returns the current rate of this currency.
+		 If a 'rate_type' key is given in the context, then only the rates of this type
+		 are considered. The spot rate is returned if the currency has no rate of this type
+		 for the company of the context.`,
+		func(rs m.CurrencySet) m.CurrencyData {
+			rateType := rs.Env().Context().GetString("rate_type")
+			if rateType == "" {
+				rateType = "spot"
+			}
+			if rateType != "spot" {
+				date := dates.Now()
+				if rs.Env().Context().HasKey("date") {
+					date = rs.Env().Context().GetDate("date").ToDateTime()
+				}
+				company := h.User().NewSet(rs.Env()).GetCompany()
+				if rs.Env().Context().HasKey("company_id") {
+					company = h.Company().BrowseOne(rs.Env(), rs.Env().Context().GetInteger("company_id"))
+				}
+				if h.CurrencyRate().Search(rs.Env(),
+					q.CurrencyRate().Currency().Equals(rs).
+						And().Name().LowerOrEqual(date).
+						AndCond(q.CurrencyRate().Company().IsNull().Or().Company().Equals(company)).
+						AndCond(rateTypeCondition(rateType))).IsEmpty() {
+					rateType = "spot"
+				}
+			}
+			return rs.WithContext("rate_type", rateType).Super().ComputeCurrentRate()
+		})
+
+	h.Currency().Methods().SelectCompaniesRates().Extend(
+		`SelectCompaniesRates returns an SQL query to get the spot currency rates per companies.`,
+		func(rs m.CurrencySet) string {
+			return currencyRateTable.ReplaceAllString(rs.Super().SelectCompaniesRates(),
+				"FROM (SELECT * FROM currency_rate WHERE rate_type IS NULL OR rate_type = 'spot') $1")
+		})
+
+}
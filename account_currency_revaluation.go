@@ -0,0 +1,285 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package account
+
+import (
+	"math"
+
+	"github.com/hexya-erp/hexya/src/actions"
+	"github.com/hexya-erp/hexya/src/models"
+	"github.com/hexya-erp/hexya/src/models/types/dates"
+	"github.com/hexya-erp/pool/h"
+	"github.com/hexya-erp/pool/m"
+	"github.com/hexya-erp/pool/q"
+)
+
+// revaluationKey identifies a group of journal items revalued together
+type revaluationKey struct {
+	account  int64
+	partner  int64
+	currency int64
+}
+
+func init() {
+
+	h.AccountCurrencyRevaluation().DeclareTransientModel()
+	h.AccountCurrencyRevaluation().AddFields(map[string]models.FieldDefinition{
+		"Company": models.Many2OneField{
+			RelationModel: h.Company(),
+			Required:      true,
+			OnChange:      h.AccountCurrencyRevaluation().Methods().OnchangeCompany(),
+			Default: func(env models.Environment) interface{} {
+				return h.User().NewSet(env).CurrentUser().Company()
+			}},
+		"Date": models.DateField{
+			String:   "Revaluation Date",
+			Required: true,
+			OnChange: h.AccountCurrencyRevaluation().Methods().OnchangeDate(),
+			Default: func(env models.Environment) interface{} {
+				return dates.Today().StartOfMonth().AddDate(0, 0, -1)
+			},
+			Help: "Open balances at this date are revalued at the closing rate of this date"},
+		"ReverseDate": models.DateField{
+			String:   "Reversal Date",
+			Required: true,
+			Default: func(env models.Environment) interface{} {
+				return dates.Today().StartOfMonth()
+			},
+			Help: "The revaluation entry is automatically reversed on this date"},
+		"Journal": models.Many2OneField{
+			RelationModel: h.AccountJournal(),
+			Required:      true,
+			Default: func(env models.Environment) interface{} {
+				return h.User().NewSet(env).CurrentUser().Company().CurrencyExchangeJournal()
+			}},
+		"Lines": models.One2ManyField{
+			RelationModel: h.AccountCurrencyRevaluationLine(),
+			ReverseFK:     "Revaluation",
+			JSON:          "line_ids",
+			ReadOnly:      true},
+		"Move": models.Many2OneField{
+			String:        "Revaluation Entry",
+			RelationModel: h.AccountMove(),
+			ReadOnly:      true},
+	})
+
+	h.AccountCurrencyRevaluationLine().DeclareTransientModel()
+	h.AccountCurrencyRevaluationLine().AddFields(map[string]models.FieldDefinition{
+		"Revaluation": models.Many2OneField{
+			RelationModel: h.AccountCurrencyRevaluation(),
+			Required:      true,
+			OnDelete:      models.Cascade},
+		"Account": models.Many2OneField{
+			RelationModel: h.AccountAccount(),
+			Required:      true,
+			OnDelete:      models.Cascade},
+		"Partner": models.Many2OneField{
+			RelationModel: h.Partner()},
+		"Currency": models.Many2OneField{
+			RelationModel: h.Currency(),
+			Required:      true},
+		"AmountCurrency": models.FloatField{
+			String: "Open Amount in Currency"},
+		"Balance": models.FloatField{
+			String: "Booked Balance",
+			Help:   "Open balance in company currency at the rates of the journal items"},
+		"RevaluedBalance": models.FloatField{
+			String: "Revalued Balance",
+			Help:   "Open amount in currency converted at the closing rate"},
+		"Difference": models.FloatField{
+			String: "Unrealized Gain/Loss"},
+	})
+
+	h.AccountCurrencyRevaluation().Methods().OnchangeCompany().DeclareMethod(
+		`OnchangeCompany sets the exchange difference journal of the selected company`,
+		func(rs m.AccountCurrencyRevaluationSet) m.AccountCurrencyRevaluationData {
+			return h.AccountCurrencyRevaluation().NewData().SetJournal(rs.Company().CurrencyExchangeJournal())
+		})
+
+	h.AccountCurrencyRevaluation().Methods().OnchangeDate().DeclareMethod(
+		`OnchangeDate sets the reversal date to the day after the revaluation date`,
+		func(rs m.AccountCurrencyRevaluationSet) m.AccountCurrencyRevaluationData {
+			if rs.Date().IsZero() {
+				return h.AccountCurrencyRevaluation().NewData()
+			}
+			return h.AccountCurrencyRevaluation().NewData().SetReverseDate(rs.Date().AddDate(0, 0, 1))
+		})
+
+	h.AccountCurrencyRevaluation().Methods().ComputeLines().DeclareMethod(
+		`ComputeLines returns the revaluation of the open foreign currency balances of the company
+		at the revaluation date, grouped by account, partner and currency. Receivables and payables
+		are revalued on their residual amounts and liquidity accounts on their balances.`,
+		func(rs m.AccountCurrencyRevaluationSet) []m.AccountCurrencyRevaluationLineData {
+			company := rs.Company()
+			companyCurrency := company.Currency()
+			moveLines := h.AccountMoveLine().Search(rs.Env(),
+				q.AccountMoveLine().Company().Equals(company).
+					And().Date().LowerOrEqual(rs.Date()).
+					And().Currency().IsNotNull().
+					And().Currency().NotEquals(companyCurrency).
+					And().MoveFilteredOn(q.AccountMove().State().Equals("posted")).
+					And().AccountFilteredOn(q.AccountAccount().InternalType().In([]string{"receivable", "payable", "liquidity"})))
+
+			var keys []revaluationKey
+			amountsCurrency := make(map[revaluationKey]float64)
+			balances := make(map[revaluationKey]float64)
+			for _, line := range moveLines.Records() {
+				key := revaluationKey{
+					account:  line.Account().ID(),
+					partner:  line.Partner().ID(),
+					currency: line.Currency().ID(),
+				}
+				if _, ok := amountsCurrency[key]; !ok {
+					keys = append(keys, key)
+				}
+				if line.Account().InternalType() == "liquidity" {
+					amountsCurrency[key] += line.AmountCurrency()
+					balances[key] += line.Debit() - line.Credit()
+					continue
+				}
+				residual, residualCurrency := rs.ResidualsAtDate(line)
+				amountsCurrency[key] += residualCurrency
+				balances[key] += residual
+			}
+
+			var res []m.AccountCurrencyRevaluationLineData
+			for _, key := range keys {
+				currency := h.Currency().BrowseOne(rs.Env(), key.currency)
+				balance := companyCurrency.Round(balances[key])
+				revalued := currency.
+					WithContext("date", rs.Date()).
+					WithContext("company_id", company.ID()).
+					Compute(amountsCurrency[key], companyCurrency, true)
+				difference := companyCurrency.Round(revalued - balance)
+				if companyCurrency.IsZero(difference) {
+					continue
+				}
+				partner := h.Partner().NewSet(rs.Env())
+				if key.partner != 0 {
+					partner = h.Partner().BrowseOne(rs.Env(), key.partner)
+				}
+				res = append(res, h.AccountCurrencyRevaluationLine().NewData().
+					SetAccount(h.AccountAccount().BrowseOne(rs.Env(), key.account)).
+					SetPartner(partner).
+					SetCurrency(currency).
+					SetAmountCurrency(currency.Round(amountsCurrency[key])).
+					SetBalance(balance).
+					SetRevaluedBalance(revalued).
+					SetDifference(difference))
+			}
+			return res
+		})
+
+	h.AccountCurrencyRevaluation().Methods().ResidualsAtDate().DeclareMethod(
+		`ResidualsAtDate returns the residual amounts in company currency and in foreign currency
+		of the given journal item at the revaluation date. Matchings with journal items dated after
+		the revaluation date are not taken into account.`,
+		func(rs m.AccountCurrencyRevaluationSet, line m.AccountMoveLineSet) (float64, float64) {
+			residual := line.AmountResidual()
+			residualCurrency := line.AmountResidualCurrency()
+			for _, partial := range line.MatchedCredits().Union(line.MatchedDebits()).Records() {
+				sign := -1.0
+				counterpart := partial.DebitMove()
+				if partial.DebitMove().Equals(line) {
+					sign = 1
+					counterpart = partial.CreditMove()
+				}
+				if !counterpart.Date().Greater(rs.Date()) {
+					continue
+				}
+				residual += sign * partial.Amount()
+				switch {
+				case partial.Currency().Equals(line.Currency()):
+					residualCurrency += sign * partial.AmountCurrency()
+				case line.Balance() != 0:
+					residualCurrency += sign * partial.Amount() * line.AmountCurrency() / line.Balance()
+				}
+			}
+			return residual, residualCurrency
+		})
+
+	h.AccountCurrencyRevaluation().Methods().ActionPreview().DeclareMethod(
+		`ActionPreview lists the unrealized exchange differences without booking them`,
+		func(rs m.AccountCurrencyRevaluationSet) *actions.Action {
+			rs.EnsureOne()
+			rs.Lines().Unlink()
+			for _, data := range rs.ComputeLines() {
+				h.AccountCurrencyRevaluationLine().Create(rs.Env(), data.SetRevaluation(rs))
+			}
+			return &actions.Action{
+				Type:     actions.ActionActWindow,
+				Model:    "AccountCurrencyRevaluation",
+				ViewMode: "form",
+				ResID:    rs.ID(),
+				Target:   "new",
+			}
+		})
+
+	h.AccountCurrencyRevaluation().Methods().PrepareMoveData().DeclareMethod(
+		`PrepareMoveData returns the data of the revaluation entry of the given lines. Each difference
+		is booked on the revalued account against the exchange gain or loss account of the company.`,
+		func(rs m.AccountCurrencyRevaluationSet, lines []m.AccountCurrencyRevaluationLineData) m.AccountMoveData {
+			company := rs.Company()
+			ref := rs.T(`Unrealized exchange difference %s`, rs.Date())
+			data := h.AccountMove().NewData().
+				SetJournal(rs.Journal()).
+				SetDate(rs.Date()).
+				SetAutoReverseDate(rs.ReverseDate()).
+				SetRef(ref)
+			for _, line := range lines {
+				difference := line.Difference()
+				data = data.CreateLines(h.AccountMoveLine().NewData().
+					SetName(ref).
+					SetAccount(line.Account()).
+					SetPartner(line.Partner()).
+					SetCurrency(line.Currency()).
+					SetAmountCurrency(0).
+					SetDebit(math.Max(difference, 0)).
+					SetCredit(math.Max(-difference, 0)))
+				counterpart := company.IncomeCurrencyExchangeAccount()
+				if difference < 0 {
+					counterpart = company.ExpenseCurrencyExchangeAccount()
+				}
+				data = data.CreateLines(h.AccountMoveLine().NewData().
+					SetName(ref).
+					SetAccount(counterpart).
+					SetPartner(line.Partner()).
+					SetDebit(math.Max(-difference, 0)).
+					SetCredit(math.Max(difference, 0)))
+			}
+			return data
+		})
+
+	h.AccountCurrencyRevaluation().Methods().ActionRevalue().DeclareMethod(
+		`ActionRevalue books the unrealized exchange differences in an entry of the exchange difference
+		journal, which is automatically reversed at the reversal date.`,
+		func(rs m.AccountCurrencyRevaluationSet) *actions.Action {
+			rs.EnsureOne()
+			company := rs.Company()
+			if company.IncomeCurrencyExchangeAccount().IsEmpty() {
+				panic(rs.T(`You should configure the 'Gain Exchange Rate Account' in the accounting settings, to manage automatically the booking of accounting entries related to differences between exchange rates.`))
+			}
+			if company.ExpenseCurrencyExchangeAccount().IsEmpty() {
+				panic(rs.T(`You should configure the 'Loss Exchange Rate Account' in the accounting settings, to manage automatically the booking of accounting entries related to differences between exchange rates.`))
+			}
+			if !rs.ReverseDate().Greater(rs.Date()) {
+				panic(rs.T(`The reversal date must be after the revaluation date.`))
+			}
+			lines := rs.ComputeLines()
+			if len(lines) == 0 {
+				panic(rs.T(`There is no exchange difference to book at %s.`, rs.Date()))
+			}
+			move := h.AccountMove().Create(rs.Env(), rs.PrepareMoveData(lines))
+			move.Post()
+			rs.SetMove(move)
+			return &actions.Action{
+				Name:     rs.T(`Revaluation Entry`),
+				Type:     actions.ActionActWindow,
+				Model:    "AccountMove",
+				ViewMode: "form",
+				ResID:    move.ID(),
+			}
+		})
+
+}
@@ -0,0 +1,281 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package account
+
+import (
+	"math"
+
+	"github.com/hexya-erp/hexya/src/models"
+	"github.com/hexya-erp/hexya/src/models/types"
+	"github.com/hexya-erp/hexya/src/models/types/dates"
+	"github.com/hexya-erp/pool/h"
+	"github.com/hexya-erp/pool/m"
+	"github.com/hexya-erp/pool/q"
+)
+
+// profitLossAccountTypes are the external IDs of the account types closed at the end of the fiscal year
+var profitLossAccountTypes = []string{
+	"account_data_account_type_other_income",
+	"account_data_account_type_revenue",
+	"account_data_account_type_depreciation",
+	"account_data_account_type_expenses",
+	"account_data_account_type_direct_costs",
+}
+
+func init() {
+
+	h.AccountFiscalyearClosing().DeclareModel()
+	h.AccountFiscalyearClosing().SetDefaultOrder("DateTo DESC", "ID DESC")
+
+	h.AccountFiscalyearClosing().AddFields(map[string]models.FieldDefinition{
+		"Company": models.Many2OneField{
+			RelationModel: h.Company(),
+			Required:      true,
+			Default: func(env models.Environment) interface{} {
+				return h.User().NewSet(env).CurrentUser().Company()
+			},
+			OnChange: h.AccountFiscalyearClosing().Methods().OnchangeCompany()},
+		"DateFrom": models.DateField{
+			String:   "Start Date",
+			Required: true},
+		"DateTo": models.DateField{
+			String:   "End Date",
+			Required: true,
+			OnChange: h.AccountFiscalyearClosing().Methods().OnchangeDateTo()},
+		"Journal": models.Many2OneField{
+			RelationModel: h.AccountJournal(),
+			Required:      true,
+			Filter:        q.AccountJournal().Type().Equals("general"),
+			Help:          "Journal in which the closing and opening entries are booked"},
+		"EarningsAccount": models.Many2OneField{
+			String:        "Current Year Earnings Account",
+			RelationModel: h.AccountAccount(),
+			Required:      true,
+			Filter: q.AccountAccount().UserTypeFilteredOn(
+				q.AccountAccountType().HexyaExternalID().Equals("account_data_unaffected_earnings"))},
+		"ProfitLossTypes": models.Many2ManyField{
+			String:        "Income and Expense Types",
+			RelationModel: h.AccountAccountType(),
+			JSON:          "profit_loss_type_ids",
+			Default: func(env models.Environment) interface{} {
+				res := h.AccountAccountType().NewSet(env)
+				for _, xmlID := range profitLossAccountTypes {
+					res = res.Union(h.AccountAccountType().NewSet(env).GetRecord(xmlID))
+				}
+				return res
+			},
+			Help: "Accounts of these types are closed into the current year earnings account"},
+		"ClosingMove": models.Many2OneField{
+			String:        "Closing Entry",
+			RelationModel: h.AccountMove(),
+			ReadOnly:      true},
+		"OpeningMove": models.Many2OneField{
+			String:        "Opening Entry",
+			RelationModel: h.AccountMove(),
+			ReadOnly:      true},
+		"PreviousLockDate": models.DateField{
+			String:   "Previous Lock Date",
+			ReadOnly: true},
+		"State": models.SelectionField{
+			Selection: types.Selection{
+				"draft":     "Draft",
+				"done":      "Closed",
+				"cancelled": "Cancelled"},
+			Required: true,
+			ReadOnly: true,
+			Default:  models.DefaultValue("draft")},
+	})
+
+	h.AccountFiscalyearClosing().Methods().OnchangeCompany().DeclareMethod(
+		`OnchangeCompany sets the dates of the last finished fiscal year of the company,
+		and its default journal and earnings account.`,
+		func(rs m.AccountFiscalyearClosingSet) m.AccountFiscalyearClosingData {
+			data := h.AccountFiscalyearClosing().NewData()
+			if rs.Company().IsEmpty() {
+				return data
+			}
+			currentFrom, _ := rs.Company().ComputeFiscalyearDates(dates.Today())
+			dateFrom, dateTo := rs.Company().ComputeFiscalyearDates(currentFrom.AddDate(0, 0, -1))
+			data.SetDateFrom(dateFrom).
+				SetDateTo(dateTo).
+				SetJournal(h.AccountJournal().Search(rs.Env(),
+					q.AccountJournal().Company().Equals(rs.Company()).And().Type().Equals("general")).Limit(1)).
+				SetEarningsAccount(h.AccountAccount().Search(rs.Env(),
+					q.AccountAccount().Company().Equals(rs.Company()).
+						And().UserTypeFilteredOn(
+						q.AccountAccountType().HexyaExternalID().Equals("account_data_unaffected_earnings"))).Limit(1))
+			return data
+		})
+
+	h.AccountFiscalyearClosing().Methods().OnchangeDateTo().DeclareMethod(
+		`OnchangeDateTo sets the start date of the fiscal year ending on the end date`,
+		func(rs m.AccountFiscalyearClosingSet) m.AccountFiscalyearClosingData {
+			data := h.AccountFiscalyearClosing().NewData()
+			if rs.DateTo().IsZero() || rs.Company().IsEmpty() {
+				return data
+			}
+			dateFrom, _ := rs.Company().ComputeFiscalyearDates(rs.DateTo())
+			return data.SetDateFrom(dateFrom)
+		})
+
+	h.AccountFiscalyearClosing().Methods().ComputeBalances().DeclareMethod(
+		`ComputeBalances returns the balance and the balance in account currency of each of the given
+		accounts, over the posted entries of the fiscal year.`,
+		func(rs m.AccountFiscalyearClosingSet, accounts m.AccountAccountSet) (map[int64]float64, map[int64]float64) {
+			balances := make(map[int64]float64)
+			currencyBalances := make(map[int64]float64)
+			lines := h.AccountMoveLine().Search(rs.Env(),
+				q.AccountMoveLine().Account().In(accounts).
+					And().Date().GreaterOrEqual(rs.DateFrom()).
+					And().Date().LowerOrEqual(rs.DateTo()).
+					And().MoveFilteredOn(q.AccountMove().State().Equals("posted")))
+			for _, line := range lines.Records() {
+				balances[line.Account().ID()] += line.Debit() - line.Credit()
+				currencyBalances[line.Account().ID()] += line.AmountCurrency()
+			}
+			return balances, currencyBalances
+		})
+
+	h.AccountFiscalyearClosing().Methods().PrepareMoveData().DeclareMethod(
+		`PrepareMoveData returns the data of an entry on the given date moving the given balances
+		into the current year earnings account. If opening is true, the balances are reopened
+		instead of being closed.`,
+		func(rs m.AccountFiscalyearClosingSet, date dates.Date, ref string, accounts m.AccountAccountSet,
+			balances, currencyBalances map[int64]float64, opening bool) m.AccountMoveData {
+
+			currency := rs.Company().Currency()
+			data := h.AccountMove().NewData().
+				SetJournal(rs.Journal()).
+				SetDate(date).
+				SetRef(ref)
+			sign := -1.0
+			if opening {
+				sign = 1.0
+			}
+			var total float64
+			for _, account := range accounts.Records() {
+				balance := currency.Round(balances[account.ID()] * sign)
+				if currency.IsZero(balance) {
+					continue
+				}
+				total += balance
+				line := h.AccountMoveLine().NewData().
+					SetName(ref).
+					SetAccount(account).
+					SetDebit(math.Max(balance, 0)).
+					SetCredit(math.Max(-balance, 0))
+				if account.Currency().IsNotEmpty() {
+					line.SetCurrency(account.Currency()).
+						SetAmountCurrency(currencyBalances[account.ID()] * sign)
+				}
+				data = data.CreateLines(line)
+			}
+			if currency.IsZero(total) {
+				return data
+			}
+			data = data.CreateLines(h.AccountMoveLine().NewData().
+				SetName(ref).
+				SetAccount(rs.EarningsAccount()).
+				SetDebit(math.Max(-total, 0)).
+				SetCredit(math.Max(total, 0)))
+			return data
+		})
+
+	h.AccountFiscalyearClosing().Methods().ActionClose().DeclareMethod(
+		`ActionClose closes the fiscal year:
+
+		- it checks that no entry of the year is left unposted,
+		- it books the income and expense balances into the current year earnings account,
+		- it reopens the balance sheet accounts whose type does not include the initial balance,
+		- it sets the company's lock date at the end of the year.`,
+		func(rs m.AccountFiscalyearClosingSet) bool {
+			rs.EnsureOne()
+			if rs.State() != "draft" {
+				panic(rs.T(`This fiscal year closing has already been processed.`))
+			}
+			if rs.DateTo().Lower(rs.DateFrom()) {
+				panic(rs.T(`The end date must be after the start date.`))
+			}
+			company := rs.Company()
+			if !company.FiscalyearLockDate().IsZero() && company.FiscalyearLockDate().GreaterEqual(rs.DateTo()) {
+				panic(rs.T(`The fiscal year ending on %s is already locked.`, rs.DateTo()))
+			}
+			company.ValidateFiscalyearLock(h.Company().NewData().SetFiscalyearLockDate(rs.DateTo()))
+
+			profitLossAccounts := h.AccountAccount().Search(rs.Env(),
+				q.AccountAccount().Company().Equals(company).
+					And().UserType().In(rs.ProfitLossTypes()))
+			reopenAccounts := h.AccountAccount().Search(rs.Env(),
+				q.AccountAccount().Company().Equals(company).
+					And().UserType().NotIn(rs.ProfitLossTypes()).
+					And().UserTypeFilteredOn(q.AccountAccountType().IncludeInitialBalance().Equals(false))).
+				Subtract(rs.EarningsAccount())
+			closedAccounts := profitLossAccounts.Union(reopenAccounts)
+
+			balances, currencyBalances := rs.ComputeBalances(closedAccounts)
+			closingData := rs.PrepareMoveData(rs.DateTo(), rs.T(`Fiscal year closing %s`, rs.DateTo()),
+				closedAccounts, balances, currencyBalances, false)
+			openingData := rs.PrepareMoveData(rs.DateTo().AddDate(0, 0, 1), rs.T(`Opening entry %s`, rs.DateTo().AddDate(0, 0, 1)),
+				reopenAccounts, balances, currencyBalances, true)
+
+			data := h.AccountFiscalyearClosing().NewData().
+				SetPreviousLockDate(company.FiscalyearLockDate()).
+				SetState("done")
+			if closingData.HasLines() {
+				closingMove := h.AccountMove().Create(rs.Env(), closingData)
+				closingMove.Post()
+				data.SetClosingMove(closingMove)
+			}
+			if openingData.HasLines() {
+				openingMove := h.AccountMove().Create(rs.Env(), openingData)
+				openingMove.Post()
+				data.SetOpeningMove(openingMove)
+			}
+			rs.Write(data)
+			company.SetFiscalyearLockDate(rs.DateTo())
+			return true
+		})
+
+	h.AccountFiscalyearClosing().Methods().ActionUndo().DeclareMethod(
+		`ActionUndo cancels the closing of the fiscal year: it deletes the closing and opening entries
+		and restores the previous lock date. This is only possible as long as no other entry has been
+		recorded in the next fiscal year, and if the closing journal allows cancelling entries.`,
+		func(rs m.AccountFiscalyearClosingSet) bool {
+			rs.EnsureOne()
+			if rs.State() != "done" {
+				panic(rs.T(`Only a processed fiscal year closing can be undone.`))
+			}
+			if h.AccountFiscalyearClosing().Search(rs.Env(),
+				q.AccountFiscalyearClosing().Company().Equals(rs.Company()).
+					And().State().Equals("done").
+					And().DateTo().Greater(rs.DateTo())).IsNotEmpty() {
+				panic(rs.T(`You must first undo the closing of the following fiscal years.`))
+			}
+			if h.AccountMove().Search(rs.Env(),
+				q.AccountMove().Company().Equals(rs.Company()).
+					And().Date().Greater(rs.DateTo()).
+					And().ID().NotIn(rs.OpeningMove().Ids())).IsNotEmpty() {
+				panic(rs.T(`The closing cannot be undone because entries have already been recorded in the next fiscal year.`))
+			}
+			rs.Company().SetFiscalyearLockDate(rs.PreviousLockDate())
+			moves := rs.ClosingMove().Union(rs.OpeningMove())
+			if moves.IsNotEmpty() {
+				moves.ButtonCancel()
+				moves.Unlink()
+			}
+			rs.SetState("cancelled")
+			return true
+		})
+
+	h.AccountFiscalyearClosing().Methods().Unlink().Extend("",
+		func(rs m.AccountFiscalyearClosingSet) int64 {
+			for _, rec := range rs.Records() {
+				if rec.State() == "done" {
+					panic(rs.T(`You cannot delete a processed fiscal year closing. Undo it first.`))
+				}
+			}
+			return rs.Super().Unlink()
+		})
+
+}
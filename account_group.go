@@ -0,0 +1,289 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package account
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/hexya-addons/account/accounttypes"
+	"github.com/hexya-erp/hexya/src/models"
+	"github.com/hexya-erp/pool/h"
+	"github.com/hexya-erp/pool/m"
+	"github.com/hexya-erp/pool/q"
+)
+
+func init() {
+
+	h.AccountGroup().DeclareModel()
+	h.AccountGroup().SetDefaultOrder("CodePrefixStart")
+
+	h.AccountGroup().AddFields(map[string]models.FieldDefinition{
+		"Name": models.CharField{
+			Required:  true,
+			Translate: true},
+		"CodePrefixStart": models.CharField{
+			String:     "Code Prefix Start",
+			Required:   true,
+			Index:      true,
+			Constraint: h.AccountGroup().Methods().CheckPrefixes(),
+			Help:       "The accounts whose code starts with a prefix between the start and the end belong to this group"},
+		"CodePrefixEnd": models.CharField{
+			String:     "Code Prefix End",
+			Constraint: h.AccountGroup().Methods().CheckPrefixes(),
+			Help:       "Defaults to the start prefix. It must have the same length as the start prefix."},
+		"Parent": models.Many2OneField{
+			RelationModel: h.AccountGroup(),
+			ReadOnly:      true,
+			Index:         true,
+			Help:          "The parent group is the most specific group whose range contains this group's range"},
+		"Children": models.One2ManyField{
+			String:        "Sub-groups",
+			RelationModel: h.AccountGroup(),
+			ReverseFK:     "Parent",
+			JSON:          "children_ids"},
+		"Accounts": models.One2ManyField{
+			RelationModel: h.AccountAccount(),
+			ReverseFK:     "Group",
+			JSON:          "account_ids"},
+		"Company": models.Many2OneField{
+			RelationModel: h.Company(),
+			Required:      true,
+			Constraint:    h.AccountGroup().Methods().CheckPrefixes(),
+			Default: func(env models.Environment) interface{} {
+				return h.Company().NewSet(env).CompanyDefaultGet()
+			}},
+	})
+
+	h.AccountAccount().AddFields(map[string]models.FieldDefinition{
+		"Group": models.Many2OneField{
+			RelationModel: h.AccountGroup(),
+			ReadOnly:      true,
+			Index:         true,
+			Help:          "The most specific group whose code prefixes match the code of this account"},
+	})
+
+	h.AccountGroup().Methods().CheckPrefixes().DeclareMethod(
+		`CheckPrefixes checks that the prefixes of the groups are consistent, that groups of the same prefix
+		length do not overlap and that groups of different prefix lengths are nested.`,
+		func(rs m.AccountGroupSet) {
+			for _, group := range rs.Records() {
+				start, end := group.CodePrefixStart(), group.CodePrefixEnd()
+				if len(start) != len(end) {
+					panic(rs.T(`The start and end prefixes of group %s must have the same length.`, group.Name()))
+				}
+				if start > end {
+					panic(rs.T(`The start prefix of group %s must not be greater than its end prefix.`, group.Name()))
+				}
+				others := h.AccountGroup().Search(rs.Env(),
+					q.AccountGroup().Company().Equals(group.Company()).And().ID().NotEquals(group.ID()))
+				for _, other := range others.Records() {
+					switch {
+					case len(other.CodePrefixStart()) == len(start):
+						if other.CodePrefixStart() <= end && other.CodePrefixEnd() >= start {
+							panic(rs.T(`Group %s overlaps group %s.`, group.Name(), other.Name()))
+						}
+					case len(other.CodePrefixStart()) < len(start):
+						if other.ContainsCode(start) != other.ContainsCode(end) {
+							panic(rs.T(`Group %s must be entirely inside or outside group %s.`, group.Name(), other.Name()))
+						}
+					default:
+						if group.ContainsCode(other.CodePrefixStart()) != group.ContainsCode(other.CodePrefixEnd()) {
+							panic(rs.T(`Group %s must be entirely inside or outside group %s.`, other.Name(), group.Name()))
+						}
+					}
+				}
+			}
+		})
+
+	h.AccountGroup().Methods().ContainsCode().DeclareMethod(
+		`ContainsCode returns true if the given account code or prefix belongs to the range of this group`,
+		func(rs m.AccountGroupSet, code string) bool {
+			start := rs.CodePrefixStart()
+			if len(code) < len(start) {
+				return false
+			}
+			prefix := code[:len(start)]
+			return prefix >= start && prefix <= rs.CodePrefixEnd()
+		})
+
+	h.AccountGroup().Methods().FindForCode().DeclareMethod(
+		`FindForCode returns the most specific group of this set whose range contains the given code,
+		or an empty set if there is none.`,
+		func(rs m.AccountGroupSet, code string) m.AccountGroupSet {
+			res := h.AccountGroup().NewSet(rs.Env())
+			for _, group := range rs.Records() {
+				if !group.ContainsCode(code) {
+					continue
+				}
+				if res.IsEmpty() || len(group.CodePrefixStart()) > len(res.CodePrefixStart()) {
+					res = group
+				}
+			}
+			return res
+		})
+
+	h.AccountGroup().Methods().AdaptTree().DeclareMethod(
+		`AdaptTree recomputes the parent of all the groups of the given company and assigns
+		each account of the company to its most specific group.`,
+		func(rs m.AccountGroupSet, company m.CompanySet) {
+			groups := h.AccountGroup().Search(rs.Env(), q.AccountGroup().Company().Equals(company))
+			for _, group := range groups.Records() {
+				candidates := groups.Filtered(func(r m.AccountGroupSet) bool {
+					return len(r.CodePrefixStart()) < len(group.CodePrefixStart())
+				})
+				parent := candidates.FindForCode(group.CodePrefixStart())
+				if !parent.Equals(group.Parent()) {
+					group.SetParent(parent)
+				}
+			}
+			accounts := h.AccountAccount().Search(rs.Env(), q.AccountAccount().Company().Equals(company))
+			for _, account := range accounts.Records() {
+				group := groups.FindForCode(account.Code())
+				if !group.Equals(account.Group()) {
+					account.SetGroup(group)
+				}
+			}
+		})
+
+	h.AccountGroup().Methods().Ancestors().DeclareMethod(
+		`Ancestors returns the chain of groups from the root group down to this group`,
+		func(rs m.AccountGroupSet) []m.AccountGroupSet {
+			var res []m.AccountGroupSet
+			for group := rs; group.IsNotEmpty(); group = group.Parent() {
+				res = append([]m.AccountGroupSet{group}, res...)
+			}
+			return res
+		})
+
+	h.AccountGroup().Methods().WithDescendants().DeclareMethod(
+		`WithDescendants returns the groups of this set together with all their sub-groups`,
+		func(rs m.AccountGroupSet) m.AccountGroupSet {
+			res := rs
+			children := h.AccountGroup().Search(rs.Env(), q.AccountGroup().Parent().In(rs))
+			for children.IsNotEmpty() {
+				res = res.Union(children)
+				children = h.AccountGroup().Search(rs.Env(), q.AccountGroup().Parent().In(children))
+			}
+			return res
+		})
+
+	h.AccountGroup().Methods().HierarchyLines().DeclareMethod(
+		`HierarchyLines returns the given account lines sorted by code, with a line before the accounts
+		of each group holding the subtotals of the group. Account lines are indented below their group.`,
+		func(rs m.AccountGroupSet, lines []accounttypes.AccountReportLine) []accounttypes.AccountReportLine {
+			sort.SliceStable(lines, func(i, j int) bool {
+				return lines[i].Code < lines[j].Code
+			})
+			chains := make([][]m.AccountGroupSet, len(lines))
+			subtotals := make(map[int64]*accounttypes.AccountReportLine)
+			for i, line := range lines {
+				chains[i] = h.AccountAccount().BrowseOne(rs.Env(), line.AccountID).Group().Ancestors()
+				for level, group := range chains[i] {
+					subtotal, ok := subtotals[group.ID()]
+					if !ok {
+						subtotal = &accounttypes.AccountReportLine{
+							GroupID: group.ID(),
+							Code:    group.CodePrefixStart(),
+							Name:    group.Name(),
+							Level:   level,
+						}
+						subtotals[group.ID()] = subtotal
+					}
+					subtotal.Debit += line.Debit
+					subtotal.Credit += line.Credit
+					subtotal.Balance += line.Balance
+				}
+			}
+			var res []accounttypes.AccountReportLine
+			var previous []m.AccountGroupSet
+			for i, line := range lines {
+				for level, group := range chains[i] {
+					if level < len(previous) && previous[level].Equals(group) {
+						continue
+					}
+					res = append(res, *subtotals[group.ID()])
+				}
+				line.Level = len(chains[i])
+				res = append(res, line)
+				previous = chains[i]
+			}
+			return res
+		})
+
+	h.AccountGroup().Methods().NameGet().Extend("",
+		func(rs m.AccountGroupSet) string {
+			if rs.CodePrefixEnd() == rs.CodePrefixStart() {
+				return fmt.Sprintf("%s %s", rs.CodePrefixStart(), rs.Name())
+			}
+			return fmt.Sprintf("%s-%s %s", rs.CodePrefixStart(), rs.CodePrefixEnd(), rs.Name())
+		})
+
+	h.AccountGroup().Methods().Create().Extend("",
+		func(rs m.AccountGroupSet, data m.AccountGroupData) m.AccountGroupSet {
+			if data.CodePrefixEnd() == "" {
+				data.SetCodePrefixEnd(data.CodePrefixStart())
+			}
+			res := rs.Super().Create(data)
+			res.AdaptTree(res.Company())
+			return res
+		})
+
+	h.AccountGroup().Methods().Write().Extend("",
+		func(rs m.AccountGroupSet, vals m.AccountGroupData) bool {
+			if !vals.HasCodePrefixStart() && !vals.HasCodePrefixEnd() && !vals.HasCompany() {
+				return rs.Super().Write(vals)
+			}
+			companies := h.Company().NewSet(rs.Env())
+			for _, group := range rs.Records() {
+				companies = companies.Union(group.Company())
+			}
+			res := rs.Super().Write(vals)
+			for _, group := range rs.Records() {
+				companies = companies.Union(group.Company())
+			}
+			for _, company := range companies.Records() {
+				rs.AdaptTree(company)
+			}
+			return res
+		})
+
+	h.AccountGroup().Methods().Unlink().Extend("",
+		func(rs m.AccountGroupSet) int64 {
+			companies := h.Company().NewSet(rs.Env())
+			for _, group := range rs.Records() {
+				companies = companies.Union(group.Company())
+			}
+			res := rs.Super().Unlink()
+			for _, company := range companies.Records() {
+				h.AccountGroup().NewSet(rs.Env()).AdaptTree(company)
+			}
+			return res
+		})
+
+	h.AccountAccount().Methods().Create().Extend("",
+		func(rs m.AccountAccountSet, data m.AccountAccountData) m.AccountAccountSet {
+			res := rs.Super().Create(data)
+			groups := h.AccountGroup().Search(rs.Env(), q.AccountGroup().Company().Equals(res.Company()))
+			if group := groups.FindForCode(res.Code()); group.IsNotEmpty() {
+				res.SetGroup(group)
+			}
+			return res
+		})
+
+	h.AccountAccount().Methods().Write().Extend("",
+		func(rs m.AccountAccountSet, vals m.AccountAccountData) bool {
+			res := rs.Super().Write(vals)
+			if vals.HasCode() || vals.HasCompany() {
+				for _, account := range rs.Records() {
+					groups := h.AccountGroup().Search(rs.Env(), q.AccountGroup().Company().Equals(account.Company()))
+					if group := groups.FindForCode(account.Code()); !group.Equals(account.Group()) {
+						account.SetGroup(group)
+					}
+				}
+			}
+			return res
+		})
+
+}
@@ -885,6 +885,8 @@ A Company bank account if this is a Customer Invoice or Vendor Refund, otherwise
 				panic(rs.T(`Invoice must be in draft or Pro-forma state in order to validate it.`))
 			}
 			toOpenInvoices.ActionDateAssign()
+			toOpenInvoices.CheckTaxLockDate()
+			toOpenInvoices.CheckPartnerVAT()
 			toOpenInvoices.ActionMoveCreate()
 			return toOpenInvoices.InvoiceValidate()
 		})
@@ -949,7 +951,8 @@ A Company bank account if this is a Customer Invoice or Vendor Refund, otherwise
 				SetAmount(tax.Amount).
 				SetBase(tax.Base).
 				SetManual(false).
-				SetSequence(int64(tax.Sequence))
+				SetSequence(int64(tax.Sequence)).
+				SetRepartitionLine(h.AccountTaxRepartitionLine().BrowseOne(rs.Env(), tax.RepartitionLineID))
 			if tax.Analytic {
 				vals.SetAccountAnalytic(line.AccountAnalytic())
 			}
@@ -976,7 +979,7 @@ A Company bank account if this is a Customer Invoice or Vendor Refund, otherwise
 			roundCurr := rs.Currency().Round
 			for _, line := range rs.InvoiceLines().Records() {
 				priceUnit := line.PriceUnit() * (1 - line.Discount()/100)
-				_, _, _, taxes := line.InvoiceLineTaxes().ComputeAll(priceUnit, rs.Currency(), line.Quantity(), line.Product(), rs.Partner())
+				_, _, _, taxes := line.InvoiceLineTaxes().AtDate(rs.TaxDate()).ComputeAll(priceUnit, rs.Currency(), line.Quantity(), line.Product(), rs.Partner())
 				for _, t := range taxes {
 					val := rs.PrepareTaxLineVals(line, t)
 					key := h.AccountTax().BrowseOne(rs.Env(), t.ID).GetGroupingKey(val)
@@ -1050,6 +1053,16 @@ A Company bank account if this is a Customer Invoice or Vendor Refund, otherwise
 			return rs.DateInvoice()
 		})
 
+	h.AccountInvoice().Methods().TaxDate().DeclareMethod(
+		`TaxDate returns the date at which the taxes and fiscal position mappings of this invoice are resolved.
+		It is the accounting date if set, the invoice date otherwise, or today for draft invoices without date.`,
+		func(rs m.AccountInvoiceSet) dates.Date {
+			if date := rs.GetCurrencyRateDate(); !date.IsZero() {
+				return date
+			}
+			return dates.Today()
+		})
+
 	h.AccountInvoice().Methods().ComputeInvoiceTotals().DeclareMethod(
 		`ComputeInvoiceTotals`,
 		func(rs m.AccountInvoiceSet, companyCurrency m.CurrencySet, invoiceMoveLines []accounttypes.InvoiceLineAMLStruct) (float64, float64, []accounttypes.InvoiceLineAMLStruct) {
@@ -1101,7 +1114,7 @@ A Company bank account if this is a Customer Invoice or Vendor Refund, otherwise
 					continue
 				}
 				taxes := h.AccountTax().NewSet(rs.Env())
-				for _, tax := range line.InvoiceLineTaxes().Records() {
+				for _, tax := range line.InvoiceLineTaxes().AtDate(rs.TaxDate()).Records() {
 					taxes = taxes.Union(tax)
 					for _, child := range tax.ChildrenTaxes().Records() {
 						if child.TypeTaxUse() != "none" {
@@ -1161,17 +1174,18 @@ A Company bank account if this is a Customer Invoice or Vendor Refund, otherwise
 				}
 
 				res = append(res, accounttypes.InvoiceLineAMLStruct{
-					InvoiceLineTaxID:  taxLine.ID(),
-					TaxLineID:         taxLine.Tax().ID(),
-					Type:              "tax",
-					Name:              taxLine.Name(),
-					PriceUnit:         taxLine.Amount(),
-					Quantity:          1,
-					Price:             taxLine.Amount(),
-					AccountID:         taxLine.Account().ID(),
-					AccountAnalyticID: taxLine.AccountAnalytic().ID(),
-					InvoiceID:         rs.ID(),
-					TaxIDs:            taxeIDS,
+					InvoiceLineTaxID:     taxLine.ID(),
+					TaxLineID:            taxLine.Tax().ID(),
+					TaxRepartitionLineID: taxLine.RepartitionLine().ID(),
+					Type:                 "tax",
+					Name:                 taxLine.Name(),
+					PriceUnit:            taxLine.Amount(),
+					Quantity:             1,
+					Price:                taxLine.Amount(),
+					AccountID:            taxLine.Account().ID(),
+					AccountAnalyticID:    taxLine.AccountAnalytic().ID(),
+					InvoiceID:            rs.ID(),
+					TaxIDs:               taxeIDS,
 				})
 			}
 			return res
@@ -1368,6 +1382,35 @@ A Company bank account if this is a Customer Invoice or Vendor Refund, otherwise
 			return rs.Write(h.AccountInvoice().NewData().SetState("open"))
 		})
 
+	h.AccountInvoice().Methods().CheckTaxLockDate().DeclareMethod(
+		`CheckTaxLockDate panics if one of these invoices has taxes and is dated prior to
+		or on the tax lock date of its company.`,
+		func(rs m.AccountInvoiceSet) {
+			for _, invoice := range rs.Records() {
+				taxLockDate := invoice.Company().TaxLockDate()
+				if taxLockDate.IsZero() {
+					continue
+				}
+				date := invoice.Date()
+				if date.IsZero() {
+					date = invoice.DateInvoice()
+				}
+				if date.IsZero() {
+					date = dates.Today()
+				}
+				if date.Greater(taxLockDate) {
+					continue
+				}
+				hasTaxes := invoice.TaxLines().IsNotEmpty()
+				for _, line := range invoice.InvoiceLines().Records() {
+					hasTaxes = hasTaxes || line.InvoiceLineTaxes().IsNotEmpty()
+				}
+				if hasTaxes {
+					panic(rs.T(`You cannot validate an invoice with taxes dated prior to or on the tax lock date %s. Please change the accounting date of the invoice.`, taxLockDate))
+				}
+			}
+		})
+
 	h.AccountInvoice().Methods().LineGetConvert().DeclareMethod(
 		`LineGetConvert`,
 		func(rs m.AccountInvoiceSet, line accounttypes.InvoiceLineAMLStruct, partner m.PartnerSet) m.AccountMoveLineData {
@@ -1762,7 +1805,7 @@ A Company bank account if this is a Customer Invoice or Vendor Refund, otherwise
 			price := rs.PriceUnit() * (1 - rs.Discount()/100)
 			var taxes float64
 			if rs.InvoiceLineTaxes().IsNotEmpty() {
-				_, taxes, _, _ = rs.InvoiceLineTaxes().ComputeAll(price, currency, rs.Quantity(), rs.Product(), rs.Invoice().Partner())
+				_, taxes, _, _ = rs.InvoiceLineTaxes().AtDate(rs.Invoice().TaxDate()).ComputeAll(price, currency, rs.Quantity(), rs.Product(), rs.Invoice().Partner())
 			}
 			data := h.AccountInvoiceLine().NewData()
 			priceSubtotalSigned := rs.Quantity() * price
@@ -1844,7 +1887,8 @@ A Company bank account if this is a Customer Invoice or Vendor Refund, otherwise
 			company := h.Company().Coalesce(rs.Company(), h.User().NewSet(rs.Env()).CurrentUser().Company())
 			taxes = taxes.Filtered(func(r m.AccountTaxSet) bool { return r.Company().Equals(company) })
 
-			fpTaxes := rs.Invoice().FiscalPosition().MapTax(taxes, rs.Product(), rs.Invoice().Partner())
+			fpTaxes := rs.Invoice().FiscalPosition().WithContext("fiscal_position_date", rs.Invoice().TaxDate()).MapTax(taxes, rs.Product(), rs.Invoice().Partner())
+			fpTaxes = fpTaxes.Union(rs.Invoice().JurisdictionTaxes())
 			res.SetInvoiceLineTaxes(fpTaxes)
 			fixPrice := h.AccountTax().NewSet(rs.Env()).FixTaxIncludedPrice
 			if strutils.IsIn(rs.Invoice().Type(), "in_invoice", "in_refund") {
@@ -1868,7 +1912,7 @@ A Company bank account if this is a Customer Invoice or Vendor Refund, otherwise
 				return data
 			}
 			part := rs.Invoice().Partner()
-			fpos := rs.Invoice().FiscalPosition()
+			fpos := rs.Invoice().FiscalPosition().WithContext("fiscal_position_date", rs.Invoice().TaxDate())
 			company := rs.Invoice().Company()
 			currency := rs.Invoice().Currency()
 			typ := rs.Invoice().Type()
@@ -1933,7 +1977,7 @@ A Company bank account if this is a Customer Invoice or Vendor Refund, otherwise
 				return data
 			}
 			if rs.Product().IsEmpty() {
-				fpos := rs.Invoice().FiscalPosition()
+				fpos := rs.Invoice().FiscalPosition().WithContext("fiscal_position_date", rs.Invoice().TaxDate())
 				data.SetInvoiceLineTaxes(fpos.MapTax(rs.Account().Taxes(), h.ProductProduct().NewSet(rs.Env()), rs.Partner()))
 				return data
 			}
@@ -113,11 +113,29 @@ will be created in 'Posted' status.'`},
 			String:        "Account",
 			RelationModel: h.AccountAccount(),
 			Related:       "Lines.Account"},
+		"AutoReverseDate": models.DateField{
+			String:     "Auto Reverse On",
+			NoCopy:     true,
+			Constraint: h.AccountMove().Methods().CheckAutoReverseDate(),
+			Help: `If set, this entry will be automatically reversed on this date once posted.
+Use it for accruals that must be cancelled at the beginning of the next period.`},
+		"ReversalOf": models.Many2OneField{
+			String:        "Reversal Of",
+			RelationModel: h.AccountMove(),
+			ReadOnly:      true,
+			NoCopy:        true,
+			Help:          "The journal entry reversed by this entry"},
+		"Reversals": models.One2ManyField{
+			String:        "Reversal Entries",
+			RelationModel: h.AccountMove(),
+			ReverseFK:     "ReversalOf",
+			JSON:          "reversal_ids",
+			ReadOnly:      true},
 	})
 
 	h.AccountMove().Methods().NameGet().Extend("",
 		func(rs m.AccountMoveSet) string {
-			if rs.State() == "draft" {
+			if rs.State() != "posted" {
 				return fmt.Sprintf("* %d", rs.ID())
 			}
 			return rs.Name()
@@ -245,7 +263,7 @@ will be created in 'Posted' status.'`},
 					move.SetName(newName)
 				}
 			}
-			return rs.Write(h.AccountMove().NewData().SetState("posted"))
+			return rs.WithContext("audit_operation", "post").Write(h.AccountMove().NewData().SetState("posted"))
 		})
 
 	h.AccountMove().Methods().ButtonCancel().DeclareMethod(
@@ -258,7 +276,9 @@ will be created in 'Posted' status.'`},
 			}
 			if len(rs.Ids()) > 0 {
 				rs.CheckLockDate()
-				h.AccountMove().Search(rs.Env(), q.AccountMove().ID().In(rs.Ids())).Write(h.AccountMove().NewData().SetState("draft"))
+				h.AccountMove().Search(rs.Env(), q.AccountMove().ID().In(rs.Ids())).
+					WithContext("audit_operation", "cancel").
+					Write(h.AccountMove().NewData().SetState("draft"))
 				rs.Collection().InvalidateCache()
 			}
 			rs.CheckLockDate()
@@ -286,32 +306,59 @@ will be created in 'Posted' status.'`},
 				}
 			}
 			rs.AssertBalanced()
+			rs.CheckPostingWindow()
 			return rs.CheckLockDate()
 		})
 
 	h.AccountMove().Methods().CheckLockDate().DeclareMethod(
-		`CheckLockDate`,
+		`CheckLockDate panics if one of the moves is dated prior to or on one of the lock dates
+		that apply to it: the fiscal year, period and tax lock dates of its company and the lock
+		date of its journal.`,
 		func(rs m.AccountMoveSet) bool {
+			isAdviser := h.User().NewSet(rs.Env()).CurrentUser().HasGroup(GroupAccountManager.ID)
 			for _, move := range rs.Records() {
-				lockDate := move.Company().FiscalyearLockDate()
-				if val := move.Company().PeriodLockDate(); val.Greater(lockDate) {
-					lockDate = val
+				company := move.Company()
+				if lockDate := company.FiscalyearLockDate(); move.Date().LowerEqual(lockDate) {
+					panic(rs.T(`You cannot add/modify entries prior to and inclusive of the fiscal year lock date %s of company %s`, lockDate, company.Name()))
 				}
-				hasgroup := h.User().NewSet(rs.Env()).CurrentUser().HasGroup("account.group_account_manager")
-				if hasgroup {
-					lockDate = move.Company().FiscalyearLockDate()
+				if lockDate := company.PeriodLockDate(); !isAdviser && move.Date().LowerEqual(lockDate) {
+					panic(rs.T(`You cannot add/modify entries prior to and inclusive of the period lock date %s of company %s. Check the company settings or ask someone with the 'Adviser' role`, lockDate, company.Name()))
 				}
-				if move.Date().LowerEqual(lockDate) {
-					if hasgroup {
-						panic(rs.T(`You cannot add/modify entries prior to and inclusive of the lock date %s`, lockDate))
-					} else {
-						panic(rs.T(`You cannot add/modify entries prior to and inclusive of the lock date %s. Check the company settings or ask someone with the 'Adviser' role`, lockDate))
-					}
+				if lockDate := move.Journal().LockDate(); move.Date().LowerEqual(lockDate) {
+					panic(rs.T(`You cannot add/modify entries prior to and inclusive of the journal lock date %s of journal %s`, lockDate, move.Journal().Name()))
+				}
+				if taxLockDate := company.TaxLockDate(); move.Date().LowerEqual(taxLockDate) && move.HasTaxLines() {
+					panic(rs.T(`You cannot add/modify entries with taxes prior to and inclusive of the tax lock date %s. Entry: %s`, taxLockDate, move.NameGet()))
 				}
 			}
 			return true
 		})
 
+	h.AccountMove().Methods().CheckPostingWindow().DeclareMethod(
+		`CheckPostingWindow panics if one of the moves is dated outside the allowed posting window of its journal`,
+		func(rs m.AccountMoveSet) {
+			for _, move := range rs.Records() {
+				journal := move.Journal()
+				if !journal.PostingDateFrom().IsZero() && move.Date().Lower(journal.PostingDateFrom()) {
+					panic(rs.T(`The journal %s does not accept entries dated before %s. Entry: %s`, journal.Name(), journal.PostingDateFrom(), move.NameGet()))
+				}
+				if !journal.PostingDateTo().IsZero() && move.Date().Greater(journal.PostingDateTo()) {
+					panic(rs.T(`The journal %s does not accept entries dated after %s. Entry: %s`, journal.Name(), journal.PostingDateTo(), move.NameGet()))
+				}
+			}
+		})
+
+	h.AccountMove().Methods().HasTaxLines().DeclareMethod(
+		`HasTaxLines returns true if this move has lines generated by a tax or subject to taxes`,
+		func(rs m.AccountMoveSet) bool {
+			for _, line := range rs.Lines().Records() {
+				if line.TaxLine().IsNotEmpty() || line.Taxes().IsNotEmpty() {
+					return true
+				}
+			}
+			return false
+		})
+
 	h.AccountMove().Methods().AssertBalanced().DeclareMethod(
 		`AssertBalanced`,
 		func(rs m.AccountMoveSet) bool {
@@ -361,7 +408,8 @@ will be created in 'Posted' status.'`},
 			reversedMove := rs.Copy(h.AccountMove().NewData().
 				SetDate(date).
 				SetJournal(h.AccountJournal().Coalesce(journal, rs.Journal())).
-				SetRef(rs.T(`reversal of: %s`, rs.Name())))
+				SetRef(rs.T(`reversal of: %s`, rs.Name())).
+				SetReversalOf(rs))
 			for _, acmLine := range reversedMove.Lines().WithContext("check_move_validity", false).Records() {
 				acmLine.Write(h.AccountMoveLine().NewData().
 					SetDebit(acmLine.Credit()).
@@ -396,6 +444,68 @@ will be created in 'Posted' status.'`},
 			return h.AccountMove().NewSet(rs.Env())
 		})
 
+	h.AccountMove().Methods().CheckAutoReverseDate().DeclareMethod(
+		`CheckAutoReverseDate checks that the automatic reversal date is after the entry date`,
+		func(rs m.AccountMoveSet) {
+			for _, move := range rs.Records() {
+				if move.AutoReverseDate().IsZero() {
+					continue
+				}
+				if !move.AutoReverseDate().Greater(move.Date()) {
+					panic(rs.T(`The automatic reversal date of the entry %s must be after its accounting date.`, move.NameGet()))
+				}
+			}
+		})
+
+	h.AccountMove().Methods().AutoReverse().DeclareMethod(
+		`AutoReverse reverses the posted entries of this set on their automatic reversal date,
+		unless they have already been reversed, and returns the reversal entries.`,
+		func(rs m.AccountMoveSet) m.AccountMoveSet {
+			res := h.AccountMove().NewSet(rs.Env())
+			for _, move := range rs.Records() {
+				if move.State() != "posted" || move.AutoReverseDate().IsZero() || move.Reversals().IsNotEmpty() {
+					continue
+				}
+				res = res.Union(move.ReverseMoves(move.AutoReverseDate(), h.AccountJournal().NewSet(rs.Env())))
+			}
+			return res
+		})
+
+	h.AccountMove().Methods().AutoReverseMoves().DeclareMethod(
+		`AutoReverseMoves reverses all posted entries whose automatic reversal date has come
+		and which have not been reversed yet. It is meant to be called by a scheduled job.
+
+		Each entry is reversed in its own transaction, so that an entry which cannot be
+		reversed does not prevent the reversal of the others.`,
+		func(rs m.AccountMoveSet) {
+			moves := h.AccountMove().Search(rs.Env(),
+				q.AccountMove().State().Equals("posted").
+					And().AutoReverseDate().IsNotNull().
+					And().AutoReverseDate().LowerOrEqual(dates.Today()).
+					And().Reversals().IsNull())
+			for _, move := range moves.Records() {
+				err := models.ExecuteInNewEnvironment(rs.Env().Uid(), func(env models.Environment) {
+					reversals := h.AccountMove().BrowseOne(env, move.ID()).AutoReverse()
+					log.Info("Automatically reversed journal entry", "move", move.Name(), "reversal", reversals.Name())
+				})
+				if err != nil {
+					log.Warn("Unable to reverse journal entry", "move", move.Name(), "error", err)
+				}
+			}
+		})
+
+	h.AccountMove().Methods().OpenReversals().DeclareMethod(
+		`OpenReversals returns an action showing the entries reversing this one`,
+		func(rs m.AccountMoveSet) *actions.Action {
+			return &actions.Action{
+				Name:     rs.T(`Reversal Entries`),
+				Type:     actions.ActionActWindow,
+				Model:    "AccountMove",
+				ViewMode: "tree,form",
+				Domain:   fmt.Sprintf("[('reversal_of_id', '=', %d)]", rs.ID()),
+			}
+		})
+
 	h.AccountMove().Methods().OpenReconcileView().DeclareMethod(
 		`OpenReconcileView`,
 		func(rs m.AccountMoveSet) *actions.Action {
@@ -1810,6 +1920,7 @@ but with the module account_tax_cash_basis, some will become exigible only when
 						SetAccount(account).
 						SetName(data.Name() + " " + taxData.Name).
 						SetTaxLine(tax).
+						SetTaxRepartitionLine(h.AccountTaxRepartitionLine().BrowseOne(rs.Env(), taxData.RepartitionLineID)).
 						SetMove(data.Move()).
 						SetPartner(data.Partner()).
 						SetStatement(data.Statement()).
@@ -2011,9 +2122,10 @@ but with the module account_tax_cash_basis, some will become exigible only when
 			return data
 		})
 
-	h.AccountMoveLine().Methods().QueryGet().DeclareMethod(
-		`QueryGet`,
-		func(rs m.AccountMoveLineSet, condition q.AccountMoveLineCondition) (string, []interface{}) {
+	h.AccountMoveLine().Methods().QueryGetCondition().DeclareMethod(
+		`QueryGetCondition returns the given condition restricted according to the
+		date, journal, state, company, tag and analytic keys of the context.`,
+		func(rs m.AccountMoveLineSet, condition q.AccountMoveLineCondition) q.AccountMoveLineCondition {
 			context := rs.Env().Context()
 			dateField := q.AccountMoveLine().Date()
 			if context.GetBool("aged_balance") {
@@ -2062,6 +2174,13 @@ but with the module account_tax_cash_basis, some will become exigible only when
 			if val := context.GetIntegerSlice("analytic_account_ids"); len(val) > 0 {
 				condition = condition.AndCond(q.AccountMoveLine().AnalyticAccountFilteredOn(q.AccountAnalyticAccount().ID().In(val)))
 			}
+			return condition
+		})
+
+	h.AccountMoveLine().Methods().QueryGet().DeclareMethod(
+		`QueryGet`,
+		func(rs m.AccountMoveLineSet, condition q.AccountMoveLineCondition) (string, []interface{}) {
+			condition = rs.QueryGetCondition(condition)
 			if !condition.IsEmpty() {
 				// FIXME
 				//return rs.SqlFromCondition(condition.Condition)
@@ -0,0 +1,208 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package account
+
+import (
+	"github.com/hexya-erp/hexya/src/actions"
+	"github.com/hexya-erp/hexya/src/models"
+	"github.com/hexya-erp/hexya/src/models/types"
+	"github.com/hexya-erp/hexya/src/models/types/dates"
+	"github.com/hexya-erp/pool/h"
+	"github.com/hexya-erp/pool/m"
+	"github.com/hexya-erp/pool/q"
+)
+
+func init() {
+
+	h.AccountJournalApprovalRule().DeclareModel()
+	h.AccountJournalApprovalRule().AddFields(map[string]models.FieldDefinition{
+		"Journal": models.Many2OneField{
+			RelationModel: h.AccountJournal(),
+			Required:      true,
+			OnDelete:      models.Cascade},
+		"AccountType": models.Many2OneField{
+			RelationModel: h.AccountAccountType(),
+			Required:      true},
+		"Threshold": models.FloatField{
+			Help: `Manual entries whose total debit on accounts of this type exceeds this amount
+must be approved before being posted.`},
+	})
+
+	h.AccountJournal().AddFields(map[string]models.FieldDefinition{
+		"MoveApproval": models.BooleanField{
+			String: "Approve Manual Entries",
+			Help: `Check this box if manual journal entries of this journal above the thresholds
+must be approved by an adviser other than their creator before being posted.`},
+		"ApprovalThreshold": models.FloatField{
+			String: "Approval Threshold",
+			Help: `Manual entries whose amount exceeds this threshold must be approved.
+Leave to zero to only apply the thresholds by account type.`},
+		"ApprovalRules": models.One2ManyField{
+			String:        "Approval Thresholds by Account Type",
+			RelationModel: h.AccountJournalApprovalRule(),
+			ReverseFK:     "Journal",
+			JSON:          "approval_rule_ids"},
+	})
+
+	h.AccountMove().Fields().State().UpdateSelection(types.Selection{
+		"to_approve": "To Approve",
+	})
+
+	h.AccountMove().AddFields(map[string]models.FieldDefinition{
+		"ApprovedBy": models.Many2OneField{
+			RelationModel: h.User(),
+			ReadOnly:      true,
+			NoCopy:        true},
+		"ApprovalDate": models.DateTimeField{
+			ReadOnly: true,
+			NoCopy:   true},
+		"RejectedBy": models.Many2OneField{
+			RelationModel: h.User(),
+			ReadOnly:      true,
+			NoCopy:        true},
+		"RejectionDate": models.DateTimeField{
+			ReadOnly: true,
+			NoCopy:   true},
+		"RejectionReason": models.TextField{
+			ReadOnly: true,
+			NoCopy:   true},
+	})
+
+	h.AccountMove().Methods().IsManual().DeclareMethod(
+		`IsManual returns true if this move has been entered manually, i.e. it has not been
+		generated by an invoice, a payment, a bank statement or the reversal of another entry.`,
+		func(rs m.AccountMoveSet) bool {
+			if rs.Env().Context().HasKey("invoice_id") || rs.StatementLine().IsNotEmpty() || rs.ReversalOf().IsNotEmpty() {
+				return false
+			}
+			for _, line := range rs.Lines().Records() {
+				if line.Invoice().IsNotEmpty() || line.Payment().IsNotEmpty() || line.Statement().IsNotEmpty() {
+					return false
+				}
+			}
+			return true
+		})
+
+	h.AccountMove().Methods().RequiresApproval().DeclareMethod(
+		`RequiresApproval returns true if this move must be approved before being posted,
+		according to the approval thresholds of its journal.`,
+		func(rs m.AccountMoveSet) bool {
+			journal := rs.Journal()
+			if !journal.MoveApproval() || !rs.IsManual() {
+				return false
+			}
+			if journal.ApprovalThreshold() > 0 && rs.Amount() > journal.ApprovalThreshold() {
+				return true
+			}
+			for _, rule := range journal.ApprovalRules().Records() {
+				var total float64
+				for _, line := range rs.Lines().Records() {
+					if line.UserType().Equals(rule.AccountType()) {
+						total += line.Debit()
+					}
+				}
+				if total > rule.Threshold() {
+					return true
+				}
+			}
+			return false
+		})
+
+	h.AccountMove().Methods().CheckApprover().DeclareMethod(
+		`CheckApprover panics if the current user is not allowed to approve this move.
+		Only advisers may approve entries, and never the ones they created themselves.`,
+		func(rs m.AccountMoveSet) {
+			if !h.User().NewSet(rs.Env()).CurrentUser().HasGroup(GroupAccountManager.ID) {
+				panic(rs.T(`Only users with the 'Adviser' role can approve the journal entry %s.`, rs.NameGet()))
+			}
+			if rs.CreateUID() == rs.Env().Uid() {
+				panic(rs.T(`You cannot approve a journal entry you created yourself. Entry: %s.`, rs.NameGet()))
+			}
+		})
+
+	h.AccountMove().Methods().CanApprove().DeclareMethod(
+		`CanApprove returns true if the current user is allowed to approve this move.`,
+		func(rs m.AccountMoveSet) bool {
+			return h.User().NewSet(rs.Env()).CurrentUser().HasGroup(GroupAccountManager.ID) &&
+				rs.CreateUID() != rs.Env().Uid()
+		})
+
+	h.AccountMove().Methods().Post().Extend("",
+		func(rs m.AccountMoveSet) bool {
+			toPost := h.AccountMove().NewSet(rs.Env())
+			for _, move := range rs.Records() {
+				if !move.RequiresApproval() {
+					toPost = toPost.Union(move)
+					continue
+				}
+				if move.CanApprove() {
+					move.Write(h.AccountMove().NewData().
+						SetApprovedBy(h.User().NewSet(rs.Env()).CurrentUser()).
+						SetApprovalDate(dates.Now()))
+					toPost = toPost.Union(move)
+					continue
+				}
+				if move.State() == "draft" {
+					move.PostValidate()
+					move.Write(h.AccountMove().NewData().SetState("to_approve"))
+				}
+			}
+			if toPost.IsEmpty() {
+				return true
+			}
+			return toPost.Super().Post()
+		})
+
+	h.AccountMove().Methods().ActionApprove().DeclareMethod(
+		`ActionApprove approves and posts the moves awaiting approval.`,
+		func(rs m.AccountMoveSet) bool {
+			for _, move := range rs.Records() {
+				if move.State() != "to_approve" {
+					panic(rs.T(`Only journal entries waiting for approval can be approved.`))
+				}
+				move.CheckApprover()
+			}
+			return rs.Post()
+		})
+
+	h.AccountMove().Methods().Reject().DeclareMethod(
+		`Reject sets back the moves awaiting approval to draft, recording the given reason.`,
+		func(rs m.AccountMoveSet, reason string) bool {
+			if reason == "" {
+				panic(rs.T(`You must give a reason to reject a journal entry.`))
+			}
+			for _, move := range rs.Records() {
+				if move.State() != "to_approve" {
+					panic(rs.T(`Only journal entries waiting for approval can be rejected.`))
+				}
+				if !h.User().NewSet(rs.Env()).CurrentUser().HasGroup(GroupAccountManager.ID) {
+					panic(rs.T(`Only users with the 'Adviser' role can reject journal entries.`))
+				}
+			}
+			return rs.WithContext("audit_operation", "cancel").Write(h.AccountMove().NewData().
+				SetState("draft").
+				SetRejectedBy(h.User().NewSet(rs.Env()).CurrentUser()).
+				SetRejectionDate(dates.Now()).
+				SetRejectionReason(reason))
+		})
+
+	h.AccountMoveReject().DeclareTransientModel()
+	h.AccountMoveReject().AddFields(map[string]models.FieldDefinition{
+		"Reason": models.TextField{
+			String:   "Rejection Reason",
+			Required: true},
+	})
+
+	h.AccountMoveReject().Methods().RejectMoves().DeclareMethod(
+		`RejectMoves rejects the active moves with the reason given in the wizard`,
+		func(rs m.AccountMoveRejectSet) *actions.Action {
+			moves := h.AccountMove().Search(rs.Env(),
+				q.AccountMove().ID().In(rs.Env().Context().GetIntegerSlice("active_ids")))
+			moves.Reject(rs.Reason())
+			return &actions.Action{
+				Type: actions.ActionCloseWindow,
+			}
+		})
+
+}
@@ -534,7 +534,7 @@ set to draft and re-processed again." `},
 					move.ButtonCancel()
 					move.Unlink()
 				}
-				rec.SetState("draft")
+				rec.WithContext("audit_operation", "cancel").SetState("draft")
 			}
 		})
 
@@ -609,7 +609,7 @@ set to draft and re-processed again." `},
 				data.SetState("posted").
 					SetMoveName(move.Name())
 
-				rec.Write(data)
+				rec.WithContext("audit_operation", "post").Write(data)
 			}
 		})
 
@@ -687,6 +687,15 @@ set to draft and re-processed again." `},
 			}
 			rs.Invoices().WithContext("check_move_validity", false).RegisterPayment(counterpartAml, h.AccountAccount().NewSet(env), h.AccountJournal().NewSet(env))
 
+			// Write withholding lines, so that the liquidity line only carries the net amount
+			withholdingLines, withheld := rs.CreateWithholdingLines(amount, move, invoiceCurrency)
+			for _, line := range withholdingLines.Records() {
+				debit -= line.Credit()
+				credit -= line.Debit()
+				amountCurrency += line.AmountCurrency()
+			}
+			amount -= withheld
+
 			// Write counterpart lines
 			if rs.Currency().Equals(rs.Company().Currency()) {
 				amountCurrency = 0
@@ -0,0 +1,385 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package account
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/hexya-erp/hexya/src/actions"
+	"github.com/hexya-erp/hexya/src/models"
+	"github.com/hexya-erp/hexya/src/models/types"
+	"github.com/hexya-erp/hexya/src/models/types/dates"
+	"github.com/hexya-erp/pool/h"
+	"github.com/hexya-erp/pool/m"
+	"github.com/hexya-erp/pool/q"
+)
+
+// recurrenceMonths gives the length in months of each recurrence period
+var recurrenceMonths = map[string]int{
+	"monthly":   1,
+	"quarterly": 3,
+	"yearly":    12,
+}
+
+func init() {
+
+	h.AccountRecurringEntry().DeclareModel()
+	h.AccountRecurringEntry().SetDefaultOrder("Name")
+
+	h.AccountRecurringEntry().AddFields(map[string]models.FieldDefinition{
+		"Name": models.CharField{
+			Required: true},
+		"Active": models.BooleanField{
+			Default: models.DefaultValue(true)},
+		"Ref": models.CharField{
+			String: "Reference",
+			Help:   "Reference set on the generated journal entries"},
+		"Journal": models.Many2OneField{
+			RelationModel: h.AccountJournal(),
+			Required:      true},
+		"Company": models.Many2OneField{
+			RelationModel: h.Company(),
+			Related:       "Journal.Company",
+			ReadOnly:      true},
+		"Recurrence": models.SelectionField{
+			Selection: types.Selection{
+				"monthly":   "Monthly",
+				"quarterly": "Quarterly",
+				"yearly":    "Yearly"},
+			Required:   true,
+			Default:    models.DefaultValue("monthly"),
+			Constraint: h.AccountRecurringEntry().Methods().CheckDayOfPeriod()},
+		"DayOfPeriod": models.IntegerField{
+			String:     "Day of Period",
+			Default:    models.DefaultValue(1),
+			Required:   true,
+			Constraint: h.AccountRecurringEntry().Methods().CheckDayOfPeriod(),
+			Help: `Day of the period on which the entry is generated, starting from 1.
+If the period is shorter, the entry is generated on the last day of the period.`},
+		"DateStart": models.DateField{
+			String:   "Start Date",
+			Required: true,
+			Default: func(env models.Environment) interface{} {
+				return dates.Today()
+			}},
+		"DateEnd": models.DateField{
+			String: "End Date",
+			Help:   "Leave empty to generate entries indefinitely"},
+		"NextDate": models.DateField{
+			String:   "Next Entry Date",
+			ReadOnly: true,
+			NoCopy:   true},
+		"TotalAmount": models.FloatField{
+			String: "Total Amount",
+			Help:   "Amount on which the lines defined as a percentage are computed"},
+		"AutoPost": models.BooleanField{
+			String: "Post Automatically",
+			Help:   "If checked, generated entries are posted. Otherwise they are left in draft."},
+		"Lines": models.One2ManyField{
+			RelationModel: h.AccountRecurringEntryLine(),
+			ReverseFK:     "RecurringEntry",
+			JSON:          "line_ids",
+			Copy:          true},
+		"Moves": models.One2ManyField{
+			String:        "Generated Entries",
+			RelationModel: h.AccountMove(),
+			ReverseFK:     "RecurringEntry",
+			JSON:          "move_ids",
+			ReadOnly:      true},
+		"MoveCount": models.IntegerField{
+			String:  "Number of Generated Entries",
+			Compute: h.AccountRecurringEntry().Methods().ComputeMoveCount(),
+			Depends: []string{"Moves"}},
+	})
+
+	h.AccountMove().AddFields(map[string]models.FieldDefinition{
+		"RecurringEntry": models.Many2OneField{
+			String:        "Recurring Entry",
+			RelationModel: h.AccountRecurringEntry(),
+			ReadOnly:      true,
+			NoCopy:        true,
+			OnDelete:      models.SetNull},
+	})
+
+	h.AccountRecurringEntry().Methods().ComputeMoveCount().DeclareMethod(
+		`ComputeMoveCount returns the number of entries generated from this template`,
+		func(rs m.AccountRecurringEntrySet) m.AccountRecurringEntryData {
+			return h.AccountRecurringEntry().NewData().SetMoveCount(int64(rs.Moves().Len()))
+		})
+
+	h.AccountRecurringEntry().Methods().CheckDayOfPeriod().DeclareMethod(
+		`CheckDayOfPeriod checks that the day of period is within the recurrence period`,
+		func(rs m.AccountRecurringEntrySet) {
+			for _, rec := range rs.Records() {
+				maxDays := int64(recurrenceMonths[rec.Recurrence()] * 31)
+				if rec.DayOfPeriod() < 1 || rec.DayOfPeriod() > maxDays {
+					panic(rs.T(`The day of period of the recurring entry %s must be between 1 and %d.`, rec.Name(), maxDays))
+				}
+			}
+		})
+
+	h.AccountRecurringEntry().Methods().CheckBalanced().DeclareMethod(
+		`CheckBalanced panics if the lines of this template do not give a balanced entry`,
+		func(rs m.AccountRecurringEntrySet) {
+			for _, rec := range rs.Records() {
+				var balance float64
+				for _, line := range rec.Lines().Records() {
+					balance += line.ComputeAmount(rec.TotalAmount())
+				}
+				if !rec.Company().Currency().IsZero(balance) {
+					panic(rs.T(`The lines of the recurring entry %s are not balanced (difference: %.2f).`, rec.Name(), balance))
+				}
+			}
+		})
+
+	h.AccountRecurringEntry().Methods().OccurrenceInPeriod().DeclareMethod(
+		`OccurrenceInPeriod returns the date on which an entry is generated for the period
+		starting on the given date.`,
+		func(rs m.AccountRecurringEntrySet, periodStart dates.Date) dates.Date {
+			periodEnd := periodStart.AddDate(0, recurrenceMonths[rs.Recurrence()], -1)
+			occurrence := periodStart.AddDate(0, 0, int(rs.DayOfPeriod())-1)
+			if occurrence.Greater(periodEnd) {
+				return periodEnd
+			}
+			return occurrence
+		})
+
+	h.AccountRecurringEntry().Methods().PeriodStart().DeclareMethod(
+		`PeriodStart returns the first day of the recurrence period the given date belongs to`,
+		func(rs m.AccountRecurringEntrySet, date dates.Date) dates.Date {
+			months := recurrenceMonths[rs.Recurrence()]
+			month := (int(date.Month())-1)/months*months + 1
+			return date.StartOfMonth().SetMonth(time.Month(month))
+		})
+
+	h.AccountRecurringEntry().Methods().ComputeNextDate().DeclareMethod(
+		`ComputeNextDate returns the first generation date strictly after the given date,
+		or the first generation date on or after the start date if the given date is zero.
+		It returns a zero date if this generation date is after the end date of the template.`,
+		func(rs m.AccountRecurringEntrySet, after dates.Date) dates.Date {
+			var occurrence dates.Date
+			if after.IsZero() {
+				periodStart := rs.PeriodStart(rs.DateStart())
+				occurrence = rs.OccurrenceInPeriod(periodStart)
+				if occurrence.Lower(rs.DateStart()) {
+					occurrence = rs.OccurrenceInPeriod(periodStart.AddDate(0, recurrenceMonths[rs.Recurrence()], 0))
+				}
+			} else {
+				periodStart := rs.PeriodStart(after).AddDate(0, recurrenceMonths[rs.Recurrence()], 0)
+				occurrence = rs.OccurrenceInPeriod(periodStart)
+			}
+			if !rs.DateEnd().IsZero() && occurrence.Greater(rs.DateEnd()) {
+				return dates.Date{}
+			}
+			return occurrence
+		})
+
+	h.AccountRecurringEntry().Methods().Create().Extend("",
+		func(rs m.AccountRecurringEntrySet, data m.AccountRecurringEntryData) m.AccountRecurringEntrySet {
+			res := rs.Super().Create(data)
+			res.CheckBalanced()
+			res.SetNextDate(res.ComputeNextDate(dates.Date{}))
+			return res
+		})
+
+	h.AccountRecurringEntry().Methods().Write().Extend("",
+		func(rs m.AccountRecurringEntrySet, data m.AccountRecurringEntryData) bool {
+			res := rs.Super().Write(data)
+			if data.HasLines() || data.HasTotalAmount() {
+				rs.CheckBalanced()
+			}
+			if data.HasDateStart() || data.HasDateEnd() || data.HasRecurrence() || data.HasDayOfPeriod() {
+				for _, rec := range rs.Records() {
+					lastMove := h.AccountMove().Search(rs.Env(), q.AccountMove().RecurringEntry().Equals(rec)).
+						OrderBy("Date DESC").Limit(1)
+					lastDate := lastMove.Date()
+					if lastDate.Lower(rec.DateStart()) {
+						lastDate = dates.Date{}
+					}
+					rec.SetNextDate(rec.ComputeNextDate(lastDate))
+				}
+			}
+			return res
+		})
+
+	h.AccountRecurringEntry().Methods().PrepareMoveData().DeclareMethod(
+		`PrepareMoveData returns the data of the journal entry to generate on the given date`,
+		func(rs m.AccountRecurringEntrySet, date dates.Date) m.AccountMoveData {
+			ref := rs.Ref()
+			if ref == "" {
+				ref = rs.Name()
+			}
+			data := h.AccountMove().NewData().
+				SetJournal(rs.Journal()).
+				SetDate(date).
+				SetRef(ref).
+				SetRecurringEntry(rs)
+			currency := rs.Company().Currency()
+			lines := rs.Lines().Records()
+			amounts := make([]float64, len(lines))
+			var balance float64
+			for i, line := range lines {
+				amounts[i] = currency.Round(line.ComputeAmount(rs.TotalAmount()))
+				balance += amounts[i]
+			}
+			if len(amounts) > 0 {
+				// The lines are balanced before rounding: the rounding difference goes on the last line
+				amounts[len(amounts)-1] = currency.Round(amounts[len(amounts)-1] - balance)
+			}
+			for i, line := range lines {
+				amount := amounts[i]
+				if currency.IsZero(amount) {
+					continue
+				}
+				label := line.Name()
+				if label == "" {
+					label = rs.Name()
+				}
+				data = data.CreateLines(h.AccountMoveLine().NewData().
+					SetName(label).
+					SetAccount(line.Account()).
+					SetPartner(line.Partner()).
+					SetAnalyticAccount(line.AnalyticAccount()).
+					SetDebit(math.Max(amount, 0)).
+					SetCredit(math.Max(-amount, 0)))
+			}
+			return data
+		})
+
+	h.AccountRecurringEntry().Methods().GenerateMove().DeclareMethod(
+		`GenerateMove creates the journal entry of this template for the given date,
+		and posts it if the template is set to post automatically.`,
+		func(rs m.AccountRecurringEntrySet, date dates.Date) m.AccountMoveSet {
+			rs.EnsureOne()
+			move := h.AccountMove().Create(rs.Env(), rs.PrepareMoveData(date))
+			if rs.AutoPost() {
+				move.Post()
+			}
+			return move
+		})
+
+	h.AccountRecurringEntry().Methods().GenerateDueMoves().DeclareMethod(
+		`GenerateDueMoves generates the entries of these templates whose date has come,
+		including the ones that have been missed, and returns them.`,
+		func(rs m.AccountRecurringEntrySet, date dates.Date) m.AccountMoveSet {
+			moves := h.AccountMove().NewSet(rs.Env())
+			for _, rec := range rs.Records() {
+				nextDate := rec.NextDate()
+				for !nextDate.IsZero() && nextDate.LowerEqual(date) {
+					if !rec.DateEnd().IsZero() && nextDate.Greater(rec.DateEnd()) {
+						nextDate = dates.Date{}
+						break
+					}
+					moves = moves.Union(rec.GenerateMove(nextDate))
+					nextDate = rec.ComputeNextDate(nextDate)
+				}
+				rec.SetNextDate(nextDate)
+			}
+			return moves
+		})
+
+	h.AccountRecurringEntry().Methods().CronGenerateMoves().DeclareMethod(
+		`CronGenerateMoves generates the due entries of all active templates.
+		Each template is processed in its own transaction, so that a failing template
+		does not prevent the others from generating their entries.
+		It is meant to be called by a scheduled job.`,
+		func(rs m.AccountRecurringEntrySet) {
+			templates := h.AccountRecurringEntry().Search(rs.Env(),
+				q.AccountRecurringEntry().NextDate().LowerOrEqual(dates.Today()))
+			var count int
+			for _, template := range templates.Records() {
+				err := models.ExecuteInNewEnvironment(rs.Env().Uid(), func(env models.Environment) {
+					moves := h.AccountRecurringEntry().BrowseOne(env, template.ID()).GenerateDueMoves(dates.Today())
+					count += moves.Len()
+				})
+				if err != nil {
+					log.Warn("Unable to generate recurring journal entries", "template", template.Name(), "error", err)
+				}
+			}
+			log.Info("Generated recurring journal entries", "count", count)
+		})
+
+	h.AccountRecurringEntry().Methods().ActionGenerate().DeclareMethod(
+		`ActionGenerate generates the due entries of these templates and displays them`,
+		func(rs m.AccountRecurringEntrySet) *actions.Action {
+			moves := rs.GenerateDueMoves(dates.Today())
+			if moves.IsEmpty() {
+				panic(rs.T(`There is no entry to generate up to today.`))
+			}
+			return &actions.Action{
+				Name:     rs.T(`Generated Entries`),
+				Type:     actions.ActionActWindow,
+				Model:    "AccountMove",
+				ViewMode: "tree,form",
+				Domain:   fmt.Sprintf("[('id', 'in', %s)]", strings.Replace(fmt.Sprint(moves.Ids()), " ", ", ", -1)),
+			}
+		})
+
+	h.AccountRecurringEntry().Methods().OpenMoves().DeclareMethod(
+		`OpenMoves returns an action displaying the entries generated by this template`,
+		func(rs m.AccountRecurringEntrySet) *actions.Action {
+			return &actions.Action{
+				Name:     rs.T(`Generated Entries`),
+				Type:     actions.ActionActWindow,
+				Model:    "AccountMove",
+				ViewMode: "tree,form",
+				Domain:   fmt.Sprintf("[('recurring_entry_id', '=', %d)]", rs.ID()),
+			}
+		})
+
+	h.AccountRecurringEntryLine().DeclareModel()
+	h.AccountRecurringEntryLine().SetDefaultOrder("Sequence", "ID")
+
+	h.AccountRecurringEntryLine().AddFields(map[string]models.FieldDefinition{
+		"RecurringEntry": models.Many2OneField{
+			RelationModel: h.AccountRecurringEntry(),
+			Required:      true,
+			OnDelete:      models.Cascade},
+		"Sequence": models.IntegerField{
+			Default: models.DefaultValue(10)},
+		"Name": models.CharField{
+			String: "Label"},
+		"Account": models.Many2OneField{
+			RelationModel: h.AccountAccount(),
+			Required:      true,
+			Filter:        q.AccountAccount().Deprecated().Equals(false)},
+		"Partner": models.Many2OneField{
+			RelationModel: h.Partner()},
+		"AnalyticAccount": models.Many2OneField{
+			RelationModel: h.AccountAnalyticAccount()},
+		"AmountType": models.SelectionField{
+			String: "Amount Type",
+			Selection: types.Selection{
+				"fixed":      "Fixed Amount",
+				"percentage": "Percentage of Total"},
+			Required: true,
+			Default:  models.DefaultValue("fixed")},
+		"Side": models.SelectionField{
+			Selection: types.Selection{
+				"debit":  "Debit",
+				"credit": "Credit"},
+			Required: true,
+			Default:  models.DefaultValue("debit")},
+		"Amount": models.FloatField{
+			Help: "Fixed amount of the line, or percentage of the template's total amount"},
+	})
+
+	h.AccountRecurringEntryLine().Methods().ComputeAmount().DeclareMethod(
+		`ComputeAmount returns the signed amount of this line (positive for debit, negative
+		for credit) given the total amount of the template.`,
+		func(rs m.AccountRecurringEntryLineSet, total float64) float64 {
+			amount := rs.Amount()
+			if rs.AmountType() == "percentage" {
+				amount = total * rs.Amount() / 100
+			}
+			if rs.Side() == "credit" {
+				return -amount
+			}
+			return amount
+		})
+
+}
@@ -0,0 +1,221 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package account
+
+import (
+	"math"
+
+	"github.com/hexya-erp/hexya/src/models"
+	"github.com/hexya-erp/hexya/src/models/types"
+	"github.com/hexya-erp/hexya/src/models/types/dates"
+	"github.com/hexya-erp/hexya/src/tools/strutils"
+	"github.com/hexya-erp/pool/h"
+	"github.com/hexya-erp/pool/m"
+	"github.com/hexya-erp/pool/q"
+)
+
+func init() {
+
+	h.Company().AddFields(map[string]models.FieldDefinition{
+		"TaxCashBasisJournal": models.Many2OneField{
+			String:        "Tax Cash Basis Journal",
+			RelationModel: h.AccountJournal(),
+			Help:          "Journal in which the entries making cash basis taxes exigible are booked"},
+	})
+
+	h.AccountTax().AddFields(map[string]models.FieldDefinition{
+		"TaxExigibility": models.SelectionField{
+			String: "Tax Due",
+			Selection: types.Selection{
+				"on_invoice": "Based on Invoice",
+				"on_payment": "Based on Payment"},
+			Default:    models.DefaultValue("on_invoice"),
+			Constraint: h.AccountTax().Methods().CheckCashBasisAccount(),
+			Help: `Based on Invoice: the tax is due as soon as the invoice is validated.
+Based on Payment: the tax is due as soon as the payment of the invoice is received.`},
+		"CashBasisTransitionAccount": models.Many2OneField{
+			String:        "Tax Transition Account",
+			RelationModel: h.AccountAccount(),
+			Filter:        q.AccountAccount().Deprecated().Equals(false),
+			Constraint:    h.AccountTax().Methods().CheckCashBasisAccount(),
+			Help: `Account in which the tax amount of invoices is booked until they are paid.
+It is then transferred to the tax account in proportion of the payments.`},
+	})
+
+	h.AccountMove().AddFields(map[string]models.FieldDefinition{
+		"TaxCashBasisRec": models.Many2OneField{
+			String:        "Tax Cash Basis Entry Of",
+			RelationModel: h.AccountPartialReconcile(),
+			Index:         true,
+			NoCopy:        true,
+			ReadOnly:      true,
+			Help:          "Technical field used to keep track of the tax cash basis reconciliation"},
+	})
+
+	h.AccountTax().Methods().CheckCashBasisAccount().DeclareMethod(
+		`CheckCashBasisAccount checks that taxes based on payments have a transition account`,
+		func(rs m.AccountTaxSet) {
+			for _, tax := range rs.Records() {
+				if tax.TaxExigibility() == "on_payment" && tax.AmountType() != "group" && tax.CashBasisTransitionAccount().IsEmpty() {
+					panic(rs.T(`The tax %s is based on payment and must have a transition account.`, tax.Name()))
+				}
+			}
+		})
+
+	h.AccountTax().Methods().IsCashBasis().DeclareMethod(
+		`IsCashBasis returns true if one of these taxes is due on payment`,
+		func(rs m.AccountTaxSet) bool {
+			for _, tax := range rs.Records() {
+				if tax.TaxExigibility() == "on_payment" {
+					return true
+				}
+			}
+			return false
+		})
+
+	h.AccountMoveLine().Methods().Create().Extend(
+		`Lines with taxes based on payments are not exigible when created, and tax lines of such taxes
+		are booked on the transition account of the tax, unless TaxExigible is explicitly given.`,
+		func(rs m.AccountMoveLineSet, data m.AccountMoveLineData) m.AccountMoveLineSet {
+			if !data.HasTaxExigible() && (data.TaxLine().IsCashBasis() || data.Taxes().IsCashBasis()) {
+				data.SetTaxExigible(false)
+				if tax := data.TaxLine(); tax.IsCashBasis() && tax.CashBasisTransitionAccount().IsNotEmpty() {
+					data.SetAccount(tax.CashBasisTransitionAccount())
+				}
+			}
+			return rs.Super().Create(data)
+		})
+
+	h.AccountPartialReconcile().Methods().CashBasisPercentage().DeclareMethod(
+		`CashBasisPercentage returns the part of the given move paid by this partial reconciliation`,
+		func(rs m.AccountPartialReconcileSet, move m.AccountMoveSet) float64 {
+			var totalAmount float64
+			for _, line := range move.Lines().Records() {
+				if strutils.IsIn(line.Account().UserType().Type(), "receivable", "payable") {
+					totalAmount += math.Abs(line.Debit() - line.Credit())
+				}
+			}
+			if move.Company().Currency().IsZero(totalAmount) {
+				return 0
+			}
+			return math.Min(rs.Amount()/totalAmount, 1)
+		})
+
+	h.AccountPartialReconcile().Methods().PrepareTaxCashBasisLines().DeclareMethod(
+		`PrepareTaxCashBasisLines adds to the given move data the lines making exigible the part of the
+		cash basis taxes of the given move which is paid by this partial reconciliation:
+
+		- tax lines are transferred from the transition account to the tax account,
+		- base lines are duplicated with their taxes, and cancelled by a line without taxes,
+		  so that the base amount appears in the tax report.`,
+		func(rs m.AccountPartialReconcileSet, move m.AccountMoveSet, data m.AccountMoveData) m.AccountMoveData {
+			percentage := rs.CashBasisPercentage(move)
+			currency := move.Company().Currency()
+			if percentage == 0 {
+				return data
+			}
+			for _, line := range move.Lines().Records() {
+				if line.TaxExigible() {
+					continue
+				}
+				amount := currency.Round(line.Balance() * percentage)
+				if currency.IsZero(amount) {
+					continue
+				}
+				switch {
+				case line.TaxLine().IsCashBasis():
+					tax := line.TaxLine()
+					account, refundAccount := tax.Account(), tax.RefundAccount()
+					if repartition := line.TaxRepartitionLine(); repartition.IsNotEmpty() {
+						account = h.AccountAccount().Coalesce(repartition.Account(), account)
+						refundAccount = h.AccountAccount().Coalesce(repartition.RefundAccount(), refundAccount)
+					}
+					if refundAccount.IsNotEmpty() && strutils.IsIn(line.Invoice().Type(), "out_refund", "in_refund") {
+						account = refundAccount
+					}
+					data = data.CreateLines(h.AccountMoveLine().NewData().
+						SetName(line.Name()).
+						SetAccount(line.Account()).
+						SetPartner(line.Partner()).
+						SetDebit(math.Max(-amount, 0)).
+						SetCredit(math.Max(amount, 0)).
+						SetTaxExigible(true))
+					data = data.CreateLines(h.AccountMoveLine().NewData().
+						SetName(line.Name()).
+						SetAccount(account).
+						SetPartner(line.Partner()).
+						SetTaxLine(tax).
+						SetTaxRepartitionLine(line.TaxRepartitionLine()).
+						SetDebit(math.Max(amount, 0)).
+						SetCredit(math.Max(-amount, 0)).
+						SetTaxExigible(true))
+				case line.Taxes().IsCashBasis():
+					data = data.CreateLines(h.AccountMoveLine().NewData().
+						SetName(line.Name()).
+						SetAccount(line.Account()).
+						SetPartner(line.Partner()).
+						SetTaxes(line.Taxes()).
+						SetDebit(math.Max(amount, 0)).
+						SetCredit(math.Max(-amount, 0)).
+						SetTaxExigible(true))
+					data = data.CreateLines(h.AccountMoveLine().NewData().
+						SetName(line.Name()).
+						SetAccount(line.Account()).
+						SetPartner(line.Partner()).
+						SetDebit(math.Max(-amount, 0)).
+						SetCredit(math.Max(amount, 0)).
+						SetTaxExigible(true))
+				}
+			}
+			return data
+		})
+
+	h.AccountPartialReconcile().Methods().CreateTaxCashBasisEntry().DeclareMethod(
+		`CreateTaxCashBasisEntry creates and posts the entry making exigible the part of the cash basis
+		taxes of the reconciled moves which is paid by this partial reconciliation.`,
+		func(rs m.AccountPartialReconcileSet) m.AccountMoveSet {
+			rs.EnsureOne()
+			date := rs.DebitMove().Date()
+			if rs.CreditMove().Date().Greater(date) {
+				date = rs.CreditMove().Date()
+			}
+			data := h.AccountMove().NewData().
+				SetDate(date).
+				SetRef(rs.T(`Tax cash basis of %s`, rs.DebitMove().Move().Name())).
+				SetTaxCashBasisRec(rs)
+			for _, move := range rs.DebitMove().Move().Union(rs.CreditMove().Move()).Records() {
+				data = rs.PrepareTaxCashBasisLines(move, data)
+			}
+			if !data.HasLines() {
+				return h.AccountMove().NewSet(rs.Env())
+			}
+			journal := rs.Company().TaxCashBasisJournal()
+			if journal.IsEmpty() {
+				panic(rs.T(`There is no tax cash basis journal defined for company %s. Please configure it first.`, rs.Company().Name()))
+			}
+			move := h.AccountMove().Create(rs.Env(), data.SetJournal(journal))
+			move.Post()
+			return move
+		})
+
+	h.AccountPartialReconcile().Methods().Create().Extend(
+		"When a partial reconciliation is created, make the paid part of cash basis taxes exigible",
+		func(rs m.AccountPartialReconcileSet, data m.AccountPartialReconcileData) m.AccountPartialReconcileSet {
+			res := rs.Super().Create(data)
+			res.CreateTaxCashBasisEntry()
+			return res
+		})
+
+	h.AccountPartialReconcile().Methods().Unlink().Extend(
+		"When removing a partial reconciliation, reverse its tax cash basis entries",
+		func(rs m.AccountPartialReconcileSet) int64 {
+			moves := h.AccountMove().Search(rs.Env(), q.AccountMove().TaxCashBasisRec().In(rs))
+			if moves.IsNotEmpty() {
+				moves.SetTaxCashBasisRec(h.AccountPartialReconcile().NewSet(rs.Env()))
+				moves.ReverseMoves(dates.Today(), h.AccountJournal().NewSet(rs.Env()))
+			}
+			return rs.Super().Unlink()
+		})
+
+}
@@ -0,0 +1,108 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package account
+
+import (
+	"github.com/hexya-addons/account/formula"
+	"github.com/hexya-erp/hexya/src/models"
+	"github.com/hexya-erp/pool/h"
+	"github.com/hexya-erp/pool/m"
+)
+
+// taxFormulaVariables is the list of the variables that can be used in the formula of a tax
+var taxFormulaVariables = []string{
+	"base_amount", "price_unit", "quantity",
+	"product.weight", "product.volume", "product.lst_price", "product.standard_price", "product.default_code",
+	"partner.is_company", "partner.country_code", "partner.state_code", "partner.zip", "partner.ref",
+}
+
+func init() {
+
+	h.AccountTax().AddFields(map[string]models.FieldDefinition{
+		"Formula": models.TextField{
+			Constraint: h.AccountTax().Methods().CheckFormula(),
+			Help: `Expression computing the tax amount, for taxes computed with a formula.
+The following variables can be used:
+- base_amount, price_unit, quantity,
+- product.weight, product.volume, product.lst_price, product.standard_price, product.default_code,
+- partner.is_company, partner.country_code, partner.state_code, partner.zip, partner.ref.
+Available operators are + - * / %, comparisons, && || ! and the functions abs, min, max, round, floor, ceil and if.
+Example: quantity * product.volume * 0.65`},
+	})
+
+	h.AccountTax().Methods().CheckFormula().DeclareMethod(
+		`CheckFormula checks that taxes computed with a formula have a valid formula`,
+		func(rs m.AccountTaxSet) {
+			for _, tax := range rs.Records() {
+				if tax.AmountType() != "formula" {
+					continue
+				}
+				if tax.Formula() == "" {
+					panic(rs.T(`The tax %s is computed with a formula, but it has no formula.`, tax.Name()))
+				}
+				f, err := formula.Parse(tax.Formula())
+				if err == nil {
+					err = f.CheckVariables(taxFormulaVariables...)
+				}
+				if err != nil {
+					panic(rs.T(`Invalid formula for tax %s: %s`, tax.Name(), err.Error()))
+				}
+			}
+		})
+
+	h.AccountTax().Methods().FormulaVariables().DeclareMethod(
+		`FormulaVariables returns the values of the variables that can be used in tax formulas`,
+		func(rs m.AccountTaxSet, baseAmount, priceUnit, quantity float64, product m.ProductProductSet, partner m.PartnerSet) map[string]interface{} {
+			vars := map[string]interface{}{
+				"base_amount":            baseAmount,
+				"price_unit":             priceUnit,
+				"quantity":               quantity,
+				"product.weight":         0.0,
+				"product.volume":         0.0,
+				"product.lst_price":      0.0,
+				"product.standard_price": 0.0,
+				"product.default_code":   "",
+				"partner.is_company":     false,
+				"partner.country_code":   "",
+				"partner.state_code":     "",
+				"partner.zip":            "",
+				"partner.ref":            "",
+			}
+			if product != nil && product.IsNotEmpty() {
+				vars["product.weight"] = product.Weight()
+				vars["product.volume"] = product.Volume()
+				vars["product.lst_price"] = product.LstPrice()
+				vars["product.standard_price"] = product.StandardPrice()
+				vars["product.default_code"] = product.DefaultCode()
+			}
+			if partner != nil && partner.IsNotEmpty() {
+				vars["partner.is_company"] = partner.IsCompany()
+				vars["partner.country_code"] = partner.Country().Code()
+				vars["partner.state_code"] = partner.State().Code()
+				vars["partner.zip"] = partner.Zip()
+				vars["partner.ref"] = partner.Ref()
+			}
+			return vars
+		})
+
+	h.AccountTax().Methods().ComputeAmount().Extend(
+		`Taxes computed with a formula evaluate it with the line values. The result of the
+		formula is the tax amount, whether the tax is included in price or not.`,
+		func(rs m.AccountTaxSet, baseAmount, priceUnit, quantity float64, product m.ProductProductSet, partner m.PartnerSet) float64 {
+			if rs.AmountType() != "formula" {
+				return rs.Super().ComputeAmount(baseAmount, priceUnit, quantity, product, partner)
+			}
+			rs.EnsureOne()
+			f, err := formula.Parse(rs.Formula())
+			if err != nil {
+				panic(rs.T(`Invalid formula for tax %s: %s`, rs.Name(), err.Error()))
+			}
+			res, err := f.Eval(rs.FormulaVariables(baseAmount, priceUnit, quantity, product, partner))
+			if err != nil {
+				panic(rs.T(`Error while computing tax %s: %s`, rs.Name(), err.Error()))
+			}
+			return res
+		})
+
+}
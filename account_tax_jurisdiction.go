@@ -0,0 +1,451 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package account
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/hexya-addons/account/jurisdiction"
+	"github.com/hexya-erp/hexya/src/actions"
+	"github.com/hexya-erp/hexya/src/models"
+	"github.com/hexya-erp/hexya/src/models/types"
+	"github.com/hexya-erp/hexya/src/models/types/dates"
+	"github.com/hexya-erp/hexya/src/tools/strutils"
+	"github.com/hexya-erp/pool/h"
+	"github.com/hexya-erp/pool/m"
+	"github.com/hexya-erp/pool/q"
+)
+
+func init() {
+
+	h.AccountTaxJurisdiction().DeclareModel()
+	h.AccountTaxJurisdiction().SetDefaultOrder("Code")
+
+	h.AccountTaxJurisdiction().AddFields(map[string]models.FieldDefinition{
+		"Code": models.CharField{
+			Required: true,
+			Index:    true},
+		"Name": models.CharField{
+			Required: true},
+		"Level": models.SelectionField{
+			Selection: types.Selection{
+				"state":    "State",
+				"county":   "County",
+				"city":     "City",
+				"district": "Special District"},
+			Required: true,
+			Default:  models.DefaultValue("state")},
+		"Country": models.Many2OneField{
+			RelationModel: h.Country()},
+		"State": models.Many2OneField{
+			RelationModel: h.CountryState()},
+		"Authority": models.Many2OneField{
+			String:        "Tax Authority",
+			RelationModel: h.Partner(),
+			Help:          "Authority to which the taxes collected for this jurisdiction are filed"},
+		"Active": models.BooleanField{
+			Default: models.DefaultValue(true)},
+		"Company": models.Many2OneField{
+			RelationModel: h.Company(),
+			Required:      true,
+			Default: func(env models.Environment) interface{} {
+				return h.User().NewSet(env).CurrentUser().Company()
+			}},
+		"Rates": models.One2ManyField{
+			RelationModel: h.AccountTaxJurisdictionRate(),
+			ReverseFK:     "Jurisdiction",
+			JSON:          "rate_ids"},
+		"Zips": models.One2ManyField{
+			String:        "Zip Codes",
+			RelationModel: h.AccountTaxJurisdictionZip(),
+			ReverseFK:     "Jurisdiction",
+			JSON:          "zip_ids"},
+	})
+
+	h.AccountTaxJurisdiction().AddSQLConstraint("code_company_uniq", "unique (code, company_id)",
+		"The code of the jurisdiction must be unique per company!")
+
+	h.AccountTaxJurisdiction().Methods().RateAt().DeclareMethod(
+		`RateAt returns the rate of this jurisdiction effective at the given date`,
+		func(rs m.AccountTaxJurisdictionSet, date dates.Date) m.AccountTaxJurisdictionRateSet {
+			rs.EnsureOne()
+			for _, rate := range rs.Rates().Records() {
+				if rate.IsEffective(date) {
+					return rate
+				}
+			}
+			return h.AccountTaxJurisdictionRate().NewSet(rs.Env())
+		})
+
+	h.AccountTaxJurisdiction().Methods().FindByZip().DeclareMethod(
+		`FindByZip returns the jurisdictions of the given company which cover the whole given zip code
+		at the given date. The zip code can be given with 5 digits or as a zip+4 code.`,
+		func(rs m.AccountTaxJurisdictionSet, zip string, date dates.Date, company m.CompanySet) m.AccountTaxJurisdictionSet {
+			res := h.AccountTaxJurisdiction().NewSet(rs.Env())
+			lo, hi, err := jurisdiction.ZipBounds(zip, zip)
+			if err != nil {
+				return res
+			}
+			cond := q.AccountTaxJurisdictionZip().ZipFrom().LowerOrEqual(lo).
+				And().ZipTo().GreaterOrEqual(hi).
+				And().DateFrom().LowerOrEqual(date).
+				AndCond(q.AccountTaxJurisdictionZip().DateTo().IsNull().Or().DateTo().GreaterOrEqual(date)).
+				And().JurisdictionFilteredOn(q.AccountTaxJurisdiction().Company().Equals(company))
+			for _, z := range h.AccountTaxJurisdictionZip().Search(rs.Env(), cond).Records() {
+				res = res.Union(z.Jurisdiction())
+			}
+			return res
+		})
+
+	h.AccountTaxJurisdiction().Methods().TaxesForZip().DeclareMethod(
+		`TaxesForZip returns the taxes of all the jurisdictions which apply to the given zip code at the given date`,
+		func(rs m.AccountTaxJurisdictionSet, zip string, date dates.Date, company m.CompanySet) m.AccountTaxSet {
+			if date.IsZero() {
+				date = dates.Today()
+			}
+			taxes := h.AccountTax().NewSet(rs.Env())
+			for _, jur := range rs.FindByZip(zip, date, company).Records() {
+				taxes = taxes.Union(jur.RateAt(date).Tax())
+			}
+			return taxes
+		})
+
+	h.AccountTaxJurisdiction().Methods().LoadRates().DeclareMethod(
+		`LoadRates creates or updates the jurisdictions of the given company from the given rate table lines.
+
+		The zip codes of each loaded jurisdiction are replaced by those of the table. A sales tax is created
+		for each new rate of a jurisdiction, and the taxes of successive rates are chained by their validity
+		dates so that documents always get the rate effective at their date.`,
+		func(rs m.AccountTaxJurisdictionSet, rates []jurisdiction.Rate, company m.CompanySet, country m.CountrySet,
+			account m.AccountAccountSet) m.AccountTaxJurisdictionSet {
+
+			byCode := make(map[string][]jurisdiction.Rate)
+			var codes []string
+			for _, r := range rates {
+				if _, ok := byCode[r.Code]; !ok {
+					codes = append(codes, r.Code)
+				}
+				byCode[r.Code] = append(byCode[r.Code], r)
+			}
+			res := h.AccountTaxJurisdiction().NewSet(rs.Env())
+			for _, code := range codes {
+				codeRates := byCode[code]
+				jur := h.AccountTaxJurisdiction().Search(rs.Env(),
+					q.AccountTaxJurisdiction().Code().Equals(code).And().Company().Equals(company))
+				data := h.AccountTaxJurisdiction().NewData().
+					SetCode(code).
+					SetName(codeRates[0].Name).
+					SetLevel(codeRates[0].Level).
+					SetCountry(country).
+					SetCompany(company)
+				if codeRates[0].State != "" {
+					data.SetState(h.CountryState().Search(rs.Env(),
+						q.CountryState().Code().Equals(codeRates[0].State).And().Country().Equals(country)).Limit(1))
+				}
+				if jur.IsEmpty() {
+					jur = h.AccountTaxJurisdiction().Create(rs.Env(), data)
+				} else {
+					jur.Write(data)
+				}
+				jur.Zips().Unlink()
+				for _, r := range codeRates {
+					h.AccountTaxJurisdictionZip().Create(rs.Env(), h.AccountTaxJurisdictionZip().NewData().
+						SetJurisdiction(jur).
+						SetZipFrom(r.ZipFrom).
+						SetZipTo(r.ZipTo).
+						SetDateFrom(dates.Date{Time: r.DateFrom}).
+						SetDateTo(dates.Date{Time: r.DateTo}))
+				}
+				jur.LoadPeriods(codeRates, account)
+				res = res.Union(jur)
+			}
+			return res
+		})
+
+	h.AccountTaxJurisdiction().Methods().LoadPeriods().DeclareMethod(
+		`LoadPeriods creates the rates of this jurisdiction for the effective periods of the given
+		rate table lines which do not exist yet, and chains the taxes of all its rates.`,
+		func(rs m.AccountTaxJurisdictionSet, rates []jurisdiction.Rate, account m.AccountAccountSet) {
+			rs.EnsureOne()
+			for _, r := range rates {
+				dateFrom := dates.Date{Time: r.DateFrom}
+				existing := h.AccountTaxJurisdictionRate().Search(rs.Env(),
+					q.AccountTaxJurisdictionRate().Jurisdiction().Equals(rs).And().DateFrom().Equals(dateFrom))
+				if existing.IsNotEmpty() && existing.Rate() == r.Rate {
+					existing.SetDateTo(dates.Date{Time: r.DateTo})
+					continue
+				}
+				tax := h.AccountTax().Create(rs.Env(), h.AccountTax().NewData().
+					SetName(fmt.Sprintf("%s %g%%", rs.Name(), r.Rate)).
+					SetDescription(fmt.Sprintf("%s %g%%", rs.Code(), r.Rate)).
+					SetTypeTaxUse("sale").
+					SetAmountType("percent").
+					SetAmount(r.Rate).
+					SetAccount(account).
+					SetRefundAccount(account).
+					SetCompany(rs.Company()).
+					SetJurisdiction(rs))
+				data := h.AccountTaxJurisdictionRate().NewData().
+					SetJurisdiction(rs).
+					SetDateFrom(dateFrom).
+					SetDateTo(dates.Date{Time: r.DateTo}).
+					SetRate(r.Rate).
+					SetTax(tax)
+				if existing.IsNotEmpty() {
+					existing.Write(data)
+					continue
+				}
+				h.AccountTaxJurisdictionRate().Create(rs.Env(), data)
+			}
+			// Chain the taxes of successive rates
+			periods := rs.Rates().Records()
+			sort.Slice(periods, func(i, j int) bool {
+				return periods[i].DateFrom().Lower(periods[j].DateFrom())
+			})
+			for i := 0; i < len(periods)-1; i++ {
+				periods[i].Tax().Write(h.AccountTax().NewData().
+					SetValidUntil(periods[i+1].DateFrom().AddDate(0, 0, -1)).
+					SetSuccessorTax(periods[i+1].Tax()))
+			}
+		})
+
+	h.AccountTaxJurisdictionRate().DeclareModel()
+	h.AccountTaxJurisdictionRate().SetDefaultOrder("DateFrom DESC")
+
+	h.AccountTaxJurisdictionRate().AddFields(map[string]models.FieldDefinition{
+		"Jurisdiction": models.Many2OneField{
+			RelationModel: h.AccountTaxJurisdiction(),
+			Required:      true,
+			OnDelete:      models.Cascade},
+		"DateFrom": models.DateField{
+			String:   "Valid From",
+			Required: true},
+		"DateTo": models.DateField{
+			String: "Valid Until"},
+		"Rate": models.FloatField{
+			String:   "Rate (%)",
+			Required: true},
+		"Tax": models.Many2OneField{
+			RelationModel: h.AccountTax(),
+			OnDelete:      models.Restrict,
+			Help:          "Tax applied for this rate"},
+	})
+
+	h.AccountTaxJurisdictionRate().Methods().IsEffective().DeclareMethod(
+		`IsEffective returns true if this rate applies at the given date`,
+		func(rs m.AccountTaxJurisdictionRateSet, date dates.Date) bool {
+			return date.GreaterEqual(rs.DateFrom()) && (rs.DateTo().IsZero() || date.LowerEqual(rs.DateTo()))
+		})
+
+	h.AccountTaxJurisdictionZip().DeclareModel()
+	h.AccountTaxJurisdictionZip().SetDefaultOrder("ZipFrom")
+
+	h.AccountTaxJurisdictionZip().AddFields(map[string]models.FieldDefinition{
+		"Jurisdiction": models.Many2OneField{
+			RelationModel: h.AccountTaxJurisdiction(),
+			Required:      true,
+			OnDelete:      models.Cascade},
+		"ZipFrom": models.CharField{
+			String:   "Zip From",
+			Required: true,
+			Index:    true,
+			Help:     "First zip+4 code of the range, as 9 digits"},
+		"ZipTo": models.CharField{
+			String:   "Zip To",
+			Required: true,
+			Help:     "Last zip+4 code of the range, as 9 digits"},
+		"DateFrom": models.DateField{
+			String:   "Valid From",
+			Required: true},
+		"DateTo": models.DateField{
+			String: "Valid Until"},
+	})
+
+	h.AccountTax().AddFields(map[string]models.FieldDefinition{
+		"Jurisdiction": models.Many2OneField{
+			String:        "Tax Jurisdiction",
+			RelationModel: h.AccountTaxJurisdiction(),
+			Index:         true,
+			Help:          "Sales tax jurisdiction for which this tax is collected"},
+	})
+
+	h.AccountInvoiceTax().AddFields(map[string]models.FieldDefinition{
+		"Jurisdiction": models.Many2OneField{
+			String:        "Tax Jurisdiction",
+			RelationModel: h.AccountTaxJurisdiction(),
+			Related:       "Tax.Jurisdiction"},
+	})
+
+	h.AccountMoveLine().AddFields(map[string]models.FieldDefinition{
+		"TaxJurisdiction": models.Many2OneField{
+			RelationModel: h.AccountTaxJurisdiction(),
+			Related:       "TaxLine.Jurisdiction"},
+	})
+
+	h.AccountFiscalPosition().AddFields(map[string]models.FieldDefinition{
+		"TaxJurisdictions": models.BooleanField{
+			String: "Use Tax Jurisdictions",
+			Help: `If set, customer invoice lines with a sales tax get the taxes of all the sales tax
+jurisdictions covering the zip code of the delivery address, in addition to the mapped taxes.`},
+	})
+
+	h.AccountTax().Methods().JurisdictionTaxable().DeclareMethod(
+		`JurisdictionTaxable returns true if this set contains a sales tax which is not a
+		jurisdiction tax and which is actually levied, i.e. a non zero or group tax.`,
+		func(rs m.AccountTaxSet) bool {
+			for _, tax := range rs.Records() {
+				if tax.TypeTaxUse() != "sale" || tax.Jurisdiction().IsNotEmpty() {
+					continue
+				}
+				if tax.AmountType() == "group" || tax.Amount() != 0 {
+					return true
+				}
+			}
+			return false
+		})
+
+	h.AccountInvoice().Methods().JurisdictionTaxes().DeclareMethod(
+		`JurisdictionTaxes returns the taxes of the sales tax jurisdictions covering the delivery
+		address of this invoice, if it is a customer invoice with a fiscal position using tax jurisdictions.`,
+		func(rs m.AccountInvoiceSet) m.AccountTaxSet {
+			if !strutils.IsIn(rs.Type(), "out_invoice", "out_refund") || !rs.FiscalPosition().TaxJurisdictions() {
+				return h.AccountTax().NewSet(rs.Env())
+			}
+			delivery := rs.GetDeliveryPartner(rs.Partner())
+			if delivery.IsEmpty() {
+				delivery = rs.Partner()
+			}
+			return h.AccountTaxJurisdiction().NewSet(rs.Env()).TaxesForZip(delivery.Zip(), rs.TaxDate(), rs.Company())
+		})
+
+	h.AccountInvoice().Methods().ApplyJurisdictionTaxes().DeclareMethod(
+		`ApplyJurisdictionTaxes replaces the jurisdiction taxes of the lines of the draft customer
+		invoices of this set by the taxes of the jurisdictions applying to them, and recomputes
+		the taxes of the invoices whose lines changed. Only lines with a sales tax get jurisdiction taxes.`,
+		func(rs m.AccountInvoiceSet) {
+			for _, invoice := range rs.Records() {
+				if invoice.State() != "draft" || invoice.Move().IsNotEmpty() ||
+					!strutils.IsIn(invoice.Type(), "out_invoice", "out_refund") {
+					continue
+				}
+				jurisdictionTaxes := invoice.JurisdictionTaxes()
+				var changed bool
+				for _, line := range invoice.InvoiceLines().Records() {
+					taxes := line.InvoiceLineTaxes().Filtered(func(r m.AccountTaxSet) bool {
+						return r.Jurisdiction().IsEmpty()
+					})
+					if taxes.JurisdictionTaxable() {
+						taxes = taxes.Union(jurisdictionTaxes)
+					}
+					if taxes.Equals(line.InvoiceLineTaxes()) {
+						continue
+					}
+					line.SetInvoiceLineTaxes(taxes)
+					changed = true
+				}
+				if changed {
+					invoice.ComputeTaxes()
+				}
+			}
+		})
+
+	h.AccountInvoice().Methods().Write().Extend("",
+		func(rs m.AccountInvoiceSet, vals m.AccountInvoiceData) bool {
+			res := rs.Super().Write(vals)
+			if vals.HasPartner() || vals.HasFiscalPosition() || vals.HasDateInvoice() || vals.HasDate() {
+				rs.ApplyJurisdictionTaxes()
+			}
+			return res
+		})
+
+	h.AccountInvoiceLine().Methods().Create().Extend("",
+		func(rs m.AccountInvoiceLineSet, data m.AccountInvoiceLineData) m.AccountInvoiceLineSet {
+			if data.Invoice().IsNotEmpty() && data.InvoiceLineTaxes().JurisdictionTaxable() {
+				data.SetInvoiceLineTaxes(data.InvoiceLineTaxes().Union(data.Invoice().JurisdictionTaxes()))
+			}
+			return rs.Super().Create(data)
+		})
+
+	h.AccountTaxJurisdictionImport().DeclareTransientModel()
+	h.AccountTaxJurisdictionImport().AddFields(map[string]models.FieldDefinition{
+		"Path": models.CharField{
+			String:   "Rate Tables",
+			Required: true,
+			Help:     "Path on the server of a CSV rate table, or of a directory of CSV rate tables"},
+		"Company": models.Many2OneField{
+			RelationModel: h.Company(),
+			Required:      true,
+			Default: func(env models.Environment) interface{} {
+				return h.User().NewSet(env).CurrentUser().Company()
+			}},
+		"Country": models.Many2OneField{
+			RelationModel: h.Country(),
+			Required:      true,
+			Default: func(env models.Environment) interface{} {
+				return h.User().NewSet(env).CurrentUser().Company().Country()
+			}},
+		"Account": models.Many2OneField{
+			String:        "Tax Account",
+			RelationModel: h.AccountAccount(),
+			Required:      true,
+			Filter:        q.AccountAccount().Deprecated().Equals(false),
+			Help:          "Account of the taxes created for new rates"},
+	})
+
+	h.AccountTaxJurisdictionImport().Methods().ReadTables().DeclareMethod(
+		`ReadTables reads the rate tables of the path of this wizard`,
+		func(rs m.AccountTaxJurisdictionImportSet) []jurisdiction.Rate {
+			info, err := os.Stat(rs.Path())
+			if err != nil {
+				panic(rs.T(`Unable to read rate tables: %s`, err.Error()))
+			}
+			files := []string{rs.Path()}
+			if info.IsDir() {
+				files = nil
+				entries, err := ioutil.ReadDir(rs.Path())
+				if err != nil {
+					panic(rs.T(`Unable to read rate tables: %s`, err.Error()))
+				}
+				for _, entry := range entries {
+					if !entry.IsDir() && strings.EqualFold(filepath.Ext(entry.Name()), ".csv") {
+						files = append(files, filepath.Join(rs.Path(), entry.Name()))
+					}
+				}
+			}
+			var rates []jurisdiction.Rate
+			for _, fileName := range files {
+				f, err := os.Open(fileName)
+				if err != nil {
+					panic(rs.T(`Unable to read rate tables: %s`, err.Error()))
+				}
+				fileRates, err := jurisdiction.ReadTable(f)
+				f.Close()
+				if err != nil {
+					panic(rs.T(`Invalid rate table %s: %s`, filepath.Base(fileName), err.Error()))
+				}
+				rates = append(rates, fileRates...)
+			}
+			return rates
+		})
+
+	h.AccountTaxJurisdictionImport().Methods().ActionImport().DeclareMethod(
+		`ActionImport loads the rate tables and displays the loaded jurisdictions`,
+		func(rs m.AccountTaxJurisdictionImportSet) *actions.Action {
+			rs.EnsureOne()
+			jurisdictions := h.AccountTaxJurisdiction().NewSet(rs.Env()).LoadRates(rs.ReadTables(), rs.Company(), rs.Country(), rs.Account())
+			return &actions.Action{
+				Name:     rs.T(`Tax Jurisdictions`),
+				Type:     actions.ActionActWindow,
+				Model:    "AccountTaxJurisdiction",
+				ViewMode: "tree,form",
+				Domain:   fmt.Sprintf("[('id', 'in', %s)]", strings.Replace(fmt.Sprint(jurisdictions.Ids()), " ", ", ", -1)),
+			}
+		})
+
+}
@@ -0,0 +1,133 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package account
+
+import (
+	"github.com/hexya-addons/account/accounttypes"
+	"github.com/hexya-erp/hexya/src/models"
+	"github.com/hexya-erp/hexya/src/tools/nbutils"
+	"github.com/hexya-erp/pool/h"
+	"github.com/hexya-erp/pool/m"
+	"github.com/hexya-erp/pool/q"
+)
+
+func init() {
+
+	h.AccountTaxRepartitionLine().DeclareModel()
+	h.AccountTaxRepartitionLine().SetDefaultOrder("Sequence", "ID")
+
+	h.AccountTaxRepartitionLine().AddFields(map[string]models.FieldDefinition{
+		"Tax": models.Many2OneField{
+			RelationModel: h.AccountTax(),
+			Required:      true,
+			OnDelete:      models.Cascade},
+		"Sequence": models.IntegerField{
+			Default: models.DefaultValue(1)},
+		"Factor": models.FloatField{
+			String:   "Percentage",
+			Required: true,
+			Default:  models.DefaultValue(100.0),
+			Help: `Percentage of the tax amount booked on this line. Use a negative percentage
+for self-assessed taxes, e.g. 100 and -100 for a reverse charge tax.`},
+		"Account": models.Many2OneField{
+			String:        "Tax Account",
+			RelationModel: h.AccountAccount(),
+			Filter:        q.AccountAccount().Deprecated().Equals(false),
+			Help:          "Account on which this part of the tax is booked. Leave empty to use the account of the invoice line."},
+		"RefundAccount": models.Many2OneField{
+			String:        "Tax Account on Refunds",
+			RelationModel: h.AccountAccount(),
+			Filter:        q.AccountAccount().Deprecated().Equals(false),
+			Help:          "Account on which this part of the tax is booked for refunds. Leave empty to use the account of the invoice line."},
+		"Tags": models.Many2ManyField{
+			RelationModel: h.AccountAccountTag(),
+			JSON:          "tag_ids",
+			Filter:        q.AccountAccountTag().Applicability().Equals("taxes"),
+			Help:          "Tags of the tax report boxes in which this part of the tax is reported"},
+		"Company": models.Many2OneField{
+			RelationModel: h.Company(),
+			Related:       "Tax.Company"},
+	})
+
+	h.AccountTax().AddFields(map[string]models.FieldDefinition{
+		"Repartitions": models.One2ManyField{
+			String:        "Repartition Lines",
+			RelationModel: h.AccountTaxRepartitionLine(),
+			ReverseFK:     "Tax",
+			JSON:          "repartition_line_ids",
+			Copy:          true,
+			Constraint:    h.AccountTax().Methods().CheckRepartitions(),
+			Help: `If set, the tax amount is split on these lines instead of being booked on the tax account.
+This allows taxes which are both due and deductible, such as reverse charge taxes.`},
+	})
+
+	h.AccountTax().Fields().PriceInclude().SetConstraint(h.AccountTax().Methods().CheckRepartitions())
+	h.AccountTax().Fields().AmountType().SetConstraint(h.AccountTax().Methods().CheckRepartitions())
+
+	h.AccountInvoiceTax().AddFields(map[string]models.FieldDefinition{
+		"RepartitionLine": models.Many2OneField{
+			RelationModel: h.AccountTaxRepartitionLine(),
+			ReadOnly:      true},
+	})
+
+	h.AccountMoveLine().AddFields(map[string]models.FieldDefinition{
+		"TaxRepartitionLine": models.Many2OneField{
+			String:        "Originator Tax Repartition Line",
+			RelationModel: h.AccountTaxRepartitionLine(),
+			OnDelete:      models.Restrict},
+	})
+
+	h.AccountTax().Methods().CheckRepartitions().DeclareMethod(
+		`CheckRepartitions checks that repartition lines are only used on taxes supporting them`,
+		func(rs m.AccountTaxSet) {
+			for _, tax := range rs.Records() {
+				if tax.Repartitions().IsEmpty() {
+					continue
+				}
+				if tax.AmountType() == "group" {
+					panic(rs.T(`The group of taxes %s cannot have repartition lines. Set them on its children taxes.`, tax.Name()))
+				}
+				if tax.PriceInclude() {
+					panic(rs.T(`The tax %s is included in price and cannot have repartition lines.`, tax.Name()))
+				}
+			}
+		})
+
+	h.AccountTax().Methods().Repartition().DeclareMethod(
+		`Repartition splits the given applied tax data of this tax on its repartition lines.
+		Amounts are rounded with the company currency and the rounding difference is put on the last line.
+		If this tax has no repartition lines, the data is returned unchanged.`,
+		func(rs m.AccountTaxSet, taxData accounttypes.AppliedTaxData) []accounttypes.AppliedTaxData {
+			rs.EnsureOne()
+			if rs.Repartitions().IsEmpty() {
+				return []accounttypes.AppliedTaxData{taxData}
+			}
+			if prec == 0 {
+				if currency == nil || currency.IsEmpty() {
+					currency = rs.Company().Currency()
+				}
+				prec = currency.Rounding()
+			}
+			lines := rs.Repartitions().Records()
+			var totalFactor, totalAmount float64
+			for _, line := range lines {
+				totalFactor += line.Factor()
+			}
+			var res []accounttypes.AppliedTaxData
+			for i, line := range lines {
+				lineData := taxData
+				lineData.Amount = nbutils.Round(taxData.Amount*line.Factor()/100, prec)
+				if i == len(lines)-1 {
+					lineData.Amount = nbutils.Round(taxData.Amount*totalFactor/100-totalAmount, prec)
+				}
+				totalAmount += lineData.Amount
+				lineData.AccountID = line.Account().ID()
+				lineData.RefundAccountID = line.RefundAccount().ID()
+				lineData.RepartitionLineID = line.ID()
+				res = append(res, lineData)
+			}
+			return res
+		})
+
+}
@@ -0,0 +1,471 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package account
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strings"
+
+	"github.com/hexya-addons/account/formula"
+	"github.com/hexya-erp/hexya/src/actions"
+	"github.com/hexya-erp/hexya/src/models"
+	"github.com/hexya-erp/hexya/src/models/types"
+	"github.com/hexya-erp/hexya/src/models/types/dates"
+	"github.com/hexya-erp/pool/h"
+	"github.com/hexya-erp/pool/m"
+	"github.com/hexya-erp/pool/q"
+)
+
+// taxBoxCodeRegexp is the pattern box codes must match to be usable in formulas
+var taxBoxCodeRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+func init() {
+
+	h.Company().AddFields(map[string]models.FieldDefinition{
+		"TaxPayableAccount": models.Many2OneField{
+			String:        "Tax Payable Account",
+			RelationModel: h.AccountAccount(),
+			Filter:        q.AccountAccount().Deprecated().Equals(false),
+			Help:          "Account in which the tax due is booked when closing a tax return period"},
+		"TaxReceivableAccount": models.Many2OneField{
+			String:        "Tax Receivable Account",
+			RelationModel: h.AccountAccount(),
+			Filter:        q.AccountAccount().Deprecated().Equals(false),
+			Help:          "Account in which the tax credit is booked when closing a tax return period"},
+	})
+
+	h.AccountTaxReport().DeclareModel()
+	h.AccountTaxReport().SetDefaultOrder("Name")
+
+	h.AccountTaxReport().AddFields(map[string]models.FieldDefinition{
+		"Name": models.CharField{
+			Required:  true,
+			Translate: true},
+		"Country": models.Many2OneField{
+			RelationModel: h.Country(),
+			Help:          "Country whose tax return this report implements"},
+		"Active": models.BooleanField{
+			Default: models.DefaultValue(true)},
+		"Boxes": models.One2ManyField{
+			RelationModel: h.AccountTaxReportBox(),
+			ReverseFK:     "Report",
+			JSON:          "box_ids",
+			Copy:          true,
+			Constraint:    h.AccountTaxReport().Methods().CheckFormulas()},
+	})
+
+	h.AccountTaxReport().Methods().CheckFormulas().DeclareMethod(
+		`CheckFormulas checks that the formulas of the boxes of this report are valid
+		and do not reference each other in a loop.`,
+		func(rs m.AccountTaxReportSet) {
+			for _, report := range rs.Records() {
+				var codes []string
+				deps := make(map[string][]string)
+				for _, box := range report.Boxes().Records() {
+					codes = append(codes, box.Code())
+				}
+				for _, box := range report.Boxes().Records() {
+					if box.BoxType() != "formula" {
+						continue
+					}
+					f, err := formula.Parse(box.Formula())
+					if err != nil {
+						panic(rs.T(`Invalid formula for box %s: %s`, box.Code(), err))
+					}
+					if err := f.CheckVariables(codes...); err != nil {
+						panic(rs.T(`Invalid formula for box %s: %s`, box.Code(), err))
+					}
+					deps[box.Code()] = f.Variables()
+				}
+				// Depth first search of a cycle in the dependency graph
+				state := make(map[string]int)
+				var visit func(code string)
+				visit = func(code string) {
+					switch state[code] {
+					case 1:
+						panic(rs.T(`The formula of box %s of report %s references itself.`, code, report.Name()))
+					case 2:
+						return
+					}
+					state[code] = 1
+					for _, dep := range deps[code] {
+						visit(dep)
+					}
+					state[code] = 2
+				}
+				for code := range deps {
+					visit(code)
+				}
+			}
+		})
+
+	h.AccountTaxReportBox().DeclareModel()
+	h.AccountTaxReportBox().SetDefaultOrder("Sequence", "ID")
+
+	h.AccountTaxReportBox().AddFields(map[string]models.FieldDefinition{
+		"Report": models.Many2OneField{
+			RelationModel: h.AccountTaxReport(),
+			Required:      true,
+			OnDelete:      models.Cascade},
+		"Sequence": models.IntegerField{
+			Default: models.DefaultValue(10)},
+		"Code": models.CharField{
+			Required:   true,
+			Constraint: h.AccountTaxReportBox().Methods().CheckCode(),
+			Help:       "Identifier of the box on the tax return, used to reference it in formulas"},
+		"Name": models.CharField{
+			String:    "Label",
+			Required:  true,
+			Translate: true},
+		"BoxType": models.SelectionField{
+			String: "Type",
+			Selection: types.Selection{
+				"base":    "Base Amount",
+				"tax":     "Tax Amount",
+				"account": "Account Balance",
+				"formula": "Formula"},
+			Required: true,
+			Default:  models.DefaultValue("tax"),
+			Help: `- Base Amount: balance of the journal items having a tax with one of the tags.
+- Tax Amount: balance of the tax journal items generated by a tax with one of the tags.
+- Account Balance: balance of the journal items on accounts with one of the tags.
+- Formula: computed from other boxes of the report.`},
+		"Tags": models.Many2ManyField{
+			RelationModel: h.AccountAccountTag(),
+			JSON:          "tag_ids"},
+		"InvertSign": models.BooleanField{
+			String: "Invert Sign",
+			Help:   "Check this box to report credit balances as positive amounts, e.g. for sales"},
+		"Formula": models.CharField{
+			Constraint: h.AccountTaxReportBox().Methods().CheckFormula(),
+			Help: `Expression computing the amount of this box from the codes of other boxes,
+e.g. 'B01 + B02 - B10' or 'max(B20 - B30, 0)'`},
+	})
+
+	h.AccountTaxReportBox().AddSQLConstraint("code_report_uniq", "unique (code, report_id)",
+		"The code of a box must be unique per tax report!")
+
+	h.AccountTaxReportBox().Methods().CheckCode().DeclareMethod(
+		`CheckCode checks that the code of the box can be used in formulas`,
+		func(rs m.AccountTaxReportBoxSet) {
+			for _, box := range rs.Records() {
+				if !taxBoxCodeRegexp.MatchString(box.Code()) {
+					panic(rs.T(`The code '%s' is invalid: it must start with a letter and contain only letters, digits and underscores.`, box.Code()))
+				}
+			}
+		})
+
+	h.AccountTaxReportBox().Methods().CheckFormula().DeclareMethod(
+		`CheckFormula checks that formula boxes have a syntactically valid formula.
+		References to other boxes are checked at the report level.`,
+		func(rs m.AccountTaxReportBoxSet) {
+			for _, box := range rs.Records() {
+				if box.BoxType() != "formula" {
+					continue
+				}
+				if strings.TrimSpace(box.Formula()) == "" {
+					panic(rs.T(`The box %s must have a formula.`, box.Code()))
+				}
+				if _, err := formula.Parse(box.Formula()); err != nil {
+					panic(rs.T(`Invalid formula for box %s: %s`, box.Code(), err))
+				}
+			}
+		})
+
+	h.AccountTaxReportBox().Methods().MoveLineCondition().DeclareMethod(
+		`MoveLineCondition returns the condition on journal items whose balance is reported in this box.
+		It must not be called on formula boxes.`,
+		func(rs m.AccountTaxReportBoxSet) q.AccountMoveLineCondition {
+			switch rs.BoxType() {
+			case "base":
+				return q.AccountMoveLine().TaxesFilteredOn(q.AccountTax().Tags().In(rs.Tags())).
+					And().TaxExigible().Equals(true)
+			case "tax":
+				return q.AccountMoveLine().TaxRepartitionLineFilteredOn(q.AccountTaxRepartitionLine().Tags().In(rs.Tags())).
+					OrCond(q.AccountMoveLine().TaxRepartitionLine().IsNull().
+						And().TaxLineFilteredOn(q.AccountTax().Tags().In(rs.Tags()))).
+					AndCond(q.AccountMoveLine().TaxExigible().Equals(true))
+			case "account":
+				return q.AccountMoveLine().AccountFilteredOn(q.AccountAccount().Tags().In(rs.Tags()))
+			}
+			panic(rs.T(`The box %s is computed by a formula and has no journal items.`, rs.Code()))
+		})
+
+	h.AccountTaxReturn().DeclareTransientModel()
+	h.AccountTaxReturn().AddFields(map[string]models.FieldDefinition{
+		"Company": models.Many2OneField{
+			RelationModel: h.Company(),
+			Required:      true,
+			Default: func(env models.Environment) interface{} {
+				return h.User().NewSet(env).CurrentUser().Company()
+			}},
+		"Report": models.Many2OneField{
+			String:        "Tax Report",
+			RelationModel: h.AccountTaxReport(),
+			Required:      true,
+			Default: func(env models.Environment) interface{} {
+				country := h.User().NewSet(env).CurrentUser().Company().Partner().Country()
+				return h.AccountTaxReport().Search(env, q.AccountTaxReport().Country().Equals(country)).Limit(1)
+			}},
+		"DateFrom": models.DateField{
+			String:   "Start Date",
+			Required: true,
+			Default: func(env models.Environment) interface{} {
+				return dates.Today().StartOfMonth().AddDate(0, -1, 0)
+			}},
+		"DateTo": models.DateField{
+			String:   "End Date",
+			Required: true,
+			Default: func(env models.Environment) interface{} {
+				return dates.Today().StartOfMonth().AddDate(0, 0, -1)
+			}},
+		"Journal": models.Many2OneField{
+			RelationModel: h.AccountJournal(),
+			Filter:        q.AccountJournal().Type().Equals("general"),
+			Help:          "Journal in which the tax closing entry is booked"},
+		"TaxPayableAccount": models.Many2OneField{
+			RelationModel: h.AccountAccount(),
+			Related:       "Company.TaxPayableAccount"},
+		"TaxReceivableAccount": models.Many2OneField{
+			RelationModel: h.AccountAccount(),
+			Related:       "Company.TaxReceivableAccount"},
+		"TaxLockDate": models.DateField{
+			Related:  "Company.TaxLockDate",
+			ReadOnly: true},
+		"Authority": models.Many2OneField{
+			String:        "Tax Authority",
+			RelationModel: h.Partner(),
+			Help:          "If set, only the taxes of the jurisdictions filed to this authority are reported"},
+		"Lines": models.One2ManyField{
+			RelationModel: h.AccountTaxReturnLine(),
+			ReverseFK:     "Return",
+			JSON:          "line_ids",
+			ReadOnly:      true},
+	})
+
+	h.AccountTaxReturn().Methods().MoveLineCondition().DeclareMethod(
+		`MoveLineCondition returns the condition restricting the given condition to the posted journal items
+		of the company of the return within its period.`,
+		func(rs m.AccountTaxReturnSet, condition q.AccountMoveLineCondition) q.AccountMoveLineCondition {
+			if rs.Authority().IsNotEmpty() {
+				taxCond := q.AccountTax().JurisdictionFilteredOn(q.AccountTaxJurisdiction().Authority().Equals(rs.Authority()))
+				condition = condition.AndCond(q.AccountMoveLine().TaxLineFilteredOn(taxCond).Or().TaxesFilteredOn(taxCond))
+			}
+			return h.AccountMoveLine().NewSet(rs.Env()).
+				WithContext("date_from", rs.DateFrom()).
+				WithContext("date_to", rs.DateTo()).
+				WithContext("strict_range", true).
+				WithContext("state", "posted").
+				WithContext("company_id", rs.Company().ID()).
+				QueryGetCondition(condition)
+		})
+
+	h.AccountTaxReturn().Methods().ComputeAmounts().DeclareMethod(
+		`ComputeAmounts returns the amount of each box of the report for the period, by box code`,
+		func(rs m.AccountTaxReturnSet) map[string]float64 {
+			rs.Report().CheckFormulas()
+			amounts := make(map[string]float64)
+			formulas := make(map[string]*formula.Formula)
+			for _, box := range rs.Report().Boxes().Records() {
+				if box.BoxType() == "formula" {
+					formulas[box.Code()] = formula.MustParse(box.Formula())
+					continue
+				}
+				var amount float64
+				for _, line := range h.AccountMoveLine().Search(rs.Env(), rs.MoveLineCondition(box.MoveLineCondition())).Records() {
+					amount += line.Balance()
+				}
+				if box.InvertSign() {
+					amount = -amount
+				}
+				amounts[box.Code()] = rs.Company().Currency().Round(amount)
+			}
+			// Formulas have been checked for cycles, so this recursion terminates
+			var compute func(code string) float64
+			compute = func(code string) float64 {
+				if amount, ok := amounts[code]; ok {
+					return amount
+				}
+				f := formulas[code]
+				vars := make(map[string]interface{})
+				for _, v := range f.Variables() {
+					vars[v] = compute(v)
+				}
+				res, err := f.Eval(vars)
+				if err != nil {
+					panic(rs.T(`Error while computing box %s: %s`, code, err))
+				}
+				amounts[code] = rs.Company().Currency().Round(res)
+				return amounts[code]
+			}
+			for code := range formulas {
+				compute(code)
+			}
+			return amounts
+		})
+
+	h.AccountTaxReturn().Methods().ActionCompute().DeclareMethod(
+		`ActionCompute computes the boxes of the tax return and displays them`,
+		func(rs m.AccountTaxReturnSet) *actions.Action {
+			rs.EnsureOne()
+			if rs.DateTo().Lower(rs.DateFrom()) {
+				panic(rs.T(`The end date must be after the start date.`))
+			}
+			rs.Lines().Unlink()
+			amounts := rs.ComputeAmounts()
+			for _, box := range rs.Report().Boxes().Records() {
+				h.AccountTaxReturnLine().Create(rs.Env(), h.AccountTaxReturnLine().NewData().
+					SetReturn(rs).
+					SetBox(box).
+					SetAmount(amounts[box.Code()]))
+			}
+			return &actions.Action{
+				Name:     rs.T(`Tax Return`),
+				Type:     actions.ActionActWindow,
+				Model:    "AccountTaxReturn",
+				ViewMode: "form",
+				ResID:    rs.ID(),
+				Target:   "new",
+			}
+		})
+
+	h.AccountTaxReturn().Methods().TaxAccounts().DeclareMethod(
+		`TaxAccounts returns the accounts of the taxes of the company of the return,
+		including the accounts of their repartition lines`,
+		func(rs m.AccountTaxReturnSet) m.AccountAccountSet {
+			res := h.AccountAccount().NewSet(rs.Env())
+			taxes := h.AccountTax().NewSet(rs.Env()).WithContext("active_test", false).Search(
+				q.AccountTax().Company().Equals(rs.Company()))
+			for _, tax := range taxes.Records() {
+				res = res.Union(tax.Account()).Union(tax.RefundAccount()).
+					Union(tax.Repartitions().Account()).Union(tax.Repartitions().RefundAccount())
+			}
+			return res
+		})
+
+	h.AccountTaxReturn().Methods().ActionClosePeriod().DeclareMethod(
+		`ActionClosePeriod books the balances of all the tax accounts at the end of the period
+		into the tax payable or receivable account and locks the period for taxes.`,
+		func(rs m.AccountTaxReturnSet) *actions.Action {
+			rs.EnsureOne()
+			company := rs.Company()
+			if rs.Journal().IsEmpty() {
+				panic(rs.T(`You must select a journal for the tax closing entry.`))
+			}
+			if rs.TaxPayableAccount().IsEmpty() || rs.TaxReceivableAccount().IsEmpty() {
+				panic(rs.T(`You must set the tax payable and receivable accounts of the company to close a tax period.`))
+			}
+			if !company.TaxLockDate().IsZero() && company.TaxLockDate().GreaterEqual(rs.DateTo()) {
+				panic(rs.T(`The tax period ending on %s is already closed.`, rs.DateTo()))
+			}
+			taxAccounts := rs.TaxAccounts()
+			if h.AccountMoveLine().Search(rs.Env(),
+				q.AccountMoveLine().Account().In(taxAccounts).
+					And().Company().Equals(company).
+					And().Date().LowerOrEqual(rs.DateTo()).
+					And().MoveFilteredOn(q.AccountMove().State().NotEquals("posted"))).IsNotEmpty() {
+				panic(rs.T(`Some journal entries with taxes of the period are not posted yet.`))
+			}
+
+			balances := make(map[int64]float64)
+			lines := h.AccountMoveLine().Search(rs.Env(),
+				q.AccountMoveLine().Account().In(taxAccounts).
+					And().Company().Equals(company).
+					And().Date().LowerOrEqual(rs.DateTo()).
+					And().MoveFilteredOn(q.AccountMove().State().Equals("posted")))
+			for _, line := range lines.Records() {
+				balances[line.Account().ID()] += line.Balance()
+			}
+
+			currency := company.Currency()
+			ref := rs.T(`Tax closing %s - %s`, rs.DateFrom(), rs.DateTo())
+			data := h.AccountMove().NewData().
+				SetJournal(rs.Journal()).
+				SetDate(rs.DateTo()).
+				SetRef(ref)
+			var total float64
+			for _, account := range taxAccounts.Records() {
+				balance := currency.Round(balances[account.ID()])
+				if currency.IsZero(balance) {
+					continue
+				}
+				total += balance
+				data = data.CreateLines(h.AccountMoveLine().NewData().
+					SetName(ref).
+					SetAccount(account).
+					SetDebit(math.Max(-balance, 0)).
+					SetCredit(math.Max(balance, 0)))
+			}
+			if !data.HasLines() {
+				panic(rs.T(`There is no tax balance to close at %s.`, rs.DateTo()))
+			}
+			if !currency.IsZero(total) {
+				counterpart := rs.TaxPayableAccount()
+				if total > 0 {
+					counterpart = rs.TaxReceivableAccount()
+				}
+				data = data.CreateLines(h.AccountMoveLine().NewData().
+					SetName(ref).
+					SetAccount(counterpart).
+					SetDebit(math.Max(total, 0)).
+					SetCredit(math.Max(-total, 0)))
+			}
+			move := h.AccountMove().Create(rs.Env(), data)
+			move.Post()
+			company.SetTaxLockDate(rs.DateTo())
+			return &actions.Action{
+				Name:     rs.T(`Tax Closing Entry`),
+				Type:     actions.ActionActWindow,
+				Model:    "AccountMove",
+				ViewMode: "form",
+				ResID:    move.ID(),
+			}
+		})
+
+	h.AccountTaxReturnLine().DeclareTransientModel()
+	h.AccountTaxReturnLine().SetDefaultOrder("Sequence", "ID")
+
+	h.AccountTaxReturnLine().AddFields(map[string]models.FieldDefinition{
+		"Return": models.Many2OneField{
+			RelationModel: h.AccountTaxReturn(),
+			Required:      true,
+			OnDelete:      models.Cascade},
+		"Box": models.Many2OneField{
+			RelationModel: h.AccountTaxReportBox(),
+			Required:      true,
+			OnDelete:      models.Cascade},
+		"Sequence": models.IntegerField{
+			Related: "Box.Sequence"},
+		"Code": models.CharField{
+			Related: "Box.Code"},
+		"Name": models.CharField{
+			String:  "Label",
+			Related: "Box.Name"},
+		"BoxType": models.SelectionField{
+			String:  "Type",
+			Related: "Box.BoxType"},
+		"Amount": models.FloatField{
+			ReadOnly: true},
+	})
+
+	h.AccountTaxReturnLine().Methods().OpenMoveLines().DeclareMethod(
+		`OpenMoveLines opens the journal items reported in this box`,
+		func(rs m.AccountTaxReturnLineSet) *actions.Action {
+			rs.EnsureOne()
+			if rs.BoxType() == "formula" {
+				panic(rs.T(`The box %s is computed by a formula and has no journal items.`, rs.Code()))
+			}
+			lines := h.AccountMoveLine().Search(rs.Env(), rs.Return().MoveLineCondition(rs.Box().MoveLineCondition()))
+			return &actions.Action{
+				Name:     rs.T(`Journal Items of Box %s`, rs.Code()),
+				Type:     actions.ActionActWindow,
+				Model:    "AccountMoveLine",
+				ViewMode: "tree,form",
+				Domain:   fmt.Sprintf("[('id', 'in', %s)]", strings.Replace(fmt.Sprint(lines.Ids()), " ", ", ", -1)),
+			}
+		})
+
+}
@@ -0,0 +1,296 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package account
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/hexya-addons/account/accounttypes"
+	"github.com/hexya-erp/hexya/src/actions"
+	"github.com/hexya-erp/hexya/src/models"
+	"github.com/hexya-erp/hexya/src/models/types/dates"
+	"github.com/hexya-erp/hexya/src/tools/strutils"
+	"github.com/hexya-erp/pool/h"
+	"github.com/hexya-erp/pool/m"
+	"github.com/hexya-erp/pool/q"
+)
+
+func init() {
+
+	h.AccountTax().AddFields(map[string]models.FieldDefinition{
+		"Withholding": models.BooleanField{
+			String:     "Withholding Tax",
+			Constraint: h.AccountTax().Methods().CheckWithholding(),
+			Help: `If set, this tax is not applied on invoices but withheld when the invoice is paid.
+The tax percentage of the paid part of the invoice base is booked on the tax account,
+and only the net amount is paid to the partner.`},
+	})
+
+	h.AccountMoveLine().AddFields(map[string]models.FieldDefinition{
+		"WithholdingBase": models.FloatField{
+			String:   "Withheld Base",
+			ReadOnly: true,
+			Help:     "Base amount in company currency on which the tax of this withholding line has been computed"},
+	})
+
+	h.AccountPayment().AddFields(map[string]models.FieldDefinition{
+		"WithholdingAmount": models.FloatField{
+			String:  "Withheld Amount",
+			Compute: h.AccountPayment().Methods().ComputeWithholdingAmount(),
+			Depends: []string{"Invoices", "Amount", "Currency", "PaymentDate"},
+			Help:    "Amount of the withholding taxes of the paid invoices. Only the remaining amount is paid to the partner."},
+	})
+
+	h.AccountTax().Methods().CheckWithholding().DeclareMethod(
+		`CheckWithholding checks that withholding taxes are simple percentage taxes with a tax account`,
+		func(rs m.AccountTaxSet) {
+			for _, tax := range rs.Records() {
+				if !tax.Withholding() {
+					continue
+				}
+				if tax.AmountType() != "percent" || tax.PriceInclude() {
+					panic(rs.T(`The withholding tax %s must be a percentage of the price, not included in price.`, tax.Name()))
+				}
+				if tax.Account().IsEmpty() {
+					panic(rs.T(`The withholding tax %s must have a tax account.`, tax.Name()))
+				}
+			}
+		})
+
+	h.AccountPayment().Methods().WithholdingTaxes().DeclareMethod(
+		`WithholdingTaxes returns the withholding taxes of the invoices paid by this payment`,
+		func(rs m.AccountPaymentSet) m.AccountTaxSet {
+			taxes := h.AccountTax().NewSet(rs.Env())
+			for _, line := range rs.Invoices().InvoiceLines().Records() {
+				taxes = taxes.Union(line.InvoiceLineTaxes().Filtered(func(r m.AccountTaxSet) bool {
+					return r.Withholding()
+				}))
+			}
+			return taxes
+		})
+
+	h.AccountPayment().Methods().ComputeWithholdings().DeclareMethod(
+		`ComputeWithholdings returns the base and amount of each withholding tax for this payment,
+		expressed in the payment currency.
+
+		The base of a withholding tax is the untaxed amount of the invoice lines with this tax,
+		in proportion of the part of the invoices total paid by this payment.`,
+		func(rs m.AccountPaymentSet) []accounttypes.AppliedTaxData {
+			rs.EnsureOne()
+			taxes := rs.WithholdingTaxes()
+			if taxes.IsEmpty() {
+				return nil
+			}
+			currency := h.Currency().Coalesce(rs.Currency(), rs.Company().Currency())
+			var total float64
+			bases := make(map[int64]float64)
+			for _, inv := range rs.Invoices().Records() {
+				sign := 1.0
+				if strutils.IsIn(inv.Type(), "out_refund", "in_refund") {
+					sign = -1.0
+				}
+				invCurrency := inv.Currency().WithContext("date", rs.PaymentDate())
+				total += sign * invCurrency.Compute(inv.AmountTotal(), currency, false)
+				for _, line := range inv.InvoiceLines().Records() {
+					for _, tax := range line.InvoiceLineTaxes().Intersect(taxes).Records() {
+						bases[tax.ID()] += sign * invCurrency.Compute(line.PriceSubtotal(), currency, false)
+					}
+				}
+			}
+			if currency.IsZero(total) {
+				return nil
+			}
+			ratio := math.Min(math.Abs(rs.Amount()/total), 1)
+			var res []accounttypes.AppliedTaxData
+			for _, tax := range taxes.Records() {
+				base := currency.Round(math.Abs(bases[tax.ID()]) * ratio)
+				amount := currency.Round(base * tax.Amount() / 100)
+				if currency.IsZero(amount) {
+					continue
+				}
+				res = append(res, accounttypes.AppliedTaxData{
+					ID:        tax.ID(),
+					Name:      tax.Name(),
+					Amount:    amount,
+					Base:      base,
+					Sequence:  tax.Sequence(),
+					AccountID: tax.Account().ID(),
+				})
+			}
+			sort.Slice(res, func(i, j int) bool {
+				return res[i].Sequence < res[j].Sequence
+			})
+			return res
+		})
+
+	h.AccountPayment().Methods().ComputeWithholdingAmount().DeclareMethod(
+		`ComputeWithholdingAmount computes the total amount withheld from this payment`,
+		func(rs m.AccountPaymentSet) m.AccountPaymentData {
+			var amount float64
+			for _, wh := range rs.ComputeWithholdings() {
+				amount += wh.Amount
+			}
+			return h.AccountPayment().NewData().SetWithholdingAmount(amount)
+		})
+
+	h.AccountPayment().Methods().CreateWithholdingLines().DeclareMethod(
+		`CreateWithholdingLines creates in the given payment move the lines of the taxes withheld
+		from this payment. amount is the signed payment amount as given to CreatePaymentEntry.
+
+		It returns the created lines and the total withheld amount, signed as amount.`,
+		func(rs m.AccountPaymentSet, amount float64, move m.AccountMoveSet, invoiceCurrency m.CurrencySet) (m.AccountMoveLineSet, float64) {
+			env := rs.Env()
+			amlObj := h.AccountMoveLine().NewSet(env).WithContext("check_move_validity", false)
+			lines := h.AccountMoveLine().NewSet(env)
+			var withheld float64
+			for _, wh := range rs.ComputeWithholdings() {
+				whAmount := math.Copysign(wh.Amount, amount)
+				debit, credit, amountCurrency, currency := amlObj.WithContext("date", rs.PaymentDate()).ComputeAmountFields(whAmount, rs.Currency(), rs.Company().Currency(), invoiceCurrency)
+				base := rs.Currency().WithContext("date", rs.PaymentDate()).Compute(wh.Base, rs.Company().Currency(), true)
+				data := rs.GetSharedMoveLineVals(credit, debit, -amountCurrency, move, h.AccountInvoice().NewSet(env)).
+					SetName(wh.Name).
+					SetAccount(h.AccountAccount().BrowseOne(env, wh.AccountID)).
+					SetTaxLine(h.AccountTax().BrowseOne(env, wh.ID)).
+					SetTaxExigible(true).
+					SetWithholdingBase(math.Copysign(base, amount)).
+					SetCurrency(currency).
+					SetPayment(rs).
+					SetJournal(rs.Journal())
+				lines = lines.Union(amlObj.Create(data))
+				withheld += whAmount
+			}
+			return lines, withheld
+		})
+
+	h.AccountWithholdingCertificate().DeclareTransientModel()
+	h.AccountWithholdingCertificate().AddFields(map[string]models.FieldDefinition{
+		"Company": models.Many2OneField{
+			RelationModel: h.Company(),
+			Required:      true,
+			Default: func(env models.Environment) interface{} {
+				return h.User().NewSet(env).CurrentUser().Company()
+			}},
+		"Partner": models.Many2OneField{
+			String:        "Vendor",
+			RelationModel: h.Partner(),
+			Help:          "Leave empty to get the certificates of all vendors"},
+		"DateFrom": models.DateField{
+			String:   "Start Date",
+			Required: true,
+			Default: func(env models.Environment) interface{} {
+				return dates.Today().StartOfMonth().AddDate(0, -1, 0)
+			}},
+		"DateTo": models.DateField{
+			String:   "End Date",
+			Required: true,
+			Default: func(env models.Environment) interface{} {
+				return dates.Today().StartOfMonth().AddDate(0, 0, -1)
+			}},
+		"Lines": models.One2ManyField{
+			RelationModel: h.AccountWithholdingCertificateLine(),
+			ReverseFK:     "Certificate",
+			JSON:          "line_ids",
+			ReadOnly:      true},
+	})
+
+	h.AccountWithholdingCertificate().Methods().MoveLineCondition().DeclareMethod(
+		`MoveLineCondition returns the condition on the posted withholding lines of vendor payments
+		of the period of this certificate`,
+		func(rs m.AccountWithholdingCertificateSet) q.AccountMoveLineCondition {
+			taxCond := q.AccountTax().Withholding().Equals(true).And().TypeTaxUse().Equals("purchase")
+			cond := q.AccountMoveLine().Company().Equals(rs.Company()).
+				And().Date().GreaterOrEqual(rs.DateFrom()).
+				And().Date().LowerOrEqual(rs.DateTo()).
+				And().MoveFilteredOn(q.AccountMove().State().Equals("posted")).
+				And().TaxLineFilteredOn(taxCond)
+			if rs.Partner().IsNotEmpty() {
+				cond = cond.And().Partner().Equals(rs.Partner().CommercialPartner())
+			}
+			return cond
+		})
+
+	h.AccountWithholdingCertificate().Methods().ActionCompute().DeclareMethod(
+		`ActionCompute computes the amounts withheld from each vendor during the period and displays them`,
+		func(rs m.AccountWithholdingCertificateSet) *actions.Action {
+			rs.EnsureOne()
+			if rs.DateTo().Lower(rs.DateFrom()) {
+				panic(rs.T(`The end date must be after the start date.`))
+			}
+			rs.Lines().Unlink()
+			type certificateKey struct {
+				partner int64
+				tax     int64
+			}
+			var keys []certificateKey
+			lines := make(map[certificateKey]m.AccountWithholdingCertificateLineData)
+			for _, aml := range h.AccountMoveLine().Search(rs.Env(), rs.MoveLineCondition()).Records() {
+				key := certificateKey{partner: aml.Partner().ID(), tax: aml.TaxLine().ID()}
+				data, ok := lines[key]
+				if !ok {
+					data = h.AccountWithholdingCertificateLine().NewData().
+						SetCertificate(rs).
+						SetPartner(aml.Partner()).
+						SetTax(aml.TaxLine())
+					lines[key] = data
+					keys = append(keys, key)
+				}
+				data.SetBase(data.Base() + aml.WithholdingBase())
+				data.SetAmount(data.Amount() + aml.Credit() - aml.Debit())
+			}
+			for _, key := range keys {
+				h.AccountWithholdingCertificateLine().Create(rs.Env(), lines[key])
+			}
+			return &actions.Action{
+				Name:     rs.T(`Withholding Certificates`),
+				Type:     actions.ActionActWindow,
+				Model:    "AccountWithholdingCertificate",
+				ViewMode: "form",
+				ResID:    rs.ID(),
+				Target:   "new",
+			}
+		})
+
+	h.AccountWithholdingCertificateLine().DeclareTransientModel()
+	h.AccountWithholdingCertificateLine().SetDefaultOrder("Partner", "Tax")
+
+	h.AccountWithholdingCertificateLine().AddFields(map[string]models.FieldDefinition{
+		"Certificate": models.Many2OneField{
+			RelationModel: h.AccountWithholdingCertificate(),
+			Required:      true,
+			OnDelete:      models.Cascade},
+		"Partner": models.Many2OneField{
+			String:        "Vendor",
+			RelationModel: h.Partner(),
+			ReadOnly:      true},
+		"Tax": models.Many2OneField{
+			String:        "Withholding Tax",
+			RelationModel: h.AccountTax(),
+			ReadOnly:      true},
+		"Base": models.FloatField{
+			ReadOnly: true},
+		"Amount": models.FloatField{
+			String:   "Withheld Amount",
+			ReadOnly: true},
+	})
+
+	h.AccountWithholdingCertificateLine().Methods().OpenMoveLines().DeclareMethod(
+		`OpenMoveLines opens the withholding journal items of this certificate line`,
+		func(rs m.AccountWithholdingCertificateLineSet) *actions.Action {
+			rs.EnsureOne()
+			lines := h.AccountMoveLine().Search(rs.Env(), rs.Certificate().MoveLineCondition().
+				And().Partner().Equals(rs.Partner()).
+				And().TaxLine().Equals(rs.Tax()))
+			return &actions.Action{
+				Name:     rs.T(`Withholdings of %s`, rs.Partner().Name()),
+				Type:     actions.ActionActWindow,
+				Model:    "AccountMoveLine",
+				ViewMode: "tree,form",
+				Domain:   fmt.Sprintf("[('id', 'in', %s)]", strings.Replace(fmt.Sprint(lines.Ids()), " ", ", ", -1)),
+			}
+		})
+
+}
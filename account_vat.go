@@ -0,0 +1,110 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package account
+
+import (
+	"github.com/hexya-addons/account/vat"
+	"github.com/hexya-erp/hexya/src/models"
+	"github.com/hexya-erp/hexya/src/models/types"
+	"github.com/hexya-erp/pool/h"
+	"github.com/hexya-erp/pool/m"
+)
+
+func init() {
+
+	h.Company().AddFields(map[string]models.FieldDefinition{
+		"VATCheck": models.SelectionField{
+			String: "VAT Number Check",
+			Selection: types.Selection{
+				"none":    "No Check",
+				"warning": "Warning",
+				"block":   "Block"},
+			Default:  models.DefaultValue("warning"),
+			Required: true,
+			Help: `Offline validation of the syntax and check digits of partners VAT numbers.
+- Warning: invalid numbers are flagged on partners and invoices.
+- Block: partners with an invalid number cannot be saved and their invoices cannot be validated.`},
+	})
+
+	h.Partner().AddFields(map[string]models.FieldDefinition{
+		"VATInvalid": models.BooleanField{
+			String:  "Invalid VAT Number",
+			Compute: h.Partner().Methods().ComputeVATInvalid(),
+			Depends: []string{"VAT", "Country"},
+			Help:    "Set if the VAT number of this partner does not pass the offline check of its country"},
+	})
+
+	h.Partner().Fields().VAT().SetConstraint(h.Partner().Methods().CheckVATNumber())
+	h.Partner().Fields().Country().SetConstraint(h.Partner().Methods().CheckVATNumber())
+
+	h.AccountInvoice().AddFields(map[string]models.FieldDefinition{
+		"PartnerVATInvalid": models.BooleanField{
+			String:  "Invalid Partner VAT Number",
+			Related: "CommercialPartner.VATInvalid"},
+	})
+
+	h.Partner().Methods().VATIsValid().DeclareMethod(
+		`VATIsValid returns true if this partner has a VAT number which passes the offline check.
+
+		Numbers of countries which are not supported by the offline check are considered valid.
+		The partner's country is used for numbers without country prefix.`,
+		func(rs m.PartnerSet) bool {
+			if rs.VAT() == "" {
+				return false
+			}
+			err := vat.Check(rs.VAT(), rs.Country().Code())
+			return err == nil || err == vat.ErrUnsupportedCountry
+		})
+
+	h.Partner().Methods().ComputeVATInvalid().DeclareMethod(
+		`ComputeVATInvalid flags partners with a VAT number that fails the offline check`,
+		func(rs m.PartnerSet) m.PartnerData {
+			if rs.VAT() == "" || rs.VATCheckMode() == "none" {
+				return h.Partner().NewData().SetVATInvalid(false)
+			}
+			return h.Partner().NewData().SetVATInvalid(!rs.VATIsValid())
+		})
+
+	h.Partner().Methods().VATCheckMode().DeclareMethod(
+		`VATCheckMode returns the VAT check mode of this partner's company,
+		or of the current user's company if the partner is shared.`,
+		func(rs m.PartnerSet) string {
+			company := rs.Company()
+			if company.IsEmpty() {
+				company = h.User().NewSet(rs.Env()).CurrentUser().Company()
+			}
+			return company.VATCheck()
+		})
+
+	h.Partner().Methods().CheckVATNumber().DeclareMethod(
+		`CheckVATNumber panics if the VAT number of one of these partners is invalid
+		and their company blocks invalid VAT numbers.`,
+		func(rs m.PartnerSet) {
+			for _, partner := range rs.Records() {
+				if partner.VAT() == "" || partner.VATCheckMode() != "block" {
+					continue
+				}
+				if !partner.VATIsValid() {
+					panic(rs.T(`The VAT number %s of %s is not valid.`, partner.VAT(), partner.DisplayName()))
+				}
+			}
+		})
+
+	h.AccountInvoice().Methods().CheckPartnerVAT().DeclareMethod(
+		`CheckPartnerVAT panics if the commercial partner of one of these invoices has an
+		invalid VAT number and the company of the invoice blocks invalid VAT numbers.`,
+		func(rs m.AccountInvoiceSet) {
+			for _, invoice := range rs.Records() {
+				partner := invoice.CommercialPartner()
+				if partner.VAT() == "" || invoice.Company().VATCheck() != "block" {
+					continue
+				}
+				if !partner.VATIsValid() {
+					panic(rs.T(`The VAT number %s of %s is not valid. Please correct it before validating the invoice.`,
+						partner.VAT(), partner.DisplayName()))
+				}
+			}
+		})
+
+}
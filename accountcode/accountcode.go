@@ -0,0 +1,122 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+// Package accountcode computes the new codes of accounts when the number of
+// digits of account codes or the prefix of bank and cash accounts changes,
+// and detects the changes that would leave codes inconsistent.
+package accountcode
+
+import (
+	"sort"
+	"strings"
+)
+
+// Resize returns the given code padded on the right with zeros to the given
+// number of digits. Trailing zeros are removed first so that a code can be
+// shortened, but significant digits are never truncated.
+func Resize(code string, digits int) string {
+	code = strings.TrimRight(code, "0")
+	if len(code) >= digits {
+		return code
+	}
+	return code + strings.Repeat("0", digits-len(code))
+}
+
+// Reprefix replaces the old prefix of the given code by the new prefix and
+// pads the remaining part on the left with zeros so that the result has the
+// given number of digits.
+func Reprefix(code, oldPrefix, newPrefix string, digits int) string {
+	rest := strings.TrimLeft(strings.TrimPrefix(code, oldPrefix), "0")
+	if pad := digits - len(newPrefix) - len(rest); pad > 0 {
+		rest = strings.Repeat("0", pad) + rest
+	}
+	return newPrefix + rest
+}
+
+// A Change is the change of code of a record
+type Change struct {
+	ID  int64
+	Old string
+	New string
+}
+
+// An Issue describes why a change cannot be applied
+type Issue int
+
+// Issues of a change
+const (
+	// NoIssue means the change can be applied
+	NoIssue Issue = iota
+	// Collision means another record would get the same code
+	Collision
+	// TooLong means the code has more significant digits than allowed
+	TooLong
+)
+
+// Check returns the issues of the given changes, indexed by record ID.
+// unchanged lists the codes of the records which are not changed, so that
+// collisions with them are detected. digits is the expected number of digits
+// of the new codes, or 0 if the number of digits is not checked.
+func Check(changes []Change, unchanged []string, digits int) map[int64]Issue {
+	res := make(map[int64]Issue)
+	count := make(map[string]int)
+	for _, code := range unchanged {
+		count[code]++
+	}
+	for _, c := range changes {
+		count[c.New]++
+	}
+	for _, c := range changes {
+		switch {
+		case count[c.New] > 1:
+			res[c.ID] = Collision
+		case digits > 0 && len(c.New) > digits:
+			res[c.ID] = TooLong
+		}
+	}
+	return res
+}
+
+// Order returns the given changes in an order in which they can be applied
+// one at a time without two records having the same code in between.
+//
+// The second result lists the changes which are part of a cycle of codes.
+// They must be given a temporary code before applying the ordered changes,
+// and be applied last.
+func Order(changes []Change) ([]Change, []Change) {
+	byOld := make(map[string]Change)
+	for _, c := range changes {
+		byOld[c.Old] = c
+	}
+	var ordered, cyclic []Change
+	done := make(map[int64]bool)
+	var visit func(c Change, path map[int64]bool)
+	visit = func(c Change, path map[int64]bool) {
+		if done[c.ID] {
+			return
+		}
+		if path[c.ID] {
+			cyclic = append(cyclic, c)
+			done[c.ID] = true
+			return
+		}
+		path[c.ID] = true
+		// The record currently holding our new code must move first
+		if next, ok := byOld[c.New]; ok && next.ID != c.ID {
+			visit(next, path)
+		}
+		if !done[c.ID] {
+			done[c.ID] = true
+			ordered = append(ordered, c)
+		}
+	}
+	sorted := make([]Change, len(changes))
+	copy(sorted, changes)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Old < sorted[j].Old
+	})
+	for _, c := range sorted {
+		visit(c, make(map[int64]bool))
+	}
+	return ordered, cyclic
+}
@@ -0,0 +1,57 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package accountcode
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestAccountCode(t *testing.T) {
+	Convey("Testing account code changes", t, func() {
+		Convey("Codes are resized on the right", func() {
+			So(Resize("101000", 8), ShouldEqual, "10100000")
+			So(Resize("10100000", 6), ShouldEqual, "101000")
+			So(Resize("101200", 4), ShouldEqual, "1012")
+			So(Resize("101234", 4), ShouldEqual, "101234")
+		})
+		Convey("Prefixes are replaced and padded on the left", func() {
+			So(Reprefix("512001", "512", "5121", 6), ShouldEqual, "512101")
+			So(Reprefix("512001", "512", "512", 8), ShouldEqual, "51200001")
+			So(Reprefix("53001", "53", "570", 6), ShouldEqual, "570001")
+		})
+		Convey("Collisions and long codes are detected", func() {
+			changes := []Change{
+				{ID: 1, Old: "101", New: "101000"},
+				{ID: 2, Old: "1010", New: "101000"},
+				{ID: 3, Old: "2", New: "200000"},
+				{ID: 4, Old: "4011234", New: "4011234"},
+			}
+			issues := Check(changes, []string{"200000"}, 6)
+			So(issues[1], ShouldEqual, Collision)
+			So(issues[2], ShouldEqual, Collision)
+			So(issues[3], ShouldEqual, Collision)
+			So(issues[4], ShouldEqual, TooLong)
+			So(Check(changes[3:], nil, 0), ShouldBeEmpty)
+		})
+		Convey("Changes are ordered to avoid temporary duplicates", func() {
+			ordered, cyclic := Order([]Change{
+				{ID: 1, Old: "1", New: "2"},
+				{ID: 2, Old: "2", New: "3"},
+			})
+			So(cyclic, ShouldBeEmpty)
+			So(ordered, ShouldHaveLength, 2)
+			So(ordered[0].ID, ShouldEqual, 2)
+			So(ordered[1].ID, ShouldEqual, 1)
+			ordered, cyclic = Order([]Change{
+				{ID: 1, Old: "1", New: "2"},
+				{ID: 2, Old: "2", New: "1"},
+			})
+			So(cyclic, ShouldHaveLength, 1)
+			So(ordered, ShouldHaveLength, 1)
+			So(cyclic[0].ID, ShouldNotEqual, ordered[0].ID)
+		})
+	})
+}
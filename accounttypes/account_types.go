@@ -4,6 +4,7 @@
 package accounttypes
 
 import (
+	"github.com/hexya-erp/hexya/src/models/types"
 	"github.com/hexya-erp/hexya/src/models/types/dates"
 )
 
@@ -29,14 +30,15 @@ type DataForReconciliationWidget struct {
 
 // An AppliedTaxData is the result of the computation of applying a tax on an amount.
 type AppliedTaxData struct {
-	ID              int64   `json:"id"`
-	Name            string  `json:"name"`
-	Amount          float64 `json:"amount"`
-	Sequence        int     `json:"sequence"`
-	AccountID       int64   `json:"account_id"`
-	RefundAccountID int64   `json:"refund_account_id"`
-	Analytic        bool    `json:"analytic"`
-	Base            float64 `json:"base"`
+	ID                int64   `json:"id"`
+	Name              string  `json:"name"`
+	Amount            float64 `json:"amount"`
+	Sequence          int     `json:"sequence"`
+	AccountID         int64   `json:"account_id"`
+	RefundAccountID   int64   `json:"refund_account_id"`
+	Analytic          bool    `json:"analytic"`
+	Base              float64 `json:"base"`
+	RepartitionLineID int64   `json:"repartition_line_id"`
 }
 
 type TransRecGetStruct struct {
@@ -67,25 +69,26 @@ type BankStatementAMLStruct struct {
 // InvoiceLineAMLStruct is a temporary struct for holding AccountMoveLine
 // data during invoice validation.
 type InvoiceLineAMLStruct struct {
-	InvoiceLineID     int64
-	InvoiceLineTaxID  int64
-	TaxLineID         int64
-	Type              string
-	Name              string
-	PriceUnit         float64
-	Quantity          float64
-	Price             float64
-	AmountCurrency    float64
-	AccountID         int64
-	ProductID         int64
-	UomID             int64
-	AccountAnalyticID int64
-	CurrencyID        int64
-	TaxIDs            []int64
-	InvoiceID         int64
-	AnalyticTagsIDs   []int64
-	AnalyticLinesIDs  []int64
-	DateMaturity      dates.Date
+	InvoiceLineID        int64
+	InvoiceLineTaxID     int64
+	TaxLineID            int64
+	TaxRepartitionLineID int64
+	Type                 string
+	Name                 string
+	PriceUnit            float64
+	Quantity             float64
+	Price                float64
+	AmountCurrency       float64
+	AccountID            int64
+	ProductID            int64
+	UomID                int64
+	AccountAnalyticID    int64
+	CurrencyID           int64
+	TaxIDs               []int64
+	InvoiceID            int64
+	AnalyticTagsIDs      []int64
+	AnalyticLinesIDs     []int64
+	DateMaturity         dates.Date
 }
 
 // AgedBalanceReportValues holds data to render the aged partner balance report
@@ -111,3 +114,97 @@ type AgedBalancePeriod struct {
 	Start dates.Date
 	Stop  dates.Date
 }
+
+// AccountReportLine holds data of an account or of an account group in the
+// trial balance and general ledger reports. Group lines have a GroupID and
+// hold the subtotals of the accounts of the group.
+type AccountReportLine struct {
+	AccountID int64
+	GroupID   int64
+	Code      string
+	Name      string
+	Level     int
+	Debit     float64
+	Credit    float64
+	Balance   float64
+	MoveLines []LedgerMoveLine
+}
+
+// LedgerMoveLine holds data of a journal item in the general ledger report
+type LedgerMoveLine struct {
+	LineID         int64
+	Date           dates.Date
+	JournalCode    string
+	Ref            string
+	Name           string
+	MoveName       string
+	PartnerName    string
+	CurrencyCode   string
+	AmountCurrency float64
+	Debit          float64
+	Credit         float64
+	Balance        float64
+}
+
+// ReportForm holds the options chosen in an accounting report wizard
+type ReportForm struct {
+	CompanyID         int64
+	JournalIDs        []int64
+	DateFrom          dates.Date
+	DateTo            dates.Date
+	TargetMove        string
+	DisplayAccount    string
+	Hierarchy         bool
+	InitialBalance    bool
+	SortBy            string
+	ResultSelection   string
+	PeriodLength      int
+	Periods           map[string]AgedBalancePeriod
+	AccountReportID   int64
+	EnableFilter      bool
+	DebitCredit       bool
+	LabelFilter       string
+	UsedContext       *types.Context
+	ComparisonContext *types.Context
+}
+
+// ReportData holds the data passed by an accounting report wizard to its report.
+// Model and IDs are the active model and records of the wizard.
+type ReportData struct {
+	Model string
+	IDs   []int64
+	Form  ReportForm
+}
+
+// ReportValues holds the values with which an accounting report is rendered
+type ReportValues struct {
+	Data           ReportData
+	Accounts       []AccountReportLine
+	JournalCodes   []string
+	FinancialLines []FinancialReportLine
+	Partners       []AgedBalanceReportValues
+	Totals         []float64
+}
+
+// ReportBalance holds the debit, credit and balance of an account or of a
+// financial report line. Accounts holds the balances of the accounts of the line.
+type ReportBalance struct {
+	Debit       float64
+	Credit      float64
+	Balance     float64
+	CompBalance float64
+	Accounts    map[int64]ReportBalance
+}
+
+// FinancialReportLine holds data of a line of a financial report.
+// Type is 'report' for the lines of the financial report and 'account' for their accounts.
+type FinancialReportLine struct {
+	Name        string
+	Type        string
+	Level       int
+	AccountType string
+	Debit       float64
+	Credit      float64
+	Balance     float64
+	BalanceCmp  float64
+}
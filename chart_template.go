@@ -469,7 +469,8 @@ defined on this template is complete`},
 				SetNote(accountTemplate.Note()).
 				SetTags(accountTemplate.Tags()).
 				SetTaxes(h.AccountTax().Browse(rs.Env(), taxIds)).
-				SetCompany(company)
+				SetCompany(company).
+				SetAccountTemplate(accountTemplate)
 
 			if accountTemplate.Currency().IsNotEmpty() {
 				data.SetCurrency(accountTemplate.Currency())
@@ -583,35 +584,12 @@ defined on this template is complete`},
 			      :returns: True`,
 		func(rs m.AccountChartTemplateSet, taxTemplateRef, accTemplateRef map[int64]int64, company m.CompanySet) bool {
 			var positions m.AccountFiscalPositionTemplateSet
-			var newFp m.AccountFiscalPositionSet
-			var taxData m.AccountFiscalPositionTaxData
-			var accountData m.AccountFiscalPositionAccountData
 
 			rs.EnsureOne()
 			positions = h.AccountFiscalPositionTemplate().Search(rs.Env(),
 				q.AccountFiscalPositionTemplate().ChartTemplate().Equals(rs))
 			for _, position := range positions.Records() {
-				newFp = h.AccountFiscalPosition().Create(rs.Env(), h.AccountFiscalPosition().NewData().
-					SetCompany(company).
-					SetName(position.Name()).
-					SetNote(position.Note()).
-					SetHexyaExternalID(fmt.Sprintf("%d_%s", company.ID(), position.HexyaExternalID())))
-				for _, tax := range position.Taxes().Records() {
-					taxData = h.AccountFiscalPositionTax().NewData().
-						SetTaxSrc(h.AccountTax().BrowseOne(rs.Env(), taxTemplateRef[tax.TaxSrc().ID()])).
-						SetPosition(newFp)
-					if tax.TaxDest().IsNotEmpty() {
-						taxData.SetTaxDest(h.AccountTax().BrowseOne(rs.Env(), taxTemplateRef[tax.TaxDest().ID()]))
-					}
-					h.AccountFiscalPositionTax().Create(rs.Env(), taxData.SetHexyaExternalID(fmt.Sprintf("%d_%s", company.ID(), tax.HexyaExternalID())))
-				}
-				for _, acc := range position.Accounts().Records() {
-					accountData = h.AccountFiscalPositionAccount().NewData().
-						SetAccountSrc(h.AccountAccount().BrowseOne(rs.Env(), accTemplateRef[acc.AccountSrc().ID()])).
-						SetAccountDest(h.AccountAccount().BrowseOne(rs.Env(), accTemplateRef[acc.AccountDest().ID()])).
-						SetPosition(newFp)
-					h.AccountFiscalPositionAccount().Create(rs.Env(), accountData.SetHexyaExternalID(fmt.Sprintf("%d_%s", company.ID(), acc.HexyaExternalID())))
-				}
+				position.GenerateFiscalPosition(taxTemplateRef, accTemplateRef, company)
 			}
 			return true
 		})
@@ -735,7 +713,8 @@ the same analytic account as the invoice line (if any)`},
 				SetIncludeBaseAmount(rs.IncludeBaseAmount()).
 				SetAnalytic(rs.Analytic()).
 				SetTags(rs.Tags()).
-				SetTaxAdjustment(rs.TaxAdjustment())
+				SetTaxAdjustment(rs.TaxAdjustment()).
+				SetTaxTemplate(rs)
 			if rs.TaxGroup().IsNotEmpty() {
 				data.SetTaxGroup(rs.TaxGroup())
 			}
@@ -805,6 +784,48 @@ the same analytic account as the invoice line (if any)`},
 			String: "Notes"},
 	})
 
+	h.AccountFiscalPositionTemplate().Methods().GenerateFiscalPosition().DeclareMethod(
+		`GenerateFiscalPosition creates the fiscal position of the given company from this template,
+		with its account and tax mappings.`,
+		func(rs m.AccountFiscalPositionTemplateSet, taxTemplateRef, accTemplateRef map[int64]int64, company m.CompanySet) m.AccountFiscalPositionSet {
+			rs.EnsureOne()
+			newFp := h.AccountFiscalPosition().Create(rs.Env(), h.AccountFiscalPosition().NewData().
+				SetCompany(company).
+				SetName(rs.Name()).
+				SetNote(rs.Note()).
+				SetFiscalPositionTemplate(rs).
+				SetHexyaExternalID(fmt.Sprintf("%d_%s", company.ID(), rs.HexyaExternalID())))
+			rs.GenerateMappings(newFp, taxTemplateRef, accTemplateRef, company)
+			return newFp
+		})
+
+	h.AccountFiscalPositionTemplate().Methods().GenerateMappings().DeclareMethod(
+		`GenerateMappings creates the account and tax mappings of this template in the given fiscal position`,
+		func(rs m.AccountFiscalPositionTemplateSet, position m.AccountFiscalPositionSet, taxTemplateRef, accTemplateRef map[int64]int64,
+			company m.CompanySet) {
+
+			var taxData m.AccountFiscalPositionTaxData
+			var accountData m.AccountFiscalPositionAccountData
+
+			rs.EnsureOne()
+			for _, tax := range rs.Taxes().Records() {
+				taxData = h.AccountFiscalPositionTax().NewData().
+					SetTaxSrc(h.AccountTax().BrowseOne(rs.Env(), taxTemplateRef[tax.TaxSrc().ID()])).
+					SetPosition(position)
+				if tax.TaxDest().IsNotEmpty() {
+					taxData.SetTaxDest(h.AccountTax().BrowseOne(rs.Env(), taxTemplateRef[tax.TaxDest().ID()]))
+				}
+				h.AccountFiscalPositionTax().Create(rs.Env(), taxData.SetHexyaExternalID(fmt.Sprintf("%d_%s", company.ID(), tax.HexyaExternalID())))
+			}
+			for _, acc := range rs.Accounts().Records() {
+				accountData = h.AccountFiscalPositionAccount().NewData().
+					SetAccountSrc(h.AccountAccount().BrowseOne(rs.Env(), accTemplateRef[acc.AccountSrc().ID()])).
+					SetAccountDest(h.AccountAccount().BrowseOne(rs.Env(), accTemplateRef[acc.AccountDest().ID()])).
+					SetPosition(position)
+				h.AccountFiscalPositionAccount().Create(rs.Env(), accountData.SetHexyaExternalID(fmt.Sprintf("%d_%s", company.ID(), acc.HexyaExternalID())))
+			}
+		})
+
 	h.AccountFiscalPositionTaxTemplate().DeclareModel()
 
 	h.AccountFiscalPositionTaxTemplate().AddFields(map[string]models.FieldDefinition{
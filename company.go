@@ -1,10 +1,12 @@
 package account
 
 import (
+	"fmt"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/hexya-addons/account/accountcode"
 	"github.com/hexya-erp/hexya/src/models"
 	"github.com/hexya-erp/hexya/src/models/types"
 	"github.com/hexya-erp/hexya/src/models/types/dates"
@@ -43,6 +45,10 @@ Use it for period locking inside an open fiscal year, for example.`},
 			String: "Lock Date",
 			Help: `No users, including Advisers, can edit accounts prior to and inclusive of this date.
 Use it for fiscal year locking for example.`},
+		"TaxLockDate": models.DateField{
+			String: "Tax Lock Date",
+			Help: `No users, including Advisers, can add or modify entries with taxes prior to and inclusive
+of this date. It is set when closing a tax return period.`},
 		"TransferAccount": models.Many2OneField{
 			String:        "Inter-Banks Transfer Account",
 			RelationModel: h.AccountAccount(),
@@ -158,40 +164,150 @@ Best Regards,`)},
 		})
 
 	h.Company().Methods().GetNewAccountCode().DeclareMethod(
-		`GetNewAccountCode`,
+		`GetNewAccountCode returns the given code with its old prefix replaced by the new prefix,
+		padded with zeros after the prefix to the given number of digits.`,
 		func(rs m.CompanySet, currentCode, oldPrefix, newPrefix string, digits int) string {
-			code := strings.Replace(currentCode, oldPrefix, "", 1)
-			code = strings.TrimLeft(code, "0")
-			// FIXME: normalement tu devrais pouvoir le gérer avec un simple Sprintf
-			//code = strutils.RightJustify(code, digits-len(newPrefix), "0")
-			return newPrefix + code
+			return accountcode.Reprefix(currentCode, oldPrefix, newPrefix, digits)
 		})
 
-	h.Company().Methods().ReflectCodePrefixChange().DeclareMethod(
-		`ReflectCodePrefixChange`,
-		func(rs m.CompanySet, oldCode, newCode string, digits int) {
-			accounts := h.AccountAccount().Search(rs.Env(),
-				q.AccountAccount().Code().Contains(oldCode).
-					And().InternalType().Equals("liquidity").
-					And().Company().Equals(rs)).OrderBy("code asc")
+	h.Company().Methods().LiquidityAccounts().DeclareMethod(
+		`LiquidityAccounts returns the liquidity accounts of this company and the default accounts
+		of its bank and cash journals, whose codes start with the bank or cash prefix.`,
+		func(rs m.CompanySet) m.AccountAccountSet {
+			res := h.AccountAccount().Search(rs.Env(),
+				q.AccountAccount().InternalType().Equals("liquidity").And().Company().Equals(rs))
+			journals := h.AccountJournal().Search(rs.Env(),
+				q.AccountJournal().Type().In([]string{"bank", "cash"}).And().Company().Equals(rs))
+			for _, journal := range journals.Records() {
+				res = res.Union(journal.DefaultDebitAccount()).Union(journal.DefaultCreditAccount())
+			}
+			return res
+		})
+
+	h.Company().Methods().CodeChanges().DeclareMethod(
+		`CodeChanges returns the code changes of the accounts of this company if its number of digits
+		and its bank and cash prefixes were set to the given values.
 
+		The prefix of liquidity accounts is replaced and the rest of their code is padded on the left,
+		then all codes are padded or shortened on the right to the number of digits. Codes which keep
+		more significant digits than the number of digits are returned unchanged so that they are reported.`,
+		func(rs m.CompanySet, digits int, bankPrefix, cashPrefix string) []accountcode.Change {
+			rs.EnsureOne()
+			digitsChanged := digits != 0 && digits != int(rs.AccountsCodeDigits())
+			prefixes := [][2]string{
+				{rs.BankAccountCodePrefix(), bankPrefix},
+				{rs.CashAccountCodePrefix(), cashPrefix},
+			}
+			liquidityIds := make(map[int64]bool)
+			for _, id := range rs.LiquidityAccounts().Ids() {
+				liquidityIds[id] = true
+			}
+			var res []accountcode.Change
+			accounts := h.AccountAccount().Search(rs.Env(), q.AccountAccount().Company().Equals(rs)).OrderBy("code asc")
 			for _, account := range accounts.Records() {
-				if strings.HasPrefix(account.Code(), oldCode) {
-					account.SetCode(rs.GetNewAccountCode(account.Code(), oldCode, newCode, digits))
+				code := account.Code()
+				newCode := code
+				if liquidityIds[account.ID()] {
+					var oldPrefix, newPrefix string
+					for _, p := range prefixes {
+						if p[0] != "" && strings.HasPrefix(code, p[0]) && len(p[0]) > len(oldPrefix) {
+							oldPrefix, newPrefix = p[0], p[1]
+						}
+					}
+					if oldPrefix != "" && newPrefix != "" && (oldPrefix != newPrefix || digitsChanged) {
+						padding := digits
+						if padding == 0 {
+							padding = len(code)
+						}
+						newCode = accountcode.Reprefix(code, oldPrefix, newPrefix, padding)
+					}
+				}
+				if digitsChanged {
+					newCode = accountcode.Resize(newCode, digits)
+				}
+				if newCode != code || (digitsChanged && len(newCode) > digits) {
+					res = append(res, accountcode.Change{ID: account.ID(), Old: code, New: newCode})
+				}
+			}
+			return res
+		})
+
+	h.Company().Methods().CheckCodeChanges().DeclareMethod(
+		`CheckCodeChanges returns the issues of the given code changes of the accounts of this company,
+		indexed by account ID. If digits is not 0, codes longer than digits are reported.`,
+		func(rs m.CompanySet, changes []accountcode.Change, digits int) map[int64]accountcode.Issue {
+			changed := make(map[int64]bool)
+			for _, c := range changes {
+				changed[c.ID] = true
+			}
+			var unchanged []string
+			for _, account := range h.AccountAccount().Search(rs.Env(), q.AccountAccount().Company().Equals(rs)).Records() {
+				if !changed[account.ID()] {
+					unchanged = append(unchanged, account.Code())
 				}
 			}
+			return accountcode.Check(changes, unchanged, digits)
+		})
+
+	h.Company().Methods().ApplyCodeChanges().DeclareMethod(
+		`ApplyCodeChanges sets the new codes of the given changes on the accounts of this company.
+		It panics if two accounts would end up with the same code or, if digits is not 0,
+		if a code would have more than digits significant digits.`,
+		func(rs m.CompanySet, changes []accountcode.Change, digits int) {
+			if len(changes) == 0 {
+				return
+			}
+			var collisions, tooLong []string
+			issues := rs.CheckCodeChanges(changes, digits)
+			for _, c := range changes {
+				switch issues[c.ID] {
+				case accountcode.Collision:
+					collisions = append(collisions, fmt.Sprintf("%s → %s", c.Old, c.New))
+				case accountcode.TooLong:
+					tooLong = append(tooLong, c.New)
+				}
+			}
+			if len(collisions) > 0 {
+				panic(rs.T(`The following account codes cannot be changed because another account would have the same code: %s`,
+					strings.Join(collisions, ", ")))
+			}
+			if len(tooLong) > 0 {
+				panic(rs.T(`The following account codes have more than %d digits: %s. Renumber these accounts before changing the number of digits.`,
+					digits, strings.Join(tooLong, ", ")))
+			}
+			ordered, cyclic := accountcode.Order(changes)
+			for _, c := range cyclic {
+				h.AccountAccount().BrowseOne(rs.Env(), c.ID).SetCode(fmt.Sprintf("%s~%d", c.Old, c.ID))
+			}
+			for _, c := range append(ordered, cyclic...) {
+				h.AccountAccount().BrowseOne(rs.Env(), c.ID).SetCode(c.New)
+			}
+		})
+
+	h.Company().Methods().ReflectCodePrefixChange().DeclareMethod(
+		`ReflectCodePrefixChange replaces the old prefix by the new one in the codes of the liquidity
+		accounts of this company, padding them to the given number of digits.`,
+		func(rs m.CompanySet, oldCode, newCode string, digits int) {
+			if oldCode == "" {
+				return
+			}
+			var changes []accountcode.Change
+			for _, account := range rs.LiquidityAccounts().Records() {
+				if !strings.HasPrefix(account.Code(), oldCode) {
+					continue
+				}
+				if code := rs.GetNewAccountCode(account.Code(), oldCode, newCode, digits); code != account.Code() {
+					changes = append(changes, accountcode.Change{ID: account.ID(), Old: account.Code(), New: code})
+				}
+			}
+			rs.ApplyCodeChanges(changes, digits)
 		})
 
 	h.Company().Methods().ReflectCodeDigitsChange().DeclareMethod(
-		`ReflectCodeDigitsChange`,
+		`ReflectCodeDigitsChange pads or shortens the codes of all the accounts of this company
+		to the given number of digits.`,
 		func(rs m.CompanySet, digits int) {
-			accounts := h.AccountAccount().Search(rs.Env(),
-				q.AccountAccount().Company().Equals(rs)).OrderBy("code asc")
-			_ = accounts
-			//for _, account := range accounts.Records() {
-			// FIXME: normalement tu devrais pouvoir le gérer avec un simple Sprintf
-			//account.SetCode(strutils.LeftJustify(strings.TrimLeft(account.Code(), "0"), digits, "0"))
-			//}
+			rs.ApplyCodeChanges(rs.CodeChanges(digits, rs.BankAccountCodePrefix(), rs.CashAccountCodePrefix()), digits)
 		})
 
 	h.Company().Methods().ValidateFiscalyearLock().DeclareMethod(
@@ -202,7 +318,7 @@ Best Regards,`)},
 			}
 			if h.AccountMove().Search(rs.Env(),
 				q.AccountMove().Company().In(rs).
-					And().State().Equals("draft").
+					And().State().In([]string{"draft", "to_approve"}).
 					And().Date().LowerOrEqual(values.FiscalyearLockDate()),
 			).IsNotEmpty() {
 				panic(rs.T(`There are still unposted entries in the period you want to lock. You should either post or delete them.`))
@@ -215,27 +331,21 @@ Best Regards,`)},
 			rs.ValidateFiscalyearLock(data)
 
 			// Reflect the change on accounts
-			for _, company := range rs.Records() {
-				digits := data.AccountsCodeDigits()
-				if digits == 0 {
-					digits = company.AccountsCodeDigits()
-				}
-				if data.BankAccountCodePrefix() != "" || data.AccountsCodeDigits() != 0 {
-					newBankCode := data.BankAccountCodePrefix()
-					if newBankCode == "" {
-						newBankCode = company.BankAccountCodePrefix()
+			if data.HasAccountsCodeDigits() || data.HasBankAccountCodePrefix() || data.HasCashAccountCodePrefix() {
+				for _, company := range rs.Records() {
+					digits := int(company.AccountsCodeDigits())
+					if data.HasAccountsCodeDigits() {
+						digits = int(data.AccountsCodeDigits())
 					}
-					company.ReflectCodePrefixChange(company.BankAccountCodePrefix(), newBankCode, int(digits))
-				}
-				if data.CashAccountCodePrefix() != "" || data.AccountsCodeDigits() != 0 {
-					newBankCode := data.CashAccountCodePrefix()
-					if newBankCode == "" {
-						newBankCode = company.CashAccountCodePrefix()
+					bankPrefix := company.BankAccountCodePrefix()
+					if data.HasBankAccountCodePrefix() {
+						bankPrefix = data.BankAccountCodePrefix()
 					}
-					company.ReflectCodePrefixChange(company.CashAccountCodePrefix(), newBankCode, int(digits))
-				}
-				if data.AccountsCodeDigits() != 0 {
-					company.ReflectCodeDigitsChange(int(digits))
+					cashPrefix := company.CashAccountCodePrefix()
+					if data.HasCashAccountCodePrefix() {
+						cashPrefix = data.CashAccountCodePrefix()
+					}
+					company.ApplyCodeChanges(company.CodeChanges(digits, bankPrefix, cashPrefix), digits)
 				}
 			}
 			return rs.Super().Write(data)
@@ -0,0 +1,241 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+// Package currencyrate reads currency exchange rates from files published by
+// central banks.
+//
+// Two formats are supported:
+//
+// - The euro foreign exchange reference rates XML of the European Central Bank
+// (eurofxref-daily.xml and eurofxref-hist.xml). Rates are given per euro.
+//
+// - A generic CSV format with a header line and the following columns, in any order:
+//
+//	date       date of the rate (YYYY-MM-DD)
+//	currency   ISO 4217 code of the currency
+//	rate       number of units of the currency for one unit of the base currency
+//	base       ISO 4217 code of the base currency (optional)
+//
+// When the base column is missing or empty, the rates are taken as is, that is
+// relative to the currency of rate 1 of the company they are imported into.
+package currencyrate
+
+import (
+	"encoding/csv"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// dateLayout is the layout of the dates of rate files
+const dateLayout = "2006-01-02"
+
+// ECBBase is the base currency of the rates published by the European Central Bank
+const ECBBase = "EUR"
+
+// requiredColumns are the columns that must be present in a CSV rate file
+var requiredColumns = []string{"date", "currency", "rate"}
+
+// A Rate is the number of units of Currency for one unit of Base at Date.
+// Base is empty if the rate is relative to the currency of rate 1 of the company.
+type Rate struct {
+	Date     time.Time
+	Currency string
+	Base     string
+	Rate     float64
+}
+
+// ecbEnvelope is the structure of the ECB eurofxref XML files
+type ecbEnvelope struct {
+	Days []struct {
+		Time  string `xml:"time,attr"`
+		Rates []struct {
+			Currency string `xml:"currency,attr"`
+			Rate     string `xml:"rate,attr"`
+		} `xml:"Cube"`
+	} `xml:"Cube>Cube"`
+}
+
+// ReadECB reads the rates of an ECB eurofxref XML file, daily or historical.
+func ReadECB(r io.Reader) ([]Rate, error) {
+	var envelope ecbEnvelope
+	if err := xml.NewDecoder(r).Decode(&envelope); err != nil {
+		return nil, fmt.Errorf("invalid ECB rate file: %s", err)
+	}
+	var res []Rate
+	for _, day := range envelope.Days {
+		date, err := time.Parse(dateLayout, day.Time)
+		if err != nil {
+			return nil, fmt.Errorf("invalid date %q", day.Time)
+		}
+		for _, rate := range day.Rates {
+			value, err := strconv.ParseFloat(rate.Rate, 64)
+			if err != nil || value <= 0 {
+				return nil, fmt.Errorf("%s: invalid rate %q for %s", day.Time, rate.Rate, rate.Currency)
+			}
+			res = append(res, Rate{
+				Date:     date,
+				Currency: strings.ToUpper(rate.Currency),
+				Base:     ECBBase,
+				Rate:     value,
+			})
+		}
+	}
+	if len(res) == 0 {
+		return nil, fmt.Errorf("no rate found in ECB rate file")
+	}
+	return res, nil
+}
+
+// ReadCSV reads the rates of a CSV rate file.
+func ReadCSV(r io.Reader) ([]Rate, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("unable to read header: %s", err)
+	}
+	columns := make(map[string]int)
+	for i, col := range header {
+		columns[strings.ToLower(strings.TrimSpace(col))] = i
+	}
+	for _, col := range requiredColumns {
+		if _, ok := columns[col]; !ok {
+			return nil, fmt.Errorf("missing column %s", col)
+		}
+	}
+	get := func(record []string, col string) string {
+		i, ok := columns[col]
+		if !ok || i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+	var res []Rate
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", line, err)
+		}
+		date, err := time.Parse(dateLayout, get(record, "date"))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid date %q", line, get(record, "date"))
+		}
+		currency := strings.ToUpper(get(record, "currency"))
+		if currency == "" {
+			return nil, fmt.Errorf("line %d: missing currency", line)
+		}
+		value, err := strconv.ParseFloat(get(record, "rate"), 64)
+		if err != nil || value <= 0 {
+			return nil, fmt.Errorf("line %d: invalid rate %q", line, get(record, "rate"))
+		}
+		res = append(res, Rate{
+			Date:     date,
+			Currency: currency,
+			Base:     strings.ToUpper(get(record, "base")),
+			Rate:     value,
+		})
+	}
+	return res, nil
+}
+
+// ReadFile reads the rates of the given file, according to its extension (.xml or .csv).
+func ReadFile(fileName string) ([]Rate, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var rates []Rate
+	switch strings.ToLower(filepath.Ext(fileName)) {
+	case ".xml":
+		rates, err = ReadECB(f)
+	case ".csv":
+		rates, err = ReadCSV(f)
+	default:
+		return nil, fmt.Errorf("%s: unsupported rate file format", fileName)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", fileName, err)
+	}
+	return rates, nil
+}
+
+// ListFiles returns the rate files of the given directory modified after the given time, sorted by name.
+func ListFiles(dir string, after time.Time) ([]string, error) {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var res []string
+	for _, info := range infos {
+		ext := strings.ToLower(filepath.Ext(info.Name()))
+		if info.IsDir() || (ext != ".xml" && ext != ".csv") || !info.ModTime().After(after) {
+			continue
+		}
+		res = append(res, filepath.Join(dir, info.Name()))
+	}
+	sort.Strings(res)
+	return res, nil
+}
+
+// Rebase converts the given rates so that they are relative to the target currency.
+// Rates without base are kept as is. The rate of the target currency itself is dropped,
+// and the rate of each base currency is added by inversion.
+//
+// It returns the converted rates and the dates for which the rate of the target currency
+// against a base currency is missing.
+func Rebase(rates []Rate, target string) ([]Rate, []time.Time) {
+	type dayBase struct {
+		date time.Time
+		base string
+	}
+	targetRates := make(map[dayBase]float64)
+	for _, rate := range rates {
+		if rate.Base != "" && rate.Currency == target {
+			targetRates[dayBase{date: rate.Date, base: rate.Base}] = rate.Rate
+		}
+	}
+	var res []Rate
+	missing := make(map[time.Time]bool)
+	inverted := make(map[dayBase]bool)
+	for _, rate := range rates {
+		if rate.Base == "" || rate.Base == target {
+			if rate.Currency != target {
+				res = append(res, Rate{Date: rate.Date, Currency: rate.Currency, Rate: rate.Rate})
+			}
+			continue
+		}
+		key := dayBase{date: rate.Date, base: rate.Base}
+		targetRate, ok := targetRates[key]
+		if !ok {
+			missing[rate.Date] = true
+			continue
+		}
+		if !inverted[key] {
+			res = append(res, Rate{Date: rate.Date, Currency: rate.Base, Rate: 1 / targetRate})
+			inverted[key] = true
+		}
+		if rate.Currency != target {
+			res = append(res, Rate{Date: rate.Date, Currency: rate.Currency, Rate: rate.Rate / targetRate})
+		}
+	}
+	var missingDates []time.Time
+	for date := range missing {
+		missingDates = append(missingDates, date)
+	}
+	sort.Slice(missingDates, func(i, j int) bool {
+		return missingDates[i].Before(missingDates[j])
+	})
+	return res, missingDates
+}
@@ -0,0 +1,113 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package currencyrate
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+const testECB = `<?xml version="1.0" encoding="UTF-8"?>
+<gesmes:Envelope xmlns:gesmes="http://www.gesmes.org/xml/2002-08-01" xmlns="http://www.ecb.int/vocabulary/2002-08-01/eurofxref">
+	<gesmes:subject>Reference rates</gesmes:subject>
+	<gesmes:Sender>
+		<gesmes:name>European Central Bank</gesmes:name>
+	</gesmes:Sender>
+	<Cube>
+		<Cube time="2020-01-03">
+			<Cube currency="USD" rate="1.1147"/>
+			<Cube currency="GBP" rate="0.85"/>
+		</Cube>
+		<Cube time="2020-01-02">
+			<Cube currency="USD" rate="1.1193"/>
+			<Cube currency="JPY" rate="121.75"/>
+		</Cube>
+	</Cube>
+</gesmes:Envelope>
+`
+
+const testCSV = `Date,Currency,Rate,Base
+2020-01-02,usd,1.25,
+2020-01-02,CHF,1.08,EUR
+`
+
+func TestCurrencyRate(t *testing.T) {
+	Convey("Testing currency rate files", t, func() {
+		date := func(s string) time.Time {
+			d, err := time.Parse(dateLayout, s)
+			So(err, ShouldBeNil)
+			return d
+		}
+		Convey("ECB files are read with EUR as base", func() {
+			rates, err := ReadECB(strings.NewReader(testECB))
+			So(err, ShouldBeNil)
+			So(rates, ShouldHaveLength, 4)
+			So(rates[0], ShouldResemble, Rate{Date: date("2020-01-03"), Currency: "USD", Base: "EUR", Rate: 1.1147})
+			So(rates[3].Currency, ShouldEqual, "JPY")
+			_, err = ReadECB(strings.NewReader(`<Envelope><Cube><Cube time="2020-01-02"><Cube currency="USD" rate="x"/></Cube></Cube></Envelope>`))
+			So(err, ShouldNotBeNil)
+			_, err = ReadECB(strings.NewReader(`<Envelope></Envelope>`))
+			So(err, ShouldNotBeNil)
+		})
+		Convey("CSV files are read with an optional base", func() {
+			rates, err := ReadCSV(strings.NewReader(testCSV))
+			So(err, ShouldBeNil)
+			So(rates, ShouldResemble, []Rate{
+				{Date: date("2020-01-02"), Currency: "USD", Rate: 1.25},
+				{Date: date("2020-01-02"), Currency: "CHF", Base: "EUR", Rate: 1.08},
+			})
+			_, err = ReadCSV(strings.NewReader("date,currency\n2020-01-02,USD\n"))
+			So(err, ShouldNotBeNil)
+			_, err = ReadCSV(strings.NewReader("date,currency,rate\n2020-01-02,USD,-1\n"))
+			So(err, ShouldNotBeNil)
+			_, err = ReadCSV(strings.NewReader("date,currency,rate\n02/01/2020,USD,1\n"))
+			So(err, ShouldNotBeNil)
+		})
+		Convey("Rates are rebased on the target currency", func() {
+			rates, _ := ReadECB(strings.NewReader(testECB))
+			rebased, missing := Rebase(rates, "USD")
+			So(missing, ShouldBeEmpty)
+			So(rebased, ShouldHaveLength, 4)
+			So(rebased[0].Currency, ShouldEqual, "EUR")
+			So(rebased[0].Rate, ShouldAlmostEqual, 1/1.1147)
+			So(rebased[1].Currency, ShouldEqual, "GBP")
+			So(rebased[1].Rate, ShouldAlmostEqual, 0.85/1.1147)
+			So(rebased[3].Currency, ShouldEqual, "JPY")
+			So(rebased[3].Rate, ShouldAlmostEqual, 121.75/1.1193)
+
+			rebased, missing = Rebase(rates, "GBP")
+			So(missing, ShouldResemble, []time.Time{date("2020-01-02")})
+			So(rebased, ShouldHaveLength, 2)
+
+			rebased, _ = Rebase(rates, "EUR")
+			So(rebased, ShouldHaveLength, 4)
+			So(rebased[0], ShouldResemble, Rate{Date: date("2020-01-03"), Currency: "USD", Rate: 1.1147})
+		})
+		Convey("Rate files are listed by modification time", func() {
+			dir, err := ioutil.TempDir("", "currencyrate")
+			So(err, ShouldBeNil)
+			defer os.RemoveAll(dir)
+			So(ioutil.WriteFile(filepath.Join(dir, "eurofxref-daily.xml"), []byte(testECB), 0644), ShouldBeNil)
+			So(ioutil.WriteFile(filepath.Join(dir, "rates.csv"), []byte(testCSV), 0644), ShouldBeNil)
+			So(ioutil.WriteFile(filepath.Join(dir, "README.txt"), []byte("foo"), 0644), ShouldBeNil)
+			files, err := ListFiles(dir, time.Time{})
+			So(err, ShouldBeNil)
+			So(files, ShouldResemble, []string{filepath.Join(dir, "eurofxref-daily.xml"), filepath.Join(dir, "rates.csv")})
+			files, err = ListFiles(dir, time.Now().Add(time.Hour))
+			So(err, ShouldBeNil)
+			So(files, ShouldBeEmpty)
+			rates, err := ReadFile(filepath.Join(dir, "rates.csv"))
+			So(err, ShouldBeNil)
+			So(rates, ShouldHaveLength, 2)
+			_, err = ReadFile(filepath.Join(dir, "README.txt"))
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
@@ -0,0 +1,177 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+// Package formula implements a small sandboxed expression language used by
+// the accounting module for user defined computations (tax report boxes,
+// formula taxes, etc.).
+//
+// An expression is made of numbers, string literals, variables, the
+// arithmetic operators + - * / %, the comparison operators == != < <= > >=,
+// the logical operators && || !, parentheses and calls to the following
+// functions: abs, min, max, round, floor, ceil and if.
+//
+// Expressions have no access to the rest of the program: they can only read
+// the variables given at evaluation time and call the functions above.
+package formula
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+const (
+	// maxLength is the maximum length of an expression
+	maxLength = 4096
+	// maxDepth is the maximum nesting level of an expression
+	maxDepth = 64
+)
+
+// A Formula is a parsed expression that can be evaluated many times
+// with different variables.
+type Formula struct {
+	expr string
+	root node
+}
+
+// Parse parses the given expression and returns the corresponding Formula.
+func Parse(expr string) (*Formula, error) {
+	if len(expr) > maxLength {
+		return nil, fmt.Errorf("expression is too long (%d characters, maximum is %d)", len(expr), maxLength)
+	}
+	tokens, err := tokenize(expr)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	root, err := p.parseExpression(0)
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind != tokenEOF {
+		return nil, fmt.Errorf("unexpected '%s' at position %d", tok.text, tok.pos+1)
+	}
+	return &Formula{expr: expr, root: root}, nil
+}
+
+// MustParse parses the given expression and panics if it is invalid.
+func MustParse(expr string) *Formula {
+	f, err := Parse(expr)
+	if err != nil {
+		panic(err)
+	}
+	return f
+}
+
+// String returns the source expression of this Formula
+func (f *Formula) String() string {
+	return f.expr
+}
+
+// Variables returns the sorted list of the variables used in this Formula.
+func (f *Formula) Variables() []string {
+	set := make(map[string]bool)
+	f.root.variables(set)
+	res := make([]string, 0, len(set))
+	for v := range set {
+		res = append(res, v)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// CheckVariables returns an error if this Formula uses a variable which is
+// not in the given allowed list.
+func (f *Formula) CheckVariables(allowed ...string) error {
+	allowedSet := make(map[string]bool)
+	for _, a := range allowed {
+		allowedSet[a] = true
+	}
+	var unknown []string
+	for _, v := range f.Variables() {
+		if !allowedSet[v] {
+			unknown = append(unknown, v)
+		}
+	}
+	if len(unknown) > 0 {
+		return fmt.Errorf("unknown variable(s): %s", strings.Join(unknown, ", "))
+	}
+	return nil
+}
+
+// Eval evaluates this Formula with the given variables and returns its numeric result.
+//
+// Variables values must be numbers (any Go integer or float type), booleans
+// (evaluated as 1 or 0) or strings.
+func (f *Formula) Eval(vars map[string]interface{}) (res float64, err error) {
+	val, err := f.root.eval(vars)
+	if err != nil {
+		return 0, err
+	}
+	num, ok := val.(float64)
+	if !ok {
+		return 0, fmt.Errorf("expression result is not a number: %v", val)
+	}
+	if math.IsNaN(num) || math.IsInf(num, 0) {
+		return 0, fmt.Errorf("expression result is not a finite number")
+	}
+	return num, nil
+}
+
+// normalize converts the given variable value to a float64 or a string
+func normalize(name string, value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case float64:
+		return v, nil
+	case float32:
+		return float64(v), nil
+	case int:
+		return float64(v), nil
+	case int8:
+		return float64(v), nil
+	case int16:
+		return float64(v), nil
+	case int32:
+		return float64(v), nil
+	case int64:
+		return float64(v), nil
+	case uint:
+		return float64(v), nil
+	case uint8:
+		return float64(v), nil
+	case uint16:
+		return float64(v), nil
+	case uint32:
+		return float64(v), nil
+	case uint64:
+		return float64(v), nil
+	case bool:
+		if v {
+			return 1.0, nil
+		}
+		return 0.0, nil
+	case string:
+		return v, nil
+	}
+	return nil, fmt.Errorf("unsupported value type %T for variable %s", value, name)
+}
+
+// truthy returns the boolean value of the given value
+func truthy(val interface{}) bool {
+	switch v := val.(type) {
+	case float64:
+		return v != 0
+	case string:
+		return v != ""
+	}
+	return false
+}
+
+// boolToFloat returns 1 if b is true and 0 otherwise
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,78 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package formula
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestFormula(t *testing.T) {
+	Convey("Testing formulas", t, func() {
+		vars := map[string]interface{}{
+			"a":    2,
+			"b":    int64(5),
+			"c.d":  1.5,
+			"flag": true,
+			"code": "B01",
+		}
+		eval := func(expr string) float64 {
+			f, err := Parse(expr)
+			So(err, ShouldBeNil)
+			res, err := f.Eval(vars)
+			So(err, ShouldBeNil)
+			return res
+		}
+		Convey("Arithmetic follows usual precedence", func() {
+			So(eval("1 + 2 * 3"), ShouldEqual, 7)
+			So(eval("(1 + 2) * 3"), ShouldEqual, 9)
+			So(eval("10 - 4 - 3"), ShouldEqual, 3)
+			So(eval("8 / 2 / 2"), ShouldEqual, 2)
+			So(eval("10 % 3"), ShouldEqual, 1)
+			So(eval("-a * -b"), ShouldEqual, 10)
+			So(eval("1.5e2"), ShouldEqual, 150)
+		})
+		Convey("Variables and functions", func() {
+			So(eval("a * c.d"), ShouldEqual, 3)
+			So(eval("flag + 1"), ShouldEqual, 2)
+			So(eval("abs(a - b)"), ShouldEqual, 3)
+			So(eval("min(b, a, 4)"), ShouldEqual, 2)
+			So(eval("max(b, a, 4)"), ShouldEqual, 5)
+			So(eval("round(2.345, 2)"), ShouldEqual, 2.35)
+			So(eval("floor(c.d) + ceil(c.d)"), ShouldEqual, 3)
+			So(eval("if(a > b, a, b)"), ShouldEqual, 5)
+		})
+		Convey("Comparisons and logical operators", func() {
+			So(eval("a < b && b <= 5"), ShouldEqual, 1)
+			So(eval("a == b || !flag"), ShouldEqual, 0)
+			So(eval("code == 'B01'"), ShouldEqual, 1)
+			So(eval("code != \"B01\""), ShouldEqual, 0)
+		})
+		Convey("Variables can be listed and checked", func() {
+			f := MustParse("a + if(flag, c.d, b) * a")
+			So(f.Variables(), ShouldResemble, []string{"a", "b", "c.d", "flag"})
+			So(f.CheckVariables("a", "b", "c.d", "flag"), ShouldBeNil)
+			So(f.CheckVariables("a", "b"), ShouldNotBeNil)
+			So(f.String(), ShouldEqual, "a + if(flag, c.d, b) * a")
+		})
+		Convey("Invalid expressions are rejected", func() {
+			for _, expr := range []string{"", "1 +", "(1 + 2", "1 2", "foo(1)", "abs()", "'abc", "a $ b"} {
+				_, err := Parse(expr)
+				So(err, ShouldNotBeNil)
+			}
+			So(func() { MustParse("1 +") }, ShouldPanic)
+		})
+		Convey("Evaluation errors are returned", func() {
+			_, err := MustParse("a / (b - 5)").Eval(vars)
+			So(err, ShouldNotBeNil)
+			_, err = MustParse("a + unknown").Eval(vars)
+			So(err, ShouldNotBeNil)
+			_, err = MustParse("code * 2").Eval(vars)
+			So(err, ShouldNotBeNil)
+			_, err = MustParse("code").Eval(vars)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
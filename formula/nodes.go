@@ -0,0 +1,265 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package formula
+
+import (
+	"fmt"
+	"math"
+)
+
+// A node is an element of the syntax tree of an expression
+type node interface {
+	// eval returns the value of this node, either a float64 or a string
+	eval(vars map[string]interface{}) (interface{}, error)
+	// variables adds the variables used by this node to the given set
+	variables(set map[string]bool)
+}
+
+// A literalNode is a number or a string constant
+type literalNode struct {
+	value interface{}
+}
+
+func (n *literalNode) eval(vars map[string]interface{}) (interface{}, error) {
+	return n.value, nil
+}
+
+func (n *literalNode) variables(set map[string]bool) {}
+
+// A variableNode is a reference to a variable given at evaluation time
+type variableNode struct {
+	name string
+}
+
+func (n *variableNode) eval(vars map[string]interface{}) (interface{}, error) {
+	val, ok := vars[n.name]
+	if !ok {
+		return nil, fmt.Errorf("unknown variable '%s'", n.name)
+	}
+	return normalize(n.name, val)
+}
+
+func (n *variableNode) variables(set map[string]bool) {
+	set[n.name] = true
+}
+
+// A unaryNode is the application of a unary operator
+type unaryNode struct {
+	op      string
+	operand node
+}
+
+func (n *unaryNode) eval(vars map[string]interface{}) (interface{}, error) {
+	val, err := n.operand.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	if n.op == "!" {
+		return boolToFloat(!truthy(val)), nil
+	}
+	num, ok := val.(float64)
+	if !ok {
+		return nil, fmt.Errorf("operator '%s' cannot be applied to string '%v'", n.op, val)
+	}
+	if n.op == "-" {
+		return -num, nil
+	}
+	return num, nil
+}
+
+func (n *unaryNode) variables(set map[string]bool) {
+	n.operand.variables(set)
+}
+
+// A binaryNode is the application of a binary operator
+type binaryNode struct {
+	op    string
+	left  node
+	right node
+}
+
+func (n *binaryNode) eval(vars map[string]interface{}) (interface{}, error) {
+	left, err := n.left.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	// Logical operators short-circuit
+	switch n.op {
+	case "&&":
+		if !truthy(left) {
+			return 0.0, nil
+		}
+		right, err := n.right.eval(vars)
+		if err != nil {
+			return nil, err
+		}
+		return boolToFloat(truthy(right)), nil
+	case "||":
+		if truthy(left) {
+			return 1.0, nil
+		}
+		right, err := n.right.eval(vars)
+		if err != nil {
+			return nil, err
+		}
+		return boolToFloat(truthy(right)), nil
+	}
+	right, err := n.right.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	switch n.op {
+	case "==":
+		return boolToFloat(left == right), nil
+	case "!=":
+		return boolToFloat(left != right), nil
+	}
+	if ls, ok := left.(string); ok {
+		rs, ok := right.(string)
+		if !ok {
+			return nil, fmt.Errorf("operator '%s' cannot compare string '%s' with a number", n.op, ls)
+		}
+		switch n.op {
+		case "<":
+			return boolToFloat(ls < rs), nil
+		case "<=":
+			return boolToFloat(ls <= rs), nil
+		case ">":
+			return boolToFloat(ls > rs), nil
+		case ">=":
+			return boolToFloat(ls >= rs), nil
+		case "+":
+			return ls + rs, nil
+		}
+		return nil, fmt.Errorf("operator '%s' cannot be applied to strings", n.op)
+	}
+	l, lok := left.(float64)
+	r, rok := right.(float64)
+	if !lok || !rok {
+		return nil, fmt.Errorf("operator '%s' cannot be applied to a number and a string", n.op)
+	}
+	switch n.op {
+	case "<":
+		return boolToFloat(l < r), nil
+	case "<=":
+		return boolToFloat(l <= r), nil
+	case ">":
+		return boolToFloat(l > r), nil
+	case ">=":
+		return boolToFloat(l >= r), nil
+	case "+":
+		return l + r, nil
+	case "-":
+		return l - r, nil
+	case "*":
+		return l * r, nil
+	case "/":
+		if r == 0 {
+			return nil, fmt.Errorf("division by zero")
+		}
+		return l / r, nil
+	case "%":
+		if r == 0 {
+			return nil, fmt.Errorf("division by zero")
+		}
+		return math.Mod(l, r), nil
+	}
+	return nil, fmt.Errorf("unknown operator '%s'", n.op)
+}
+
+func (n *binaryNode) variables(set map[string]bool) {
+	n.left.variables(set)
+	n.right.variables(set)
+}
+
+// A callNode is a call to one of the built-in functions
+type callNode struct {
+	name string
+	fnct function
+	args []node
+}
+
+func (n *callNode) eval(vars map[string]interface{}) (interface{}, error) {
+	if n.fnct.lazy != nil {
+		return n.fnct.lazy(vars, n.args)
+	}
+	args := make([]float64, len(n.args))
+	for i, arg := range n.args {
+		val, err := arg.eval(vars)
+		if err != nil {
+			return nil, err
+		}
+		num, ok := val.(float64)
+		if !ok {
+			return nil, fmt.Errorf("argument %d of function '%s' is not a number", i+1, n.name)
+		}
+		args[i] = num
+	}
+	return n.fnct.call(args)
+}
+
+func (n *callNode) variables(set map[string]bool) {
+	for _, arg := range n.args {
+		arg.variables(set)
+	}
+}
+
+// A function is a built-in function that can be called from expressions.
+//
+// Functions evaluate either all their arguments as numbers before being
+// called (call), or evaluate them on demand (lazy).
+type function struct {
+	minArgs int
+	maxArgs int
+	call    func(args []float64) (interface{}, error)
+	lazy    func(vars map[string]interface{}, args []node) (interface{}, error)
+}
+
+// functions is the list of available functions by name
+var functions = map[string]function{
+	"abs": {minArgs: 1, maxArgs: 1, call: func(args []float64) (interface{}, error) {
+		return math.Abs(args[0]), nil
+	}},
+	"min": {minArgs: 1, maxArgs: -1, call: func(args []float64) (interface{}, error) {
+		res := args[0]
+		for _, a := range args[1:] {
+			res = math.Min(res, a)
+		}
+		return res, nil
+	}},
+	"max": {minArgs: 1, maxArgs: -1, call: func(args []float64) (interface{}, error) {
+		res := args[0]
+		for _, a := range args[1:] {
+			res = math.Max(res, a)
+		}
+		return res, nil
+	}},
+	"round": {minArgs: 1, maxArgs: 2, call: func(args []float64) (interface{}, error) {
+		var digits float64
+		if len(args) > 1 {
+			digits = args[1]
+		}
+		if digits < 0 || digits > 15 || digits != math.Trunc(digits) {
+			return nil, fmt.Errorf("invalid number of digits %v for function 'round'", digits)
+		}
+		factor := math.Pow(10, digits)
+		return math.Round(args[0]*factor) / factor, nil
+	}},
+	"floor": {minArgs: 1, maxArgs: 1, call: func(args []float64) (interface{}, error) {
+		return math.Floor(args[0]), nil
+	}},
+	"ceil": {minArgs: 1, maxArgs: 1, call: func(args []float64) (interface{}, error) {
+		return math.Ceil(args[0]), nil
+	}},
+	"if": {minArgs: 3, maxArgs: 3, lazy: func(vars map[string]interface{}, args []node) (interface{}, error) {
+		cond, err := args[0].eval(vars)
+		if err != nil {
+			return nil, err
+		}
+		if truthy(cond) {
+			return args[1].eval(vars)
+		}
+		return args[2].eval(vars)
+	}},
+}
@@ -0,0 +1,304 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package account
+
+import (
+	"github.com/hexya-erp/hexya/src/models"
+	"github.com/hexya-erp/hexya/src/models/types"
+	"github.com/hexya-erp/hexya/src/tools/strutils"
+	"github.com/hexya-erp/pool/h"
+	"github.com/hexya-erp/pool/m"
+	"github.com/hexya-erp/pool/q"
+)
+
+// intercompanyMirrorTypes maps the type of an invoice issued to another company
+// to the type of the document created in that company.
+var intercompanyMirrorTypes = map[string]string{
+	"out_invoice": "in_invoice",
+	"out_refund":  "in_refund",
+}
+
+func init() {
+
+	h.Company().AddFields(map[string]models.FieldDefinition{
+		"IntercompanyRule": models.SelectionField{
+			String: "Intercompany Documents",
+			Selection: types.Selection{
+				"none":  "Do not mirror",
+				"draft": "Create draft documents",
+				"sync":  "Create and synchronize documents"},
+			Default:  models.DefaultValue("none"),
+			Required: true,
+			Help: `Defines what happens in this company when another company of the database issues an invoice,
+a refund or a payment to it. With 'Create draft documents', the mirrored vendor bill, refund or payment is
+created as draft. With 'Create and synchronize documents', it is also validated, and it is cancelled when
+the original document is cancelled.`},
+	})
+
+	h.AccountInvoice().AddFields(map[string]models.FieldDefinition{
+		"IntercompanyInvoice": models.Many2OneField{
+			String:        "Intercompany Document",
+			RelationModel: h.AccountInvoice(),
+			ReadOnly:      true,
+			NoCopy:        true,
+			Help:          "The mirror of this invoice in the other company"},
+	})
+
+	h.AccountPayment().AddFields(map[string]models.FieldDefinition{
+		"IntercompanyPayment": models.Many2OneField{
+			String:        "Intercompany Payment",
+			RelationModel: h.AccountPayment(),
+			ReadOnly:      true,
+			NoCopy:        true,
+			Help:          "The mirror of this payment in the other company"},
+	})
+
+	h.Partner().Methods().IntercompanyCompany().DeclareMethod(
+		`IntercompanyCompany returns the company of the database whose partner is the commercial
+		partner of this partner, or an empty set if this partner is not a company of the database.`,
+		func(rs m.PartnerSet) m.CompanySet {
+			if rs.IsEmpty() {
+				return h.Company().NewSet(rs.Env())
+			}
+			return h.Company().NewSet(rs.Env()).Sudo().Search(
+				q.Company().Partner().Equals(rs.CommercialPartner())).Limit(1)
+		})
+
+	h.AccountInvoice().Methods().IntercompanyTarget().DeclareMethod(
+		`IntercompanyTarget returns the company in which this invoice must be mirrored,
+		or an empty set if there is none.`,
+		func(rs m.AccountInvoiceSet) m.CompanySet {
+			empty := h.Company().NewSet(rs.Env())
+			if _, ok := intercompanyMirrorTypes[rs.Type()]; !ok {
+				return empty
+			}
+			company := rs.Partner().IntercompanyCompany()
+			if company.IsEmpty() || company.Equals(rs.Company()) || company.IntercompanyRule() == "none" {
+				return empty
+			}
+			return company
+		})
+
+	h.AccountInvoice().Methods().IntercompanyProduct().DeclareMethod(
+		`IntercompanyProduct returns the product to use in the given company for the given product.
+		Products shared between companies are kept. Otherwise, the product of the company with the same
+		internal reference is used, if any.`,
+		func(rs m.AccountInvoiceSet, product m.ProductProductSet, company m.CompanySet) m.ProductProductSet {
+			if product.IsEmpty() || product.Company().IsEmpty() || product.Company().Equals(company) {
+				return product
+			}
+			if product.DefaultCode() == "" {
+				return h.ProductProduct().NewSet(rs.Env())
+			}
+			return h.ProductProduct().Search(rs.Env(),
+				q.ProductProduct().DefaultCode().Equals(product.DefaultCode()).
+					AndCond(q.ProductProduct().Company().Equals(company).Or().Company().IsNull())).Limit(1)
+		})
+
+	h.AccountInvoice().Methods().PrepareIntercompanyInvoice().DeclareMethod(
+		`PrepareIntercompanyInvoice returns the data to create the mirror of this invoice in the
+		given company. Products are mapped to the company, and taxes and accounts are resolved
+		through the fiscal position of the company for the issuing company's partner.`,
+		func(rs m.AccountInvoiceSet, company m.CompanySet) m.AccountInvoiceData {
+			env := rs.WithContext("force_company", company.ID()).Env()
+			typ := intercompanyMirrorTypes[rs.Type()]
+			partner := h.Partner().BrowseOne(env, rs.Company().Partner().ID())
+			journal := h.AccountJournal().Search(env,
+				q.AccountJournal().Type().Equals("purchase").And().Company().Equals(company)).Limit(1)
+			if journal.IsEmpty() {
+				panic(rs.T(`Please define a purchase journal for company %s to receive intercompany invoices.`, company.Name()))
+			}
+			fPos := h.AccountFiscalPosition().NewSet(env).GetFiscalPosition(partner, partner)
+			data := h.AccountInvoice().NewData().
+				SetType(typ).
+				SetCompany(company).
+				SetJournal(journal).
+				SetPartner(partner).
+				SetFiscalPosition(fPos).
+				SetCurrency(rs.Currency()).
+				SetDateInvoice(rs.DateInvoice()).
+				SetReference(rs.Number()).
+				SetOrigin(rs.Number()).
+				SetName(rs.Name()).
+				SetIntercompanyInvoice(rs)
+			if rs.RefundInvoice().IntercompanyInvoice().IsNotEmpty() {
+				data.SetRefundInvoice(rs.RefundInvoice().IntercompanyInvoice())
+			}
+			for _, line := range rs.InvoiceLines().Records() {
+				product := rs.IntercompanyProduct(line.Product(), company).WithContext("force_company", company.ID())
+				account := h.AccountInvoiceLine().NewSet(env).GetInvoiceLineAccount(typ, product, fPos, company)
+				if account.IsEmpty() {
+					account = journal.DefaultDebitAccount()
+				}
+				taxes := h.AccountTax().Coalesce(product.SupplierTaxes(), account.Taxes()).
+					Filtered(func(r m.AccountTaxSet) bool { return r.Company().Equals(company) })
+				data = data.CreateInvoiceLines(h.AccountInvoiceLine().NewData().
+					SetName(line.Name()).
+					SetProduct(product).
+					SetQuantity(line.Quantity()).
+					SetUom(line.Uom()).
+					SetPriceUnit(line.PriceUnit()).
+					SetDiscount(line.Discount()).
+					SetAccount(account).
+					SetInvoiceLineTaxes(fPos.MapTax(taxes, product, partner)))
+			}
+			return data
+		})
+
+	h.AccountInvoice().Methods().MirrorIntercompany().DeclareMethod(
+		`MirrorIntercompany creates the mirror of the invoices of this set issued to another company,
+		according to the intercompany rule of that company.`,
+		func(rs m.AccountInvoiceSet) {
+			for _, invoice := range rs.Records() {
+				if invoice.IntercompanyInvoice().IsNotEmpty() && invoice.IntercompanyInvoice().State() != "cancel" {
+					continue
+				}
+				company := invoice.IntercompanyTarget()
+				if company.IsEmpty() {
+					continue
+				}
+				data := invoice.PrepareIntercompanyInvoice(company)
+				mirror := h.AccountInvoice().NewSet(rs.Env()).Sudo().
+					WithContext("force_company", company.ID()).
+					WithContext("intercompany_sync", true).
+					Create(data)
+				invoice.SetIntercompanyInvoice(mirror)
+				if company.IntercompanyRule() == "sync" {
+					mirror.ActionInvoiceOpen()
+				}
+			}
+		})
+
+	h.AccountInvoice().Methods().ActionInvoiceOpen().Extend("",
+		func(rs m.AccountInvoiceSet) bool {
+			res := rs.Super().ActionInvoiceOpen()
+			if !rs.Env().Context().GetBool("intercompany_sync") {
+				rs.MirrorIntercompany()
+			}
+			return res
+		})
+
+	h.AccountInvoice().Methods().ActionCancel().Extend("",
+		func(rs m.AccountInvoiceSet) bool {
+			res := rs.Super().ActionCancel()
+			if rs.Env().Context().GetBool("intercompany_sync") {
+				return res
+			}
+			for _, invoice := range rs.Records() {
+				mirror := invoice.IntercompanyInvoice().Sudo()
+				if !strutils.IsIn(mirror.State(), "draft", "proforma2", "open") {
+					continue
+				}
+				if mirror.State() == "open" && mirror.Company().IntercompanyRule() != "sync" {
+					continue
+				}
+				mirror.WithContext("intercompany_sync", true).ActionInvoiceCancel()
+			}
+			return res
+		})
+
+	h.AccountPayment().Methods().IntercompanyTarget().DeclareMethod(
+		`IntercompanyTarget returns the company in which this payment must be mirrored,
+		or an empty set if there is none.`,
+		func(rs m.AccountPaymentSet) m.CompanySet {
+			empty := h.Company().NewSet(rs.Env())
+			if rs.PaymentType() != "outbound" {
+				return empty
+			}
+			company := rs.Partner().IntercompanyCompany()
+			if company.IsEmpty() || company.Equals(rs.Company()) || company.IntercompanyRule() == "none" {
+				return empty
+			}
+			return company
+		})
+
+	h.AccountPayment().Methods().PrepareIntercompanyPayment().DeclareMethod(
+		`PrepareIntercompanyPayment returns the data to create the mirror of this payment in the given
+		company. The mirrored payment is linked to the mirrors of the paid invoices.`,
+		func(rs m.AccountPaymentSet, company m.CompanySet) m.AccountPaymentData {
+			env := rs.WithContext("force_company", company.ID()).Env()
+			journals := h.AccountJournal().Search(env,
+				q.AccountJournal().Type().Equals("bank").And().Company().Equals(company))
+			journal := journals.Filtered(func(r m.AccountJournalSet) bool {
+				return h.Currency().Coalesce(r.Currency(), company.Currency()).Equals(rs.Currency())
+			}).Limit(1)
+			if journal.IsEmpty() {
+				journal = journals.Limit(1)
+			}
+			if journal.IsEmpty() {
+				panic(rs.T(`Please define a bank journal for company %s to receive intercompany payments.`, company.Name()))
+			}
+			partnerType := "customer"
+			if rs.PartnerType() == "customer" {
+				partnerType = "supplier"
+			}
+			invoices := h.AccountInvoice().NewSet(env)
+			for _, invoice := range rs.Invoices().Records() {
+				if mirror := invoice.IntercompanyInvoice(); mirror.State() == "open" {
+					invoices = invoices.Union(mirror)
+				}
+			}
+			return h.AccountPayment().NewData().
+				SetPaymentType("inbound").
+				SetPartnerType(partnerType).
+				SetPartner(h.Partner().BrowseOne(env, rs.Company().Partner().ID())).
+				SetJournal(journal).
+				SetPaymentMethod(h.AccountPaymentMethod().NewSet(env).GetRecord("account_account_payment_method_manual_in")).
+				SetAmount(rs.Amount()).
+				SetCurrency(rs.Currency()).
+				SetPaymentDate(rs.PaymentDate()).
+				SetCommunication(rs.Communication()).
+				SetInvoices(invoices).
+				SetIntercompanyPayment(rs)
+		})
+
+	h.AccountPayment().Methods().Post().Extend("",
+		func(rs m.AccountPaymentSet) {
+			rs.Super().Post()
+			if rs.Env().Context().GetBool("intercompany_sync") {
+				return
+			}
+			for _, payment := range rs.Records() {
+				if mirror := payment.IntercompanyPayment().Sudo(); mirror.IsNotEmpty() {
+					if mirror.State() == "draft" && mirror.Company().IntercompanyRule() == "sync" {
+						mirror.WithContext("intercompany_sync", true).Post()
+					}
+					continue
+				}
+				company := payment.IntercompanyTarget()
+				if company.IsEmpty() {
+					continue
+				}
+				mirror := h.AccountPayment().NewSet(rs.Env()).Sudo().
+					WithContext("force_company", company.ID()).
+					WithContext("intercompany_sync", true).
+					Create(payment.PrepareIntercompanyPayment(company))
+				payment.SetIntercompanyPayment(mirror)
+				if company.IntercompanyRule() == "sync" && mirror.Invoices().Filtered(func(r m.AccountInvoiceSet) bool {
+					return r.State() != "open"
+				}).IsEmpty() {
+					mirror.Post()
+				}
+			}
+		})
+
+	h.AccountPayment().Methods().Cancel().Extend("",
+		func(rs m.AccountPaymentSet) {
+			rs.Super().Cancel()
+			if rs.Env().Context().GetBool("intercompany_sync") {
+				return
+			}
+			for _, payment := range rs.Records() {
+				mirror := payment.IntercompanyPayment().Sudo().WithContext("intercompany_sync", true)
+				switch {
+				case mirror.IsEmpty():
+				case mirror.State() == "draft" && mirror.MoveName() == "":
+					mirror.Unlink()
+				case mirror.State() != "draft" && mirror.Company().IntercompanyRule() == "sync":
+					mirror.Cancel()
+				}
+			}
+		})
+
+}
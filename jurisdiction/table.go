@@ -0,0 +1,217 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+// Package jurisdiction reads sales tax rate tables of stacked tax jurisdictions
+// (e.g. US state, county, city and special districts) from CSV files.
+//
+// A rate table has a header line and one line per zip code range, jurisdiction
+// and effective period, with the following columns:
+//
+//	zip_from    first zip code of the range, as 5 digits or zip+4
+//	zip_to      last zip code of the range (optional, defaults to zip_from)
+//	code        unique code of the jurisdiction
+//	name        name of the jurisdiction
+//	level       one of state, county, city and district
+//	state       code of the state of the jurisdiction (optional)
+//	rate        tax rate of the jurisdiction in percent
+//	date_from   first day of the effective period (YYYY-MM-DD)
+//	date_to     last day of the effective period (optional)
+//
+// Columns may be given in any order.
+package jurisdiction
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// dateLayout is the layout of the dates of rate tables
+const dateLayout = "2006-01-02"
+
+// Levels lists the jurisdiction levels from the widest to the narrowest
+var Levels = []string{"state", "county", "city", "district"}
+
+// requiredColumns are the columns that must be present in a rate table
+var requiredColumns = []string{"zip_from", "code", "name", "level", "rate", "date_from"}
+
+// A Rate is a line of a rate table: the rate of a jurisdiction in a zip code
+// range during an effective period.
+type Rate struct {
+	// ZipFrom and ZipTo are the bounds of the zip range, as 9 digits
+	ZipFrom  string
+	ZipTo    string
+	Code     string
+	Name     string
+	Level    string
+	State    string
+	Rate     float64
+	DateFrom time.Time
+	// DateTo is zero if the period has no end
+	DateTo time.Time
+}
+
+// NormalizeZip returns the given zip code as 5 digits, or 9 digits for a zip+4 code.
+// Spaces and the dash of zip+4 codes are removed.
+func NormalizeZip(zip string) (string, error) {
+	res := strings.Replace(strings.Replace(strings.TrimSpace(zip), "-", "", 1), " ", "", -1)
+	if len(res) != 5 && len(res) != 9 {
+		return "", fmt.Errorf("invalid zip code '%s'", zip)
+	}
+	for _, c := range res {
+		if c < '0' || c > '9' {
+			return "", fmt.Errorf("invalid zip code '%s'", zip)
+		}
+	}
+	return res, nil
+}
+
+// ZipBounds returns the 9 digits bounds of the given zip range. A 5 digits
+// zip code covers all its zip+4 codes.
+func ZipBounds(from, to string) (string, string, error) {
+	lo, err := NormalizeZip(from)
+	if err != nil {
+		return "", "", err
+	}
+	if strings.TrimSpace(to) == "" {
+		to = from
+	}
+	hi, err := NormalizeZip(to)
+	if err != nil {
+		return "", "", err
+	}
+	if len(lo) == 5 {
+		lo += "0000"
+	}
+	if len(hi) == 5 {
+		hi += "9999"
+	}
+	if hi < lo {
+		return "", "", fmt.Errorf("zip range %s-%s is empty", from, to)
+	}
+	return lo, hi, nil
+}
+
+// ReadTable reads the rate table in CSV format from r.
+//
+// It returns an error if a line is invalid, or if the same jurisdiction has
+// different rates in overlapping periods.
+func ReadTable(r io.Reader) ([]Rate, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("unable to read header: %s", err)
+	}
+	columns := make(map[string]int)
+	for i, col := range header {
+		columns[strings.ToLower(strings.TrimSpace(col))] = i
+	}
+	for _, col := range requiredColumns {
+		if _, ok := columns[col]; !ok {
+			return nil, fmt.Errorf("missing column %s", col)
+		}
+	}
+	var res []Rate
+	for lineNum := 2; ; lineNum++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		get := func(col string) string {
+			idx, ok := columns[col]
+			if !ok || idx >= len(record) {
+				return ""
+			}
+			return strings.TrimSpace(record[idx])
+		}
+		rate, err := parseRate(get)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", lineNum, err)
+		}
+		res = append(res, rate)
+	}
+	if err := checkRates(res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// parseRate returns the Rate of a table line, given a function returning the value of a column
+func parseRate(get func(string) string) (Rate, error) {
+	var (
+		res Rate
+		err error
+	)
+	res.ZipFrom, res.ZipTo, err = ZipBounds(get("zip_from"), get("zip_to"))
+	if err != nil {
+		return res, err
+	}
+	res.Code, res.Name, res.State = get("code"), get("name"), get("state")
+	if res.Code == "" || res.Name == "" {
+		return res, fmt.Errorf("jurisdiction code and name are required")
+	}
+	res.Level = strings.ToLower(get("level"))
+	if LevelIndex(res.Level) < 0 {
+		return res, fmt.Errorf("invalid level '%s', must be one of %s", res.Level, strings.Join(Levels, ", "))
+	}
+	res.Rate, err = strconv.ParseFloat(get("rate"), 64)
+	if err != nil || res.Rate < 0 || res.Rate >= 100 {
+		return res, fmt.Errorf("invalid rate '%s'", get("rate"))
+	}
+	res.DateFrom, err = time.Parse(dateLayout, get("date_from"))
+	if err != nil {
+		return res, fmt.Errorf("invalid start date '%s'", get("date_from"))
+	}
+	if dateTo := get("date_to"); dateTo != "" {
+		res.DateTo, err = time.Parse(dateLayout, dateTo)
+		if err != nil {
+			return res, fmt.Errorf("invalid end date '%s'", dateTo)
+		}
+		if res.DateTo.Before(res.DateFrom) {
+			return res, fmt.Errorf("end date %s is before start date %s", dateTo, get("date_from"))
+		}
+	}
+	return res, nil
+}
+
+// checkRates returns an error if a jurisdiction has different rates in overlapping periods
+func checkRates(rates []Rate) error {
+	byCode := make(map[string][]Rate)
+	for _, r := range rates {
+		byCode[r.Code] = append(byCode[r.Code], r)
+	}
+	for code, codeRates := range byCode {
+		for i, r1 := range codeRates {
+			for _, r2 := range codeRates[i+1:] {
+				if r1.Rate == r2.Rate || !overlap(r1, r2) {
+					continue
+				}
+				return fmt.Errorf("jurisdiction %s has rates %g and %g in overlapping periods", code, r1.Rate, r2.Rate)
+			}
+		}
+	}
+	return nil
+}
+
+// overlap returns true if the effective periods of r1 and r2 overlap
+func overlap(r1, r2 Rate) bool {
+	return (r2.DateTo.IsZero() || !r1.DateFrom.After(r2.DateTo)) &&
+		(r1.DateTo.IsZero() || !r2.DateFrom.After(r1.DateTo))
+}
+
+// LevelIndex returns the index of the given level in Levels, or -1 if it is unknown
+func LevelIndex(level string) int {
+	for i, l := range Levels {
+		if l == level {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,75 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package jurisdiction
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+const testTable = `zip_from,zip_to,code,name,level,state,rate,date_from,date_to
+90001,96162,CA,California,state,CA,6.0,2020-01-01,
+90001,90899,LAC,Los Angeles County,county,CA,0.25,2020-01-01,
+90001,90899,LAD,LA County District,district,CA,2.25,2020-01-01,2020-12-31
+90001,90899,LAD,LA County District,district,CA,2.5,2021-01-01,
+90210-0000,90210-4999,BH,Beverly Hills,city,CA,0.5,2020-01-01,
+`
+
+func TestJurisdictionTable(t *testing.T) {
+	Convey("Testing jurisdiction rate tables", t, func() {
+		date := func(s string) time.Time {
+			d, err := time.Parse(dateLayout, s)
+			So(err, ShouldBeNil)
+			return d
+		}
+		Convey("Zip codes are normalized", func() {
+			zip, err := NormalizeZip(" 90210-1234 ")
+			So(err, ShouldBeNil)
+			So(zip, ShouldEqual, "902101234")
+			zip, err = NormalizeZip("90210")
+			So(err, ShouldBeNil)
+			So(zip, ShouldEqual, "90210")
+			_, err = NormalizeZip("9021")
+			So(err, ShouldNotBeNil)
+			_, err = NormalizeZip("9021A")
+			So(err, ShouldNotBeNil)
+			lo, hi, err := ZipBounds("90001", "")
+			So(err, ShouldBeNil)
+			So(lo, ShouldEqual, "900010000")
+			So(hi, ShouldEqual, "900019999")
+			_, _, err = ZipBounds("90002", "90001")
+			So(err, ShouldNotBeNil)
+		})
+		Convey("Tables are read", func() {
+			rates, err := ReadTable(strings.NewReader(testTable))
+			So(err, ShouldBeNil)
+			So(rates, ShouldHaveLength, 5)
+			So(rates[0].Code, ShouldEqual, "CA")
+			So(rates[0].ZipFrom, ShouldEqual, "900010000")
+			So(rates[0].ZipTo, ShouldEqual, "961629999")
+			So(rates[0].DateTo.IsZero(), ShouldBeTrue)
+			So(rates[2].Level, ShouldEqual, "district")
+			So(rates[2].Rate, ShouldEqual, 2.25)
+			So(rates[2].DateTo.Equal(date("2020-12-31")), ShouldBeTrue)
+			So(rates[3].DateFrom.Equal(date("2021-01-01")), ShouldBeTrue)
+			So(rates[4].ZipFrom, ShouldEqual, "902100000")
+			So(rates[4].ZipTo, ShouldEqual, "902104999")
+			So(rates[4].State, ShouldEqual, "CA")
+		})
+		Convey("Invalid tables are rejected", func() {
+			_, err := ReadTable(strings.NewReader("zip_from,code,name,level,rate\n90001,CA,California,state,6\n"))
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "date_from")
+			_, err = ReadTable(strings.NewReader("zip_from,code,name,level,rate,date_from\n90001,CA,California,country,6,2020-01-01\n"))
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "line 2")
+			_, err = ReadTable(strings.NewReader("zip_from,code,name,level,rate,date_from\n90001,CA,California,state,6,2020-01-01\n90002,CA,California,state,7,2020-06-01\n"))
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "overlapping")
+		})
+	})
+}
@@ -0,0 +1,457 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+// Package localization reads and validates chart of accounts localization packages.
+//
+// A localization package is a directory or a zip archive of CSV files, one per
+// template model, named after the model (e.g. AccountAccountTemplate.csv). Each
+// file has a header line with the field names of the model and an ID column
+// holding the external ID of the record. Relational columns hold external IDs,
+// separated by '|' for many2many fields. They may reference records of the
+// package or records which already exist in the database.
+//
+// The Manifest.csv file is required and has a single line with the Code, Name,
+// Version, Country and Description of the package. Versions are dot separated
+// numbers such as 2.1.0.
+package localization
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ManifestFile is the name of the metadata file of a package
+const ManifestFile = "Manifest.csv"
+
+// A RowError is an error on a line of a package file
+type RowError struct {
+	File string
+	// Line is the line number in the file, or 0 if the error is about the whole file
+	Line int
+	// Column is the name of the faulty column, if any
+	Column string
+	Msg    string
+}
+
+// Error returns the error message with its location
+func (e RowError) Error() string {
+	var loc string
+	switch {
+	case e.Line > 0 && e.Column != "":
+		loc = fmt.Sprintf("%s:%d: %s: ", e.File, e.Line, e.Column)
+	case e.Line > 0:
+		loc = fmt.Sprintf("%s:%d: ", e.File, e.Line)
+	case e.File != "":
+		loc = fmt.Sprintf("%s: ", e.File)
+	}
+	return loc + e.Msg
+}
+
+// Errors is the list of errors of a package
+type Errors []RowError
+
+// Error returns the messages of all errors, one per line
+func (e Errors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// A Row is a line of a package file
+type Row struct {
+	Line   int
+	Values map[string]string
+}
+
+// Get returns the value of the given column, or an empty string if the column is not set
+func (r Row) Get(col string) string {
+	return r.Values[col]
+}
+
+// ID returns the external ID of the record of this row
+func (r Row) ID() string {
+	return r.Values["ID"]
+}
+
+// Bool returns the value of the given boolean column
+func (r Row) Bool(col string) bool {
+	val, _ := strconv.ParseBool(r.Get(col))
+	return val
+}
+
+// BoolOr returns the value of the given boolean column, or def if it is not set
+func (r Row) BoolOr(col string, def bool) bool {
+	if r.Get(col) == "" {
+		return def
+	}
+	return r.Bool(col)
+}
+
+// Int returns the value of the given integer column
+func (r Row) Int(col string) int64 {
+	val, _ := strconv.ParseInt(r.Get(col), 10, 64)
+	return val
+}
+
+// Float returns the value of the given float column
+func (r Row) Float(col string) float64 {
+	val, _ := strconv.ParseFloat(r.Get(col), 64)
+	return val
+}
+
+// List returns the external IDs of the given many2many column
+func (r Row) List(col string) []string {
+	var res []string
+	for _, id := range strings.Split(r.Get(col), "|") {
+		if id = strings.TrimSpace(id); id != "" {
+			res = append(res, id)
+		}
+	}
+	return res
+}
+
+// A Package is a localization package
+type Package struct {
+	Code        string
+	Name        string
+	Version     string
+	Country     string
+	Description string
+	// Rows holds the rows of each template model of the package
+	Rows map[string][]Row
+}
+
+// Open reads the localization package at the given path, which may be
+// a directory or a zip archive.
+func Open(pkgPath string) (*Package, error) {
+	info, err := os.Stat(pkgPath)
+	if err != nil {
+		return nil, err
+	}
+	files := make(map[string][]byte)
+	if info.IsDir() {
+		entries, err := ioutil.ReadDir(pkgPath)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			if entry.IsDir() || !strings.EqualFold(filepath.Ext(entry.Name()), ".csv") {
+				continue
+			}
+			content, err := ioutil.ReadFile(filepath.Join(pkgPath, entry.Name()))
+			if err != nil {
+				return nil, err
+			}
+			files[entry.Name()] = content
+		}
+		return Read(files)
+	}
+	archive, err := zip.OpenReader(pkgPath)
+	if err != nil {
+		return nil, err
+	}
+	defer archive.Close()
+	for _, f := range archive.File {
+		name := path.Base(f.Name)
+		if f.FileInfo().IsDir() || !strings.EqualFold(path.Ext(name), ".csv") {
+			continue
+		}
+		if _, exists := files[name]; exists {
+			return nil, fmt.Errorf("file %s is present twice in archive", name)
+		}
+		r, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		content, err := ioutil.ReadAll(r)
+		r.Close()
+		if err != nil {
+			return nil, err
+		}
+		files[name] = content
+	}
+	return Read(files)
+}
+
+// Read returns the package made of the given CSV files, given by name.
+//
+// It checks the manifest, the columns of each file and the format of the values.
+// References are checked by Validate. The returned error is of type Errors.
+func Read(files map[string][]byte) (*Package, error) {
+	pkg := &Package{Rows: make(map[string][]Row)}
+	var errs Errors
+	manifest, ok := files[ManifestFile]
+	if !ok {
+		return nil, Errors{{File: ManifestFile, Msg: "missing package manifest"}}
+	}
+	errs = append(errs, pkg.readManifest(manifest)...)
+	for name, content := range files {
+		if name == ManifestFile {
+			continue
+		}
+		model := strings.TrimSuffix(name, filepath.Ext(name))
+		spec := specByModel(model)
+		if spec == nil {
+			errs = append(errs, RowError{File: name, Msg: "unknown template model " + model})
+			continue
+		}
+		rows, fileErrs := readFile(name, content, spec)
+		errs = append(errs, fileErrs...)
+		pkg.Rows[model] = rows
+	}
+	if len(pkg.Rows[ChartTemplateModel]) == 0 {
+		errs = append(errs, RowError{File: ChartTemplateModel + ".csv", Msg: "a package must define at least one chart template"})
+	}
+	if len(errs) > 0 {
+		errs.sort()
+		return nil, errs
+	}
+	return pkg, nil
+}
+
+// readManifest reads the given manifest file into pkg
+func (pkg *Package) readManifest(content []byte) Errors {
+	rows, err := readCSV(content)
+	if err != nil {
+		return Errors{{File: ManifestFile, Msg: err.Error()}}
+	}
+	if len(rows) != 2 {
+		return Errors{{File: ManifestFile, Msg: "manifest must have a header and exactly one line"}}
+	}
+	values := make(map[string]string)
+	for i, col := range rows[0] {
+		if i < len(rows[1]) {
+			values[strings.TrimSpace(col)] = strings.TrimSpace(rows[1][i])
+		}
+	}
+	pkg.Code, pkg.Name, pkg.Version = values["Code"], values["Name"], values["Version"]
+	pkg.Country, pkg.Description = values["Country"], values["Description"]
+	var errs Errors
+	for _, col := range []string{"Code", "Name", "Version"} {
+		if values[col] == "" {
+			errs = append(errs, RowError{File: ManifestFile, Line: 2, Column: col, Msg: "value is required"})
+		}
+	}
+	if pkg.Version != "" {
+		if _, err := parseVersion(pkg.Version); err != nil {
+			errs = append(errs, RowError{File: ManifestFile, Line: 2, Column: "Version", Msg: err.Error()})
+		}
+	}
+	return errs
+}
+
+// readCSV returns the records of the given CSV content
+func readCSV(content []byte) ([][]string, error) {
+	reader := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(content, []byte("\xef\xbb\xbf"))))
+	reader.TrimLeadingSpace = true
+	reader.FieldsPerRecord = -1
+	var res [][]string
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return res, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, record)
+	}
+}
+
+// readFile returns the rows of the given file of the given model, and the errors
+// found in its columns and values.
+func readFile(name string, content []byte, spec *modelSpec) ([]Row, Errors) {
+	records, err := readCSV(content)
+	if err != nil {
+		return nil, Errors{{File: name, Msg: err.Error()}}
+	}
+	if len(records) == 0 {
+		return nil, Errors{{File: name, Msg: "missing header line"}}
+	}
+	var errs Errors
+	header := records[0]
+	for i, col := range header {
+		header[i] = strings.TrimSpace(col)
+		if spec.column(header[i]) == nil {
+			errs = append(errs, RowError{File: name, Line: 1, Column: header[i], Msg: "unknown column"})
+		}
+	}
+	for _, col := range spec.columns {
+		if col.required && !contains(header, col.name) {
+			errs = append(errs, RowError{File: name, Line: 1, Column: col.name, Msg: "missing required column"})
+		}
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	var rows []Row
+	for i, record := range records[1:] {
+		row := Row{Line: i + 2, Values: make(map[string]string)}
+		if len(record) != len(header) {
+			errs = append(errs, RowError{File: name, Line: row.Line,
+				Msg: fmt.Sprintf("line has %d values instead of %d", len(record), len(header))})
+			continue
+		}
+		for j, col := range header {
+			row.Values[col] = strings.TrimSpace(record[j])
+		}
+		for _, col := range spec.columns {
+			if err := col.check(row.Get(col.name)); err != nil {
+				errs = append(errs, RowError{File: name, Line: row.Line, Column: col.name, Msg: err.Error()})
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows, errs
+}
+
+// contains returns true if list contains s
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate checks the IDs and the references of the package. References which do not
+// point to a record of the package are checked with the given exists function, which
+// must return true if a record of the given model with the given external ID exists.
+func (pkg *Package) Validate(exists func(model, id string) bool) Errors {
+	var errs Errors
+	ids := make(map[string]map[string]bool)
+	for _, spec := range specs {
+		ids[spec.model] = make(map[string]bool)
+		for _, row := range pkg.Rows[spec.model] {
+			if ids[spec.model][row.ID()] {
+				errs = append(errs, RowError{File: spec.file(), Line: row.Line, Column: "ID",
+					Msg: fmt.Sprintf("duplicate ID %s", row.ID())})
+			}
+			ids[spec.model][row.ID()] = true
+		}
+	}
+	for _, spec := range specs {
+		for _, row := range pkg.Rows[spec.model] {
+			for _, col := range spec.columns {
+				if col.kind != kindRef && col.kind != kindRefs {
+					continue
+				}
+				for _, id := range row.List(col.name) {
+					if ids[col.model][id] || exists(col.model, id) {
+						continue
+					}
+					errs = append(errs, RowError{File: spec.file(), Line: row.Line, Column: col.name,
+						Msg: fmt.Sprintf("unknown %s %s", col.model, id)})
+				}
+			}
+		}
+	}
+	for _, row := range pkg.Rows[TaxTemplateModel] {
+		if row.Get("AmountType") != "group" && len(row.List("ChildrenTaxes")) > 0 {
+			errs = append(errs, RowError{File: TaxTemplateModel + ".csv", Line: row.Line, Column: "ChildrenTaxes",
+				Msg: "only taxes of type group may have children taxes"})
+		}
+	}
+	if _, err := pkg.ChartTemplates(); err != nil {
+		errs = append(errs, err.(RowError))
+	}
+	errs.sort()
+	return errs
+}
+
+// ChartTemplates returns the chart templates of the package, parents first.
+//
+// It returns an error if the parents of a chart template form a loop.
+func (pkg *Package) ChartTemplates() ([]Row, error) {
+	charts := pkg.Rows[ChartTemplateModel]
+	byID := make(map[string]Row)
+	for _, row := range charts {
+		byID[row.ID()] = row
+	}
+	var res []Row
+	done := make(map[string]bool)
+	for _, row := range charts {
+		var chain []Row
+		visiting := make(map[string]bool)
+		for cur, ok := row, true; ok && !done[cur.ID()]; cur, ok = byID[cur.Get("Parent")] {
+			if visiting[cur.ID()] {
+				return nil, RowError{File: ChartTemplateModel + ".csv", Line: cur.Line, Column: "Parent",
+					Msg: fmt.Sprintf("chart template %s is its own ancestor", cur.ID())}
+			}
+			visiting[cur.ID()] = true
+			chain = append(chain, cur)
+		}
+		for i := len(chain) - 1; i >= 0; i-- {
+			done[chain[i].ID()] = true
+			res = append(res, chain[i])
+		}
+	}
+	return res, nil
+}
+
+// sort sorts the errors by file and line
+func (e Errors) sort() {
+	sort.SliceStable(e, func(i, j int) bool {
+		if e[i].File != e[j].File {
+			return e[i].File < e[j].File
+		}
+		return e[i].Line < e[j].Line
+	})
+}
+
+// parseVersion returns the numbers of the given dot separated version
+func parseVersion(version string) ([]int, error) {
+	var res []int
+	for _, part := range strings.Split(version, ".") {
+		num, err := strconv.Atoi(part)
+		if err != nil || num < 0 {
+			return nil, fmt.Errorf("invalid version '%s'", version)
+		}
+		res = append(res, num)
+	}
+	return res, nil
+}
+
+// CompareVersions returns -1, 0 or 1 if version v1 is respectively lower than,
+// equal to or greater than version v2. Invalid versions are lower than valid ones.
+func CompareVersions(v1, v2 string) int {
+	n1, err1 := parseVersion(v1)
+	n2, err2 := parseVersion(v2)
+	switch {
+	case err1 != nil && err2 != nil:
+		return 0
+	case err1 != nil:
+		return -1
+	case err2 != nil:
+		return 1
+	}
+	for i := 0; i < len(n1) || i < len(n2); i++ {
+		var a, b int
+		if i < len(n1) {
+			a = n1[i]
+		}
+		if i < len(n2) {
+			b = n2[i]
+		}
+		switch {
+		case a < b:
+			return -1
+		case a > b:
+			return 1
+		}
+	}
+	return 0
+}
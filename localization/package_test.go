@@ -0,0 +1,106 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package localization
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// testFiles returns the files of a small valid package
+func testFiles() map[string][]byte {
+	return map[string][]byte{
+		"Manifest.csv": []byte("Code,Name,Version,Country\nl10n_test,Test Chart,1.2.0,FR\n"),
+		"AccountChartTemplate.csv": []byte(`ID,Name,Parent,Currency,TransferAccount,PropertyAccountReceivable
+test_chart_base,Base Chart,,base_EUR,test_transfer,test_receivable
+test_chart,Test Chart,test_chart_base,base_EUR,test_transfer,
+`),
+		"AccountAccountTemplate.csv": []byte(`ID,ChartTemplate,Code,Name,UserType,Reconcile,Tags
+test_transfer,test_chart_base,580000,Transfers,account_data_account_type_current_assets,true,
+test_receivable,test_chart_base,411000,Customers,account_data_account_type_receivable,true,test_tag
+test_vat,test_chart,445710,VAT Collected,account_data_account_type_current_liabilities,,
+`),
+		"AccountAccountTag.csv": []byte("ID,Name,Applicability\ntest_tag,Test Tag,accounts\n"),
+		"AccountTaxTemplate.csv": []byte(`ID,ChartTemplate,Name,TypeTaxUse,AmountType,Amount,Account
+test_tax_20,test_chart,VAT 20%,sale,percent,20,test_vat
+`),
+	}
+}
+
+// existing simulates the records of the database
+func existing(model, id string) bool {
+	switch model {
+	case "Currency":
+		return id == "base_EUR"
+	case "AccountAccountType":
+		return id == "account_data_account_type_current_assets" ||
+			id == "account_data_account_type_receivable" ||
+			id == "account_data_account_type_current_liabilities"
+	}
+	return false
+}
+
+func TestLocalizationPackage(t *testing.T) {
+	Convey("Testing localization packages", t, func() {
+		Convey("A valid package is read and validated", func() {
+			pkg, err := Read(testFiles())
+			So(err, ShouldBeNil)
+			So(pkg.Code, ShouldEqual, "l10n_test")
+			So(pkg.Version, ShouldEqual, "1.2.0")
+			So(pkg.Rows[AccountTemplateModel], ShouldHaveLength, 3)
+			So(pkg.Validate(existing), ShouldBeEmpty)
+			charts, err := pkg.ChartTemplates()
+			So(err, ShouldBeNil)
+			So(charts, ShouldHaveLength, 2)
+			So(charts[0].ID(), ShouldEqual, "test_chart_base")
+			So(charts[1].Get("Parent"), ShouldEqual, "test_chart_base")
+			So(pkg.Rows[AccountTemplateModel][1].List("Tags"), ShouldResemble, []string{"test_tag"})
+			So(pkg.Rows[AccountTemplateModel][0].Bool("Reconcile"), ShouldBeTrue)
+		})
+		Convey("Invalid rows are reported with their location", func() {
+			files := testFiles()
+			files["AccountTaxTemplate.csv"] = []byte(`ID,ChartTemplate,Name,TypeTaxUse,AmountType,Amount
+test_tax_20,test_chart,VAT 20%,sales,percent,20
+test_tax_10,test_chart,VAT 10%,sale,percent,ten
+`)
+			_, err := Read(files)
+			So(err, ShouldNotBeNil)
+			errs := err.(Errors)
+			So(errs, ShouldHaveLength, 2)
+			So(errs[0].Line, ShouldEqual, 2)
+			So(errs[0].Column, ShouldEqual, "TypeTaxUse")
+			So(errs[1].Error(), ShouldStartWith, "AccountTaxTemplate.csv:3: Amount: ")
+		})
+		Convey("Unknown references and loops are reported", func() {
+			files := testFiles()
+			files["AccountChartTemplate.csv"] = []byte(`ID,Name,Parent,Currency,TransferAccount
+test_chart_base,Base Chart,test_chart,base_USD,test_transfer
+test_chart,Test Chart,test_chart_base,base_EUR,test_transfer
+`)
+			pkg, err := Read(files)
+			So(err, ShouldBeNil)
+			errs := pkg.Validate(existing)
+			So(errs, ShouldHaveLength, 2)
+			So(errs[0].Msg, ShouldEqual, "unknown Currency base_USD")
+			So(errs[1].Msg, ShouldContainSubstring, "own ancestor")
+		})
+		Convey("Files and columns must be known and the manifest is required", func() {
+			files := testFiles()
+			files["AccountJournal.csv"] = []byte("ID,Name\n")
+			files["AccountAccountTag.csv"] = []byte("ID,Name,Colour\ntest_tag,Test Tag,1\n")
+			_, err := Read(files)
+			So(err, ShouldNotBeNil)
+			So(err.(Errors), ShouldHaveLength, 2)
+			delete(files, "Manifest.csv")
+			_, err = Read(files)
+			So(err.Error(), ShouldEqual, "Manifest.csv: missing package manifest")
+		})
+		Convey("Versions are compared numerically", func() {
+			So(CompareVersions("1.10.0", "1.9"), ShouldEqual, 1)
+			So(CompareVersions("2.0", "2.0.0"), ShouldEqual, 0)
+			So(CompareVersions("1.2", "1.2.1"), ShouldEqual, -1)
+		})
+	})
+}
@@ -0,0 +1,232 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package localization
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Template models of a package
+const (
+	AccountTagModel             = "AccountAccountTag"
+	TaxGroupModel               = "AccountTaxGroup"
+	AccountTemplateModel        = "AccountAccountTemplate"
+	ChartTemplateModel          = "AccountChartTemplate"
+	TaxTemplateModel            = "AccountTaxTemplate"
+	FiscalPositionTemplateModel = "AccountFiscalPositionTemplate"
+	FiscalPositionTaxModel      = "AccountFiscalPositionTaxTemplate"
+	FiscalPositionAccountModel  = "AccountFiscalPositionAccountTemplate"
+	ReconcileModelTemplateModel = "AccountReconcileModelTemplate"
+	accountTypeModel            = "AccountAccountType"
+	currencyModel               = "Currency"
+)
+
+// kind is the type of the values of a column
+type kind int
+
+const (
+	kindText kind = iota
+	kindBool
+	kindInt
+	kindFloat
+	kindSelection
+	kindRef
+	kindRefs
+)
+
+// columnSpec describes a column of a package file
+type columnSpec struct {
+	name     string
+	kind     kind
+	required bool
+	// model is the referenced model of kindRef and kindRefs columns
+	model string
+	// values are the allowed values of kindSelection columns
+	values []string
+}
+
+// check returns an error if the given value is not valid for this column
+func (c columnSpec) check(value string) error {
+	if value == "" {
+		if c.required {
+			return fmt.Errorf("value is required")
+		}
+		return nil
+	}
+	var err error
+	switch c.kind {
+	case kindBool:
+		_, err = strconv.ParseBool(value)
+	case kindInt:
+		_, err = strconv.ParseInt(value, 10, 64)
+	case kindFloat:
+		_, err = strconv.ParseFloat(value, 64)
+	case kindSelection:
+		if !contains(c.values, value) {
+			return fmt.Errorf("invalid value '%s', must be one of %s", value, strings.Join(c.values, ", "))
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("invalid value '%s'", value)
+	}
+	return nil
+}
+
+// modelSpec describes the file of a template model
+type modelSpec struct {
+	model   string
+	columns []columnSpec
+}
+
+// file returns the file name of this model
+func (s modelSpec) file() string {
+	return s.model + ".csv"
+}
+
+// column returns the spec of the given column, or nil if this model has no such column
+func (s *modelSpec) column(name string) *columnSpec {
+	for i := range s.columns {
+		if s.columns[i].name == name {
+			return &s.columns[i]
+		}
+	}
+	return nil
+}
+
+// specByModel returns the spec of the given template model, or nil if it is unknown
+func specByModel(model string) *modelSpec {
+	for i := range specs {
+		if specs[i].model == model {
+			return &specs[i]
+		}
+	}
+	return nil
+}
+
+// Column helpers
+func id() columnSpec                    { return columnSpec{name: "ID", required: true} }
+func text(name string) columnSpec       { return columnSpec{name: name} }
+func boolean(name string) columnSpec    { return columnSpec{name: name, kind: kindBool} }
+func integer(name string) columnSpec    { return columnSpec{name: name, kind: kindInt} }
+func float(name string) columnSpec      { return columnSpec{name: name, kind: kindFloat} }
+func ref(name, model string) columnSpec { return columnSpec{name: name, kind: kindRef, model: model} }
+func refs(name, model string) columnSpec {
+	return columnSpec{name: name, kind: kindRefs, model: model}
+}
+func selection(name string, values ...string) columnSpec {
+	return columnSpec{name: name, kind: kindSelection, values: values}
+}
+func required(c columnSpec) columnSpec {
+	c.required = true
+	return c
+}
+
+// specs lists the template models of a package in the order they must be loaded
+var specs = []modelSpec{
+	{model: AccountTagModel, columns: []columnSpec{
+		id(),
+		required(text("Name")),
+		selection("Applicability", "accounts", "taxes"),
+		integer("Color"),
+	}},
+	{model: TaxGroupModel, columns: []columnSpec{
+		id(),
+		required(text("Name")),
+		integer("Sequence"),
+	}},
+	{model: AccountTemplateModel, columns: []columnSpec{
+		id(),
+		required(ref("ChartTemplate", ChartTemplateModel)),
+		required(text("Code")),
+		required(text("Name")),
+		required(ref("UserType", accountTypeModel)),
+		boolean("Reconcile"),
+		ref("Currency", currencyModel),
+		refs("Tags", AccountTagModel),
+		refs("Taxes", TaxTemplateModel),
+		boolean("Nocreate"),
+		text("Note"),
+	}},
+	{model: ChartTemplateModel, columns: []columnSpec{
+		id(),
+		required(text("Name")),
+		ref("Parent", ChartTemplateModel),
+		integer("CodeDigits"),
+		required(ref("Currency", currencyModel)),
+		boolean("Visible"),
+		boolean("UseAngloSaxon"),
+		boolean("CompleteTaxSet"),
+		text("BankAccountCodePrefix"),
+		text("CashAccountCodePrefix"),
+		required(ref("TransferAccount", AccountTemplateModel)),
+		ref("IncomeCurrencyExchangeAccount", AccountTemplateModel),
+		ref("ExpenseCurrencyExchangeAccount", AccountTemplateModel),
+		ref("PropertyAccountReceivable", AccountTemplateModel),
+		ref("PropertyAccountPayable", AccountTemplateModel),
+		ref("PropertyAccountExpenseCateg", AccountTemplateModel),
+		ref("PropertyAccountIncomeCateg", AccountTemplateModel),
+		ref("PropertyAccountExpense", AccountTemplateModel),
+		ref("PropertyAccountIncome", AccountTemplateModel),
+		ref("PropertyStockAccountInputCateg", AccountTemplateModel),
+		ref("PropertyStockAccountOutputCateg", AccountTemplateModel),
+		ref("PropertyStockValuationAccount", AccountTemplateModel),
+	}},
+	{model: TaxTemplateModel, columns: []columnSpec{
+		id(),
+		required(ref("ChartTemplate", ChartTemplateModel)),
+		required(text("Name")),
+		text("Description"),
+		required(selection("TypeTaxUse", "sale", "purchase", "none")),
+		selection("AmountType", "group", "fixed", "percent", "division"),
+		required(float("Amount")),
+		integer("Sequence"),
+		ref("Account", AccountTemplateModel),
+		ref("RefundAccount", AccountTemplateModel),
+		boolean("PriceInclude"),
+		boolean("IncludeBaseAmount"),
+		boolean("Analytic"),
+		boolean("TaxAdjustment"),
+		boolean("Active"),
+		refs("Tags", AccountTagModel),
+		ref("TaxGroup", TaxGroupModel),
+		refs("ChildrenTaxes", TaxTemplateModel),
+	}},
+	{model: FiscalPositionTemplateModel, columns: []columnSpec{
+		id(),
+		required(ref("ChartTemplate", ChartTemplateModel)),
+		required(text("Name")),
+		text("Note"),
+	}},
+	{model: FiscalPositionTaxModel, columns: []columnSpec{
+		id(),
+		required(ref("Position", FiscalPositionTemplateModel)),
+		required(ref("TaxSrc", TaxTemplateModel)),
+		ref("TaxDest", TaxTemplateModel),
+	}},
+	{model: FiscalPositionAccountModel, columns: []columnSpec{
+		id(),
+		required(ref("Position", FiscalPositionTemplateModel)),
+		required(ref("AccountSrc", AccountTemplateModel)),
+		required(ref("AccountDest", AccountTemplateModel)),
+	}},
+	{model: ReconcileModelTemplateModel, columns: []columnSpec{
+		id(),
+		required(ref("ChartTemplate", ChartTemplateModel)),
+		required(text("Name")),
+		integer("Sequence"),
+		ref("Account", AccountTemplateModel),
+		text("Label"),
+		selection("AmountType", "fixed", "percentage"),
+		float("Amount"),
+		ref("Tax", TaxTemplateModel),
+		boolean("HasSecondLine"),
+		ref("SecondAccount", AccountTemplateModel),
+		text("SecondLabel"),
+		selection("SecondAmountType", "fixed", "percentage"),
+		float("SecondAmount"),
+		ref("SecondTax", TaxTemplateModel),
+	}},
+}
@@ -94,15 +94,22 @@ func init() {
 		})
 
 	h.AccountFiscalPosition().Methods().MapTax().DeclareMethod(
-		`MapTax`,
+		`MapTax returns the taxes to apply instead of the given taxes according to this fiscal position.
+
+		Taxes and mappings are resolved at the date given by the 'fiscal_position_date' key
+		of the context, or today if it is not set.`,
 		func(rs m.AccountFiscalPositionSet, taxes m.AccountTaxSet, product m.ProductProductSet,
 			partner m.PartnerSet) m.AccountTaxSet {
 
+			date := rs.Env().Context().GetDate("fiscal_position_date")
 			result := h.AccountTax().NewSet(rs.Env())
-			for _, tax := range taxes.Records() {
+			for _, tax := range taxes.AtDate(date).Records() {
 				taxCount := 0
 				for _, t := range rs.Taxes().Records() {
-					if t.TaxSrc().Equals(tax) {
+					if !t.IsEffective(date) {
+						continue
+					}
+					if t.TaxSrc().Equals(tax) || t.TaxSrc().AtDate(date).Equals(tax) {
 						taxCount++
 						if t.TaxDest().IsNotEmpty() {
 							result = result.Union(t.TaxDest())
@@ -113,14 +120,16 @@ func init() {
 					result = result.Union(tax)
 				}
 			}
-			return result
+			return result.AtDate(date)
 		})
 
 	h.AccountFiscalPosition().Methods().MapAccount().DeclareMethod(
-		`MapAccount`,
+		`MapAccount returns the account to use instead of the given account according to this fiscal position,
+		at the date given by the 'fiscal_position_date' key of the context, or today if it is not set.`,
 		func(rs m.AccountFiscalPositionSet, account m.AccountAccountSet) m.AccountAccountSet {
+			date := rs.Env().Context().GetDate("fiscal_position_date")
 			for _, pos := range rs.Accounts().Records() {
-				if pos.AccountSrc().Equals(account) {
+				if pos.AccountSrc().Equals(account) && pos.IsEffective(date) {
 					return pos.AccountDest()
 				}
 			}
@@ -128,11 +137,16 @@ func init() {
 		})
 
 	h.AccountFiscalPosition().Methods().MapAccounts().DeclareMethod(
-		`MapAccounts Receive a dictionary having accounts in values and try to replace those accounts accordingly to the fiscal position.`,
+		`MapAccounts Receive a dictionary having accounts in values and try to replace those accounts accordingly to the fiscal position.
+		Mappings are resolved at the date given by the 'fiscal_position_date' key of the context, or today if it is not set.`,
 		func(rs m.AccountFiscalPositionSet, accounts map[string]m.AccountAccountSet) map[string]m.AccountAccountSet {
 
+			date := rs.Env().Context().GetDate("fiscal_position_date")
 			refDict := make(map[int64]m.AccountAccountSet)
 			for _, line := range rs.Accounts().Records() {
+				if !line.IsEffective(date) {
+					continue
+				}
 				refDict[line.AccountSrc().ID()] = line.AccountDest()
 			}
 			for key, acc := range accounts {
@@ -250,9 +264,10 @@ func init() {
 				return val
 			}
 
-			// First search only matching VAT positions
+			// First search only matching VAT positions. Invalid VAT numbers are ignored,
+			// unless the company does not check VAT numbers.
 			vatRequired := false
-			if partner.VAT() != "" {
+			if partner.VAT() != "" && (partner.VATCheckMode() == "none" || partner.VATIsValid()) {
 				vatRequired = true
 			}
 			fp := rs.GetFposByRegion(delivery.Country(), delivery.State(), delivery.Zip(), vatRequired)
@@ -281,6 +296,14 @@ func init() {
 		"TaxDest": models.Many2OneField{
 			String:        "Tax to Apply",
 			RelationModel: h.AccountTax()},
+		"DateFrom": models.DateField{
+			String:     "Valid From",
+			Constraint: h.AccountFiscalPositionTax().Methods().CheckDates(),
+			Help:       "If set, this mapping only applies to documents dated on or after this day"},
+		"DateTo": models.DateField{
+			String:     "Valid Until",
+			Constraint: h.AccountFiscalPositionTax().Methods().CheckDates(),
+			Help:       "If set, this mapping only applies to documents dated on or before this day"},
 	})
 
 	h.AccountFiscalPositionTax().AddSQLConstraint("tax_src_dest_uniq",
@@ -292,6 +315,24 @@ func init() {
 			return rs.Position().DisplayName()
 		})
 
+	h.AccountFiscalPositionTax().Methods().CheckDates().DeclareMethod(
+		`CheckDates checks that the validity period of the mapping is consistent`,
+		func(rs m.AccountFiscalPositionTaxSet) {
+			if !rs.DateFrom().IsZero() && !rs.DateTo().IsZero() && rs.DateTo().Lower(rs.DateFrom()) {
+				panic(rs.T(`The end of validity of a tax mapping must be after its start.`))
+			}
+		})
+
+	h.AccountFiscalPositionTax().Methods().IsEffective().DeclareMethod(
+		`IsEffective returns true if this mapping applies at the given date (today if zero)`,
+		func(rs m.AccountFiscalPositionTaxSet, date dates.Date) bool {
+			if date.IsZero() {
+				date = dates.Today()
+			}
+			return (rs.DateFrom().IsZero() || date.GreaterEqual(rs.DateFrom())) &&
+				(rs.DateTo().IsZero() || date.LowerEqual(rs.DateTo()))
+		})
+
 	h.AccountFiscalPositionAccount().DeclareModel()
 	h.AccountFiscalPositionAccount().AddFields(map[string]models.FieldDefinition{
 		"Position": models.Many2OneField{
@@ -309,6 +350,14 @@ func init() {
 			RelationModel: h.AccountAccount(),
 			Filter:        q.AccountAccount().Deprecated().Equals(false),
 			Required:      true},
+		"DateFrom": models.DateField{
+			String:     "Valid From",
+			Constraint: h.AccountFiscalPositionAccount().Methods().CheckDates(),
+			Help:       "If set, this mapping only applies to documents dated on or after this day"},
+		"DateTo": models.DateField{
+			String:     "Valid Until",
+			Constraint: h.AccountFiscalPositionAccount().Methods().CheckDates(),
+			Help:       "If set, this mapping only applies to documents dated on or before this day"},
 	})
 
 	h.AccountFiscalPositionAccount().AddSQLConstraint("account_src_dest_uniq",
@@ -320,6 +369,24 @@ func init() {
 			return rs.Position().DisplayName()
 		})
 
+	h.AccountFiscalPositionAccount().Methods().CheckDates().DeclareMethod(
+		`CheckDates checks that the validity period of the mapping is consistent`,
+		func(rs m.AccountFiscalPositionAccountSet) {
+			if !rs.DateFrom().IsZero() && !rs.DateTo().IsZero() && rs.DateTo().Lower(rs.DateFrom()) {
+				panic(rs.T(`The end of validity of an account mapping must be after its start.`))
+			}
+		})
+
+	h.AccountFiscalPositionAccount().Methods().IsEffective().DeclareMethod(
+		`IsEffective returns true if this mapping applies at the given date (today if zero)`,
+		func(rs m.AccountFiscalPositionAccountSet, date dates.Date) bool {
+			if date.IsZero() {
+				date = dates.Today()
+			}
+			return (rs.DateFrom().IsZero() || date.GreaterEqual(rs.DateFrom())) &&
+				(rs.DateTo().IsZero() || date.LowerEqual(rs.DateTo()))
+		})
+
 	h.Partner().AddFields(map[string]models.FieldDefinition{
 		"Credit": models.FloatField{
 			String:  "Total Receivable",
@@ -147,6 +147,7 @@ to value expenses for the current product.`,
 				SetInvoice(h.AccountInvoice().BrowseOne(rs.Env(), line.InvoiceID)).
 				SetTaxes(h.AccountTax().Browse(rs.Env(), line.TaxIDs)).
 				SetTaxLine(h.AccountTax().BrowseOne(rs.Env(), line.TaxLineID)).
+				SetTaxRepartitionLine(h.AccountTaxRepartitionLine().BrowseOne(rs.Env(), line.TaxRepartitionLineID)).
 				SetAnalyticTags(h.AccountAnalyticTag().Browse(rs.Env(), line.AnalyticTagsIDs))
 			return res
 		})
@@ -275,42 +275,29 @@ func init() {
 		})
 
 	h.ReportAccountReportAgedpartnerbalance().Methods().RenderHtml().DeclareMethod(
-		`RenderHtml`,
-		func(rs m.ReportAccountReportAgedpartnerbalanceSet, args struct {
-			Docids interface{}
-			Data   interface{}
-		}) {
-			//@api.model
-			/*def render_html(self, docids, data=None):
-			  if not data.get('form') or not self.env.context.get('active_model') or not self.env.context.get('active_id'):
-			      raise UserError(_("Form content is missing, this report cannot be printed."))
-
-			  total = []
-			  model = self.env.context.get('active_model')
-			  docs = self.env[model].browse(self.env.context.get('active_id'))
-
-			  target_move = data['form'].get('target_move', 'all')
-			  date_from = data['form'].get('date_from', time.strftime('%Y-%m-%d'))
-
-			  if data['form']['result_selection'] == 'customer':
-			      account_type = ['receivable']
-			  elif data['form']['result_selection'] == 'supplier':
-			      account_type = ['payable']
-			  else:
-			      account_type = ['payable', 'receivable']
-
-			  movelines, total, dummy = self._get_partner_move_lines(account_type, date_from, target_move, data['form']['period_length'])
-			  docargs = {
-			      'doc_ids': self.ids,
-			      'doc_model': model,
-			      'data': data['form'],
-			      'docs': docs,
-			      'time': time,
-			      'get_partner_lines': movelines,
-			      'get_direction': total,
-			  }
-			  return self.env['report'].render('account.report_agedpartnerbalance', docargs)
-			*/
+		`RenderHtml computes the aged balance of the partners selected by the given data.
+		Amounts are translated into the currency of the user with the rate type of the wizard.`,
+		func(rs m.ReportAccountReportAgedpartnerbalanceSet, data accounttypes.ReportData) accounttypes.ReportValues {
+			accountType := []string{"payable", "receivable"}
+			switch data.Form.ResultSelection {
+			case "customer":
+				accountType = []string{"receivable"}
+			case "supplier":
+				accountType = []string{"payable"}
+			}
+			dateFrom := data.Form.DateFrom
+			if dateFrom.IsZero() {
+				dateFrom = dates.Today()
+			}
+			if data.Form.UsedContext != nil && data.Form.UsedContext.HasKey("rate_type") {
+				rs = rs.WithContext("rate_type", data.Form.UsedContext.GetString("rate_type"))
+			}
+			moveLines, total, _ := rs.GetPartnerMoveLines(accountType, dateFrom, data.Form.TargetMove, data.Form.PeriodLength)
+			return accounttypes.ReportValues{
+				Data:     data,
+				Partners: moveLines,
+				Totals:   total,
+			}
 		})
 
 }
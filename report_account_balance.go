@@ -4,98 +4,86 @@
 package account
 
 import (
+	"github.com/hexya-addons/account/accounttypes"
+	"github.com/hexya-erp/hexya/src/models"
+	"github.com/hexya-erp/hexya/src/tools/strutils"
 	"github.com/hexya-erp/pool/h"
 	"github.com/hexya-erp/pool/m"
+	"github.com/hexya-erp/pool/q"
 )
 
 func init() {
 
 	h.ReportAccountReportTrialbalance().DeclareTransientModel()
 	h.ReportAccountReportTrialbalance().Methods().GetAccounts().DeclareMethod(
-		`GetAccounts`,
-		func(rs m.ReportAccountReportTrialbalanceSet, args struct {
-			Accounts       interface{}
-			DisplayAccount interface{}
-		}) {
-			/*def _get_accounts(self, accounts, display_account):
-			  """ compute the balance, debit and credit for the provided accounts
-			      :Arguments:
-			          `accounts`: list of accounts record,
-			          `display_account`: it's used to display either all accounts or those accounts which balance is > 0
-			      :Returns a list of dictionary of Accounts with following key and value
-			          `name`: Account name,
-			          `code`: Account code,
-			          `credit`: total amount of credit,
-			          `debit`: total amount of debit,
-			          `balance`: total amount of balance,
-			  """
+		`GetAccounts computes the balance, debit and credit of the given accounts over the journal items
+		selected by the context. displayAccount is used to display either all accounts, those with
+		movements or those with a non zero balance. If hierarchy is true, the lines are grouped by
+		account group with subtotals.`,
+		func(rs m.ReportAccountReportTrialbalanceSet, accounts m.AccountAccountSet, displayAccount string,
+			hierarchy bool) []accounttypes.AccountReportLine {
 
-			  account_result = {}
-			  # Prepare sql query base on selected parameters from wizard
-			  tables, where_clause, where_params = self.env['account.move.line']._query_get()
-			  tables = tables.replace('"','')
-			  if not tables:
-			      tables = 'account_move_line'
-			  wheres = [""]
-			  if where_clause.strip():
-			      wheres.append(where_clause.strip())
-			  filters = " AND ".join(wheres)
-			  # compute the balance, debit and credit for the provided accounts
-			  request = ("SELECT account_id AS id, SUM(debit) AS debit, SUM(credit) AS credit, (SUM(debit) - SUM(credit)) AS balance" +\
-			             " FROM " + tables + " WHERE account_id IN %s " + filters + " GROUP BY account_id")
-			  params = (tuple(accounts.ids),) + tuple(where_params)
-			  self.env.cr.execute(request, params)
-			  for row in self.env.cr.dictfetchall():
-			      account_result[row.pop('id')] = row
+			debits := make(map[int64]float64)
+			credits := make(map[int64]float64)
+			condition := h.AccountMoveLine().NewSet(rs.Env()).QueryGetCondition(q.AccountMoveLine().Account().In(accounts))
+			aggs := h.AccountMoveLine().Search(rs.Env(), condition).
+				GroupBy(h.AccountMoveLine().Fields().Account()).
+				Aggregates(
+					h.AccountMoveLine().Fields().Account(),
+					h.AccountMoveLine().Fields().Debit(),
+					h.AccountMoveLine().Fields().Credit())
+			for _, agg := range aggs {
+				debits[agg.Values().Account().ID()] = agg.Values().Debit()
+				credits[agg.Values().Account().ID()] = agg.Values().Credit()
+			}
 
-			  account_res = []
-			  for account in accounts:
-			      res = dict((fn, 0.0) for fn in ['credit', 'debit', 'balance'])
-			      currency = account.currency_id and account.currency_id or account.company_id.currency_id
-			      res['code'] = account.code
-			      res['name'] = account.name
-			      if account.id in account_result.keys():
-			          res['debit'] = account_result[account.id].get('debit')
-			          res['credit'] = account_result[account.id].get('credit')
-			          res['balance'] = account_result[account.id].get('balance')
-			      if display_account == 'all':
-			          account_res.append(res)
-			      if display_account == 'not_zero' and not currency.is_zero(res['balance']):
-			          account_res.append(res)
-			      if display_account == 'movement' and (not currency.is_zero(res['debit']) or not currency.is_zero(res['credit'])):
-			          account_res.append(res)
-			  return account_res
-
-
-			*/
+			var res []accounttypes.AccountReportLine
+			for _, account := range accounts.Records() {
+				currency := account.Currency()
+				if currency.IsEmpty() {
+					currency = account.Company().Currency()
+				}
+				line := accounttypes.AccountReportLine{
+					AccountID: account.ID(),
+					Code:      account.Code(),
+					Name:      account.Name(),
+					Debit:     debits[account.ID()],
+					Credit:    credits[account.ID()],
+					Balance:   debits[account.ID()] - credits[account.ID()],
+				}
+				switch {
+				case displayAccount == "not_zero" && currency.IsZero(line.Balance):
+					continue
+				case displayAccount == "movement" && currency.IsZero(line.Debit) && currency.IsZero(line.Credit):
+					continue
+				}
+				res = append(res, line)
+			}
+			if hierarchy {
+				res = h.AccountGroup().NewSet(rs.Env()).HierarchyLines(res)
+			}
+			return res
 		})
-	h.ReportAccountReportTrialbalance().Methods().RenderHtml().DeclareMethod(
-		`RenderHtml`,
-		func(rs m.ReportAccountReportTrialbalanceSet, args struct {
-			Docids interface{}
-			Data   interface{}
-		}) {
-			//@api.model
-			/*def render_html(self, docids, data=None):
-			  if not data.get('form') or not self.env.context.get('active_model'):
-			      raise UserError(_("Form content is missing, this report cannot be printed."))
-
-			  self.model = self.env.context.get('active_model')
-			  docs = self.env[self.model].browse(self.env.context.get('active_ids', []))
-			  display_account = data['form'].get('display_account')
-			  accounts = docs if self.model == 'account.account' else self.env['account.account'].search([])
-			  account_res = self.with_context(data['form'].get('used_context'))._get_accounts(accounts, display_account)
 
-			  docargs = {
-			      'doc_ids': self.ids,
-			      'doc_model': self.model,
-			      'data': data['form'],
-			      'docs': docs,
-			      'time': time,
-			      'Accounts': account_res,
-			  }
-			  return self.env['report'].render('account.report_trialbalance', docargs)
-			*/
+	h.ReportAccountReportTrialbalance().Methods().RenderHtml().DeclareMethod(
+		`RenderHtml computes the trial balance of the accounts selected by the given data`,
+		func(rs m.ReportAccountReportTrialbalanceSet, data accounttypes.ReportData) accounttypes.ReportValues {
+			accounts := reportedAccounts(rs.Env(), data)
+			lines := rs.WithNewContext(data.Form.UsedContext).GetAccounts(accounts, data.Form.DisplayAccount, data.Form.Hierarchy)
+			return accounttypes.ReportValues{
+				Data:     data,
+				Accounts: lines,
+			}
 		})
 
 }
+
+// reportedAccounts returns the accounts printed by an account report with the given data,
+// that is the active accounts if the wizard was launched from accounts, or all the accounts
+// of the company of the wizard otherwise.
+func reportedAccounts(env models.Environment, data accounttypes.ReportData) m.AccountAccountSet {
+	if strutils.IsIn(data.Model, "account.account", "AccountAccount") {
+		return h.AccountAccount().Browse(env, data.IDs)
+	}
+	return h.AccountAccount().Search(env, q.AccountAccount().Company().Equals(h.Company().BrowseOne(env, data.Form.CompanyID)))
+}
@@ -8,62 +8,67 @@ import (
 	"github.com/hexya-erp/hexya/src/models/types"
 	"github.com/hexya-erp/pool/h"
 	"github.com/hexya-erp/pool/m"
+	"github.com/hexya-erp/pool/q"
 )
 
 func init() {
 
 	h.AccountFinancialReport().DeclareModel()
 	h.AccountFinancialReport().Methods().GetLevel().DeclareMethod(
-		`GetLevel`,
+		`GetLevel computes the level of this record in the tree structure of the reports`,
 		func(rs m.AccountFinancialReportSet) m.AccountFinancialReportData {
-			//@api.depends('parent_id','parent_id.level')
-			/*def _get_level(self):
-			  '''Returns a dictionary with key=the ID of a record and value = the level of this
-			     record in the tree structure.'''
-			  for report in self:
-			      level = 0
-			      if report.parent_id:
-			          level = report.parent_id.level + 1
-			      report.level = level
-
-			*/
-			return h.AccountFinancialReport().NewData()
+			var level int64
+			if rs.Parent().IsNotEmpty() {
+				level = rs.Parent().Level() + 1
+			}
+			return h.AccountFinancialReport().NewData().SetLevel(level)
 		})
 
 	h.AccountFinancialReport().Methods().GetChildrenByOrder().DeclareMethod(
-		`GetChildrenByOrder`,
-		func(rs m.AccountFinancialReportSet) {
-			/*def _get_children_by_order(self):
-			    '''returns a recordset of all the children computed recursively, and sorted by sequence. Ready for the printing'''
-			    res = self
-			    children = self.search([('parent_id', 'in', self.ids)], order='sequence ASC')
-			    if children:
-			        for child in children:
-			            res += child._get_children_by_order()
-			    return res
-
-			name = */
+		`GetChildrenByOrder returns these reports followed by all their children computed recursively
+		and sorted by sequence, ready for printing.`,
+		func(rs m.AccountFinancialReportSet) m.AccountFinancialReportSet {
+			var ids []int64
+			for _, report := range rs.Records() {
+				ids = append(ids, report.ID())
+				children := h.AccountFinancialReport().Search(rs.Env(), q.AccountFinancialReport().Parent().Equals(report)).
+					OrderBy("Sequence")
+				for _, child := range children.Records() {
+					ids = append(ids, child.GetChildrenByOrder().Ids()...)
+				}
+			}
+			return h.AccountFinancialReport().Browse(rs.Env(), ids)
 		})
+
 	h.AccountFinancialReport().AddFields(map[string]models.FieldDefinition{
 		"Name":      models.CharField{String: "Report Name" /*['Report Name']*/, Required: true, Translate: true},
 		"Parent":    models.Many2OneField{String: "Parent", RelationModel: h.AccountFinancialReport(), JSON: "parent_id" /*['account.financial.report']*/ /*['Parent']*/},
 		"Childrens": models.One2ManyField{String: "Account Report", RelationModel: h.AccountFinancialReport(), ReverseFK: "Parent", JSON: "children_ids" /*['account.financial.report']*/ /*[ 'parent_id']*/ /*['Account Report']*/},
 		"Sequence":  models.IntegerField{String: "Sequence')" /*['Sequence']*/},
-		"Level":     models.IntegerField{String: "Level", Compute: h.AccountFinancialReport().Methods().GetLevel() /*[ string 'Level']*/ /*[ store True]*/},
+		"Level":     models.IntegerField{String: "Level", Compute: h.AccountFinancialReport().Methods().GetLevel(), Depends: []string{"Parent", "Parent.Level"} /*[ string 'Level']*/ /*[ store True]*/},
 		"Type": models.SelectionField{String: "Type", Selection: types.Selection{
 			"sum":            "View",
 			"accounts":       "Accounts",
 			"account_type":   "Account Type",
 			"account_report": "Report Value",
+			"account_groups": "Account Groups",
 			/*[ ('sum', 'View'  ('accounts', 'Accounts'  ('account_type', 'Account Type'  ('account_report', 'Report Value'  ]*/}, /*[]*/ /*['Type']*/ Default: models.DefaultValue("sum")},
 		"Accounts":      models.Many2ManyField{String: "account_account_financial_report", RelationModel: h.AccountAccount(), JSON: "account_ids" /*['account.account']*/ /*['account_account_financial_report']*/ /*[ 'report_line_id']*/ /*[ 'account_id']*/ /*[ 'Accounts']*/},
 		"AccountReport": models.Many2OneField{String: "Report Value", RelationModel: h.AccountFinancialReport(), JSON: "account_report_id" /*['account.financial.report']*/ /*['Report Value']*/},
 		"AccountTypes":  models.Many2ManyField{String: "account_account_financial_report_type", RelationModel: h.AccountAccountType(), JSON: "account_type_ids" /*['account.account.type']*/ /*['account_account_financial_report_type']*/ /*[ 'report_id']*/ /*[ 'account_type_id']*/ /*[ 'Account Types']*/},
+		"AccountGroups": models.Many2ManyField{
+			RelationModel: h.AccountGroup(),
+			JSON:          "account_group_ids",
+			Help:          "The accounts of these groups and of their sub-groups are reported in this line"},
 		"Sign": models.SelectionField{String: "Sign on Reports", Selection: types.Selection{
 			"-1": "Reverse balance sign",
 			"1":  "Preserve balance sign",
 		}, /*[]*/ /*['Sign on Reports']*/ Required: true, Default: models.DefaultValue("1"), Help: "For accounts that are typically more debited than credited and that you would like to print as negative amounts in your reports" /*[ you should reverse the sign of the balance; e.g.: Expense account. The same applies for accounts that are typically more credited than debited and that you would like to print as positive amounts in your reports; e.g.: Income account.']*/},
-		"DisplayDetail": models.SelectionField{ /*display_detail = fields.Selection([ ('no_detail', 'No detail'), ('detail_flat', 'Display children flat'), ('detail_with_hierarchy', 'Display children with hierarchy')*/ },
+		"DisplayDetail": models.SelectionField{String: "Display details", Selection: types.Selection{
+			"no_detail":             "No detail",
+			"detail_flat":           "Display children flat",
+			"detail_with_hierarchy": "Display children with hierarchy",
+		}, Default: models.DefaultValue("detail_flat")},
 		"StyleOverwrite": models.SelectionField{String: "Financial Report Style", Selection: types.Selection{
 			"0": "Automatic formatting",
 			"1": "Main Title 1 (bold underlined)",
@@ -75,4 +80,19 @@ func init() {
 			/*[ (0, 'Automatic formatting'  (1, 'Main Title 1 (bold, underlined)'  (2, 'Title 2 (bold)'  (3, 'Title 3 (bold, smaller)'  (4, 'Normal Text'  (5, 'Italic Text (smaller)'  (6, 'Smallest Text'  ]*/}, /*[]*/ /*['Financial Report Style']*/ Default: models.DefaultValue("0"), Help: "You can set up here the format you want this record to be displayed. If you leave the automatic formatting" /*[ it will be computed based on the financial reports hierarchy (auto-computed field 'level')."]*/},
 	})
 
+	h.AccountFinancialReport().Methods().ReportAccounts().DeclareMethod(
+		`ReportAccounts returns the accounts whose balance is reported in this line, depending on its type.
+		It returns an empty set for view and report value lines.`,
+		func(rs m.AccountFinancialReportSet) m.AccountAccountSet {
+			switch rs.Type() {
+			case "accounts":
+				return rs.Accounts()
+			case "account_type":
+				return h.AccountAccount().Search(rs.Env(), q.AccountAccount().UserType().In(rs.AccountTypes()))
+			case "account_groups":
+				return h.AccountAccount().Search(rs.Env(), q.AccountAccount().Group().In(rs.AccountGroups().WithDescendants()))
+			}
+			return h.AccountAccount().NewSet(rs.Env())
+		})
+
 }
@@ -8,6 +8,7 @@ import (
 
 	"github.com/hexya-erp/hexya/src/models"
 	"github.com/hexya-erp/hexya/src/models/types"
+	"github.com/hexya-erp/hexya/src/models/types/dates"
 	"github.com/hexya-erp/hexya/src/tools/nbutils"
 	"github.com/hexya-erp/pool/h"
 	"github.com/hexya-erp/pool/m"
@@ -18,26 +19,23 @@ func init() {
 
 	h.AccountInvoiceReport().DeclareManualModel()
 	h.AccountInvoiceReport().Methods().ComputeAmountsInUserCurrency().DeclareMethod(
-		`ComputeAmountsInUserCurrency`,
+		`ComputeAmountsInUserCurrency computes the amounts in the currency of the company of the user.
+		Amounts are translated at the rate of the invoice date, of the type given by the 'rate_type'
+		key of the context (spot rates by default).`,
 		func(rs m.AccountInvoiceReportSet) m.AccountInvoiceReportData {
-			//@api.depends('currency_id','date','price_total','price_average','residual')
-			/*def _compute_amounts_in_user_currency(self):
-			  """Compute the amounts in the currency of the user
-			  """
-			  context = dict(self._context or {})
-			  user_currency_id = self.env.user.company_id.currency_id
-			  currency_rate_id = self.env['res.currency.rate'].search([
-			      ('rate', '=', 1),
-			      '|', ('company_id', '=', self.env.user.company_id.id), ('company_id', '=', False)], limit=1)
-			  base_currency_id = currency_rate_id.currency_id
-			  ctx = context.copy()
-			  for record in self:
-			      ctx['date'] = record.date
-			      record.user_currency_price_total = base_currency_id.with_context(ctx).compute(record.price_total, user_currency_id)
-			      record.user_currency_price_average = base_currency_id.with_context(ctx).compute(record.price_average, user_currency_id)
-			      record.user_currency_residual = base_currency_id.with_context(ctx).compute(record.residual, user_currency_id)
-			*/
-			return h.AccountInvoiceReport().NewData()
+			userCompany := h.User().NewSet(rs.Env()).CurrentUser().Company()
+			userCurrency := userCompany.Currency()
+			date := rs.Date()
+			if date.IsZero() {
+				date = dates.Today()
+			}
+			currency := rs.Company().Currency().
+				WithContext("date", date).
+				WithContext("company_id", userCompany.ID())
+			return h.AccountInvoiceReport().NewData().
+				SetUserCurrencyPriceTotal(currency.Compute(rs.PriceTotal(), userCurrency, true)).
+				SetUserCurrencyPriceAverage(currency.Compute(rs.PriceAverage(), userCurrency, true)).
+				SetUserCurrencyResidual(currency.Compute(rs.Residual(), userCurrency, true))
 		})
 	h.AccountInvoiceReport().AddFields(map[string]models.FieldDefinition{
 		"Date":       models.DateField{String: "Date", ReadOnly: true},
@@ -4,193 +4,182 @@
 package account
 
 import (
+	"sort"
+	"strconv"
+
+	"github.com/hexya-addons/account/accounttypes"
 	"github.com/hexya-erp/pool/h"
 	"github.com/hexya-erp/pool/m"
+	"github.com/hexya-erp/pool/q"
 )
 
 func init() {
 
 	h.ReportAccountReportFinancial().DeclareTransientModel()
 	h.ReportAccountReportFinancial().Methods().ComputeAccountBalance().DeclareMethod(
-		`ComputeAccountBalance`,
-		func(rs m.ReportAccountReportFinancialSet, args struct {
-			Accounts interface{}
-		}) {
-			/*def _compute_account_balance(self, accounts):
-			  """ compute the balance, debit and credit for the provided accounts
-			  """
-			  mapping = {
-			      'balance': "COALESCE(SUM(debit),0) - COALESCE(SUM(credit), 0) as balance",
-			      'debit': "COALESCE(SUM(debit), 0) as debit",
-			      'credit': "COALESCE(SUM(credit), 0) as credit",
-			  }
-
-			  res = {}
-			  for account in accounts:
-			      res[account.id] = dict((fn, 0.0) for fn in mapping.keys())
-			  if accounts:
-			      tables, where_clause, where_params = self.env['account.move.line']._query_get()
-			      tables = tables.replace('"', '') if tables else "account_move_line"
-			      wheres = [""]
-			      if where_clause.strip():
-			          wheres.append(where_clause.strip())
-			      filters = " AND ".join(wheres)
-			      request = "SELECT account_id as id, " + ', '.join(mapping.values()) + \
-			                 " FROM " + tables + \
-			                 " WHERE account_id IN %s " \
-			                      + filters + \
-			                 " GROUP BY account_id"
-			      params = (tuple(accounts._ids),) + tuple(where_params)
-			      self.env.cr.execute(request, params)
-			      for row in self.env.cr.dictfetchall():
-			          res[row['id']] = row
-			  return res
-
-			*/
+		`ComputeAccountBalance computes the balance, debit and credit of the given accounts
+		over the journal items selected by the context`,
+		func(rs m.ReportAccountReportFinancialSet, accounts m.AccountAccountSet) map[int64]accounttypes.ReportBalance {
+			res := make(map[int64]accounttypes.ReportBalance)
+			for _, account := range accounts.Records() {
+				res[account.ID()] = accounttypes.ReportBalance{}
+			}
+			if accounts.IsEmpty() {
+				return res
+			}
+			condition := h.AccountMoveLine().NewSet(rs.Env()).QueryGetCondition(q.AccountMoveLine().Account().In(accounts))
+			aggs := h.AccountMoveLine().Search(rs.Env(), condition).
+				GroupBy(h.AccountMoveLine().Fields().Account()).
+				Aggregates(
+					h.AccountMoveLine().Fields().Account(),
+					h.AccountMoveLine().Fields().Debit(),
+					h.AccountMoveLine().Fields().Credit())
+			for _, agg := range aggs {
+				res[agg.Values().Account().ID()] = accounttypes.ReportBalance{
+					Debit:   agg.Values().Debit(),
+					Credit:  agg.Values().Credit(),
+					Balance: agg.Values().Debit() - agg.Values().Credit(),
+				}
+			}
+			return res
 		})
+
 	h.ReportAccountReportFinancial().Methods().ComputeReportBalance().DeclareMethod(
-		`ComputeReportBalance`,
-		func(rs m.ReportAccountReportFinancialSet, args struct {
-			Reports interface{}
-		}) {
-			/*def _compute_report_balance(self, reports):
-			  '''returns a dictionary with key=the ID of a record and value=the credit, debit and balance amount
-			     computed for this record. If the record is of type :
-			         'accounts' : it's the sum of the linked accounts
-			         'account_type' : it's the sum of leaf accoutns with such an account_type
-			         'account_report' : it's the amount of the related report
-			         'sum' : it's the sum of the children of this record (aka a 'view' record)'''
-			  res = {}
-			  fields = ['credit', 'debit', 'balance']
-			  for report in reports:
-			      if report.id in res:
-			          continue
-			      res[report.id] = dict((fn, 0.0) for fn in fields)
-			      if report.type == 'accounts':
-			          # it's the sum of the linked accounts
-			          res[report.id]['account'] = self._compute_account_balance(report.account_ids)
-			          for value in res[report.id]['account'].values():
-			              for field in fields:
-			                  res[report.id][field] += value.get(field)
-			      elif report.type == 'account_type':
-			          # it's the sum the leaf accounts with such an account type
-			          accounts = self.env['account.account'].search([('user_type_id', 'in', report.account_type_ids.ids)])
-			          res[report.id]['account'] = self._compute_account_balance(accounts)
-			          for value in res[report.id]['account'].values():
-			              for field in fields:
-			                  res[report.id][field] += value.get(field)
-			      elif report.type == 'account_report' and report.account_report_id:
-			          # it's the amount of the linked report
-			          res2 = self._compute_report_balance(report.account_report_id)
-			          for key, value in res2.items():
-			              for field in fields:
-			                  res[report.id][field] += value[field]
-			      elif report.type == 'sum':
-			          # it's the sum of the children of this account.report
-			          res2 = self._compute_report_balance(report.children_ids)
-			          for key, value in res2.items():
-			              for field in fields:
-			                  res[report.id][field] += value[field]
-			  return res
+		`ComputeReportBalance returns the credit, debit and balance of each of the given reports,
+		mapped by report ID. Depending on the type of the report, it is:
 
-			*/
+		- the sum of the accounts of the report for 'accounts', 'account_type' and 'account_groups'
+		  reports. In this case, the balances of the accounts are also returned.
+		- the amount of the related report for 'account_report' reports.
+		- the sum of the children of the report for 'sum' reports.`,
+		func(rs m.ReportAccountReportFinancialSet, reports m.AccountFinancialReportSet) map[int64]accounttypes.ReportBalance {
+			res := make(map[int64]accounttypes.ReportBalance)
+			for _, report := range reports.Records() {
+				if _, exists := res[report.ID()]; exists {
+					continue
+				}
+				var (
+					balance accounttypes.ReportBalance
+					res2    map[int64]accounttypes.ReportBalance
+				)
+				switch report.Type() {
+				case "accounts", "account_type", "account_groups":
+					balance.Accounts = rs.ComputeAccountBalance(report.ReportAccounts())
+					res2 = balance.Accounts
+				case "account_report":
+					if report.AccountReport().IsNotEmpty() {
+						res2 = rs.ComputeReportBalance(report.AccountReport())
+					}
+				case "sum":
+					res2 = rs.ComputeReportBalance(report.Childrens())
+				}
+				for _, value := range res2 {
+					balance.Debit += value.Debit
+					balance.Credit += value.Credit
+					balance.Balance += value.Balance
+				}
+				res[report.ID()] = balance
+			}
+			return res
 		})
-	h.ReportAccountReportFinancial().Methods().GetAccountLines().DeclareMethod(
-		`GetAccountLines`,
-		func(rs m.ReportAccountReportFinancialSet, args struct {
-			Data interface{}
-		}) {
-			/*def get_account_lines(self, data):
-			  lines = []
-			  account_report = self.env['account.financial.report'].search([('id', '=', data['account_report_id'][0])])
-			  child_reports = account_report._get_children_by_order()
-			  res = self.with_context(data.get('used_context'))._compute_report_balance(child_reports)
-			  if data['enable_filter']:
-			      comparison_res = self.with_context(data.get('comparison_context'))._compute_report_balance(child_reports)
-			      for report_id, value in comparison_res.items():
-			          res[report_id]['comp_bal'] = value['balance']
-			          report_acc = res[report_id].get('account')
-			          if report_acc:
-			              for account_id, val in comparison_res[report_id].get('account').items():
-			                  report_acc[account_id]['comp_bal'] = val['balance']
 
-			  for report in child_reports:
-			      vals = {
-			          'name': report.name,
-			          'balance': res[report.id]['balance'] * report.sign,
-			          'type': 'report',
-			          'level': bool(report.style_overwrite) and report.style_overwrite or report.level,
-			          'account_type': report.type or False, #used to underline the financial report balances
-			      }
-			      if data['debit_credit']:
-			          vals['debit'] = res[report.id]['debit']
-			          vals['credit'] = res[report.id]['credit']
-
-			      if data['enable_filter']:
-			          vals['balance_cmp'] = res[report.id]['comp_bal'] * report.sign
-
-			      lines.append(vals)
-			      if report.display_detail == 'no_detail':
-			          #the rest of the loop is used to display the details of the financial report, so it's not needed here.
-			          continue
+	h.ReportAccountReportFinancial().Methods().GetAccountLines().DeclareMethod(
+		`GetAccountLines returns the lines of the financial report of the given form,
+		followed each by the lines of its accounts if it has details.`,
+		func(rs m.ReportAccountReportFinancialSet, form accounttypes.ReportForm) []accounttypes.FinancialReportLine {
+			var lines []accounttypes.FinancialReportLine
+			accountReport := h.AccountFinancialReport().BrowseOne(rs.Env(), form.AccountReportID)
+			childReports := accountReport.GetChildrenByOrder()
+			res := rs.WithNewContext(form.UsedContext).ComputeReportBalance(childReports)
+			if form.EnableFilter {
+				comparisonRes := rs.WithNewContext(form.ComparisonContext).ComputeReportBalance(childReports)
+				for reportID, value := range comparisonRes {
+					reportRes := res[reportID]
+					reportRes.CompBalance = value.Balance
+					for accountID, val := range value.Accounts {
+						accountRes := reportRes.Accounts[accountID]
+						accountRes.CompBalance = val.Balance
+						reportRes.Accounts[accountID] = accountRes
+					}
+					res[reportID] = reportRes
+				}
+			}
 
-			      if res[report.id].get('account'):
-			          sub_lines = []
-			          for account_id, value in res[report.id]['account'].items():
-			              #if there are accounts to display, we add them to the lines with a level equals to their level in
-			              #the COA + 1 (to avoid having them with a too low level that would conflicts with the level of data
-			              #financial reports for Assets, liabilities...)
-			              flag = False
-			              account = self.env['account.account'].browse(account_id)
-			              vals = {
-			                  'name': account.code + ' ' + account.name,
-			                  'balance': value['balance'] * report.sign or 0.0,
-			                  'type': 'account',
-			                  'level': report.display_detail == 'detail_with_hierarchy' and 4,
-			                  'account_type': account.internal_type,
-			              }
-			              if data['debit_credit']:
-			                  vals['debit'] = value['debit']
-			                  vals['credit'] = value['credit']
-			                  if not account.company_id.currency_id.is_zero(vals['debit']) or not account.company_id.currency_id.is_zero(vals['credit']):
-			                      flag = True
-			              if not account.company_id.currency_id.is_zero(vals['balance']):
-			                  flag = True
-			              if data['enable_filter']:
-			                  vals['balance_cmp'] = value['comp_bal'] * report.sign
-			                  if not account.company_id.currency_id.is_zero(vals['balance_cmp']):
-			                      flag = True
-			              if flag:
-			                  sub_lines.append(vals)
-			          lines += sorted(sub_lines, key=lambda sub_line: sub_line['name'])
-			  return lines
+			for _, report := range childReports.Records() {
+				sign := 1.0
+				if report.Sign() == "-1" {
+					sign = -1
+				}
+				level := int(report.Level())
+				if style, _ := strconv.Atoi(report.StyleOverwrite()); style != 0 {
+					level = style
+				}
+				vals := accounttypes.FinancialReportLine{
+					Name:        report.Name(),
+					Balance:     res[report.ID()].Balance * sign,
+					Type:        "report",
+					Level:       level,
+					AccountType: report.Type(),
+				}
+				if form.DebitCredit {
+					vals.Debit = res[report.ID()].Debit
+					vals.Credit = res[report.ID()].Credit
+				}
+				if form.EnableFilter {
+					vals.BalanceCmp = res[report.ID()].CompBalance * sign
+				}
+				lines = append(lines, vals)
+				if report.DisplayDetail() == "no_detail" {
+					// the rest of the loop is used to display the details of the financial report,
+					// so it's not needed here.
+					continue
+				}
 
-			*/
+				var subLines []accounttypes.FinancialReportLine
+				for accountID, value := range res[report.ID()].Accounts {
+					// if there are accounts to display, we add them to the lines with a level equals
+					// to 4 to avoid having them with a too low level that would conflict with the level
+					// of data financial reports for Assets, liabilities...
+					account := h.AccountAccount().BrowseOne(rs.Env(), accountID)
+					currency := account.Company().Currency()
+					vals := accounttypes.FinancialReportLine{
+						Name:        account.Code() + " " + account.Name(),
+						Balance:     value.Balance * sign,
+						Type:        "account",
+						AccountType: account.InternalType(),
+					}
+					if report.DisplayDetail() == "detail_with_hierarchy" {
+						vals.Level = 4
+					}
+					flag := !currency.IsZero(vals.Balance)
+					if form.DebitCredit {
+						vals.Debit = value.Debit
+						vals.Credit = value.Credit
+						flag = flag || !currency.IsZero(vals.Debit) || !currency.IsZero(vals.Credit)
+					}
+					if form.EnableFilter {
+						vals.BalanceCmp = value.CompBalance * sign
+						flag = flag || !currency.IsZero(vals.BalanceCmp)
+					}
+					if flag {
+						subLines = append(subLines, vals)
+					}
+				}
+				sort.Slice(subLines, func(i, j int) bool {
+					return subLines[i].Name < subLines[j].Name
+				})
+				lines = append(lines, subLines...)
+			}
+			return lines
 		})
-	h.ReportAccountReportFinancial().Methods().RenderHtml().DeclareMethod(
-		`RenderHtml`,
-		func(rs m.ReportAccountReportFinancialSet, args struct {
-			Docids interface{}
-			Data   interface{}
-		}) {
-			//@api.model
-			/*def render_html(self, docids, data=None):
-			  if not data.get('form') or not self.env.context.get('active_model') or not self.env.context.get('active_id'):
-			      raise UserError(_("Form content is missing, this report cannot be printed."))
 
-			  self.model = self.env.context.get('active_model')
-			  docs = self.env[self.model].browse(self.env.context.get('active_id'))
-			  report_lines = self.get_account_lines(data.get('form'))
-			  docargs = {
-			      'doc_ids': self.ids,
-			      'doc_model': self.model,
-			      'data': data['form'],
-			      'docs': docs,
-			      'time': time,
-			      'get_account_lines': report_lines,
-			  }
-			  return self.env['report'].render('account.report_financial', docargs)
-			*/
+	h.ReportAccountReportFinancial().Methods().RenderHtml().DeclareMethod(
+		`RenderHtml computes the lines of the financial report selected by the given data`,
+		func(rs m.ReportAccountReportFinancialSet, data accounttypes.ReportData) accounttypes.ReportValues {
+			return accounttypes.ReportValues{
+				Data:           data,
+				FinancialLines: rs.GetAccountLines(data.Form),
+			}
 		})
 
 }
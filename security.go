@@ -103,4 +103,49 @@ func init() {
 	h.AccountTaxGroup().Methods().Load().AllowGroup(base.GroupUser)
 	h.AccountTaxGroup().Methods().Load().AllowGroup(GroupAccountInvoice)
 	h.AccountTaxGroup().Methods().AllowAllToGroup(GroupAccountManager)
+	h.AccountAuditLog().Methods().Load().AllowGroup(GroupAccountUser)
+	h.AccountAuditLog().Methods().Load().AllowGroup(GroupAccountManager)
+	h.AccountAuditLogExport().Methods().AllowAllToGroup(GroupAccountManager)
+	h.AccountJournalApprovalRule().Methods().Load().AllowGroup(GroupAccountUser)
+	h.AccountJournalApprovalRule().Methods().AllowAllToGroup(GroupAccountManager)
+	h.AccountMoveReject().Methods().AllowAllToGroup(GroupAccountManager)
+	h.AccountRecurringEntry().Methods().AllowAllToGroup(GroupAccountUser)
+	h.AccountRecurringEntryLine().Methods().AllowAllToGroup(GroupAccountUser)
+	h.AccountFiscalyearClosing().Methods().Load().AllowGroup(GroupAccountUser)
+	h.AccountFiscalyearClosing().Methods().AllowAllToGroup(GroupAccountManager)
+	h.AccountCurrencyRevaluation().Methods().AllowAllToGroup(GroupAccountManager)
+	h.AccountCurrencyRevaluationLine().Methods().AllowAllToGroup(GroupAccountManager)
+	h.AccountCurrencyRateImport().Methods().AllowAllToGroup(GroupAccountManager)
+	h.AccountTaxReport().Methods().Load().AllowGroup(GroupAccountUser)
+	h.AccountTaxReport().Methods().AllowAllToGroup(GroupAccountManager)
+	h.AccountTaxReportBox().Methods().Load().AllowGroup(GroupAccountUser)
+	h.AccountTaxReportBox().Methods().AllowAllToGroup(GroupAccountManager)
+	h.AccountTaxReturn().Methods().AllowAllToGroup(GroupAccountUser)
+	h.AccountTaxReturnLine().Methods().AllowAllToGroup(GroupAccountUser)
+	h.AccountWithholdingCertificate().Methods().AllowAllToGroup(GroupAccountUser)
+	h.AccountWithholdingCertificateLine().Methods().AllowAllToGroup(GroupAccountUser)
+	h.AccountTaxJurisdiction().Methods().AllowAllToGroup(GroupAccountManager)
+	h.AccountTaxJurisdiction().Methods().Load().AllowGroup(base.GroupUser)
+	h.AccountTaxJurisdictionRate().Methods().AllowAllToGroup(GroupAccountManager)
+	h.AccountTaxJurisdictionRate().Methods().Load().AllowGroup(base.GroupUser)
+	h.AccountTaxJurisdictionZip().Methods().AllowAllToGroup(GroupAccountManager)
+	h.AccountTaxJurisdictionZip().Methods().Load().AllowGroup(base.GroupUser)
+	h.AccountTaxJurisdictionImport().Methods().AllowAllToGroup(GroupAccountManager)
+	h.AccountChartLocalizationImport().Methods().AllowAllToGroup(GroupAccountManager)
+	h.AccountChartUpgrade().Methods().AllowAllToGroup(GroupAccountManager)
+	h.AccountChartUpgradeLine().Methods().AllowAllToGroup(GroupAccountManager)
+	h.AccountGroup().Methods().AllowAllToGroup(GroupAccountManager)
+	h.AccountGroup().Methods().Load().AllowGroup(base.GroupUser)
+	h.AccountAccountMerge().Methods().AllowAllToGroup(GroupAccountManager)
+	h.AccountCodeUpdate().Methods().AllowAllToGroup(GroupAccountManager)
+	h.AccountCodeUpdateLine().Methods().AllowAllToGroup(GroupAccountManager)
+	h.AccountConsolidation().Methods().AllowAllToGroup(GroupAccountManager)
+	h.AccountConsolidation().Methods().Load().AllowGroup(GroupAccountUser)
+	h.AccountConsolidationAccount().Methods().AllowAllToGroup(GroupAccountManager)
+	h.AccountConsolidationAccount().Methods().Load().AllowGroup(GroupAccountUser)
+	h.AccountConsolidationReport().Methods().AllowAllToGroup(GroupAccountUser)
+	h.AccountConsolidationReportLine().Methods().AllowAllToGroup(GroupAccountUser)
+	h.AccountTaxRepartitionLine().Methods().Load().AllowGroup(base.GroupUser)
+	h.AccountTaxRepartitionLine().Methods().Load().AllowGroup(GroupAccountInvoice)
+	h.AccountTaxRepartitionLine().Methods().AllowAllToGroup(GroupAccountManager)
 }
@@ -0,0 +1,79 @@
+package account
+
+import (
+	"testing"
+
+	"github.com/hexya-erp/hexya/src/models"
+	"github.com/hexya-erp/hexya/src/models/security"
+	"github.com/hexya-erp/hexya/src/models/types/dates"
+	"github.com/hexya-erp/pool/h"
+	"github.com/hexya-erp/pool/m"
+	"github.com/hexya-erp/pool/q"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestAccountAccountMerge(t *testing.T) {
+	Convey("Tests account merge", t, FailureContinues, func() {
+		So(models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+			company := h.User().NewSet(env).CurrentUser().Company()
+			expenses := h.AccountAccountType().NewSet(env).GetRecord("account_data_account_type_expenses")
+			newAccount := func(code string, reconcile bool) m.AccountAccountSet {
+				return h.AccountAccount().Create(env, h.AccountAccount().NewData().
+					SetCode(code).
+					SetName("Account "+code).
+					SetUserType(expenses).
+					SetReconcile(reconcile).
+					SetCompany(company))
+			}
+			source := newAccount("969001", false)
+			target := newAccount("969002", false)
+			journal := h.AccountJournal().Search(env, q.AccountJournal().Type().Equals("general").
+				And().Company().Equals(company)).Limit(1)
+			move := h.AccountMove().Create(env, h.AccountMove().NewData().
+				SetJournal(journal).
+				SetDate(dates.Today()).
+				CreateLines(h.AccountMoveLine().NewData().
+					SetName("foo").
+					SetDebit(10).
+					SetAccount(source)).
+				CreateLines(h.AccountMoveLine().NewData().
+					SetName("bar").
+					SetCredit(10).
+					SetAccount(target)))
+			move.Post()
+			newMerge := func(target m.AccountAccountSet) m.AccountAccountMergeSet {
+				return h.AccountAccountMerge().Create(env, h.AccountAccountMerge().NewData().
+					SetCompany(company).
+					SetSourceAccounts(source).
+					SetTargetAccount(target))
+			}
+
+			Convey("Posted journal items are moved to the target account", func() {
+				merge := newMerge(target)
+				merge.ActionMerge()
+				So(merge.State(), ShouldEqual, "done")
+				So(merge.Log(), ShouldContainSubstring, "Journal items: 1 in 1 entries")
+				So(h.AccountMoveLine().Search(env, q.AccountMoveLine().Account().Equals(source)).IsEmpty(), ShouldBeTrue)
+				So(h.AccountMoveLine().Search(env, q.AccountMoveLine().Account().Equals(target)).Len(), ShouldEqual, 2)
+				So(source.Deprecated(), ShouldBeTrue)
+				So(source.MergedInto().ID(), ShouldEqual, target.ID())
+				logs := h.AccountAuditLog().Search(env, q.AccountAuditLog().ResModel().Equals("AccountMoveLine").
+					And().Operation().Equals("merge"))
+				So(logs.Len(), ShouldEqual, 1)
+			})
+			Convey("Posted journal items cannot be moved by writing them directly", func() {
+				line := h.AccountMoveLine().Search(env, q.AccountMoveLine().Account().Equals(source))
+				So(func() { line.WithContext("account_merge", true).SetAccount(target) }, ShouldPanic)
+			})
+			Convey("Incompatible accounts cannot be merged", func() {
+				So(func() { newMerge(newAccount("969003", true)).ActionMerge() }, ShouldPanic)
+				So(func() { newMerge(source).ActionMerge() }, ShouldPanic)
+				So(source.Deprecated(), ShouldBeFalse)
+			})
+			Convey("Locked journal items cannot be moved", func() {
+				company.SetFiscalyearLockDate(dates.Today())
+				So(func() { newMerge(target).ActionMerge() }, ShouldPanic)
+			})
+		}), ShouldBeNil)
+	})
+}
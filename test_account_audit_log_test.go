@@ -0,0 +1,101 @@
+package account
+
+import (
+	"testing"
+
+	"github.com/hexya-erp/hexya/src/models"
+	"github.com/hexya-erp/hexya/src/models/security"
+	"github.com/hexya-erp/hexya/src/models/types/dates"
+	"github.com/hexya-erp/pool/h"
+	"github.com/hexya-erp/pool/m"
+	"github.com/hexya-erp/pool/q"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type TestAccountAuditLogStruct struct {
+	Super          TestAccountBaseStruct
+	GeneralJournal m.AccountJournalSet
+	Account        m.AccountAccountSet
+}
+
+func initTestAccountAuditLogStruct(env models.Environment) TestAccountAuditLogStruct {
+	var out TestAccountAuditLogStruct
+	out.Super = initTestAccountBaseStruct(env)
+	journals := h.AccountJournal().Search(env, q.AccountJournal().Type().Equals("general"))
+	So(journals.IsNotEmpty(), ShouldBeTrue)
+	out.GeneralJournal = journals.Records()[0]
+	accounts := h.AccountAccount().Search(env, q.AccountAccount().InternalType().Equals("other"))
+	So(accounts.IsNotEmpty(), ShouldBeTrue)
+	out.Account = accounts.Records()[0]
+	return out
+}
+
+func TestAccountAuditLog(t *testing.T) {
+	Convey("Tests Account Audit Log", t, FailureContinues, func() {
+		So(models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+			self := initTestAccountAuditLogStruct(env)
+			move := h.AccountMove().Create(env,
+				h.AccountMove().NewData().
+					SetJournal(self.GeneralJournal).
+					SetDate(dates.Today()).
+					CreateLines(h.AccountMoveLine().NewData().
+						SetName("foo").
+						SetDebit(10).
+						SetAccount(self.Account)).
+					CreateLines(h.AccountMoveLine().NewData().
+						SetName("bar").
+						SetCredit(10).
+						SetAccount(self.Account)))
+			Convey("Modifying a move is logged", func() {
+				move.SetRef("Audited reference")
+				logs := h.AccountAuditLog().Search(env,
+					q.AccountAuditLog().ResModel().Equals("AccountMove").
+						And().ResID().Equals(move.ID()).
+						And().FieldName().Equals("Ref"))
+				So(logs.Len(), ShouldEqual, 1)
+				So(logs.Operation(), ShouldEqual, "write")
+				So(logs.OldValue(), ShouldEqual, "")
+				So(logs.NewValue(), ShouldEqual, "Audited reference")
+			})
+			Convey("Audit trail entries cannot be changed", func() {
+				move.SetRef("Audited reference")
+				logs := h.AccountAuditLog().Search(env,
+					q.AccountAuditLog().ResModel().Equals("AccountMove").
+						And().ResID().Equals(move.ID()))
+				So(logs.IsNotEmpty(), ShouldBeTrue)
+				So(func() { logs.SetNewValue("Forged reference") }, ShouldPanic)
+				So(func() { logs.Unlink() }, ShouldPanic)
+				manager := initTestAccountBaseUserStruct(env).AccountManager
+				So(logs.Sudo(manager.ID()).Records()[0].NewValue(), ShouldEqual, "Audited reference")
+				So(func() {
+					h.AccountAuditLog().NewSet(env).Sudo(manager.ID()).Create(h.AccountAuditLog().NewData().
+						SetResModel("AccountMove").
+						SetResID(move.ID()).
+						SetOperation("write").
+						SetFieldName("Ref"))
+				}, ShouldPanic)
+			})
+			Convey("Posting a move is logged as such", func() {
+				move.Post()
+				logs := h.AccountAuditLog().Search(env,
+					q.AccountAuditLog().ResModel().Equals("AccountMove").
+						And().ResID().Equals(move.ID()).
+						And().FieldName().Equals("State"))
+				So(logs.Len(), ShouldEqual, 1)
+				So(logs.Operation(), ShouldEqual, "post")
+				So(logs.OldValue(), ShouldEqual, "Unposted")
+				So(logs.NewValue(), ShouldEqual, "Posted")
+			})
+			Convey("Deleting a move line is logged", func() {
+				line := move.Lines().Records()[0]
+				lineID := line.ID()
+				line.WithContext("check_move_validity", false).Unlink()
+				logs := h.AccountAuditLog().Search(env,
+					q.AccountAuditLog().ResModel().Equals("AccountMoveLine").
+						And().ResID().Equals(lineID).
+						And().Operation().Equals("unlink"))
+				So(logs.IsNotEmpty(), ShouldBeTrue)
+			})
+		}), ShouldBeNil)
+	})
+}
@@ -0,0 +1,123 @@
+package account
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hexya-erp/hexya/src/models"
+	"github.com/hexya-erp/hexya/src/models/security"
+	"github.com/hexya-erp/pool/h"
+	"github.com/hexya-erp/pool/q"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// testLocalizationFiles returns the files of a small localization package
+func testLocalizationFiles() map[string]string {
+	return map[string]string{
+		"Manifest.csv": "Code,Name,Version,Country\nl10n_test,Test Chart,1.0.0,FR\n",
+		"AccountChartTemplate.csv": `ID,Name,Parent,Currency,TransferAccount,PropertyAccountReceivable
+l10n_test_chart_base,Test Base Chart,,base_EUR,l10n_test_transfer,l10n_test_receivable
+l10n_test_chart,Test Chart,l10n_test_chart_base,base_EUR,l10n_test_transfer,
+`,
+		"AccountAccountTemplate.csv": `ID,ChartTemplate,Code,Name,UserType,Reconcile,Taxes
+l10n_test_transfer,l10n_test_chart_base,580000,Transfers,account_data_account_type_current_assets,true,
+l10n_test_receivable,l10n_test_chart_base,411000,Customers,account_data_account_type_receivable,true,
+l10n_test_revenue,l10n_test_chart,706000,Services,account_data_account_type_revenue,,l10n_test_tax_20
+l10n_test_revenue_export,l10n_test_chart,706200,Export Services,account_data_account_type_revenue,,
+l10n_test_vat,l10n_test_chart,445710,VAT Collected,account_data_account_type_current_liabilities,,
+`,
+		"AccountTaxTemplate.csv": `ID,ChartTemplate,Name,TypeTaxUse,AmountType,Amount,Account,RefundAccount
+l10n_test_tax_20,l10n_test_chart,VAT 20%,sale,percent,20,l10n_test_vat,l10n_test_vat
+l10n_test_tax_0,l10n_test_chart,VAT 0%,sale,percent,0,,
+`,
+		"AccountFiscalPositionTemplate.csv": "ID,ChartTemplate,Name\nl10n_test_fp_export,l10n_test_chart,Export\n",
+		"AccountFiscalPositionTaxTemplate.csv": "ID,Position,TaxSrc,TaxDest\n" +
+			"l10n_test_fp_export_tax,l10n_test_fp_export,l10n_test_tax_20,l10n_test_tax_0\n",
+		"AccountFiscalPositionAccountTemplate.csv": "ID,Position,AccountSrc,AccountDest\n" +
+			"l10n_test_fp_export_account,l10n_test_fp_export,l10n_test_revenue,l10n_test_revenue_export\n",
+		"AccountReconcileModelTemplate.csv": "ID,ChartTemplate,Name,Account,Label,AmountType\n" +
+			"l10n_test_bank_fees,l10n_test_chart,Bank Fees,l10n_test_revenue,Fees,percentage\n",
+	}
+}
+
+func TestAccountChartLocalization(t *testing.T) {
+	Convey("Tests the loading of localization packages as chart templates", t, FailureContinues, func() {
+		So(models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+			dir, err := ioutil.TempDir("", "localization")
+			So(err, ShouldBeNil)
+			defer os.RemoveAll(dir)
+			writeFiles := func(files map[string]string) {
+				for name, content := range files {
+					So(ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644), ShouldBeNil)
+				}
+			}
+			wizard := h.AccountChartLocalizationImport().Create(env, h.AccountChartLocalizationImport().NewData().
+				SetPath(dir))
+
+			Convey("Packages are loaded as chart template trees", func() {
+				writeFiles(testLocalizationFiles())
+				wizard.ActionValidate()
+				So(wizard.Report(), ShouldEqual, "Localization package l10n_test version 1.0.0 is valid.")
+				wizard.ActionImport()
+				charts := h.AccountChartTemplate().Search(env, q.AccountChartTemplate().LocalizationCode().Equals("l10n_test"))
+				So(charts.Len(), ShouldEqual, 2)
+				base := h.AccountChartTemplate().NewSet(env).GetRecord("l10n_test_chart_base")
+				chart := h.AccountChartTemplate().NewSet(env).GetRecord("l10n_test_chart")
+				So(chart.Parent().Equals(base), ShouldBeTrue)
+				So(chart.LocalizationVersion(), ShouldEqual, "1.0.0")
+				So(base.TransferAccount().Code(), ShouldEqual, "580000")
+				So(base.PropertyAccountReceivable().Code(), ShouldEqual, "411000")
+
+				tax := h.AccountTaxTemplate().NewSet(env).GetRecord("l10n_test_tax_20")
+				exemptTax := h.AccountTaxTemplate().NewSet(env).GetRecord("l10n_test_tax_0")
+				revenue := h.AccountAccountTemplate().NewSet(env).GetRecord("l10n_test_revenue")
+				So(tax.ChartTemplate().Equals(chart), ShouldBeTrue)
+				So(tax.Amount(), ShouldEqual, 20)
+				So(tax.Account().Code(), ShouldEqual, "445710")
+				So(revenue.ChartTemplate().Equals(chart), ShouldBeTrue)
+				So(revenue.Taxes().Equals(tax), ShouldBeTrue)
+
+				position := h.AccountFiscalPositionTemplate().NewSet(env).GetRecord("l10n_test_fp_export")
+				So(position.ChartTemplate().Equals(chart), ShouldBeTrue)
+				So(position.Taxes().Len(), ShouldEqual, 1)
+				So(position.Taxes().TaxSrc().Equals(tax), ShouldBeTrue)
+				So(position.Taxes().TaxDest().Equals(exemptTax), ShouldBeTrue)
+				So(position.Accounts().Len(), ShouldEqual, 1)
+				So(position.Accounts().AccountSrc().Equals(revenue), ShouldBeTrue)
+				So(position.Accounts().AccountDest().Code(), ShouldEqual, "706200")
+
+				reconcileModel := h.AccountReconcileModelTemplate().NewSet(env).GetRecord("l10n_test_bank_fees")
+				So(reconcileModel.ChartTemplate().Equals(chart), ShouldBeTrue)
+				So(reconcileModel.Account().Equals(revenue), ShouldBeTrue)
+				So(reconcileModel.Amount(), ShouldEqual, 100)
+			})
+			Convey("Failing rows are reported and nothing is loaded", func() {
+				files := testLocalizationFiles()
+				files["AccountTaxTemplate.csv"] = `ID,ChartTemplate,Name,TypeTaxUse,AmountType,Amount,Account,RefundAccount
+l10n_test_tax_20,l10n_test_chart,VAT 20%,sale,percent,20,l10n_test_vat,l10n_test_unknown
+l10n_test_tax_0,l10n_test_other_chart,VAT 0%,sale,percent,0,,
+`
+				writeFiles(files)
+				wizard.ActionValidate()
+				So(wizard.Report(), ShouldEqual, `2 errors found:
+AccountTaxTemplate.csv:2: RefundAccount: unknown AccountAccountTemplate l10n_test_unknown
+AccountTaxTemplate.csv:3: ChartTemplate: unknown AccountChartTemplate l10n_test_other_chart`)
+				So(func() { wizard.ActionImport() }, ShouldPanic)
+				So(h.AccountChartTemplate().Search(env,
+					q.AccountChartTemplate().LocalizationCode().Equals("l10n_test")).IsEmpty(), ShouldBeTrue)
+			})
+			Convey("Invalid values are reported with their location", func() {
+				files := testLocalizationFiles()
+				files["AccountTaxTemplate.csv"] = `ID,ChartTemplate,Name,TypeTaxUse,AmountType,Amount,Account,RefundAccount
+l10n_test_tax_20,l10n_test_chart,VAT 20%,sales,percent,20,l10n_test_vat,l10n_test_vat
+l10n_test_tax_0,l10n_test_chart,VAT 0%,sale,percent,0,,
+`
+				writeFiles(files)
+				wizard.ActionValidate()
+				So(wizard.Report(), ShouldStartWith, "1 errors found:\nAccountTaxTemplate.csv:2: TypeTaxUse: ")
+			})
+		}), ShouldBeNil)
+	})
+}
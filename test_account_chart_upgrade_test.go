@@ -0,0 +1,95 @@
+package account
+
+import (
+	"testing"
+
+	"github.com/hexya-erp/hexya/src/models"
+	"github.com/hexya-erp/hexya/src/models/security"
+	"github.com/hexya-erp/hexya/src/models/types/dates"
+	"github.com/hexya-erp/pool/h"
+	"github.com/hexya-erp/pool/m"
+	"github.com/hexya-erp/pool/q"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestAccountChartUpgrade(t *testing.T) {
+	Convey("Tests chart of accounts upgrade", t, FailureContinues, func() {
+		So(models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+			company := h.User().NewSet(env).CurrentUser().Company()
+			chart := h.AccountChartTemplate().NewSet(env).GetRecord("l10n_generic_coa_configurable_chart_template")
+			interimTemplate := h.AccountAccountTemplate().NewSet(env).GetRecord("l10n_generic_coa_conf_cas_interim1")
+			newTemplate := h.AccountAccountTemplate().Create(env, h.AccountAccountTemplate().NewData().
+				SetCode("101199").
+				SetName("New Current Assets").
+				SetUserType(interimTemplate.UserType()).
+				SetChartTemplate(chart))
+			interimTemplate.SetName("Stock Interim (Received)")
+
+			upgrade := h.AccountChartUpgrade().Create(env, h.AccountChartUpgrade().NewData().SetCompany(company))
+			findLine := func(template m.AccountAccountTemplateSet) m.AccountChartUpgradeLineSet {
+				return upgrade.Lines().Filtered(func(r m.AccountChartUpgradeLineSet) bool {
+					return r.Type() == "account" && r.AccountTemplate().ID() == template.ID()
+				})
+			}
+
+			Convey("Installed accounts are linked to their template", func() {
+				interim := upgrade.TemplateAccount(interimTemplate)
+				So(interim.IsNotEmpty(), ShouldBeTrue)
+				So(interim.AccountTemplate().ID(), ShouldEqual, interimTemplate.ID())
+			})
+			Convey("Added and changed accounts are listed and applied", func() {
+				upgrade.ActionCompute()
+				addLine := findLine(newTemplate)
+				So(addLine.Len(), ShouldEqual, 1)
+				So(addLine.Operation(), ShouldEqual, "add")
+				So(addLine.Selected(), ShouldBeTrue)
+				updateLine := findLine(interimTemplate)
+				So(updateLine.Len(), ShouldEqual, 1)
+				So(updateLine.Operation(), ShouldEqual, "update")
+				So(updateLine.Changes(), ShouldContainSubstring, "Stock Interim (Received)")
+				upgrade.ActionApply()
+				newAccount := upgrade.TemplateAccount(newTemplate)
+				So(newAccount.IsNotEmpty(), ShouldBeTrue)
+				So(newAccount.Code(), ShouldEqual, "101199")
+				So(newAccount.Company().ID(), ShouldEqual, company.ID())
+				So(upgrade.TemplateAccount(interimTemplate).Name(), ShouldEqual, "Stock Interim (Received)")
+				upgrade.ActionCompute()
+				So(findLine(newTemplate).IsEmpty(), ShouldBeTrue)
+				So(findLine(interimTemplate).IsEmpty(), ShouldBeTrue)
+			})
+			Convey("Accounts used in journal items are not modified", func() {
+				interim := upgrade.TemplateAccount(interimTemplate)
+				journal := h.AccountJournal().Search(env, q.AccountJournal().Type().Equals("general").
+					And().Company().Equals(company)).Limit(1)
+				h.AccountMove().Create(env, h.AccountMove().NewData().
+					SetJournal(journal).
+					SetDate(dates.Today()).
+					CreateLines(h.AccountMoveLine().NewData().
+						SetName("foo").
+						SetDebit(10).
+						SetAccount(interim)).
+					CreateLines(h.AccountMoveLine().NewData().
+						SetName("bar").
+						SetCredit(10).
+						SetAccount(interim)))
+				upgrade.ActionCompute()
+				updateLine := findLine(interimTemplate)
+				So(updateLine.Used(), ShouldBeTrue)
+				So(updateLine.Selected(), ShouldBeFalse)
+				updateLine.SetSelected(true)
+				oldName := interim.Name()
+				upgrade.ActionApply()
+				So(interim.Name(), ShouldEqual, oldName)
+			})
+			Convey("Accounts whose template leaves the chart are deprecated", func() {
+				interim := upgrade.TemplateAccount(interimTemplate)
+				interimTemplate.SetNocreate(true)
+				upgrade.ActionCompute()
+				removeLine := findLine(interimTemplate)
+				So(removeLine.Operation(), ShouldEqual, "remove")
+				upgrade.ActionApply()
+				So(interim.Deprecated(), ShouldBeTrue)
+			})
+		}), ShouldBeNil)
+	})
+}
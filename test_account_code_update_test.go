@@ -0,0 +1,69 @@
+package account
+
+import (
+	"testing"
+
+	"github.com/hexya-erp/hexya/src/models"
+	"github.com/hexya-erp/hexya/src/models/security"
+	"github.com/hexya-erp/pool/h"
+	"github.com/hexya-erp/pool/m"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestAccountCodeUpdate(t *testing.T) {
+	Convey("Tests the update of account codes when the company settings change", t, FailureContinues, func() {
+		So(models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+			company := h.Company().Create(env, h.Company().NewData().
+				SetName("Account Codes Company").
+				SetCurrency(h.Currency().NewSet(env).GetRecord("base_EUR")).
+				SetAccountsCodeDigits(6).
+				SetBankAccountCodePrefix("512").
+				SetCashAccountCodePrefix("53"))
+			newAccount := func(code, accountType string, reconcile bool) m.AccountAccountSet {
+				return h.AccountAccount().Create(env, h.AccountAccount().NewData().
+					SetCode(code).
+					SetName("Account "+code).
+					SetUserType(h.AccountAccountType().NewSet(env).GetRecord(accountType)).
+					SetReconcile(reconcile).
+					SetCompany(company))
+			}
+			receivable := newAccount("411000", "account_data_account_type_receivable", true)
+			revenue := newAccount("700000", "account_data_account_type_revenue", false)
+			bank := newAccount("512001", "account_data_account_type_liquidity", false)
+
+			Convey("Codes are padded to the new number of digits", func() {
+				company.SetAccountsCodeDigits(8)
+				So(company.AccountsCodeDigits(), ShouldEqual, 8)
+				So(receivable.Code(), ShouldEqual, "41100000")
+				So(revenue.Code(), ShouldEqual, "70000000")
+				So(bank.Code(), ShouldEqual, "51200001")
+				Convey("and shortened back when removing digits", func() {
+					company.SetAccountsCodeDigits(4)
+					So(receivable.Code(), ShouldEqual, "4110")
+					So(revenue.Code(), ShouldEqual, "7000")
+					So(bank.Code(), ShouldEqual, "5121")
+				})
+			})
+			Convey("The codes of liquidity accounts follow their prefix", func() {
+				company.SetBankAccountCodePrefix("5121")
+				So(bank.Code(), ShouldEqual, "512101")
+				So(revenue.Code(), ShouldEqual, "700000")
+			})
+			Convey("Changes giving the same code to two accounts are rejected", func() {
+				other := newAccount("41100", "account_data_account_type_receivable", true)
+				So(func() { company.SetAccountsCodeDigits(4) }, ShouldPanic)
+				So(company.AccountsCodeDigits(), ShouldEqual, 6)
+				So(receivable.Code(), ShouldEqual, "411000")
+				So(other.Code(), ShouldEqual, "41100")
+				So(revenue.Code(), ShouldEqual, "700000")
+			})
+			Convey("Changes leaving codes with too many digits are rejected", func() {
+				other := newAccount("701234", "account_data_account_type_revenue", false)
+				So(func() { company.SetAccountsCodeDigits(4) }, ShouldPanic)
+				So(company.AccountsCodeDigits(), ShouldEqual, 6)
+				So(other.Code(), ShouldEqual, "701234")
+				So(revenue.Code(), ShouldEqual, "700000")
+			})
+		}), ShouldBeNil)
+	})
+}
@@ -0,0 +1,182 @@
+package account
+
+import (
+	"testing"
+
+	"github.com/hexya-erp/hexya/src/models"
+	"github.com/hexya-erp/hexya/src/models/security"
+	"github.com/hexya-erp/hexya/src/models/types/dates"
+	"github.com/hexya-erp/pool/h"
+	"github.com/hexya-erp/pool/m"
+	"github.com/hexya-erp/pool/q"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestAccountConsolidation(t *testing.T) {
+	Convey("Tests the consolidation of two companies with different currencies", t, FailureContinues, func() {
+		So(models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+			groupCurrency := h.Currency().Create(env, h.Currency().NewData().
+				SetName("XGC").
+				SetSymbol("G").
+				SetRounding(0.01).
+				SetActive(true))
+			subCurrency := h.Currency().Create(env, h.Currency().NewData().
+				SetName("XSC").
+				SetSymbol("S").
+				SetRounding(0.01).
+				SetActive(true))
+			groupCompany := h.Company().Create(env, h.Company().NewData().
+				SetName("Consolidation Group").
+				SetCurrency(groupCurrency))
+			subsidiary := h.Company().Create(env, h.Company().NewData().
+				SetName("Consolidation Subsidiary").
+				SetCurrency(subCurrency))
+			// 1 group currency unit is worth 4, 2 then 1.25 subsidiary units (spot) and 2.5 units (average)
+			for _, rate := range []struct {
+				date     string
+				rate     float64
+				rateType string
+			}{
+				{"2029-01-01", 4, "spot"},
+				{"2030-02-01", 2, "spot"},
+				{"2030-03-31", 1.25, "spot"},
+				{"2030-01-01", 2.5, "average"},
+			} {
+				h.CurrencyRate().Create(env, h.CurrencyRate().NewData().
+					SetName(dates.ParseDate(rate.date).ToDateTime()).
+					SetRate(rate.rate).
+					SetRateType(rate.rateType).
+					SetCurrency(subCurrency).
+					SetCompany(groupCompany))
+			}
+
+			newAccount := func(company m.CompanySet, code, accountType string) m.AccountAccountSet {
+				return h.AccountAccount().Create(env, h.AccountAccount().NewData().
+					SetCode(code).
+					SetName("Account "+code).
+					SetUserType(h.AccountAccountType().NewSet(env).GetRecord(accountType)).
+					SetReconcile(accountType == "account_data_account_type_receivable" ||
+						accountType == "account_data_account_type_payable").
+					SetCompany(company))
+			}
+			subBank := newAccount(subsidiary, "512000", "account_data_account_type_liquidity")
+			subCapital := newAccount(subsidiary, "101000", "account_data_account_type_equity")
+			subReceivable := newAccount(subsidiary, "411000", "account_data_account_type_receivable")
+			subIncome := newAccount(subsidiary, "700000", "account_data_account_type_revenue")
+			groupPayable := newAccount(groupCompany, "401000", "account_data_account_type_payable")
+			groupExpense := newAccount(groupCompany, "600000", "account_data_account_type_expenses")
+
+			journals := map[int64]m.AccountJournalSet{
+				subsidiary.ID(): h.AccountJournal().Create(env, h.AccountJournal().NewData().
+					SetName("Subsidiary Operations").
+					SetCode("CSSUB").
+					SetType("general").
+					SetCompany(subsidiary)),
+				groupCompany.ID(): h.AccountJournal().Create(env, h.AccountJournal().NewData().
+					SetName("Group Operations").
+					SetCode("CSGRP").
+					SetType("general").
+					SetCompany(groupCompany)),
+			}
+			post := func(date string, debit, credit m.AccountAccountSet, amount float64, partner m.PartnerSet) {
+				move := h.AccountMove().Create(env, h.AccountMove().NewData().
+					SetJournal(journals[debit.Company().ID()]).
+					SetDate(dates.ParseDate(date)).
+					CreateLines(h.AccountMoveLine().NewData().
+						SetName("debit").
+						SetAccount(debit).
+						SetPartner(partner).
+						SetDebit(amount)).
+					CreateLines(h.AccountMoveLine().NewData().
+						SetName("credit").
+						SetAccount(credit).
+						SetPartner(partner).
+						SetCredit(amount)))
+				move.Post()
+			}
+			noPartner := h.Partner().NewSet(env)
+			// Income of the previous year, translated at the spot rate of its date (0.25)
+			post("2029-06-01", subBank, subIncome, 100, noPartner)
+			// Capital contribution, translated at the spot rate of its date (0.5)
+			post("2030-02-10", subBank, subCapital, 1000, noPartner)
+			// Intercompany sale of 300 subsidiary units, i.e. 240 group units at the closing rate
+			post("2030-03-01", subReceivable, subIncome, 300, groupCompany.Partner())
+			post("2030-03-01", groupExpense, groupPayable, 240, subsidiary.Partner())
+
+			consolidation := h.AccountConsolidation().Create(env, h.AccountConsolidation().NewData().
+				SetName("Test Consolidation").
+				SetCompany(groupCompany).
+				SetCurrency(groupCurrency).
+				SetCompanies(groupCompany.Union(subsidiary)))
+			newGroupAccount := func(code, nature string, accounts m.AccountAccountSet) m.AccountConsolidationAccountSet {
+				return h.AccountConsolidationAccount().Create(env, h.AccountConsolidationAccount().NewData().
+					SetConsolidation(consolidation).
+					SetCode(code).
+					SetName("Group "+code).
+					SetNature(nature).
+					SetAccounts(accounts))
+			}
+			newGroupAccount("101", "equity", subCapital)
+			newGroupAccount("401", "balance", groupPayable)
+			newGroupAccount("411", "balance", subReceivable)
+			newGroupAccount("512", "balance", subBank)
+			newGroupAccount("600", "income", groupExpense)
+			newGroupAccount("700", "income", subIncome)
+			consolidation.SetTranslationAccount(newGroupAccount("107", "equity", h.AccountAccount().NewSet(env)))
+			consolidation.SetRetainedEarningsAccount(newGroupAccount("120", "equity", h.AccountAccount().NewSet(env)))
+
+			report := h.AccountConsolidationReport().Create(env, h.AccountConsolidationReport().NewData().
+				SetConsolidation(consolidation).
+				SetDateFrom(dates.ParseDate("2030-01-01")).
+				SetDateTo(dates.ParseDate("2030-03-31")))
+			reportLine := func(code string) m.AccountConsolidationReportLineSet {
+				return report.Lines().Filtered(func(r m.AccountConsolidationReportLineSet) bool {
+					return r.ConsolidationAccount().Code() == code
+				})
+			}
+
+			Convey("Balances are translated at the rate of their nature", func() {
+				report.ActionCompute()
+				So(report.ClosingRate(subCurrency), ShouldAlmostEqual, 0.8)
+				So(report.AverageRate(subCurrency), ShouldAlmostEqual, 0.4)
+				// Closing rate
+				So(reportLine("512").Balance(), ShouldAlmostEqual, 880)
+				// Average rate
+				So(reportLine("700").Balance(), ShouldAlmostEqual, -120)
+				So(reportLine("600").Balance(), ShouldAlmostEqual, 240)
+				// Historical rates
+				So(reportLine("101").Balance(), ShouldAlmostEqual, -500)
+				So(reportLine("120").Balance(), ShouldAlmostEqual, -25)
+			})
+			Convey("The translation difference balances the consolidated accounts", func() {
+				report.ActionCompute()
+				So(reportLine("107").Balance(), ShouldAlmostEqual, -475)
+				var total float64
+				for _, line := range report.Lines().Records() {
+					total += line.Total()
+				}
+				So(groupCurrency.IsZero(total), ShouldBeTrue)
+			})
+			Convey("Intercompany balances are eliminated", func() {
+				report.ActionCompute()
+				So(reportLine("411").Balance(), ShouldAlmostEqual, 240)
+				So(reportLine("411").Elimination(), ShouldAlmostEqual, -240)
+				So(reportLine("411").Total(), ShouldAlmostEqual, 0)
+				So(reportLine("401").Balance(), ShouldAlmostEqual, -240)
+				So(reportLine("401").Elimination(), ShouldAlmostEqual, 240)
+				So(reportLine("401").Total(), ShouldAlmostEqual, 0)
+				So(reportLine("107").Elimination(), ShouldAlmostEqual, 0)
+			})
+			Convey("Intercompany balances are kept when elimination is disabled", func() {
+				consolidation.SetEliminateIntercompany(false)
+				report.ActionCompute()
+				So(reportLine("411").Elimination(), ShouldAlmostEqual, 0)
+				So(reportLine("411").Total(), ShouldAlmostEqual, 240)
+			})
+			Convey("Accounts with a balance must be mapped", func() {
+				post("2030-03-15", subBank, newAccount(subsidiary, "706000", "account_data_account_type_revenue"), 10, noPartner)
+				So(func() { report.ActionCompute() }, ShouldPanic)
+			})
+		}), ShouldBeNil)
+	})
+}
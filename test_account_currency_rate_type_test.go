@@ -0,0 +1,97 @@
+package account
+
+import (
+	"testing"
+
+	"github.com/hexya-addons/account/currencyrate"
+	"github.com/hexya-erp/hexya/src/models"
+	"github.com/hexya-erp/hexya/src/models/security"
+	"github.com/hexya-erp/hexya/src/models/types/dates"
+	"github.com/hexya-erp/pool/h"
+	"github.com/hexya-erp/pool/q"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestAccountCurrencyRateType(t *testing.T) {
+	Convey("Tests currency rate types", t, FailureContinues, func() {
+		So(models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+			company := h.User().NewSet(env).CurrentUser().Company()
+			currency := h.Currency().NewSet(env).GetRecord("base_USD")
+			if currency.Equals(company.Currency()) {
+				currency = h.Currency().NewSet(env).GetRecord("base_EUR")
+			}
+			date := dates.ParseDate("2015-06-30")
+			spot := currency.WithContext("date", date).WithContext("company_id", company.ID())
+			spotRate := spot.Rate()
+			h.CurrencyRate().Create(env, h.CurrencyRate().NewData().
+				SetName(dates.ParseDate("2015-06-01").ToDateTime()).
+				SetRate(spotRate*3).
+				SetRateType("average").
+				SetCurrency(currency).
+				SetCompany(company))
+
+			Convey("Spot rates ignore the rates of other types", func() {
+				So(spot.WithContext("rate_type", "spot").Rate(), ShouldEqual, spotRate)
+				So(spot.Rate(), ShouldEqual, spotRate)
+			})
+			Convey("Rates of the requested type are used when they exist", func() {
+				So(spot.WithContext("rate_type", "average").Rate(), ShouldAlmostEqual, spotRate*3, 0.000001)
+			})
+			Convey("Spot rates are used when there is no rate of the requested type", func() {
+				So(spot.WithContext("rate_type", "budget").Rate(), ShouldEqual, spotRate)
+			})
+			Convey("Spot rates are used when only another company has rates of the requested type", func() {
+				other := h.Company().Create(env, h.Company().NewData().
+					SetName("Other Rates Company").
+					SetCurrency(company.Currency()))
+				h.CurrencyRate().Create(env, h.CurrencyRate().NewData().
+					SetName(dates.ParseDate("2015-06-15").ToDateTime()).
+					SetRate(7.5).
+					SetCurrency(currency))
+				otherSpot := currency.WithContext("date", date).WithContext("company_id", other.ID())
+				So(otherSpot.Rate(), ShouldEqual, 7.5)
+				So(otherSpot.WithContext("rate_type", "average").Rate(), ShouldEqual, 7.5)
+			})
+			Convey("Imported rates are recorded with the given type", func() {
+				count, _ := company.ImportCurrencyRates([]currencyrate.Rate{{
+					Date:     date.Time,
+					Currency: currency.Name(),
+					Rate:     spotRate * 5,
+				}}, "budget")
+				So(count, ShouldEqual, 2)
+				So(spot.WithContext("rate_type", "budget").Rate(), ShouldAlmostEqual, spotRate*5, 0.000001)
+				So(spot.Rate(), ShouldEqual, spotRate)
+				companyRate := h.CurrencyRate().Search(env, q.CurrencyRate().Currency().Equals(company.Currency()).
+					And().Company().Equals(company).
+					And().Name().Equals(date.ToDateTime()).
+					And().RateType().Equals("budget"))
+				So(companyRate.Len(), ShouldEqual, 1)
+				So(companyRate.Rate(), ShouldEqual, 1)
+			})
+			Convey("Rate searches are restricted to the rate type of the context", func() {
+				rates := h.CurrencyRate().NewSet(env).WithContext("rate_type", "average").
+					Search(q.CurrencyRate().Currency().Equals(currency))
+				So(rates.Len(), ShouldEqual, 1)
+				So(rates.RateType(), ShouldEqual, "average")
+			})
+			Convey("Company rates are computed with spot rates only", func() {
+				sql := currency.SelectCompaniesRates()
+				So(sql, ShouldContainSubstring, "rate_type = 'spot') r2")
+				So(sql, ShouldContainSubstring, "rate_type = 'spot') r\n")
+			})
+			Convey("The aged balance is translated with the rate type of the wizard", func() {
+				wizard := h.AccountAgedTrialBalance().Create(env, h.AccountAgedTrialBalance().NewData().
+					SetResultSelection("customer").
+					SetDateFrom(date).
+					SetRateType("average"))
+				values := wizard.ComputeReport()
+				So(values.Data.Form.UsedContext.GetString("rate_type"), ShouldEqual, "average")
+				So(values.Data.Form.ResultSelection, ShouldEqual, "customer")
+				So(values.Data.Form.Periods["4"].Name, ShouldEqual, "0-30")
+				So(values.Data.Form.Periods["4"].Stop.Equal(date), ShouldBeTrue)
+				So(values.Data.Form.Periods["0"].Name, ShouldEqual, "+120")
+				So(values.Data.Form.Periods["0"].Start.IsZero(), ShouldBeTrue)
+			})
+		}), ShouldBeNil)
+	})
+}
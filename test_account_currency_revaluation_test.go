@@ -0,0 +1,114 @@
+package account
+
+import (
+	"testing"
+
+	"github.com/hexya-erp/hexya/src/models"
+	"github.com/hexya-erp/hexya/src/models/security"
+	"github.com/hexya-erp/hexya/src/models/types/dates"
+	"github.com/hexya-erp/pool/h"
+	"github.com/hexya-erp/pool/m"
+	"github.com/hexya-erp/pool/q"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestAccountCurrencyRevaluation(t *testing.T) {
+	Convey("Tests unrealized exchange difference revaluation", t, FailureContinues, func() {
+		So(models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+			company := h.User().NewSet(env).CurrentUser().Company()
+			currency := h.Currency().NewSet(env).GetRecord("base_USD")
+			if currency.Equals(company.Currency()) {
+				currency = h.Currency().NewSet(env).GetRecord("base_EUR")
+			}
+			partner := h.Partner().NewSet(env).GetRecord("base_res_partner_2")
+			receivable := h.AccountAccount().Search(env, q.AccountAccount().Company().Equals(company).
+				And().InternalType().Equals("receivable")).Limit(1)
+			income := h.AccountAccount().Search(env, q.AccountAccount().Company().Equals(company).
+				And().UserTypeFilteredOn(q.AccountAccountType().HexyaExternalID().Equals("account_data_account_type_revenue"))).Limit(1)
+			journal := h.AccountJournal().Search(env, q.AccountJournal().Type().Equals("general").
+				And().Company().Equals(company)).Limit(1)
+			date := dates.ParseDate("2015-06-30")
+			h.CurrencyRate().Create(env, h.CurrencyRate().NewData().
+				SetName(date.ToDateTime()).
+				SetRate(currency.WithContext("date", date).Rate()*2).
+				SetCurrency(currency).
+				SetCompany(company))
+			move := h.AccountMove().Create(env, h.AccountMove().NewData().
+				SetJournal(journal).
+				SetDate(dates.ParseDate("2015-06-15")).
+				CreateLines(h.AccountMoveLine().NewData().
+					SetName("foreign receivable").
+					SetAccount(receivable).
+					SetPartner(partner).
+					SetCurrency(currency).
+					SetAmountCurrency(100).
+					SetDebit(80)).
+				CreateLines(h.AccountMoveLine().NewData().
+					SetName("foreign sale").
+					SetAccount(income).
+					SetCredit(80)))
+			move.Post()
+			revaluation := h.AccountCurrencyRevaluation().Create(env, h.AccountCurrencyRevaluation().NewData().
+				SetCompany(company).
+				SetJournal(company.CurrencyExchangeJournal()).
+				SetDate(date).
+				SetReverseDate(date.AddDate(0, 0, 1)))
+			expected := company.Currency().Round(currency.WithContext("date", date).Compute(100, company.Currency(), true) - 80)
+
+			Convey("The preview lists the difference per account, partner and currency", func() {
+				revaluation.ActionPreview()
+				lines := revaluation.Lines().Filtered(func(r m.AccountCurrencyRevaluationLineSet) bool {
+					return r.Account().Equals(receivable) && r.Partner().Equals(partner)
+				})
+				So(lines.Len(), ShouldEqual, 1)
+				So(lines.AmountCurrency(), ShouldEqual, 100)
+				So(lines.Balance(), ShouldEqual, 80)
+				So(lines.Difference(), ShouldEqual, expected)
+			})
+			payInvoice := func(date dates.Date) {
+				payment := h.AccountMove().Create(env, h.AccountMove().NewData().
+					SetJournal(journal).
+					SetDate(date).
+					CreateLines(h.AccountMoveLine().NewData().
+						SetName("foreign payment").
+						SetAccount(receivable).
+						SetPartner(partner).
+						SetCurrency(currency).
+						SetAmountCurrency(-100).
+						SetCredit(80)).
+					CreateLines(h.AccountMoveLine().NewData().
+						SetName("payment counterpart").
+						SetAccount(income).
+						SetDebit(80)))
+				payment.Post()
+				move.Lines().Union(payment.Lines()).
+					Filtered(func(r m.AccountMoveLineSet) bool { return r.Account().Equals(receivable) }).
+					Reconcile(h.AccountAccount().NewSet(env), h.AccountJournal().NewSet(env))
+			}
+			receivableLines := func() m.AccountCurrencyRevaluationLineSet {
+				revaluation.ActionPreview()
+				return revaluation.Lines().Filtered(func(r m.AccountCurrencyRevaluationLineSet) bool {
+					return r.Account().Equals(receivable) && r.Partner().Equals(partner)
+				})
+			}
+			Convey("Payments dated after the revaluation date are ignored", func() {
+				payInvoice(date.AddDate(0, 0, 10))
+				lines := receivableLines()
+				So(lines.Len(), ShouldEqual, 1)
+				So(lines.AmountCurrency(), ShouldEqual, 100)
+				So(lines.Balance(), ShouldEqual, 80)
+				So(lines.Difference(), ShouldEqual, expected)
+			})
+			Convey("Payments dated before the revaluation date settle the receivable", func() {
+				payInvoice(date.AddDate(0, 0, -10))
+				So(receivableLines().IsEmpty(), ShouldBeTrue)
+			})
+			Convey("The revaluation entry is booked and set to be reversed", func() {
+				revaluation.ActionRevalue()
+				So(revaluation.Move().State(), ShouldEqual, "posted")
+				So(revaluation.Move().AutoReverseDate().Equal(date.AddDate(0, 0, 1)), ShouldBeTrue)
+				So(revaluation.Move().Journal().ID(), ShouldEqual, company.CurrencyExchangeJournal().ID())
+			})
+		}), ShouldBeNil)
+	})
+}
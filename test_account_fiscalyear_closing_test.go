@@ -0,0 +1,99 @@
+package account
+
+import (
+	"testing"
+
+	"github.com/hexya-erp/hexya/src/models"
+	"github.com/hexya-erp/hexya/src/models/security"
+	"github.com/hexya-erp/hexya/src/models/types/dates"
+	"github.com/hexya-erp/pool/h"
+	"github.com/hexya-erp/pool/m"
+	"github.com/hexya-erp/pool/q"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestAccountFiscalyearClosing(t *testing.T) {
+	Convey("Tests the closing of fiscal years", t, FailureContinues, func() {
+		So(models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+			company := h.Company().Create(env, h.Company().NewData().
+				SetName("Closing Company").
+				SetCurrency(h.Currency().NewSet(env).GetRecord("base_EUR")))
+			newAccount := func(code, accountType string, reconcile bool) m.AccountAccountSet {
+				return h.AccountAccount().Create(env, h.AccountAccount().NewData().
+					SetCode(code).
+					SetName("Account "+code).
+					SetUserType(h.AccountAccountType().NewSet(env).GetRecord(accountType)).
+					SetReconcile(reconcile).
+					SetCompany(company))
+			}
+			receivable := newAccount("411000", "account_data_account_type_receivable", true)
+			revenue := newAccount("700000", "account_data_account_type_revenue", false)
+			earnings := newAccount("120000", "account_data_unaffected_earnings", false)
+			journal := h.AccountJournal().Create(env, h.AccountJournal().NewData().
+				SetName("Closing Operations").
+				SetCode("CLOS").
+				SetType("general").
+				SetCompany(company))
+			post := func(date string) m.AccountMoveSet {
+				move := h.AccountMove().Create(env, h.AccountMove().NewData().
+					SetJournal(journal).
+					SetDate(dates.ParseDate(date)).
+					CreateLines(h.AccountMoveLine().NewData().
+						SetName("sale").
+						SetAccount(receivable).
+						SetDebit(1000)).
+					CreateLines(h.AccountMoveLine().NewData().
+						SetName("sale").
+						SetAccount(revenue).
+						SetCredit(1000)))
+				move.Post()
+				return move
+			}
+			post("2014-06-01")
+			closing := h.AccountFiscalyearClosing().Create(env, h.AccountFiscalyearClosing().NewData().
+				SetCompany(company).
+				SetDateFrom(dates.ParseDate("2014-01-01")).
+				SetDateTo(dates.ParseDate("2014-12-31")).
+				SetJournal(journal).
+				SetEarningsAccount(earnings))
+
+			Convey("Only processed closings can be undone", func() {
+				So(func() { closing.ActionUndo() }, ShouldPanic)
+			})
+			Convey("Closing books the income into the earnings account and locks the year", func() {
+				closing.ActionClose()
+				So(closing.State(), ShouldEqual, "done")
+				So(closing.ClosingMove().State(), ShouldEqual, "posted")
+				So(closing.ClosingMove().Date().Equal(dates.ParseDate("2014-12-31")), ShouldBeTrue)
+				So(closing.OpeningMove().IsEmpty(), ShouldBeTrue)
+				closingLine := func(account m.AccountAccountSet) m.AccountMoveLineSet {
+					return closing.ClosingMove().Lines().Filtered(func(r m.AccountMoveLineSet) bool {
+						return r.Account().Equals(account)
+					})
+				}
+				So(closing.ClosingMove().Lines().Len(), ShouldEqual, 2)
+				So(closingLine(revenue).Debit(), ShouldEqual, 1000)
+				So(closingLine(earnings).Credit(), ShouldEqual, 1000)
+				So(company.FiscalyearLockDate().Equal(dates.ParseDate("2014-12-31")), ShouldBeTrue)
+				So(func() { closing.Unlink() }, ShouldPanic)
+
+				Convey("Undoing requires the journal to allow cancelling entries", func() {
+					So(func() { closing.ActionUndo() }, ShouldPanic)
+				})
+				Convey("Undoing cancels and deletes the closing entry and restores the lock date", func() {
+					journal.SetUpdatePosted(true)
+					closingMove := closing.ClosingMove()
+					closing.ActionUndo()
+					So(closing.State(), ShouldEqual, "cancelled")
+					So(h.AccountMove().Search(env, q.AccountMove().ID().Equals(closingMove.ID())).IsEmpty(), ShouldBeTrue)
+					So(company.FiscalyearLockDate().IsZero(), ShouldBeTrue)
+				})
+				Convey("Closings cannot be undone once the next year has entries", func() {
+					journal.SetUpdatePosted(true)
+					post("2015-01-15")
+					So(func() { closing.ActionUndo() }, ShouldPanic)
+				})
+			})
+		}), ShouldBeNil)
+	})
+}
@@ -0,0 +1,156 @@
+package account
+
+import (
+	"testing"
+
+	"github.com/hexya-addons/account/accounttypes"
+	"github.com/hexya-erp/hexya/src/models"
+	"github.com/hexya-erp/hexya/src/models/security"
+	"github.com/hexya-erp/hexya/src/models/types/dates"
+	"github.com/hexya-erp/pool/h"
+	"github.com/hexya-erp/pool/m"
+	"github.com/hexya-erp/pool/q"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestAccountGroup(t *testing.T) {
+	Convey("Tests account groups", t, FailureContinues, func() {
+		So(models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+			company := h.User().NewSet(env).CurrentUser().Company()
+			expenses := h.AccountAccountType().NewSet(env).GetRecord("account_data_account_type_expenses")
+			newGroup := func(name, start, end string) m.AccountGroupSet {
+				return h.AccountGroup().Create(env, h.AccountGroup().NewData().
+					SetName(name).
+					SetCodePrefixStart(start).
+					SetCodePrefixEnd(end).
+					SetCompany(company))
+			}
+			newAccount := func(code string) m.AccountAccountSet {
+				return h.AccountAccount().Create(env, h.AccountAccount().NewData().
+					SetCode(code).
+					SetName("Account "+code).
+					SetUserType(expenses).
+					SetCompany(company))
+			}
+			root := newGroup("Test Expenses", "97", "98")
+			purchases := newGroup("Test Purchases", "971", "972")
+			account := newAccount("971100")
+
+			Convey("Groups are nested by prefix range", func() {
+				So(purchases.Parent().ID(), ShouldEqual, root.ID())
+				So(root.Parent().IsEmpty(), ShouldBeTrue)
+				So(purchases.NameGet(), ShouldEqual, "971-972 Test Purchases")
+				So(root.WithDescendants().Len(), ShouldEqual, 2)
+			})
+			Convey("Accounts are assigned to their most specific group", func() {
+				So(account.Group().ID(), ShouldEqual, purchases.ID())
+				account.SetCode("980100")
+				So(account.Group().ID(), ShouldEqual, root.ID())
+				account.SetCode("990100")
+				So(account.Group().IsEmpty(), ShouldBeTrue)
+			})
+			Convey("Accounts are reassigned when groups change", func() {
+				services := newGroup("Test Services", "9711", "")
+				So(services.CodePrefixEnd(), ShouldEqual, "9711")
+				So(services.Parent().ID(), ShouldEqual, purchases.ID())
+				So(account.Group().ID(), ShouldEqual, services.ID())
+				services.Unlink()
+				So(account.Group().ID(), ShouldEqual, purchases.ID())
+			})
+			Convey("Overlapping groups are rejected", func() {
+				So(func() { newGroup("Overlap", "972", "973") }, ShouldPanic)
+				So(func() { newGroup("Straddle", "9690", "9710") }, ShouldPanic)
+				So(func() { newGroup("Bad Length", "97", "980") }, ShouldPanic)
+			})
+			Convey("Hierarchy lines hold group subtotals", func() {
+				other := newAccount("980200")
+				lines := h.AccountGroup().NewSet(env).HierarchyLines([]accounttypes.AccountReportLine{
+					{AccountID: other.ID(), Code: other.Code(), Debit: 50, Balance: 50},
+					{AccountID: account.ID(), Code: account.Code(), Debit: 100, Balance: 100},
+				})
+				So(lines, ShouldHaveLength, 4)
+				So(lines[0].GroupID, ShouldEqual, root.ID())
+				So(lines[0].Balance, ShouldEqual, 150)
+				So(lines[1].GroupID, ShouldEqual, purchases.ID())
+				So(lines[1].Level, ShouldEqual, 1)
+				So(lines[1].Balance, ShouldEqual, 100)
+				So(lines[2].AccountID, ShouldEqual, account.ID())
+				So(lines[2].Level, ShouldEqual, 2)
+				So(lines[3].AccountID, ShouldEqual, other.ID())
+				So(lines[3].Level, ShouldEqual, 1)
+			})
+			Convey("Reports can group accounts by account groups", func() {
+				other := newAccount("990100")
+				journal := h.AccountJournal().Search(env, q.AccountJournal().Type().Equals("general").
+					And().Company().Equals(company)).Limit(1)
+				move := h.AccountMove().Create(env, h.AccountMove().NewData().
+					SetJournal(journal).
+					SetDate(dates.Today()).
+					CreateLines(h.AccountMoveLine().NewData().
+						SetName("debit").
+						SetAccount(account).
+						SetDebit(100)).
+					CreateLines(h.AccountMoveLine().NewData().
+						SetName("credit").
+						SetAccount(other).
+						SetCredit(100)))
+				move.Post()
+				findLine := func(lines []accounttypes.AccountReportLine, groupID, accountID int64) *accounttypes.AccountReportLine {
+					for i, line := range lines {
+						if line.GroupID == groupID && line.AccountID == accountID {
+							return &lines[i]
+						}
+					}
+					return nil
+				}
+				Convey("in the trial balance", func() {
+					wizard := h.AccountBalanceReport().Create(env, h.AccountBalanceReport().NewData().
+						SetHierarchy(true))
+					So(wizard.CheckReport(), ShouldBeNil)
+					values := wizard.ComputeReport()
+					So(values.Data.Form.Hierarchy, ShouldBeTrue)
+					rootLine := findLine(values.Accounts, root.ID(), 0)
+					So(rootLine, ShouldNotBeNil)
+					So(rootLine.Balance, ShouldEqual, 100)
+					So(findLine(values.Accounts, purchases.ID(), 0).Balance, ShouldEqual, 100)
+					So(findLine(values.Accounts, 0, account.ID()).Level, ShouldEqual, 2)
+					So(findLine(values.Accounts, 0, other.ID()).Balance, ShouldEqual, -100)
+					wizard.SetHierarchy(false)
+					So(findLine(wizard.ComputeReport().Accounts, root.ID(), 0), ShouldBeNil)
+				})
+				Convey("in the general ledger", func() {
+					wizard := h.AccountReportGeneralLedger().Create(env, h.AccountReportGeneralLedger().NewData().
+						SetHierarchy(true))
+					values := wizard.ComputeReport()
+					So(findLine(values.Accounts, root.ID(), 0).Balance, ShouldEqual, 100)
+					accountLine := findLine(values.Accounts, 0, account.ID())
+					So(accountLine.MoveLines, ShouldHaveLength, 1)
+					So(accountLine.MoveLines[0].Debit, ShouldEqual, 100)
+				})
+				Convey("in financial reports", func() {
+					report := h.AccountFinancialReport().Create(env, h.AccountFinancialReport().NewData().
+						SetName("Test Report").
+						SetType("sum"))
+					h.AccountFinancialReport().Create(env, h.AccountFinancialReport().NewData().
+						SetName("Test Expenses").
+						SetParent(report).
+						SetType("account_groups").
+						SetAccountGroups(root).
+						SetDisplayDetail("detail_flat"))
+					wizard := h.AccountingReport().Create(env, h.AccountingReport().NewData().
+						SetAccountReport(report))
+					lines := wizard.ComputeReport().FinancialLines
+					So(lines, ShouldHaveLength, 3)
+					So(lines[0].Name, ShouldEqual, "Test Report")
+					So(lines[0].Balance, ShouldEqual, 100)
+					So(lines[1].Name, ShouldEqual, "Test Expenses")
+					So(lines[1].Level, ShouldEqual, 1)
+					So(lines[1].Balance, ShouldEqual, 100)
+					So(lines[2].Name, ShouldEqual, "971100 Account 971100")
+					So(lines[2].Type, ShouldEqual, "account")
+					So(lines[2].Balance, ShouldEqual, 100)
+				})
+			})
+		}), ShouldBeNil)
+	})
+}
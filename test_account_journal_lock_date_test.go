@@ -0,0 +1,76 @@
+package account
+
+import (
+	"testing"
+
+	"github.com/hexya-erp/hexya/src/models"
+	"github.com/hexya-erp/hexya/src/models/security"
+	"github.com/hexya-erp/hexya/src/models/types/dates"
+	"github.com/hexya-erp/pool/h"
+	"github.com/hexya-erp/pool/m"
+	"github.com/hexya-erp/pool/q"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestAccountJournalLockDate(t *testing.T) {
+	Convey("Tests period and journal lock dates and posting windows", t, FailureContinues, func() {
+		So(models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+			self := initTestAccountBaseUserStruct(env)
+			journal := h.AccountJournal().Search(env, q.AccountJournal().Type().Equals("general").
+				And().Company().Equals(self.MainCompany)).Limit(1)
+			account := h.AccountAccount().Search(env, q.AccountAccount().InternalType().Equals("other").
+				And().Company().Equals(self.MainCompany)).Limit(1)
+			yesterday := dates.Today().AddDate(0, 0, -1)
+			createMove := func(user m.UserSet, date dates.Date) m.AccountMoveSet {
+				return h.AccountMove().NewSet(env).Sudo(user.ID()).Create(h.AccountMove().NewData().
+					SetJournal(journal).
+					SetDate(date).
+					CreateLines(h.AccountMoveLine().NewData().
+						SetName("debit").
+						SetAccount(account).
+						SetDebit(10)).
+					CreateLines(h.AccountMoveLine().NewData().
+						SetName("credit").
+						SetAccount(account).
+						SetCredit(10)))
+			}
+			Convey("The period lock date only applies to non advisers", func() {
+				userMove := createMove(self.AccountUser, yesterday)
+				managerMove := createMove(self.AccountManager, yesterday)
+				self.MainCompany.SetPeriodLockDate(dates.Today())
+				So(func() { userMove.Post() }, ShouldPanic)
+				So(userMove.State(), ShouldEqual, "draft")
+				managerMove.Post()
+				So(managerMove.State(), ShouldEqual, "posted")
+			})
+			Convey("The journal lock date applies to advisers too", func() {
+				managerMove := createMove(self.AccountManager, yesterday)
+				otherMove := createMove(self.AccountManager, dates.Today().AddDate(0, 0, 1))
+				journal.SetLockDate(dates.Today())
+				So(func() { managerMove.Post() }, ShouldPanic)
+				So(managerMove.State(), ShouldEqual, "draft")
+				otherMove.Post()
+				So(otherMove.State(), ShouldEqual, "posted")
+			})
+			Convey("Entries can only be posted within the posting window of their journal", func() {
+				before := createMove(self.AccountManager, dates.Today().AddDate(0, 0, -10))
+				within := createMove(self.AccountManager, dates.Today())
+				after := createMove(self.AccountManager, dates.Today().AddDate(0, 0, 10))
+				journal.Write(h.AccountJournal().NewData().
+					SetPostingDateFrom(dates.Today().AddDate(0, 0, -5)).
+					SetPostingDateTo(dates.Today().AddDate(0, 0, 5)))
+				So(func() { before.Post() }, ShouldPanic)
+				So(func() { after.Post() }, ShouldPanic)
+				within.Post()
+				So(within.State(), ShouldEqual, "posted")
+			})
+			Convey("The posting window must end after it starts", func() {
+				So(func() {
+					journal.Write(h.AccountJournal().NewData().
+						SetPostingDateFrom(dates.Today()).
+						SetPostingDateTo(dates.Today().AddDate(0, 0, -1)))
+				}, ShouldPanic)
+			})
+		}), ShouldBeNil)
+	})
+}
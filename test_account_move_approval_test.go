@@ -0,0 +1,88 @@
+package account
+
+import (
+	"testing"
+
+	"github.com/hexya-erp/hexya/src/models"
+	"github.com/hexya-erp/hexya/src/models/security"
+	"github.com/hexya-erp/hexya/src/models/types/dates"
+	"github.com/hexya-erp/pool/h"
+	"github.com/hexya-erp/pool/m"
+	"github.com/hexya-erp/pool/q"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestAccountMoveApproval(t *testing.T) {
+	Convey("Tests approval of manual journal entries", t, FailureContinues, func() {
+		So(models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+			self := initTestAccountBaseUserStruct(env)
+			journal := h.AccountJournal().Search(env, q.AccountJournal().Type().Equals("general").
+				And().Company().Equals(self.MainCompany)).Limit(1)
+			account := h.AccountAccount().Search(env, q.AccountAccount().InternalType().Equals("other").
+				And().Company().Equals(self.MainCompany)).Limit(1)
+			journal.Write(h.AccountJournal().NewData().
+				SetMoveApproval(true).
+				SetApprovalThreshold(100))
+			createMove := func(user m.UserSet, amount float64) m.AccountMoveSet {
+				return h.AccountMove().NewSet(env).Sudo(user.ID()).Create(h.AccountMove().NewData().
+					SetJournal(journal).
+					SetDate(dates.Today()).
+					CreateLines(h.AccountMoveLine().NewData().
+						SetName("debit").
+						SetAccount(account).
+						SetDebit(amount)).
+					CreateLines(h.AccountMoveLine().NewData().
+						SetName("credit").
+						SetAccount(account).
+						SetCredit(amount)))
+			}
+			Convey("Entries below the threshold are posted directly", func() {
+				move := createMove(self.AccountUser, 50)
+				move.Post()
+				So(move.State(), ShouldEqual, "posted")
+			})
+			Convey("Entries above the threshold wait for approval", func() {
+				move := createMove(self.AccountUser, 500)
+				move.Post()
+				So(move.State(), ShouldEqual, "to_approve")
+				Convey("A plain user cannot approve or reject them", func() {
+					So(func() { move.ActionApprove() }, ShouldPanic)
+					So(func() { move.Reject("wrong account") }, ShouldPanic)
+					So(move.State(), ShouldEqual, "to_approve")
+				})
+				Convey("An adviser can approve them", func() {
+					managerMove := move.Sudo(self.AccountManager.ID())
+					So(managerMove.CanApprove(), ShouldBeTrue)
+					managerMove.ActionApprove()
+					So(move.State(), ShouldEqual, "posted")
+					So(move.ApprovedBy().Equals(self.AccountManager), ShouldBeTrue)
+				})
+				Convey("An adviser can reject them", func() {
+					move.Sudo(self.AccountManager.ID()).Reject("wrong account")
+					So(move.State(), ShouldEqual, "draft")
+					So(move.RejectedBy().Equals(self.AccountManager), ShouldBeTrue)
+				})
+			})
+			Convey("Journals without global threshold only apply the thresholds by account type", func() {
+				journal.SetApprovalThreshold(0)
+				move := createMove(self.AccountUser, 500)
+				move.Post()
+				So(move.State(), ShouldEqual, "posted")
+				h.AccountJournalApprovalRule().Create(env, h.AccountJournalApprovalRule().NewData().
+					SetJournal(journal).
+					SetAccountType(account.UserType()).
+					SetThreshold(100))
+				move = createMove(self.AccountUser, 500)
+				move.Post()
+				So(move.State(), ShouldEqual, "to_approve")
+			})
+			Convey("Advisers cannot approve their own entries", func() {
+				move := createMove(self.AccountManager, 500)
+				move.Post()
+				So(move.State(), ShouldEqual, "to_approve")
+				So(move.CanApprove(), ShouldBeFalse)
+				So(func() { move.ActionApprove() }, ShouldPanic)
+			})
+		}), ShouldBeNil)
+	})
+}
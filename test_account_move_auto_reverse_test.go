@@ -0,0 +1,70 @@
+package account
+
+import (
+	"testing"
+
+	"github.com/hexya-erp/hexya/src/models"
+	"github.com/hexya-erp/hexya/src/models/security"
+	"github.com/hexya-erp/hexya/src/models/types/dates"
+	"github.com/hexya-erp/pool/h"
+	"github.com/hexya-erp/pool/m"
+	"github.com/hexya-erp/pool/q"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestAccountMoveAutoReverse(t *testing.T) {
+	Convey("Tests the automatic reversal of journal entries", t, FailureContinues, func() {
+		So(models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+			tps := initTestPaymentStruct(env)
+			company := h.User().NewSet(env).CurrentUser().Company()
+			journal := h.AccountJournal().Search(env, q.AccountJournal().Type().Equals("general").
+				And().Company().Equals(company)).Limit(1)
+			date := dates.ParseDate("2015-06-30")
+			move := h.AccountMove().Create(env, h.AccountMove().NewData().
+				SetJournal(journal).
+				SetDate(date).
+				SetAutoReverseDate(date.AddDate(0, 0, 1)).
+				CreateLines(h.AccountMoveLine().NewData().
+					SetName("accrued revenue").
+					SetAccount(tps.AccountReceivable).
+					SetPartner(tps.PartnerAgrolait).
+					SetDebit(100)).
+				CreateLines(h.AccountMoveLine().NewData().
+					SetName("accrued revenue").
+					SetAccount(tps.AccountRevenue).
+					SetCredit(100)))
+
+			Convey("The reversal date must be after the entry date", func() {
+				So(func() { move.SetAutoReverseDate(date) }, ShouldPanic)
+			})
+			Convey("Draft entries are not reversed", func() {
+				So(move.AutoReverse().IsEmpty(), ShouldBeTrue)
+			})
+			Convey("Posted entries are reversed on their reversal date", func() {
+				move.Post()
+				reversal := move.AutoReverse()
+				So(reversal.Len(), ShouldEqual, 1)
+				So(reversal.State(), ShouldEqual, "posted")
+				So(reversal.Date().Equal(date.AddDate(0, 0, 1)), ShouldBeTrue)
+				So(reversal.ReversalOf().Equals(move), ShouldBeTrue)
+				So(move.Reversals().Equals(reversal), ShouldBeTrue)
+				tps.CheckJournalItems(reversal.Lines(), []TestAMLStruct{
+					{Account: tps.AccountReceivable, Credit: 100},
+					{Account: tps.AccountRevenue, Debit: 100},
+				})
+				receivableLines := move.Lines().Union(reversal.Lines()).Filtered(func(r m.AccountMoveLineSet) bool {
+					return r.Account().Equals(tps.AccountReceivable)
+				})
+				So(receivableLines.Len(), ShouldEqual, 2)
+				for _, line := range receivableLines.Records() {
+					So(line.Reconciled(), ShouldBeTrue)
+				}
+				So(receivableLines.Records()[0].FullReconcile().Equals(receivableLines.Records()[1].FullReconcile()), ShouldBeTrue)
+				Convey("Reversed entries are not reversed twice", func() {
+					So(move.AutoReverse().IsEmpty(), ShouldBeTrue)
+					So(move.Reversals().Len(), ShouldEqual, 1)
+				})
+			})
+		}), ShouldBeNil)
+	})
+}
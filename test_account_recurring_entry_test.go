@@ -0,0 +1,121 @@
+package account
+
+import (
+	"testing"
+
+	"github.com/hexya-erp/hexya/src/models"
+	"github.com/hexya-erp/hexya/src/models/security"
+	"github.com/hexya-erp/hexya/src/models/types/dates"
+	"github.com/hexya-erp/pool/h"
+	"github.com/hexya-erp/pool/m"
+	"github.com/hexya-erp/pool/q"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestAccountRecurringEntry(t *testing.T) {
+	Convey("Tests recurring journal entries", t, FailureContinues, func() {
+		So(models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+			company := h.User().NewSet(env).CurrentUser().Company()
+			journal := h.AccountJournal().Search(env, q.AccountJournal().Type().Equals("general").
+				And().Company().Equals(company)).Limit(1)
+			accounts := h.AccountAccount().Search(env, q.AccountAccount().InternalType().Equals("other").
+				And().Company().Equals(company)).Limit(2).Records()
+			newTemplate := func(data m.AccountRecurringEntryData) m.AccountRecurringEntrySet {
+				return h.AccountRecurringEntry().Create(env, data.
+					SetName("Rent").
+					SetJournal(journal).
+					CreateLines(h.AccountRecurringEntryLine().NewData().
+						SetSequence(1).
+						SetAccount(accounts[0]).
+						SetAmount(500)).
+					CreateLines(h.AccountRecurringEntryLine().NewData().
+						SetSequence(2).
+						SetAccount(accounts[1]).
+						SetSide("credit").
+						SetAmount(500)))
+			}
+			Convey("The next date is the day of the first period on or after the start date", func() {
+				template := newTemplate(h.AccountRecurringEntry().NewData().
+					SetDayOfPeriod(15).
+					SetDateStart(dates.ParseDate("2015-01-20")))
+				So(template.NextDate().Equal(dates.ParseDate("2015-02-15")), ShouldBeTrue)
+				template.SetRecurrence("quarterly")
+				So(template.NextDate().Equal(dates.ParseDate("2015-04-15")), ShouldBeTrue)
+			})
+			Convey("Missed entries are generated until the end date", func() {
+				template := newTemplate(h.AccountRecurringEntry().NewData().
+					SetDateStart(dates.ParseDate("2015-01-01")).
+					SetDateEnd(dates.ParseDate("2015-03-15")).
+					SetAutoPost(true))
+				moves := template.GenerateDueMoves(dates.ParseDate("2015-12-31"))
+				So(moves.Len(), ShouldEqual, 3)
+				So(template.MoveCount(), ShouldEqual, 3)
+				So(template.NextDate().IsZero(), ShouldBeTrue)
+				for _, move := range moves.Records() {
+					So(move.State(), ShouldEqual, "posted")
+					So(move.Date().Day(), ShouldEqual, 1)
+				}
+				So(template.GenerateDueMoves(dates.ParseDate("2016-12-31")).IsEmpty(), ShouldBeTrue)
+			})
+			Convey("Templates ending before their next date are not due anymore", func() {
+				template := newTemplate(h.AccountRecurringEntry().NewData().
+					SetDateStart(dates.ParseDate("2015-01-01")))
+				template.SetDateEnd(dates.ParseDate("2014-12-31"))
+				So(template.NextDate().IsZero(), ShouldBeTrue)
+				So(template.GenerateDueMoves(dates.ParseDate("2015-12-31")).IsEmpty(), ShouldBeTrue)
+			})
+			Convey("Rounding differences are put on the last line", func() {
+				template := h.AccountRecurringEntry().Create(env, h.AccountRecurringEntry().NewData().
+					SetName("Allocation").
+					SetJournal(journal).
+					SetTotalAmount(1000).
+					CreateLines(h.AccountRecurringEntryLine().NewData().
+						SetSequence(1).
+						SetAccount(accounts[0]).
+						SetAmountType("percentage").
+						SetAmount(33.3334)).
+					CreateLines(h.AccountRecurringEntryLine().NewData().
+						SetSequence(2).
+						SetAccount(accounts[0]).
+						SetAmountType("percentage").
+						SetAmount(33.3333)).
+					CreateLines(h.AccountRecurringEntryLine().NewData().
+						SetSequence(3).
+						SetAccount(accounts[0]).
+						SetAmountType("percentage").
+						SetAmount(33.3333)).
+					CreateLines(h.AccountRecurringEntryLine().NewData().
+						SetSequence(4).
+						SetAccount(accounts[1]).
+						SetSide("credit").
+						SetAmountType("percentage").
+						SetAmount(100)))
+				move := template.GenerateMove(dates.Today())
+				var debit, credit float64
+				for _, line := range move.Lines().Records() {
+					debit += line.Debit()
+					credit += line.Credit()
+				}
+				So(company.Currency().CompareAmounts(debit, credit), ShouldEqual, 0)
+				So(debit, ShouldAlmostEqual, 999.99, 0.001)
+			})
+			Convey("Unbalanced templates are rejected", func() {
+				So(func() {
+					h.AccountRecurringEntry().Create(env, h.AccountRecurringEntry().NewData().
+						SetName("Unbalanced").
+						SetJournal(journal).
+						CreateLines(h.AccountRecurringEntryLine().NewData().
+							SetAccount(accounts[0]).
+							SetAmount(100)))
+				}, ShouldPanic)
+			})
+			Convey("The day of period is checked when the recurrence changes", func() {
+				template := newTemplate(h.AccountRecurringEntry().NewData().
+					SetRecurrence("quarterly").
+					SetDayOfPeriod(60))
+				So(func() { template.SetRecurrence("monthly") }, ShouldPanic)
+				So(func() { template.SetDayOfPeriod(100) }, ShouldPanic)
+			})
+		}), ShouldBeNil)
+	})
+}
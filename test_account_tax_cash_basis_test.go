@@ -0,0 +1,124 @@
+package account
+
+import (
+	"testing"
+
+	"github.com/hexya-erp/hexya/src/models"
+	"github.com/hexya-erp/hexya/src/models/security"
+	"github.com/hexya-erp/hexya/src/models/types/dates"
+	"github.com/hexya-erp/pool/h"
+	"github.com/hexya-erp/pool/m"
+	"github.com/hexya-erp/pool/q"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestAccountTaxCashBasis(t *testing.T) {
+	Convey("Tests taxes based on payment", t, FailureContinues, func() {
+		So(models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+			tps := initTestPaymentStruct(env)
+			company := h.User().NewSet(env).CurrentUser().Company()
+			company.SetTaxCashBasisJournal(h.AccountJournal().Search(env, q.AccountJournal().Type().Equals("general").
+				And().Company().Equals(company)).Limit(1))
+			liabilities := h.AccountAccountType().NewSet(env).GetRecord("account_data_account_type_current_liabilities")
+			newAccount := func(code string) m.AccountAccountSet {
+				return h.AccountAccount().Create(env, h.AccountAccount().NewData().
+					SetCode(code).
+					SetName("Account "+code).
+					SetUserType(liabilities).
+					SetCompany(company))
+			}
+			taxAccount := newAccount("TAXCB1")
+			transitionAccount := newAccount("TAXCB2")
+			tax := h.AccountTax().Create(env, h.AccountTax().NewData().
+				SetName("Cash Basis Tax 10").
+				SetAmountType("percent").
+				SetAmount(10).
+				SetTypeTaxUse("sale").
+				SetAccount(taxAccount).
+				SetTaxExigibility("on_payment").
+				SetCashBasisTransitionAccount(transitionAccount))
+			balance := func(account m.AccountAccountSet) float64 {
+				var res float64
+				for _, line := range h.AccountMoveLine().Search(env, q.AccountMoveLine().Account().Equals(account).
+					And().MoveFilteredOn(q.AccountMove().State().Equals("posted"))).Records() {
+					res += line.Debit() - line.Credit()
+				}
+				return company.Currency().Round(res)
+			}
+
+			invoice := h.AccountInvoice().Create(env, h.AccountInvoice().NewData().
+				SetPartner(tps.PartnerAgrolait).
+				SetReferenceType("none").
+				SetAccount(tps.AccountReceivable).
+				SetType("out_invoice").
+				SetDateInvoice(dates.ParseDate("2015-06-26")).
+				CreateInvoiceLines(h.AccountInvoiceLine().NewData().
+					SetProduct(tps.Product).
+					SetQuantity(1).
+					SetPriceUnit(100).
+					SetName("something").
+					SetAccount(tps.AccountRevenue).
+					SetInvoiceLineTaxes(tax)))
+			invoice.ActionInvoiceOpen()
+			So(invoice.AmountTotal(), ShouldEqual, 110)
+
+			Convey("The tax is booked on the transition account until the invoice is paid", func() {
+				So(balance(transitionAccount), ShouldEqual, -10)
+				So(balance(taxAccount), ShouldEqual, 0)
+				for _, line := range invoice.Move().Lines().Records() {
+					if line.TaxLine().Equals(tax) || line.Taxes().Equals(tax) {
+						So(line.TaxExigible(), ShouldBeFalse)
+					}
+				}
+			})
+			Convey("Partial payments make the paid part of the tax exigible", func() {
+				payment := h.AccountMove().Create(env, h.AccountMove().NewData().
+					SetJournal(tps.BankJournalEuro).
+					SetDate(dates.ParseDate("2015-07-15")).
+					CreateLines(h.AccountMoveLine().NewData().
+						SetName("payment").
+						SetAccount(tps.AccountEur).
+						SetDebit(55)).
+					CreateLines(h.AccountMoveLine().NewData().
+						SetName("payment").
+						SetAccount(tps.AccountReceivable).
+						SetPartner(tps.PartnerAgrolait).
+						SetCredit(55)))
+				payment.Post()
+				receivableLines := invoice.Move().Lines().Union(payment.Lines()).Filtered(func(r m.AccountMoveLineSet) bool {
+					return r.Account().Equals(tps.AccountReceivable)
+				})
+				receivableLines.Reconcile(h.AccountAccount().NewSet(env), h.AccountJournal().NewSet(env))
+				So(invoice.Residual(), ShouldEqual, 55)
+
+				paymentLine := payment.Lines().Filtered(func(r m.AccountMoveLineSet) bool {
+					return r.Account().Equals(tps.AccountReceivable)
+				})
+				partial := paymentLine.MatchedDebits()
+				So(partial.Len(), ShouldEqual, 1)
+				cashBasisMove := h.AccountMove().Search(env, q.AccountMove().TaxCashBasisRec().Equals(partial))
+				So(cashBasisMove.Len(), ShouldEqual, 1)
+				So(cashBasisMove.State(), ShouldEqual, "posted")
+				So(cashBasisMove.Journal().Equals(company.TaxCashBasisJournal()), ShouldBeTrue)
+				So(balance(transitionAccount), ShouldEqual, -5)
+				So(balance(taxAccount), ShouldEqual, -5)
+				var base float64
+				for _, line := range cashBasisMove.Lines().Records() {
+					if line.Taxes().Equals(tax) {
+						So(line.TaxExigible(), ShouldBeTrue)
+						base += line.Credit() - line.Debit()
+					}
+				}
+				So(base, ShouldEqual, 50)
+
+				Convey("Unreconciling reverses the cash basis entry", func() {
+					paymentLine.RemoveMoveReconcile()
+					So(invoice.Residual(), ShouldEqual, 110)
+					So(cashBasisMove.TaxCashBasisRec().IsEmpty(), ShouldBeTrue)
+					So(balance(transitionAccount), ShouldEqual, -10)
+					So(balance(taxAccount), ShouldEqual, 0)
+				})
+			})
+		}), ShouldBeNil)
+	})
+}
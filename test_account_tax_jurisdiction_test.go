@@ -0,0 +1,124 @@
+package account
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hexya-addons/account/jurisdiction"
+	"github.com/hexya-erp/hexya/src/models"
+	"github.com/hexya-erp/hexya/src/models/security"
+	"github.com/hexya-erp/hexya/src/models/types/dates"
+	"github.com/hexya-erp/pool/h"
+	"github.com/hexya-erp/pool/m"
+	"github.com/hexya-erp/pool/q"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestAccountTaxJurisdiction(t *testing.T) {
+	Convey("Tests the taxes of sales tax jurisdictions on invoices", t, FailureContinues, func() {
+		So(models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+			tps := initTestPaymentStruct(env)
+			company := h.User().NewSet(env).CurrentUser().Company()
+			taxAccount := h.AccountAccount().Search(env, q.AccountAccount().InternalType().Equals("other").
+				And().Company().Equals(company)).Limit(1)
+			newRate := func(zipFrom, zipTo, code, level string, rate float64) jurisdiction.Rate {
+				lo, hi, _ := jurisdiction.ZipBounds(zipFrom, zipTo)
+				return jurisdiction.Rate{
+					ZipFrom:  lo,
+					ZipTo:    hi,
+					Code:     code,
+					Name:     "Jurisdiction " + code,
+					Level:    level,
+					Rate:     rate,
+					DateFrom: time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC),
+				}
+			}
+			h.AccountTaxJurisdiction().NewSet(env).LoadRates([]jurisdiction.Rate{
+				newRate("90001", "96162", "TSTATE", "state", 6),
+				newRate("90210", "", "TCITY", "city", 0.5),
+			}, company, company.Country(), taxAccount)
+			stateTax := h.AccountTax().Search(env, q.AccountTax().JurisdictionFilteredOn(q.AccountTaxJurisdiction().Code().Equals("TSTATE")))
+			cityTax := h.AccountTax().Search(env, q.AccountTax().JurisdictionFilteredOn(q.AccountTaxJurisdiction().Code().Equals("TCITY")))
+			So(stateTax.Len(), ShouldEqual, 1)
+			So(cityTax.Len(), ShouldEqual, 1)
+
+			fiscalPosition := h.AccountFiscalPosition().Create(env, h.AccountFiscalPosition().NewData().
+				SetName("Sales Tax Jurisdictions").
+				SetTaxJurisdictions(true))
+			newPartner := func(name, zip string) m.PartnerSet {
+				return h.Partner().Create(env, h.Partner().NewData().
+					SetName(name).
+					SetZip(zip))
+			}
+			cityPartner := newPartner("City Customer", "90210")
+			statePartner := newPartner("State Customer", "94105")
+			otherTax := h.AccountTax().Create(env, h.AccountTax().NewData().
+				SetName("Other Tax 1").
+				SetAmountType("percent").
+				SetAmount(1).
+				SetTypeTaxUse("sale"))
+			newInvoice := func(fPos m.AccountFiscalPositionSet) m.AccountInvoiceSet {
+				return h.AccountInvoice().Create(env, h.AccountInvoice().NewData().
+					SetPartner(cityPartner).
+					SetFiscalPosition(fPos).
+					SetReferenceType("none").
+					SetAccount(tps.AccountReceivable).
+					SetType("out_invoice").
+					SetDateInvoice(dates.ParseDate("2015-06-26")).
+					CreateInvoiceLines(h.AccountInvoiceLine().NewData().
+						SetQuantity(1).
+						SetPriceUnit(100).
+						SetName("goods").
+						SetAccount(tps.AccountRevenue).
+						SetInvoiceLineTaxes(otherTax)))
+			}
+
+			Convey("Created lines get the taxes of the jurisdictions of the customer", func() {
+				invoice := newInvoice(fiscalPosition)
+				So(invoice.InvoiceLines().InvoiceLineTaxes().Equals(otherTax.Union(stateTax).Union(cityTax)), ShouldBeTrue)
+				So(invoice.AmountTax(), ShouldEqual, 7.5)
+				So(invoice.AmountTotal(), ShouldEqual, 107.5)
+			})
+			Convey("Jurisdiction taxes are replaced when the customer changes", func() {
+				invoice := newInvoice(fiscalPosition)
+				invoice.SetPartner(statePartner)
+				So(invoice.InvoiceLines().InvoiceLineTaxes().Equals(otherTax.Union(stateTax)), ShouldBeTrue)
+				So(invoice.AmountTax(), ShouldEqual, 7)
+			})
+			Convey("Jurisdiction taxes are removed with the fiscal position", func() {
+				invoice := newInvoice(fiscalPosition)
+				invoice.SetFiscalPosition(h.AccountFiscalPosition().NewSet(env))
+				So(invoice.InvoiceLines().InvoiceLineTaxes().Equals(otherTax), ShouldBeTrue)
+				So(invoice.AmountTax(), ShouldEqual, 1)
+			})
+			Convey("Jurisdiction taxes follow the delivery address of the customer", func() {
+				h.Partner().Create(env, h.Partner().NewData().
+					SetName("State Delivery").
+					SetType("delivery").
+					SetParent(cityPartner).
+					SetZip("94105"))
+				invoice := newInvoice(fiscalPosition)
+				So(invoice.InvoiceLines().InvoiceLineTaxes().Equals(otherTax.Union(stateTax)), ShouldBeTrue)
+				So(invoice.AmountTax(), ShouldEqual, 7)
+			})
+			Convey("Lines without sales tax do not get jurisdiction taxes", func() {
+				invoice := newInvoice(fiscalPosition)
+				exempt := h.AccountInvoiceLine().Create(env, h.AccountInvoiceLine().NewData().
+					SetInvoice(invoice).
+					SetQuantity(1).
+					SetPriceUnit(50).
+					SetName("service").
+					SetAccount(tps.AccountRevenue))
+				So(exempt.InvoiceLineTaxes().IsEmpty(), ShouldBeTrue)
+				invoice.SetPartner(statePartner)
+				So(exempt.InvoiceLineTaxes().IsEmpty(), ShouldBeTrue)
+				So(invoice.AmountTax(), ShouldEqual, 7)
+			})
+			Convey("Invoices without jurisdiction fiscal position are not affected", func() {
+				invoice := newInvoice(h.AccountFiscalPosition().NewSet(env))
+				So(invoice.InvoiceLines().InvoiceLineTaxes().Equals(otherTax), ShouldBeTrue)
+				So(invoice.AmountTax(), ShouldEqual, 1)
+			})
+		}), ShouldBeNil)
+	})
+}
@@ -0,0 +1,77 @@
+package account
+
+import (
+	"testing"
+
+	"github.com/hexya-erp/hexya/src/models"
+	"github.com/hexya-erp/hexya/src/models/security"
+	"github.com/hexya-erp/hexya/src/models/types/dates"
+	"github.com/hexya-erp/pool/h"
+	"github.com/hexya-erp/pool/m"
+	"github.com/hexya-erp/pool/q"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type TestAccountTaxLockDateStruct struct {
+	Super          TestAccountBaseStruct
+	GeneralJournal m.AccountJournalSet
+	Account        m.AccountAccountSet
+	Tax            m.AccountTaxSet
+}
+
+func initTestAccountTaxLockDateStruct(env models.Environment) TestAccountTaxLockDateStruct {
+	var out TestAccountTaxLockDateStruct
+	out.Super = initTestAccountBaseStruct(env)
+	journals := h.AccountJournal().Search(env, q.AccountJournal().Type().Equals("general"))
+	So(journals.IsNotEmpty(), ShouldBeTrue)
+	out.GeneralJournal = journals.Records()[0]
+	accounts := h.AccountAccount().Search(env, q.AccountAccount().InternalType().Equals("other").
+		And().Company().Equals(out.GeneralJournal.Company()))
+	So(accounts.IsNotEmpty(), ShouldBeTrue)
+	out.Account = accounts.Records()[0]
+	taxes := h.AccountTax().Search(env, q.AccountTax().Company().Equals(out.GeneralJournal.Company()))
+	So(taxes.IsNotEmpty(), ShouldBeTrue)
+	out.Tax = taxes.Records()[0]
+	return out
+}
+
+func TestAccountTaxLockDate(t *testing.T) {
+	Convey("Tests Tax Lock Date", t, FailureContinues, func() {
+		So(models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+			self := initTestAccountTaxLockDateStruct(env)
+			yesterday := dates.Today().AddDate(0, 0, -1)
+			createMove := func(tax m.AccountTaxSet) m.AccountMoveSet {
+				return h.AccountMove().Create(env,
+					h.AccountMove().NewData().
+						SetJournal(self.GeneralJournal).
+						SetDate(yesterday).
+						CreateLines(h.AccountMoveLine().NewData().
+							SetName("foo").
+							SetDebit(10).
+							SetAccount(self.Account).
+							SetTaxLine(tax)).
+						CreateLines(h.AccountMoveLine().NewData().
+							SetName("bar").
+							SetCredit(10).
+							SetAccount(self.Account)))
+			}
+			plainMove := createMove(h.AccountTax().NewSet(env))
+			taxMove := createMove(self.Tax)
+			self.GeneralJournal.Company().SetTaxLockDate(dates.Today())
+			Convey("Moves without taxes can still be posted in a tax locked period", func() {
+				So(plainMove.HasTaxLines(), ShouldBeFalse)
+				plainMove.Post()
+				So(plainMove.State(), ShouldEqual, "posted")
+			})
+			Convey("Moves with taxes cannot be posted in a tax locked period", func() {
+				So(taxMove.HasTaxLines(), ShouldBeTrue)
+				So(func() { taxMove.Post() }, ShouldPanic)
+			})
+			Convey("Moves with taxes can be posted after the tax lock date", func() {
+				taxMove.SetDate(dates.Today().AddDate(0, 0, 1))
+				taxMove.Post()
+				So(taxMove.State(), ShouldEqual, "posted")
+			})
+		}), ShouldBeNil)
+	})
+}
@@ -0,0 +1,192 @@
+package account
+
+import (
+	"testing"
+
+	"github.com/hexya-erp/hexya/src/models"
+	"github.com/hexya-erp/hexya/src/models/security"
+	"github.com/hexya-erp/hexya/src/models/types/dates"
+	"github.com/hexya-erp/pool/h"
+	"github.com/hexya-erp/pool/m"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestAccountTaxReturnClosePeriod(t *testing.T) {
+	Convey("Tests the closing of tax return periods", t, FailureContinues, func() {
+		So(models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+			company := h.Company().Create(env, h.Company().NewData().
+				SetName("Tax Closing Company").
+				SetCurrency(h.Currency().NewSet(env).GetRecord("base_EUR")))
+			newAccount := func(code, accountType string, reconcile bool) m.AccountAccountSet {
+				return h.AccountAccount().Create(env, h.AccountAccount().NewData().
+					SetCode(code).
+					SetName("Account "+code).
+					SetUserType(h.AccountAccountType().NewSet(env).GetRecord(accountType)).
+					SetReconcile(reconcile).
+					SetCompany(company))
+			}
+			receivable := newAccount("411000", "account_data_account_type_receivable", true)
+			revenue := newAccount("700000", "account_data_account_type_revenue", false)
+			taxAccount := newAccount("445710", "account_data_account_type_current_liabilities", false)
+			taxPayable := newAccount("445510", "account_data_account_type_current_liabilities", false)
+			taxReceivable := newAccount("445670", "account_data_account_type_current_assets", false)
+			tax := h.AccountTax().Create(env, h.AccountTax().NewData().
+				SetName("Tax 20.0").
+				SetAmountType("percent").
+				SetAmount(20).
+				SetTypeTaxUse("sale").
+				SetAccount(taxAccount).
+				SetRefundAccount(taxAccount).
+				SetCompany(company))
+			journal := h.AccountJournal().Create(env, h.AccountJournal().NewData().
+				SetName("Tax Operations").
+				SetCode("TAXC").
+				SetType("general").
+				SetCompany(company))
+			sale := h.AccountMove().Create(env, h.AccountMove().NewData().
+				SetJournal(journal).
+				SetDate(dates.ParseDate("2015-02-15")).
+				CreateLines(h.AccountMoveLine().NewData().
+					SetName("sale").
+					SetAccount(receivable).
+					SetDebit(120)).
+				CreateLines(h.AccountMoveLine().NewData().
+					SetName("sale").
+					SetAccount(revenue).
+					SetCredit(100)).
+				CreateLines(h.AccountMoveLine().NewData().
+					SetName("tax").
+					SetAccount(taxAccount).
+					SetTaxLine(tax).
+					SetCredit(20)))
+			report := h.AccountTaxReport().Create(env, h.AccountTaxReport().NewData().
+				SetName("Test Tax Report"))
+			taxReturn := h.AccountTaxReturn().Create(env, h.AccountTaxReturn().NewData().
+				SetCompany(company).
+				SetReport(report).
+				SetDateFrom(dates.ParseDate("2015-01-01")).
+				SetDateTo(dates.ParseDate("2015-03-31")).
+				SetJournal(journal))
+
+			Convey("The tax payable and receivable accounts of the company are required", func() {
+				sale.Post()
+				So(func() { taxReturn.ActionClosePeriod() }, ShouldPanic)
+			})
+			company.SetTaxPayableAccount(taxPayable)
+			company.SetTaxReceivableAccount(taxReceivable)
+			Convey("Periods with unposted tax entries cannot be closed", func() {
+				So(func() { taxReturn.ActionClosePeriod() }, ShouldPanic)
+				So(company.TaxLockDate().IsZero(), ShouldBeTrue)
+			})
+			Convey("Closing books the tax balances into the tax payable account and locks the period", func() {
+				sale.Post()
+				action := taxReturn.ActionClosePeriod()
+				closingMove := h.AccountMove().BrowseOne(env, action.ResID)
+				So(closingMove.State(), ShouldEqual, "posted")
+				So(closingMove.Date().Equal(dates.ParseDate("2015-03-31")), ShouldBeTrue)
+				So(closingMove.Journal().Equals(journal), ShouldBeTrue)
+				closingLine := func(account m.AccountAccountSet) m.AccountMoveLineSet {
+					return closingMove.Lines().Filtered(func(r m.AccountMoveLineSet) bool {
+						return r.Account().Equals(account)
+					})
+				}
+				So(closingMove.Lines().Len(), ShouldEqual, 2)
+				So(closingLine(taxAccount).Debit(), ShouldEqual, 20)
+				So(closingLine(taxPayable).Credit(), ShouldEqual, 20)
+				So(company.TaxLockDate().Equal(dates.ParseDate("2015-03-31")), ShouldBeTrue)
+				So(taxReturn.TaxLockDate().Equal(dates.ParseDate("2015-03-31")), ShouldBeTrue)
+				Convey("Closed periods cannot be closed again", func() {
+					So(func() { taxReturn.ActionClosePeriod() }, ShouldPanic)
+				})
+				Convey("Tax entries cannot be posted in the closed period", func() {
+					lateSale := sale.Copy(h.AccountMove().NewData().SetDate(dates.ParseDate("2015-03-15")))
+					So(func() { lateSale.Post() }, ShouldPanic)
+				})
+			})
+			Convey("Tax credits are booked into the tax receivable account", func() {
+				sale.Post()
+				h.AccountMove().Create(env, h.AccountMove().NewData().
+					SetJournal(journal).
+					SetDate(dates.ParseDate("2015-03-10")).
+					CreateLines(h.AccountMoveLine().NewData().
+						SetName("refund").
+						SetAccount(receivable).
+						SetCredit(180)).
+					CreateLines(h.AccountMoveLine().NewData().
+						SetName("refund").
+						SetAccount(revenue).
+						SetDebit(150)).
+					CreateLines(h.AccountMoveLine().NewData().
+						SetName("tax").
+						SetAccount(taxAccount).
+						SetTaxLine(tax).
+						SetDebit(30))).Post()
+				action := taxReturn.ActionClosePeriod()
+				closingMove := h.AccountMove().BrowseOne(env, action.ResID)
+				closingLine := func(account m.AccountAccountSet) m.AccountMoveLineSet {
+					return closingMove.Lines().Filtered(func(r m.AccountMoveLineSet) bool {
+						return r.Account().Equals(account)
+					})
+				}
+				So(closingLine(taxAccount).Credit(), ShouldEqual, 10)
+				So(closingLine(taxReceivable).Debit(), ShouldEqual, 10)
+				So(closingLine(taxPayable).IsEmpty(), ShouldBeTrue)
+			})
+			Convey("The accounts of the repartition lines of reverse charge taxes are closed", func() {
+				sale.Post()
+				taxDue := newAccount("445200", "account_data_account_type_current_liabilities", false)
+				taxDeductible := newAccount("445660", "account_data_account_type_current_assets", false)
+				expense := newAccount("607000", "account_data_account_type_expenses", false)
+				payable := newAccount("401000", "account_data_account_type_payable", true)
+				reverseCharge := h.AccountTax().Create(env, h.AccountTax().NewData().
+					SetName("Reverse Charge 20.0").
+					SetAmountType("percent").
+					SetAmount(20).
+					SetTypeTaxUse("purchase").
+					SetCompany(company).
+					CreateRepartitions(h.AccountTaxRepartitionLine().NewData().
+						SetSequence(1).
+						SetFactor(100).
+						SetAccount(taxDue).
+						SetRefundAccount(taxDue)).
+					CreateRepartitions(h.AccountTaxRepartitionLine().NewData().
+						SetSequence(2).
+						SetFactor(-100).
+						SetAccount(taxDeductible).
+						SetRefundAccount(taxDeductible)))
+				h.AccountMove().Create(env, h.AccountMove().NewData().
+					SetJournal(journal).
+					SetDate(dates.ParseDate("2015-03-10")).
+					CreateLines(h.AccountMoveLine().NewData().
+						SetName("purchase").
+						SetAccount(payable).
+						SetCredit(500)).
+					CreateLines(h.AccountMoveLine().NewData().
+						SetName("purchase").
+						SetAccount(expense).
+						SetDebit(500)).
+					CreateLines(h.AccountMoveLine().NewData().
+						SetName("tax due").
+						SetAccount(taxDue).
+						SetTaxLine(reverseCharge).
+						SetCredit(100)).
+					CreateLines(h.AccountMoveLine().NewData().
+						SetName("tax deductible").
+						SetAccount(taxDeductible).
+						SetTaxLine(reverseCharge).
+						SetDebit(100))).Post()
+				action := taxReturn.ActionClosePeriod()
+				closingMove := h.AccountMove().BrowseOne(env, action.ResID)
+				closingLine := func(account m.AccountAccountSet) m.AccountMoveLineSet {
+					return closingMove.Lines().Filtered(func(r m.AccountMoveLineSet) bool {
+						return r.Account().Equals(account)
+					})
+				}
+				So(closingLine(taxDue).Debit(), ShouldEqual, 100)
+				So(closingLine(taxDeductible).Credit(), ShouldEqual, 100)
+				So(closingLine(taxAccount).Debit(), ShouldEqual, 20)
+				So(closingLine(taxPayable).Credit(), ShouldEqual, 20)
+			})
+		}), ShouldBeNil)
+	})
+}
@@ -0,0 +1,90 @@
+package account
+
+import (
+	"testing"
+
+	"github.com/hexya-erp/hexya/src/models"
+	"github.com/hexya-erp/hexya/src/models/security"
+	"github.com/hexya-erp/hexya/src/models/types/dates"
+	"github.com/hexya-erp/pool/h"
+	"github.com/hexya-erp/pool/m"
+	"github.com/hexya-erp/pool/q"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestAccountTaxWithholding(t *testing.T) {
+	Convey("Tests taxes withheld on payments", t, FailureContinues, func() {
+		So(models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+			tps := initTestPaymentStruct(env)
+			company := h.User().NewSet(env).CurrentUser().Company()
+			taxAccount := h.AccountAccount().Create(env, h.AccountAccount().NewData().
+				SetCode("WHT001").
+				SetName("Withheld Taxes").
+				SetUserType(h.AccountAccountType().NewSet(env).GetRecord("account_data_account_type_current_liabilities")).
+				SetCompany(company))
+			tax := h.AccountTax().Create(env, h.AccountTax().NewData().
+				SetName("Withholding 10").
+				SetAmountType("percent").
+				SetAmount(10).
+				SetTypeTaxUse("purchase").
+				SetAccount(taxAccount).
+				SetWithholding(true))
+			invoice := h.AccountInvoice().Create(env, h.AccountInvoice().NewData().
+				SetPartner(tps.PartnerAgrolait).
+				SetReferenceType("none").
+				SetAccount(tps.AccountPayable).
+				SetType("in_invoice").
+				SetDateInvoice(dates.ParseDate("2015-06-26")).
+				CreateInvoiceLines(h.AccountInvoiceLine().NewData().
+					SetProduct(tps.Product).
+					SetQuantity(1).
+					SetPriceUnit(1000).
+					SetName("services").
+					SetAccount(tps.AccountRevenue).
+					SetInvoiceLineTaxes(tax)))
+			invoice.ActionInvoiceOpen()
+			So(invoice.AmountTotal(), ShouldEqual, 1000)
+
+			pay := func(amount float64) m.AccountPaymentSet {
+				payment := h.AccountPayment().Create(env, h.AccountPayment().NewData().
+					SetPaymentDate(dates.ParseDate("2015-07-15")).
+					SetPaymentType("outbound").
+					SetPartnerType("supplier").
+					SetPartner(tps.PartnerAgrolait).
+					SetAmount(amount).
+					SetCurrency(tps.CurrencyEur).
+					SetJournal(tps.BankJournalEuro).
+					SetPaymentMethod(tps.PaymentMethodManualOut).
+					SetInvoices(invoice))
+				payment.Post()
+				return payment
+			}
+			Convey("Only the net amount is paid to the vendor", func() {
+				payment := pay(1000)
+				So(payment.WithholdingAmount(), ShouldEqual, 100)
+				tps.CheckJournalItems(payment.MoveLines(), []TestAMLStruct{
+					{Account: tps.AccountPayable, Debit: 1000},
+					{Account: taxAccount, Credit: 100},
+					{Account: tps.AccountEur, Credit: 900},
+				})
+				whLine := payment.MoveLines().Filtered(func(r m.AccountMoveLineSet) bool {
+					return r.Account().Equals(taxAccount)
+				})
+				So(whLine.TaxLine().Equals(tax), ShouldBeTrue)
+				So(whLine.WithholdingBase(), ShouldEqual, 1000)
+				So(invoice.State(), ShouldEqual, "paid")
+			})
+			Convey("Partial payments withhold the tax of the paid part of the base", func() {
+				payment := pay(500)
+				So(payment.WithholdingAmount(), ShouldEqual, 50)
+				tps.CheckJournalItems(payment.MoveLines(), []TestAMLStruct{
+					{Account: tps.AccountPayable, Debit: 500},
+					{Account: taxAccount, Credit: 50},
+					{Account: tps.AccountEur, Credit: 450},
+				})
+				So(invoice.Residual(), ShouldEqual, 500)
+				So(h.AccountMoveLine().Search(env, q.AccountMoveLine().Account().Equals(taxAccount)).Len(), ShouldEqual, 1)
+			})
+		}), ShouldBeNil)
+	})
+}
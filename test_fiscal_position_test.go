@@ -5,8 +5,10 @@ import (
 
 	"github.com/hexya-erp/hexya/src/models"
 	"github.com/hexya-erp/hexya/src/models/security"
+	"github.com/hexya-erp/hexya/src/models/types/dates"
 	"github.com/hexya-erp/pool/h"
 	"github.com/hexya-erp/pool/m"
+	"github.com/hexya-erp/pool/q"
 	. "github.com/smartystreets/goconvey/convey"
 )
 
@@ -53,7 +55,7 @@ func initTestFiscalPositionStruct(env models.Environment) TestFiscalPositionStru
 		SetCountry(out.Be))
 	out.George = h.Partner().Create(env, h.Partner().NewData().
 		SetName("George").
-		SetVAT("FR0477472701").
+		SetVAT("FR40303265045").
 		//SetNotifyEmail("none").
 		SetCountry(out.Fr))
 	out.Alberto = h.Partner().Create(env, h.Partner().NewData().
@@ -164,3 +166,79 @@ func Test10FpCountry(t *testing.T) {
 		}), ShouldBeNil)
 	})
 }
+
+func TestFpDatedMappings(t *testing.T) {
+	Convey("Test fiscal position mappings with validity dates", t, FailureContinues, func() {
+		So(models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+			newTax := func(name string, amount float64) m.AccountTaxSet {
+				return h.AccountTax().Create(env, h.AccountTax().NewData().
+					SetName(name).
+					SetAmountType("percent").
+					SetAmount(amount))
+			}
+			srcTax := newTax("Source Tax 10", 10)
+			destTax := newTax("Export Tax 0", 0)
+			accounts := h.AccountAccount().Search(env, q.AccountAccount().InternalType().Equals("other")).Limit(2).Records()
+			fPos := h.AccountFiscalPosition().Create(env, h.AccountFiscalPosition().NewData().
+				SetName("Dated Export").
+				CreateTaxes(h.AccountFiscalPositionTax().NewData().
+					SetTaxSrc(srcTax).
+					SetTaxDest(destTax).
+					SetDateTo(dates.ParseDate("2015-06-30"))).
+				CreateAccounts(h.AccountFiscalPositionAccount().NewData().
+					SetAccountSrc(accounts[0]).
+					SetAccountDest(accounts[1]).
+					SetDateFrom(dates.ParseDate("2015-07-01"))))
+			atDate := func(date string) m.AccountFiscalPositionSet {
+				return fPos.WithContext("fiscal_position_date", dates.ParseDate(date))
+			}
+			noProduct := h.ProductProduct().NewSet(env)
+			noPartner := h.Partner().NewSet(env)
+
+			Convey("Mappings only apply during their validity period", func() {
+				So(atDate("2015-06-30").MapTax(srcTax, noProduct, noPartner).Equals(destTax), ShouldBeTrue)
+				So(atDate("2015-07-01").MapTax(srcTax, noProduct, noPartner).Equals(srcTax), ShouldBeTrue)
+				So(atDate("2015-06-30").MapAccount(accounts[0]).Equals(accounts[0]), ShouldBeTrue)
+				So(atDate("2015-07-01").MapAccount(accounts[0]).Equals(accounts[1]), ShouldBeTrue)
+				mapped := atDate("2015-07-01").MapAccounts(map[string]m.AccountAccountSet{"income": accounts[0]})
+				So(mapped["income"].Equals(accounts[1]), ShouldBeTrue)
+			})
+			Convey("Mappings of a tax apply to its successors", func() {
+				successor := newTax("Source Tax 12", 12)
+				srcTax.Write(h.AccountTax().NewData().
+					SetValidUntil(dates.ParseDate("2015-03-31")).
+					SetSuccessorTax(successor))
+				So(atDate("2015-03-31").MapTax(srcTax, noProduct, noPartner).Equals(destTax), ShouldBeTrue)
+				So(atDate("2015-04-01").MapTax(successor, noProduct, noPartner).Equals(destTax), ShouldBeTrue)
+				So(atDate("2015-07-01").MapTax(srcTax, noProduct, noPartner).Equals(successor), ShouldBeTrue)
+			})
+			Convey("The generic date key of the context is ignored", func() {
+				So(fPos.WithContext("date", dates.ParseDate("2015-06-01")).MapTax(srcTax, noProduct, noPartner).Equals(srcTax), ShouldBeTrue)
+				So(fPos.WithContext("date", dates.ParseDate("2015-06-01")).MapAccount(accounts[0]).Equals(accounts[1]), ShouldBeTrue)
+			})
+		}), ShouldBeNil)
+	})
+}
+
+func TestFpVATCheck(t *testing.T) {
+	Convey("Test fiscal positions of partners with an invalid VAT number", t, FailureContinues, func() {
+		So(models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+			company := h.User().NewSet(env).CurrentUser().Company()
+			company.SetVATCheck("warning")
+			self := initTestFiscalPositionStruct(env)
+			self.George.SetVAT("FR0477472701")
+			So(self.George.VATIsValid(), ShouldBeFalse)
+			getFP := func(partner m.PartnerSet) m.AccountFiscalPositionSet {
+				return h.AccountFiscalPosition().NewSet(env).GetFiscalPosition(partner, h.Partner().NewSet(env))
+			}
+
+			Convey("Invalid VAT numbers do not match VAT required positions", func() {
+				So(getFP(self.George).Equals(self.FrB2C), ShouldBeTrue)
+			})
+			Convey("Any VAT number matches VAT required positions when VAT numbers are not checked", func() {
+				company.SetVATCheck("none")
+				So(getFP(self.George).Equals(self.FrB2B), ShouldBeTrue)
+			})
+		}), ShouldBeNil)
+	})
+}
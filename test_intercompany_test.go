@@ -0,0 +1,125 @@
+package account
+
+import (
+	"testing"
+
+	"github.com/hexya-erp/hexya/src/models"
+	"github.com/hexya-erp/hexya/src/models/security"
+	"github.com/hexya-erp/hexya/src/models/types/dates"
+	"github.com/hexya-erp/pool/h"
+	"github.com/hexya-erp/pool/m"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestIntercompany(t *testing.T) {
+	Convey("Tests the mirroring of intercompany documents", t, FailureContinues, func() {
+		So(models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+			tps := initTestPaymentStruct(env)
+			company := h.User().NewSet(env).CurrentUser().Company()
+			sister := h.Company().Create(env, h.Company().NewData().
+				SetName("Sister Company").
+				SetCurrency(tps.CurrencyEur))
+			newAccount := func(code, accountType string, reconcile bool) m.AccountAccountSet {
+				return h.AccountAccount().Create(env, h.AccountAccount().NewData().
+					SetCode(code).
+					SetName("Sister "+code).
+					SetUserType(h.AccountAccountType().NewSet(env).GetRecord(accountType)).
+					SetReconcile(reconcile).
+					SetCompany(sister))
+			}
+			sisterPayable := newAccount("401000", "account_data_account_type_payable", true)
+			sisterExpense := newAccount("600000", "account_data_account_type_expenses", false)
+			sisterBank := newAccount("512000", "account_data_account_type_liquidity", false)
+			h.AccountJournal().Create(env, h.AccountJournal().NewData().
+				SetName("Sister Purchases").
+				SetCode("SPUR").
+				SetType("purchase").
+				SetUpdatePosted(true).
+				SetDefaultDebitAccount(sisterExpense).
+				SetDefaultCreditAccount(sisterExpense).
+				SetCompany(sister))
+			sisterBankJournal := h.AccountJournal().Create(env, h.AccountJournal().NewData().
+				SetName("Sister Bank").
+				SetCode("SBNK").
+				SetType("bank").
+				SetDefaultDebitAccount(sisterBank).
+				SetDefaultCreditAccount(sisterBank).
+				SetCompany(sister))
+			company.Partner().WithContext("force_company", sister.ID()).SetPropertyAccountPayable(sisterPayable)
+
+			invoice := h.AccountInvoice().Create(env, h.AccountInvoice().NewData().
+				SetPartner(sister.Partner()).
+				SetReferenceType("none").
+				SetAccount(tps.AccountReceivable).
+				SetType("out_invoice").
+				SetDateInvoice(dates.ParseDate("2015-06-26")).
+				CreateInvoiceLines(h.AccountInvoiceLine().NewData().
+					SetProduct(tps.Product).
+					SetQuantity(1).
+					SetPriceUnit(100).
+					SetName("consulting").
+					SetAccount(tps.AccountRevenue)))
+			invoice.Journal().SetUpdatePosted(true)
+
+			Convey("Invoices are not mirrored in companies without intercompany rule", func() {
+				invoice.ActionInvoiceOpen()
+				So(invoice.IntercompanyInvoice().IsEmpty(), ShouldBeTrue)
+			})
+			Convey("Invoices issued to another company are mirrored as draft vendor bills", func() {
+				sister.SetIntercompanyRule("draft")
+				invoice.ActionInvoiceOpen()
+				mirror := invoice.IntercompanyInvoice()
+				So(mirror.Len(), ShouldEqual, 1)
+				So(mirror.Type(), ShouldEqual, "in_invoice")
+				So(mirror.State(), ShouldEqual, "draft")
+				So(mirror.Company().Equals(sister), ShouldBeTrue)
+				So(mirror.Partner().Equals(company.Partner()), ShouldBeTrue)
+				So(mirror.Account().Equals(sisterPayable), ShouldBeTrue)
+				So(mirror.InvoiceLines().Account().Equals(sisterExpense), ShouldBeTrue)
+				So(mirror.AmountTotal(), ShouldEqual, invoice.AmountTotal())
+				So(mirror.Reference(), ShouldEqual, invoice.Number())
+				So(mirror.IntercompanyInvoice().Equals(invoice), ShouldBeTrue)
+				Convey("Cancelling the invoice cancels its draft mirror", func() {
+					invoice.ActionInvoiceCancel()
+					So(mirror.State(), ShouldEqual, "cancel")
+				})
+			})
+			Convey("Synchronized mirrors are validated and cancelled with the original invoice", func() {
+				sister.SetIntercompanyRule("sync")
+				invoice.ActionInvoiceOpen()
+				mirror := invoice.IntercompanyInvoice()
+				So(mirror.State(), ShouldEqual, "open")
+				invoice.ActionInvoiceCancel()
+				So(mirror.State(), ShouldEqual, "cancel")
+			})
+			Convey("Payments of mirrored invoices are mirrored in the paid company", func() {
+				sister.SetIntercompanyRule("sync")
+				company.SetIntercompanyRule("sync")
+				invoice.ActionInvoiceOpen()
+				mirror := invoice.IntercompanyInvoice()
+				payment := h.AccountPayment().Create(env, h.AccountPayment().NewData().
+					SetPaymentDate(dates.ParseDate("2015-07-15")).
+					SetPaymentType("outbound").
+					SetPartnerType("supplier").
+					SetPartner(company.Partner()).
+					SetAmount(mirror.AmountTotal()).
+					SetCurrency(tps.CurrencyEur).
+					SetJournal(sisterBankJournal).
+					SetPaymentMethod(tps.PaymentMethodManualOut).
+					SetInvoices(mirror))
+				payment.Post()
+				So(mirror.State(), ShouldEqual, "paid")
+				mirrorPayment := payment.IntercompanyPayment()
+				So(mirrorPayment.Len(), ShouldEqual, 1)
+				So(mirrorPayment.PaymentType(), ShouldEqual, "inbound")
+				So(mirrorPayment.PartnerType(), ShouldEqual, "customer")
+				So(mirrorPayment.Partner().Equals(sister.Partner()), ShouldBeTrue)
+				So(mirrorPayment.Journal().Company().Equals(company), ShouldBeTrue)
+				So(mirrorPayment.Amount(), ShouldEqual, payment.Amount())
+				So(mirrorPayment.IntercompanyPayment().Equals(payment), ShouldBeTrue)
+				So(mirrorPayment.State(), ShouldEqual, "posted")
+				So(invoice.State(), ShouldEqual, "paid")
+			})
+		}), ShouldBeNil)
+	})
+}
@@ -262,3 +262,160 @@ func TestTaxMoveLinesCreation(t *testing.T) {
 		}), ShouldBeNil)
 	})
 }
+
+func TestTaxFormula(t *testing.T) {
+	Convey("Test Tax Formula", t, FailureContinues, func() {
+		So(models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+			tts := initTestTaxStruct(env)
+			formulaTax := h.AccountTax().Create(env,
+				h.AccountTax().NewData().
+					SetName("Formula tax").
+					SetAmountType("formula").
+					SetAmount(0).
+					SetSequence(8).
+					SetFormula("if(base_amount > 100, base_amount * 0.05, 2) + quantity * 0.5"))
+			Convey("Formula taxes are computed with the line values", func() {
+				res := tts.simpleComputeAll(formulaTax, 200)
+				So(res.totalExcluded, ShouldEqual, 200)
+				So(res.totalIncluded, ShouldEqual, 210.5)
+				So(res.taxes, ShouldHaveLength, 1)
+				So(res.taxes[0].Amount, ShouldEqual, 10.5)
+				res = tts.simpleComputeAll(formulaTax, 50)
+				So(res.totalIncluded, ShouldEqual, 52.5)
+			})
+			Convey("Formula taxes can be combined with other taxes", func() {
+				res := tts.simpleComputeAll(formulaTax.Union(tts.PercentTax), 200)
+				So(res.totalIncluded, ShouldEqual, 230.5)
+			})
+			Convey("Invalid formulas are rejected", func() {
+				So(func() { formulaTax.SetFormula("base_amount *") }, ShouldPanic)
+				So(func() { formulaTax.SetFormula("unknown_var * 2") }, ShouldPanic)
+				So(func() {
+					h.AccountTax().Create(env,
+						h.AccountTax().NewData().
+							SetName("Formula tax without formula").
+							SetAmountType("formula").
+							SetAmount(0))
+				}, ShouldPanic)
+			})
+		}), ShouldBeNil)
+	})
+}
+
+func TestTaxRepartition(t *testing.T) {
+	Convey("Test Tax Repartition", t, FailureContinues, func() {
+		So(models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+			tts := initTestTaxStruct(env)
+			Convey("Reverse charge taxes are booked as due and deductible", func() {
+				tts.PercentTax.Write(h.AccountTax().NewData().
+					CreateRepartitions(h.AccountTaxRepartitionLine().NewData().
+						SetSequence(1).
+						SetFactor(100)).
+					CreateRepartitions(h.AccountTaxRepartitionLine().NewData().
+						SetSequence(2).
+						SetFactor(-100)))
+				res := tts.simpleComputeAll(tts.PercentTax, 200)
+				So(res.totalExcluded, ShouldEqual, 200)
+				So(res.totalIncluded, ShouldEqual, 200)
+				So(res.taxes, ShouldHaveLength, 2)
+				So(res.taxes[0].Amount, ShouldEqual, 20)
+				So(res.taxes[1].Amount, ShouldEqual, -20)
+			})
+			Convey("Rounding differences are put on the last repartition line", func() {
+				tts.PercentTax.Write(h.AccountTax().NewData().
+					CreateRepartitions(h.AccountTaxRepartitionLine().NewData().
+						SetSequence(1).
+						SetFactor(33.33)).
+					CreateRepartitions(h.AccountTaxRepartitionLine().NewData().
+						SetSequence(2).
+						SetFactor(33.33)).
+					CreateRepartitions(h.AccountTaxRepartitionLine().NewData().
+						SetSequence(3).
+						SetFactor(33.34)))
+				res := tts.simpleComputeAll(tts.PercentTax, 1)
+				So(res.taxes, ShouldHaveLength, 3)
+				So(res.taxes[0].Amount, ShouldEqual, 0.03)
+				So(res.taxes[1].Amount, ShouldEqual, 0.03)
+				So(res.taxes[2].Amount, ShouldEqual, 0.04)
+				So(res.totalIncluded, ShouldAlmostEqual, 1.1, 0.000001)
+			})
+			Convey("Repartition amounts are rounded at the precision of the computation", func() {
+				tts.PercentTax.Write(h.AccountTax().NewData().
+					CreateRepartitions(h.AccountTaxRepartitionLine().NewData().
+						SetSequence(1).
+						SetFactor(50)).
+					CreateRepartitions(h.AccountTaxRepartitionLine().NewData().
+						SetSequence(2).
+						SetFactor(50)))
+				Convey("in the currency of the computation", func() {
+					currency := h.Currency().Create(env, h.Currency().NewData().
+						SetName("TRP").
+						SetSymbol("T").
+						SetRounding(1))
+					_, _, totalIncl, taxes := tts.PercentTax.ComputeAll(
+						110, currency, 1, h.ProductProduct().NewSet(env), h.Partner().NewSet(env))
+					So(taxes, ShouldHaveLength, 2)
+					So(taxes[0].Amount, ShouldEqual, 6)
+					So(taxes[1].Amount, ShouldEqual, 5)
+					So(totalIncl, ShouldEqual, 121)
+				})
+				Convey("with the higher precision of global rounding", func() {
+					h.User().NewSet(env).CurrentUser().Company().SetTaxCalculationRoundingMethod("round_globally")
+					res := tts.simpleComputeAll(tts.PercentTax, 0.5)
+					So(res.taxes, ShouldHaveLength, 2)
+					So(res.taxes[0].Amount, ShouldAlmostEqual, 0.025, 0.0000001)
+					So(res.taxes[1].Amount, ShouldAlmostEqual, 0.025, 0.0000001)
+				})
+			})
+			Convey("Taxes with repartition lines cannot be included in price", func() {
+				tts.PercentTax.Write(h.AccountTax().NewData().
+					CreateRepartitions(h.AccountTaxRepartitionLine().NewData().
+						SetFactor(100)))
+				So(func() { tts.PercentTax.SetPriceInclude(true) }, ShouldPanic)
+				So(func() { tts.PercentTax.SetAmountType("group") }, ShouldPanic)
+			})
+		}), ShouldBeNil)
+	})
+}
+
+func TestTaxSuccession(t *testing.T) {
+	Convey("Test Tax Succession", t, FailureContinues, func() {
+		So(models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+			tts := initTestTaxStruct(env)
+			newTax := h.AccountTax().Create(env, h.AccountTax().NewData().
+				SetName("Percent Tax 12").
+				SetAmountType("percent").
+				SetAmount(12))
+			tts.PercentTax.Write(h.AccountTax().NewData().
+				SetValidUntil(dates.ParseDate("2015-06-30")).
+				SetSuccessorTax(newTax))
+			Convey("Taxes are replaced by their successor after their validity date", func() {
+				So(tts.PercentTax.AtDate(dates.ParseDate("2015-06-30")).Equals(tts.PercentTax), ShouldBeTrue)
+				So(tts.PercentTax.AtDate(dates.ParseDate("2015-07-01")).Equals(newTax), ShouldBeTrue)
+				So(tts.PercentTax.Union(tts.FixedTax).AtDate(dates.ParseDate("2015-07-01")).Equals(newTax.Union(tts.FixedTax)), ShouldBeTrue)
+			})
+			Convey("Successions are followed to the tax effective at the date", func() {
+				lastTax := h.AccountTax().Create(env, h.AccountTax().NewData().
+					SetName("Percent Tax 14").
+					SetAmountType("percent").
+					SetAmount(14))
+				newTax.Write(h.AccountTax().NewData().
+					SetValidUntil(dates.ParseDate("2015-12-31")).
+					SetSuccessorTax(lastTax))
+				So(tts.PercentTax.AtDate(dates.ParseDate("2015-09-01")).Equals(newTax), ShouldBeTrue)
+				So(tts.PercentTax.AtDate(dates.ParseDate("2016-01-01")).Equals(lastTax), ShouldBeTrue)
+			})
+			Convey("Successions cannot loop", func() {
+				So(func() { newTax.SetSuccessorTax(tts.PercentTax) }, ShouldPanic)
+			})
+			Convey("Successors must have the same scope", func() {
+				purchaseTax := h.AccountTax().Create(env, h.AccountTax().NewData().
+					SetName("Purchase Tax").
+					SetAmountType("percent").
+					SetAmount(12).
+					SetTypeTaxUse("purchase"))
+				So(func() { tts.PercentTax.SetSuccessorTax(purchaseTax) }, ShouldPanic)
+			})
+		}), ShouldBeNil)
+	})
+}
@@ -0,0 +1,457 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package vat
+
+import (
+	"strconv"
+	"strings"
+)
+
+// checkAT checks Austrian UID numbers: U followed by 8 digits
+func checkAT(n string) bool {
+	if len(n) != 9 || n[0] != 'U' || !isDigits(n[1:]) {
+		return false
+	}
+	return mod(6-luhnSum(n[1:8]), 10) == digit(n, 8)
+}
+
+// luhnSum returns the Luhn sum modulo 10 of the given digits, the last digit being
+// at a non doubled position.
+func luhnSum(s string) int {
+	var sum int
+	for i := 0; i < len(s); i++ {
+		d := digit(s, len(s)-1-i)
+		if i%2 == 1 {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+	}
+	return sum % 10
+}
+
+// checkBE checks Belgian enterprise numbers: 10 digits starting with 0 or 1
+func checkBE(n string) bool {
+	if len(n) == 9 {
+		n = "0" + n
+	}
+	if len(n) != 10 || !isDigits(n) || (n[0] != '0' && n[0] != '1') {
+		return false
+	}
+	base, _ := strconv.Atoi(n[:8])
+	check, _ := strconv.Atoi(n[8:])
+	return 97-base%97 == check
+}
+
+// checkBG checks Bulgarian VAT numbers: 9 digits for legal entities,
+// 10 digits for persons, foreigners and others
+func checkBG(n string) bool {
+	if !isDigits(n) {
+		return false
+	}
+	switch len(n) {
+	case 9:
+		check := weightedSum(n, 1, 2, 3, 4, 5, 6, 7, 8) % 11
+		if check == 10 {
+			check = weightedSum(n, 3, 4, 5, 6, 7, 8, 9, 10) % 11
+		}
+		return check%10 == digit(n, 8)
+	case 10:
+		egn := weightedSum(n, 2, 4, 8, 5, 10, 9, 7, 3, 6) % 11 % 10
+		pnf := weightedSum(n, 7, 1, 3, 9, 7, 1, 3, 9, 7) % 10
+		other := mod(11-weightedSum(n, 4, 3, 2, 7, 6, 5, 4, 3, 2), 11)
+		last := digit(n, 9)
+		return egn == last || pnf == last || other == last
+	}
+	return false
+}
+
+// checkCY checks Cypriot VAT numbers: 8 digits followed by a check letter
+func checkCY(n string) bool {
+	if len(n) != 9 || !isDigits(n[:8]) || !isLetter(n[8]) || strings.HasPrefix(n, "12") {
+		return false
+	}
+	translation := []int{1, 0, 5, 7, 9, 13, 15, 17, 19, 21}
+	var sum int
+	for i := 0; i < 8; i++ {
+		if i%2 == 0 {
+			sum += translation[digit(n, i)]
+		} else {
+			sum += digit(n, i)
+		}
+	}
+	return n[8] == byte('A'+sum%26)
+}
+
+// checkCZ checks Czech DIČ numbers: 8 digits for legal entities,
+// 9 or 10 digits for individuals
+func checkCZ(n string) bool {
+	if !isDigits(n) {
+		return false
+	}
+	switch len(n) {
+	case 8:
+		if n[0] == '9' {
+			return false
+		}
+		check := mod(11-weightedSum(n, 8, 7, 6, 5, 4, 3, 2), 11)
+		if check == 0 {
+			check = 1
+		}
+		return check%10 == digit(n, 7)
+	case 9:
+		// Special individual numbers and birth numbers issued before 1954 have no check digit
+		return true
+	case 10:
+		val, _ := strconv.ParseInt(n, 10, 64)
+		if val%11 == 0 {
+			return true
+		}
+		first, _ := strconv.ParseInt(n[:9], 10, 64)
+		return first%11 == 10 && n[9] == '0'
+	}
+	return false
+}
+
+// checkDE checks German USt-IdNr: 9 digits
+func checkDE(n string) bool {
+	return len(n) == 9 && isDigits(n) && n[0] != '0' && mod11_10(n)
+}
+
+// checkDK checks Danish CVR numbers: 8 digits
+func checkDK(n string) bool {
+	return len(n) == 8 && isDigits(n) && n[0] != '0' && weightedSum(n, 2, 7, 6, 5, 4, 3, 2, 1)%11 == 0
+}
+
+// checkEE checks Estonian KMKR numbers: 9 digits starting with 10
+func checkEE(n string) bool {
+	return len(n) == 9 && isDigits(n) && strings.HasPrefix(n, "10") && weightedSum(n, 3, 7, 1, 3, 7, 1, 3, 7, 1)%10 == 0
+}
+
+// checkEL checks Greek VAT numbers: 9 digits
+func checkEL(n string) bool {
+	if len(n) == 8 {
+		n = "0" + n
+	}
+	if len(n) != 9 || !isDigits(n) {
+		return false
+	}
+	var sum int
+	for i := 0; i < 8; i++ {
+		sum = sum*2 + digit(n, i)
+	}
+	return sum*2%11%10 == digit(n, 8)
+}
+
+// checkES checks Spanish NIF numbers of persons (DNI and NIE) and legal entities (CIF)
+func checkES(n string) bool {
+	if len(n) != 9 {
+		return false
+	}
+	const dniLetters = "TRWAGMYFPDXBNJZSQVHLCKE"
+	dni := func(number string, letter byte) bool {
+		if !isDigits(number) {
+			return false
+		}
+		val, _ := strconv.Atoi(number)
+		return dniLetters[val%23] == letter
+	}
+	switch {
+	case isDigits(n[:8]):
+		// DNI
+		return dni(n[:8], n[8])
+	case strings.IndexByte("XYZ", n[0]) >= 0:
+		// NIE
+		return dni(strconv.Itoa(strings.IndexByte("XYZ", n[0]))+n[1:8], n[8])
+	case strings.IndexByte("KLM", n[0]) >= 0:
+		return dni(n[1:8], n[8])
+	case strings.IndexByte("ABCDEFGHJNPQRSUVW", n[0]) >= 0 && isDigits(n[1:8]):
+		// CIF
+		var sum int
+		for i := 1; i < 8; i++ {
+			d := digit(n, i)
+			if i%2 == 1 {
+				d *= 2
+				d = d/10 + d%10
+			}
+			sum += d
+		}
+		check := (10 - sum%10) % 10
+		return n[8] == byte('0'+check) || n[8] == "JABCDEFGHI"[check]
+	}
+	return false
+}
+
+// checkFI checks Finnish ALV numbers: 8 digits
+func checkFI(n string) bool {
+	return len(n) == 8 && isDigits(n) && weightedSum(n, 7, 9, 10, 5, 8, 4, 2, 1)%11 == 0
+}
+
+// checkFR checks French TVA numbers: a 2 characters key followed by a 9 digits SIREN number
+func checkFR(n string) bool {
+	if len(n) != 11 || !isDigits(n[2:]) || !luhn(n[2:]) {
+		return false
+	}
+	if !isDigits(n[:2]) {
+		// New style keys have letters and cannot be verified offline
+		const alphabet = "0123456789ABCDEFGHJKLMNPQRSTUVWXYZ"
+		return strings.IndexByte(alphabet, n[0]) >= 0 && strings.IndexByte(alphabet, n[1]) >= 0
+	}
+	siren, _ := strconv.Atoi(n[2:])
+	key, _ := strconv.Atoi(n[:2])
+	return (12+3*(siren%97))%97 == key
+}
+
+// checkGB checks United Kingdom VAT numbers: 9 digits, 12 digits for branches,
+// or GD/HA followed by 3 digits for government departments and health authorities
+func checkGB(n string) bool {
+	switch {
+	case len(n) == 5 && strings.HasPrefix(n, "GD") && isDigits(n[2:]):
+		val, _ := strconv.Atoi(n[2:])
+		return val < 500
+	case len(n) == 5 && strings.HasPrefix(n, "HA") && isDigits(n[2:]):
+		val, _ := strconv.Atoi(n[2:])
+		return val >= 500
+	case (len(n) == 9 || len(n) == 12) && isDigits(n):
+		sum := weightedSum(n, 8, 7, 6, 5, 4, 3, 2, 10, 1) % 97
+		return sum == 0 || sum == 42
+	}
+	return false
+}
+
+// checkHR checks Croatian OIB numbers: 11 digits
+func checkHR(n string) bool {
+	return len(n) == 11 && isDigits(n) && mod11_10(n)
+}
+
+// checkHU checks Hungarian ANUM numbers: 8 digits
+func checkHU(n string) bool {
+	return len(n) == 8 && isDigits(n) && weightedSum(n, 9, 7, 3, 1, 9, 7, 3, 1)%10 == 0
+}
+
+// checkIE checks Irish VAT numbers: 7 digits followed by one or two letters,
+// or old style numbers with a letter or symbol in second position
+func checkIE(n string) bool {
+	if len(n) == 8 && n[0] >= '0' && n[0] <= '9' && strings.IndexByte("ABCDEFGHIJKLMNOPQRSTUVWXYZ+*", n[1]) >= 0 {
+		// Old style number
+		n = "0" + n[2:7] + n[:1] + n[7:]
+	}
+	if (len(n) != 8 && len(n) != 9) || !isDigits(n[:7]) {
+		return false
+	}
+	const alphabet = "WABCDEFGHIJKLMNOPQRSTUV"
+	var extra int
+	if len(n) == 9 {
+		extra = strings.IndexByte(alphabet, n[8])
+		if extra < 0 {
+			return false
+		}
+	}
+	sum := weightedSum(n, 8, 7, 6, 5, 4, 3, 2) + 9*extra
+	return n[7] == alphabet[sum%23]
+}
+
+// checkIT checks Italian partita IVA: 11 digits
+func checkIT(n string) bool {
+	if len(n) != 11 || !isDigits(n) || n[:7] == "0000000" {
+		return false
+	}
+	office, _ := strconv.Atoi(n[7:10])
+	if !(office >= 1 && office <= 100) && office != 120 && office != 121 && office != 888 && office != 999 {
+		return false
+	}
+	return luhn(n)
+}
+
+// checkLT checks Lithuanian PVM numbers: 9 digits for legal entities, 12 digits for others
+func checkLT(n string) bool {
+	if (len(n) != 9 && len(n) != 12) || !isDigits(n) || n[len(n)-2] != '1' {
+		return false
+	}
+	body := n[:len(n)-1]
+	var check int
+	for i := range body {
+		check += (1 + i%9) * digit(body, i)
+	}
+	check %= 11
+	if check == 10 {
+		check = 0
+		for i := range body {
+			check += (1 + (i+2)%9) * digit(body, i)
+		}
+	}
+	return check%11%10 == digit(n, len(n)-1)
+}
+
+// checkLU checks Luxembourg TVA numbers: 8 digits
+func checkLU(n string) bool {
+	if len(n) != 8 || !isDigits(n) {
+		return false
+	}
+	base, _ := strconv.Atoi(n[:6])
+	check, _ := strconv.Atoi(n[6:])
+	return base%89 == check
+}
+
+// checkLV checks Latvian PVN numbers: 11 digits
+func checkLV(n string) bool {
+	if len(n) != 11 || !isDigits(n) {
+		return false
+	}
+	switch {
+	case n[0] > '3':
+		// Legal entity
+		return weightedSum(n, 9, 1, 4, 8, 3, 10, 2, 5, 7, 6, 1)%11 == 3
+	case strings.HasPrefix(n, "32"):
+		// New personal codes have no check digit
+		return true
+	}
+	// Personal code
+	return (1+weightedSum(n, 10, 5, 8, 4, 2, 1, 6, 3, 7, 9))%11%10 == digit(n, 10)
+}
+
+// checkMT checks Maltese VAT numbers: 8 digits
+func checkMT(n string) bool {
+	return len(n) == 8 && isDigits(n) && n[0] != '0' && weightedSum(n, 3, 4, 6, 7, 8, 9, 10, 1)%37 == 0
+}
+
+// checkNL checks Dutch BTW numbers: 9 digits, B and 2 digits
+func checkNL(n string) bool {
+	if len(n) != 12 || !isDigits(n[:9]) || n[9] != 'B' || !isDigits(n[10:]) || n[10:] == "00" {
+		return false
+	}
+	if mod(weightedSum(n, 9, 8, 7, 6, 5, 4, 3, 2)-digit(n, 8), 11) == 0 {
+		return true
+	}
+	// Numbers of sole traders issued since 2020 are checked with ISO 7064 Mod 97, 10
+	return mod97("NL"+n) == 1
+}
+
+// checkPL checks Polish NIP numbers: 10 digits
+func checkPL(n string) bool {
+	if len(n) != 10 || !isDigits(n) {
+		return false
+	}
+	check := weightedSum(n, 6, 5, 7, 2, 3, 4, 5, 6, 7) % 11
+	return check != 10 && check == digit(n, 9)
+}
+
+// checkPT checks Portuguese NIF numbers: 9 digits
+func checkPT(n string) bool {
+	if len(n) != 9 || !isDigits(n) || n[0] == '0' {
+		return false
+	}
+	return mod(11-weightedSum(n, 9, 8, 7, 6, 5, 4, 3, 2), 11)%10 == digit(n, 8)
+}
+
+// checkRO checks Romanian CIF numbers: 2 to 10 digits, or 13 digits personal CNP
+func checkRO(n string) bool {
+	if !isDigits(n) {
+		return false
+	}
+	switch {
+	case len(n) == 13:
+		check := weightedSum(n, 2, 7, 9, 1, 4, 6, 3, 5, 8, 2, 7, 9) % 11
+		if check == 10 {
+			check = 1
+		}
+		return check == digit(n, 12)
+	case len(n) >= 2 && len(n) <= 10:
+		n = strings.Repeat("0", 10-len(n)) + n
+		return weightedSum(n, 7, 5, 3, 2, 1, 7, 5, 3, 2)*10%11%10 == digit(n, 9)
+	}
+	return false
+}
+
+// checkSE checks Swedish VAT numbers: 10 digits organisation number followed by 01
+func checkSE(n string) bool {
+	return len(n) == 12 && isDigits(n) && strings.HasSuffix(n, "01") && luhn(n[:10])
+}
+
+// checkSI checks Slovenian DDV numbers: 8 digits
+func checkSI(n string) bool {
+	if len(n) != 8 || !isDigits(n) || n[0] == '0' {
+		return false
+	}
+	check := 11 - weightedSum(n, 8, 7, 6, 5, 4, 3, 2)%11
+	if check == 11 {
+		return false
+	}
+	return check%10 == digit(n, 7)
+}
+
+// checkSK checks Slovak IČ DPH numbers: 10 digits
+func checkSK(n string) bool {
+	if len(n) != 10 || !isDigits(n) || n[0] == '0' || strings.IndexByte("234789", n[2]) < 0 {
+		return false
+	}
+	val, _ := strconv.ParseInt(n, 10, 64)
+	return val%11 == 0
+}
+
+// checkCH checks Swiss UID numbers: E followed by 9 digits, and an optional MWST, TVA or IVA suffix
+func checkCH(n string) bool {
+	for _, suffix := range []string{"MWST", "TVA", "IVA", "TPV"} {
+		n = strings.TrimSuffix(n, suffix)
+	}
+	if len(n) != 10 || n[0] != 'E' || !isDigits(n[1:]) {
+		return false
+	}
+	n = n[1:]
+	check := mod(11-weightedSum(n, 5, 4, 3, 2, 7, 6, 5, 4), 11)
+	return check != 10 && check == digit(n, 8)
+}
+
+// checkNO checks Norwegian MVA numbers: 9 digits organisation number and an optional MVA suffix
+func checkNO(n string) bool {
+	n = strings.TrimSuffix(n, "MVA")
+	if len(n) != 9 || !isDigits(n) {
+		return false
+	}
+	check := mod(11-weightedSum(n, 3, 2, 7, 6, 5, 4, 3, 2), 11)
+	return check != 10 && check == digit(n, 8)
+}
+
+// checkAU checks Australian Business Numbers: 11 digits
+func checkAU(n string) bool {
+	if len(n) != 11 || !isDigits(n) || n[0] == '0' {
+		return false
+	}
+	sum := 10*(digit(n, 0)-1) + weightedSum(n[1:], 1, 3, 5, 7, 9, 11, 13, 15, 17, 19)
+	return sum%89 == 0
+}
+
+// checkBR checks Brazilian CNPJ (14 digits) and CPF (11 digits) numbers
+func checkBR(n string) bool {
+	if !isDigits(n) || strings.Count(n, n[:1]) == len(n) {
+		return false
+	}
+	switch len(n) {
+	case 14:
+		checkDigit := func(s string, weights ...int) int {
+			check := 11 - weightedSum(s, weights...)%11
+			if check >= 10 {
+				return 0
+			}
+			return check
+		}
+		return checkDigit(n, 5, 4, 3, 2, 9, 8, 7, 6, 5, 4, 3, 2) == digit(n, 12) &&
+			checkDigit(n, 6, 5, 4, 3, 2, 9, 8, 7, 6, 5, 4, 3, 2) == digit(n, 13)
+	case 11:
+		d1 := weightedSum(n, 10, 9, 8, 7, 6, 5, 4, 3, 2) * 10 % 11 % 10
+		d2 := weightedSum(n, 11, 10, 9, 8, 7, 6, 5, 4, 3, 2) * 10 % 11 % 10
+		return d1 == digit(n, 9) && d2 == digit(n, 10)
+	}
+	return false
+}
+
+// checkCA checks Canadian Business Numbers: 9 digits and an optional program account (e.g. RT0001)
+func checkCA(n string) bool {
+	if len(n) == 15 && n[9:11] == "RT" && isDigits(n[11:]) {
+		n = n[:9]
+	}
+	return len(n) == 9 && isDigits(n) && luhn(n)
+}
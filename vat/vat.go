@@ -0,0 +1,198 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+// Package vat validates VAT and tax identification numbers offline, by
+// checking their syntax and check digits.
+//
+// Supported countries are the member states of the European Union, the
+// United Kingdom (GB and XI), Switzerland, Norway, Australia, Brazil and
+// Canada. Numbers are given with their country prefix (EL for Greece),
+// or without prefix if a default country is given.
+package vat
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// ErrUnsupportedCountry is returned when the number belongs to a country
+// which is not supported by offline validation.
+var ErrUnsupportedCountry = errors.New("unsupported country")
+
+// checkers maps country prefixes to their number validation function.
+// The validation functions get the compact number without country prefix.
+var checkers = map[string]func(string) bool{
+	"AT": checkAT,
+	"AU": checkAU,
+	"BE": checkBE,
+	"BG": checkBG,
+	"BR": checkBR,
+	"CA": checkCA,
+	"CH": checkCH,
+	"CY": checkCY,
+	"CZ": checkCZ,
+	"DE": checkDE,
+	"DK": checkDK,
+	"EE": checkEE,
+	"EL": checkEL,
+	"ES": checkES,
+	"FI": checkFI,
+	"FR": checkFR,
+	"GB": checkGB,
+	"HR": checkHR,
+	"HU": checkHU,
+	"IE": checkIE,
+	"IT": checkIT,
+	"LT": checkLT,
+	"LU": checkLU,
+	"LV": checkLV,
+	"MT": checkMT,
+	"NL": checkNL,
+	"NO": checkNO,
+	"PL": checkPL,
+	"PT": checkPT,
+	"RO": checkRO,
+	"SE": checkSE,
+	"SI": checkSI,
+	"SK": checkSK,
+	"XI": checkGB,
+}
+
+// Compact returns the given number in upper case, without spaces and
+// usual separators.
+func Compact(number string) string {
+	var res strings.Builder
+	for _, c := range strings.ToUpper(number) {
+		switch {
+		case unicode.IsSpace(c), strings.ContainsRune(".-/,:", c):
+			continue
+		}
+		res.WriteRune(c)
+	}
+	return res.String()
+}
+
+// Split returns the country prefix and the compact number without prefix of the
+// given VAT number. If the number has no country prefix, defaultCountry is used.
+//
+// Country codes are returned as VAT prefixes, i.e. EL for Greece.
+func Split(number, defaultCountry string) (string, string) {
+	number = Compact(number)
+	if len(number) > 2 && isLetter(number[0]) && isLetter(number[1]) {
+		return prefix(number[:2]), number[2:]
+	}
+	return prefix(strings.ToUpper(defaultCountry)), number
+}
+
+// prefix returns the VAT prefix of the given ISO country code
+func prefix(country string) string {
+	if country == "GR" {
+		return "EL"
+	}
+	return country
+}
+
+// IsSupported returns true if the numbers of the given country (ISO code
+// or VAT prefix) can be validated offline.
+func IsSupported(country string) bool {
+	_, ok := checkers[prefix(strings.ToUpper(country))]
+	return ok
+}
+
+// Check returns nil if the given VAT number is valid. If the number has no country
+// prefix, it is checked as a number of defaultCountry.
+//
+// It returns ErrUnsupportedCountry if the number's country is not supported.
+func Check(number, defaultCountry string) error {
+	country, num := Split(number, defaultCountry)
+	checker, ok := checkers[country]
+	if !ok {
+		return ErrUnsupportedCountry
+	}
+	if !checker(num) {
+		return fmt.Errorf("%s is not a valid %s VAT number", number, country)
+	}
+	return nil
+}
+
+// isLetter returns true if c is an ASCII upper case letter
+func isLetter(c byte) bool {
+	return c >= 'A' && c <= 'Z'
+}
+
+// isDigits returns true if s is made only of ASCII digits and is not empty
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// digit returns the value of the digit at index i of s
+func digit(s string, i int) int {
+	return int(s[i] - '0')
+}
+
+// weightedSum returns the sum of the digits of s multiplied by the given weights
+func weightedSum(s string, weights ...int) int {
+	var sum int
+	for i, w := range weights {
+		sum += w * digit(s, i)
+	}
+	return sum
+}
+
+// mod returns the positive modulo of a by b
+func mod(a, b int) int {
+	return ((a % b) + b) % b
+}
+
+// luhn returns true if the given digits pass the Luhn check
+func luhn(s string) bool {
+	var sum int
+	for i := 0; i < len(s); i++ {
+		d := digit(s, len(s)-1-i)
+		if i%2 == 1 {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+	}
+	return sum%10 == 0
+}
+
+// mod11_10 returns true if the given digits pass the ISO 7064 Mod 11, 10 check
+func mod11_10(s string) bool {
+	check := 5
+	for i := 0; i < len(s); i++ {
+		if check == 0 {
+			check = 10
+		}
+		check = (check*2%11 + digit(s, i)) % 10
+	}
+	return check == 1
+}
+
+// mod97 returns the remainder of the division by 97 of the given number, in which
+// letters are replaced by their position in the alphabet plus 9 (A=10, B=11, etc.)
+func mod97(s string) int {
+	var rem int
+	for _, c := range s {
+		switch {
+		case c >= '0' && c <= '9':
+			rem = (rem*10 + int(c-'0')) % 97
+		case c >= 'A' && c <= 'Z':
+			rem = (rem*100 + int(c-'A') + 10) % 97
+		}
+	}
+	return rem
+}
@@ -0,0 +1,48 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package vat
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestVATCheck(t *testing.T) {
+	Convey("Testing offline VAT number validation", t, func() {
+		Convey("Valid numbers are accepted", func() {
+			for _, number := range []string{
+				"ATU13585627", "BE0403019261", "BG175074752", "CY-10259033P", "CZ 25123891",
+				"DE 136,695 976", "DK 13585628", "EE 100 931 558", "EL 094259216", "ES A13 585 625",
+				"ES 54362315K", "FI 20774740", "FR 40 303 265 045", "GB 980 7806 84", "HR 33392005961",
+				"HU-12892312", "IE 6433435F", "IE8Z49289F", "IT 00743110157", "LT 119511515",
+				"LU 150 274 42", "LV 4000 3521 600", "LV161175-19997", "MT 1167-9112", "NL004495445B01",
+				"PL 8567346215", "PT 501 964 843", "RO 185 472 90", "SE 123456789701", "SI 5022 3054",
+				"SK 202 274 96 19", "CHE-107.787.577 IVA", "NO 995 525 828 MVA", "AU51824753556",
+				"BR16.727.230/0001-97", "BR390.533.447-05", "CA123456782",
+			} {
+				So(Check(number, ""), ShouldBeNil)
+			}
+		})
+		Convey("Invalid numbers are rejected", func() {
+			for _, number := range []string{
+				"ATU13585626", "DE136695978", "FR41303265045", "NL004495446B01",
+				"PL8567346216", "NO995525829", "BE0123456789", "CHE107787578",
+			} {
+				err := Check(number, "")
+				So(err, ShouldNotBeNil)
+				So(err, ShouldNotEqual, ErrUnsupportedCountry)
+			}
+		})
+		Convey("Numbers without prefix use the default country", func() {
+			So(Check("403019261", "be"), ShouldBeNil)
+			So(Check("094259216", "GR"), ShouldBeNil)
+			So(IsSupported("gr"), ShouldBeTrue)
+		})
+		Convey("Unsupported countries are reported", func() {
+			So(Check("US123", ""), ShouldEqual, ErrUnsupportedCountry)
+			So(IsSupported("US"), ShouldBeFalse)
+		})
+	})
+}
@@ -4,6 +4,7 @@
 package account
 
 import (
+	"github.com/hexya-addons/account/accounttypes"
 	"github.com/hexya-erp/hexya/src/actions"
 	"github.com/hexya-erp/hexya/src/models"
 	"github.com/hexya-erp/hexya/src/models/types"
@@ -41,43 +42,52 @@ func init() {
 	})
 
 	h.AccountCommonReport().Methods().BuildContexts().DeclareMethod(
-		`BuildContexts`,
-		func(rs m.AccountCommonReportSet, args struct {
-			Data interface{}
-		}) {
+		`BuildContexts returns the context with which the journal items of the report are selected`,
+		func(rs m.AccountCommonReportSet) *types.Context {
+			return types.NewContext().
+				WithKey("journal_ids", rs.Journals().Ids()).
+				WithKey("state", rs.TargetMove()).
+				WithKey("date_from", rs.DateFrom()).
+				WithKey("date_to", rs.DateTo()).
+				WithKey("strict_range", !rs.DateFrom().IsZero())
+		})
 
-			/*def _build_contexts(self, data):
-			  result = {}
-			  result['journal_ids'] = 'journal_ids' in data['form'] and data['form']['journal_ids'] or False
-			  result['state'] = 'target_move' in data['form'] and data['form']['target_move'] or ''
-			  result['date_from'] = data['form']['date_from'] or False
-			  result['date_to'] = data['form']['date_to'] or False
-			  result['strict_range'] = True if result['date_from'] else False
-			  return result
+	h.AccountCommonReport().Methods().ReportData().DeclareMethod(
+		`ReportData returns the options of this wizard as the data passed to its report`,
+		func(rs m.AccountCommonReportSet) accounttypes.ReportData {
+			rs.EnsureOne()
+			return accounttypes.ReportData{
+				Model: rs.Env().Context().GetString("active_model"),
+				IDs:   rs.Env().Context().GetIntegerSlice("active_ids"),
+				Form: accounttypes.ReportForm{
+					CompanyID:   rs.Company().ID(),
+					JournalIDs:  rs.Journals().Ids(),
+					DateFrom:    rs.DateFrom(),
+					DateTo:      rs.DateTo(),
+					TargetMove:  rs.TargetMove(),
+					UsedContext: rs.BuildContexts(),
+				},
+			}
+		})
 
-			*/
+	h.AccountCommonReport().Methods().ComputeReport().DeclareMethod(
+		`ComputeReport returns the values with which the report of this wizard is rendered.
+		It must be overridden by the wizards whose report is computed.`,
+		func(rs m.AccountCommonReportSet) accounttypes.ReportValues {
+			panic(rs.T("Not implemented"))
 		})
 
 	h.AccountCommonReport().Methods().PrintReport().DeclareMethod(
-		`PrintReport`,
+		`PrintReport returns the action printing the report of this wizard with the given data,
+		or nil if there is none.`,
 		func(rs m.AccountCommonReportSet, data interface{}) *actions.Action {
-			panic(rs.T("Not implemented"))
+			return nil
 		})
 
 	h.AccountCommonReport().Methods().CheckReport().DeclareMethod(
-		`CheckReport`,
-		func(rs m.AccountCommonReportSet) {
-			//@api.multi
-			/*def check_report(self):
-			  self.ensure_one()
-			  data = {}
-			  data['ids'] = self.env.context.get('active_ids', [])
-			  data['model'] = self.env.context.get('active_model', 'ir.ui.menu')
-			  data['form'] = self.read(['date_from', 'date_to', 'journal_ids', 'target_move'])[0]
-			  used_context = self._build_contexts(data)
-			  data['form']['used_context'] = dict(used_context, lang=self.env.context.get('lang', 'en_US'))
-			  return self._print_report(data)
-			*/
+		`CheckReport collects the options of this wizard and returns the action printing its report`,
+		func(rs m.AccountCommonReportSet) *actions.Action {
+			return rs.PrintReport(rs.ReportData())
 		})
 
 }
@@ -4,6 +4,7 @@
 package account
 
 import (
+	"github.com/hexya-addons/account/accounttypes"
 	"github.com/hexya-erp/hexya/src/models"
 	"github.com/hexya-erp/hexya/src/models/types"
 	"github.com/hexya-erp/pool/h"
@@ -24,11 +25,15 @@ func init() {
 				"not_zero": "With balance is not equal to 0"},
 			Required: true,
 			Default:  models.DefaultValue("movement")},
+		"Hierarchy": models.BooleanField{
+			String: "Group by Account Groups",
+			Help:   "Display the accounts under their account groups, with a subtotal for each group"},
 	})
 	h.AccountCommonAccountReport().Methods().PrePrintReport().DeclareMethod(
-		`PrePrintReport`,
-		func(rs m.AccountCommonAccountReportSet, data map[string]interface{}) map[string]interface{} {
-			data["form"] = rs.Read([]string{"display_account"})[0]
+		`PrePrintReport adds the account display options of this wizard to the given data`,
+		func(rs m.AccountCommonAccountReportSet, data accounttypes.ReportData) accounttypes.ReportData {
+			data.Form.DisplayAccount = rs.DisplayAccount()
+			data.Form.Hierarchy = rs.Hierarchy()
 			return data
 		})
 
@@ -4,6 +4,7 @@
 package account
 
 import (
+	"github.com/hexya-addons/account/accounttypes"
 	"github.com/hexya-erp/hexya/src/models"
 	"github.com/hexya-erp/hexya/src/models/types"
 	"github.com/hexya-erp/pool/h"
@@ -23,14 +24,10 @@ func init() {
 				"customer_supplier": "Receivable and Payable Accounts"}},
 	})
 	h.AccountCommonPartnerReport().Methods().PrePrintReport().DeclareMethod(
-		`PrePrintReport`,
-		func(rs m.AccountCommonPartnerReportSet, args struct {
-			Data interface{}
-		}) {
-			/*def pre_print_report(self, data):
-			  data['form'].update(self.read(['result_selection'])[0])
-			  return data
-			*/
+		`PrePrintReport adds the partner accounts selection of this wizard to the given data`,
+		func(rs m.AccountCommonPartnerReportSet, data accounttypes.ReportData) accounttypes.ReportData {
+			data.Form.ResultSelection = rs.ResultSelection()
+			return data
 		})
 
 }
@@ -4,6 +4,7 @@
 package account
 
 import (
+	"github.com/hexya-addons/account/accounttypes"
 	"github.com/hexya-erp/hexya/src/models"
 	"github.com/hexya-erp/hexya/src/models/types"
 	"github.com/hexya-erp/pool/h"
@@ -57,48 +58,30 @@ func init() {
 			Help:   "This option allows you to get more details about the way your balances are computed. Because it is space consuming, we do not allow to use it while doing a comparison."},
 	})
 	h.AccountingReport().Methods().BuildComparisonContext().DeclareMethod(
-		`BuildComparisonContext`,
-		func(rs m.AccountingReportSet, args struct {
-			Data interface{}
-		}) {
-			/*def _build_comparison_context(self, data):
-			  result = {}
-			  result['journal_ids'] = 'journal_ids' in data['form'] and data['form']['journal_ids'] or False
-			  result['state'] = 'target_move' in data['form'] and data['form']['target_move'] or ''
-			  if data['form']['filter_cmp'] == 'filter_date':
-			      result['date_from'] = data['form']['date_from_cmp']
-			      result['date_to'] = data['form']['date_to_cmp']
-			      result['strict_range'] = True
-			  return result
-
-			*/
+		`BuildComparisonContext returns the context with which the journal items of the
+		comparison column of the report are selected`,
+		func(rs m.AccountingReportSet) *types.Context {
+			ctx := types.NewContext().
+				WithKey("journal_ids", rs.Journals().Ids()).
+				WithKey("state", rs.TargetMove())
+			if rs.FilterCmp() == "filter_date" {
+				ctx = ctx.
+					WithKey("date_from", rs.DateFromCmp()).
+					WithKey("date_to", rs.DateToCmp()).
+					WithKey("strict_range", true)
+			}
+			return ctx
 		})
-	h.AccountingReport().Methods().CheckReport().DeclareMethod(
-		`CheckReport`,
-		func(rs m.AccountingReportSet) {
-			//@api.multi
-			/*def check_report(self):
-			  res = super(AccountingReport, self).check_report()
-			  data = {}
-			  data['form'] = self.read(['account_report_id', 'date_from_cmp', 'date_to_cmp', 'journal_ids', 'filter_cmp', 'target_move'])[0]
-			  for field in ['account_report_id']:
-			      if isinstance(data['form'][field], tuple):
-			          data['form'][field] = data['form'][field][0]
-			  comparison_context = self._build_comparison_context(data)
-			  res['data']['form']['comparison_context'] = comparison_context
-			  return res
 
-			*/
-		})
-	h.AccountingReport().Methods().PrintReport().DeclareMethod(
-		`PrintReport`,
-		func(rs m.AccountingReportSet, args struct {
-			Data interface{}
-		}) {
-			/*def _print_report(self, data):
-			  data['form'].update(self.read(['date_from_cmp', 'debit_credit', 'date_to_cmp', 'filter_cmp', 'account_report_id', 'enable_filter', 'label_filter', 'target_move'])[0])
-			  return self.env['report'].get_action(self, 'account.report_financial', data=data)
-			*/
+	h.AccountingReport().Methods().ComputeReport().Extend("",
+		func(rs m.AccountingReportSet) accounttypes.ReportValues {
+			data := rs.ReportData()
+			data.Form.AccountReportID = rs.AccountReport().ID()
+			data.Form.EnableFilter = rs.EnableFilter()
+			data.Form.DebitCredit = rs.DebitCredit()
+			data.Form.LabelFilter = rs.LabelFilter()
+			data.Form.ComparisonContext = rs.BuildComparisonContext()
+			return h.ReportAccountReportFinancial().NewSet(rs.Env()).RenderHtml(data)
 		})
 
 }
@@ -4,8 +4,13 @@
 package account
 
 import (
+	"fmt"
+	"strconv"
+
+	"github.com/hexya-addons/account/accounttypes"
 	"github.com/hexya-erp/hexya/src/actions"
 	"github.com/hexya-erp/hexya/src/models"
+	"github.com/hexya-erp/hexya/src/models/types"
 	"github.com/hexya-erp/hexya/src/models/types/dates"
 	"github.com/hexya-erp/pool/h"
 	"github.com/hexya-erp/pool/m"
@@ -21,12 +26,24 @@ func init() {
 			String:   "Period Length (days)",
 			Required: true,
 			Default:  models.DefaultValue(30)},
+		"RateType": models.SelectionField{
+			String:    "Translation Rate Type",
+			Selection: currencyRateTypes,
+			Required:  true,
+			Default:   models.DefaultValue("spot"),
+			Help:      "Type of the currency rates used to translate amounts into the currency of the company"},
 	})
 	h.AccountAgedTrialBalance().Fields().Journals().SetRequired(true)
 	h.AccountAgedTrialBalance().Fields().DateFrom().SetDefault(func(env models.Environment) interface{} {
 		return dates.Today()
 	})
 
+	h.AccountAgedTrialBalance().Methods().BuildContexts().Extend(
+		`BuildContexts adds the rate type with which the amounts of the report are translated`,
+		func(rs m.AccountAgedTrialBalanceSet) *types.Context {
+			return rs.Super().BuildContexts().WithKey("rate_type", rs.RateType())
+		})
+
 	h.AccountAgedTrialBalance().Methods().PrintReport().Extend("",
 		func(rs m.AccountAgedTrialBalanceSet, data interface{}) *actions.Action {
 			/*def _print_report(self, data):
@@ -57,4 +74,34 @@ func init() {
 			}
 		})
 
+	h.AccountAgedTrialBalance().Methods().ComputeReport().Extend("",
+		func(rs m.AccountAgedTrialBalanceSet) accounttypes.ReportValues {
+			data := rs.PrePrintReport(rs.ReportData())
+			periodLength := int(rs.PeriodLength())
+			data.Form.PeriodLength = periodLength
+			if periodLength <= 0 {
+				panic(rs.T("You must set a period length greater than 0."))
+			}
+			if data.Form.DateFrom.IsZero() {
+				panic(rs.T("You must set a start date."))
+			}
+
+			data.Form.Periods = make(map[string]accounttypes.AgedBalancePeriod)
+			start := data.Form.DateFrom
+			for i := 4; i >= 0; i-- {
+				stop := start.AddDate(0, 0, -(periodLength - 1))
+				period := accounttypes.AgedBalancePeriod{
+					Name: fmt.Sprintf("+%d", 4*periodLength),
+					Stop: start,
+				}
+				if i != 0 {
+					period.Name = fmt.Sprintf("%d-%d", (5-(i+1))*periodLength, (5-i)*periodLength)
+					period.Start = stop
+				}
+				data.Form.Periods[strconv.Itoa(i)] = period
+				start = stop.AddDate(0, 0, -1)
+			}
+			return h.ReportAccountReportAgedpartnerbalance().NewSet(rs.Env()).RenderHtml(data)
+		})
+
 }
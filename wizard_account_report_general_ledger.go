@@ -4,6 +4,7 @@
 package account
 
 import (
+	"github.com/hexya-addons/account/accounttypes"
 	"github.com/hexya-erp/hexya/src/models"
 	"github.com/hexya-erp/hexya/src/models/types"
 	"github.com/hexya-erp/pool/h"
@@ -27,18 +28,15 @@ func init() {
 			Required: true,
 			Default:  models.DefaultValue("sort_date")},
 	})
-	h.AccountReportGeneralLedger().Methods().PrintReport().DeclareMethod(
-		`PrintReport`,
-		func(rs m.AccountCommonAccountReportSet, data map[string]interface{}) map[string]interface{} {
-			/*def _print_report(self, data):
-			  data = self.pre_print_report(data)
-			  data['form'].update(self.read(['initial_balance', 'sortby'])[0])
-			  if data['form'].get('initial_balance') and not data['form'].get('date_from'):
-			      raise UserError(_("You must define a Start Date"))
-			  records = self.env[data['model']].browse(data.get('ids', []))
-			  return self.env['report'].with_context(landscape=True).get_action(records, 'account.report_generalledger', data=data)
-			*/
-			return nil
+	h.AccountReportGeneralLedger().Methods().ComputeReport().Extend("",
+		func(rs m.AccountReportGeneralLedgerSet) accounttypes.ReportValues {
+			data := rs.PrePrintReport(rs.ReportData())
+			data.Form.InitialBalance = rs.InitialBalance()
+			data.Form.SortBy = rs.Sortby()
+			if data.Form.InitialBalance && data.Form.DateFrom.IsZero() {
+				panic(rs.T("You must define a Start Date"))
+			}
+			return h.ReportAccountReportGeneralledger().NewSet(rs.Env()).RenderHtml(data)
 		})
 
 }